@@ -1,16 +0,0 @@
-package main
-
-import (
-	"fmt"
-
-	"golang.org/x/crypto/bcrypt"
-)
-
-func main() {
-	password := "adminpassword"
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	if err != nil {
-		panic(err)
-	}
-	fmt.Println(string(hashedPassword))
-}
@@ -0,0 +1,30 @@
+// Package apitoken creates and hashes personal API tokens.
+package apitoken
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+)
+
+// Prefix starts every token so leaked tokens are easy to recognize.
+const Prefix = "dyt_"
+
+// Generate returns a new random token, the short prefix shown to the user to
+// tell their tokens apart, and the hash to store.
+func Generate() (token, display, hash string, err error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", "", err
+	}
+	token = Prefix + base64.RawURLEncoding.EncodeToString(buf)
+	return token, token[:len(Prefix)+6], Hash(token), nil
+}
+
+// Hash returns the hex SHA-256 of a token. Tokens carry 256 random bits, so
+// a fast hash is enough and lets the token be looked up by its hash.
+func Hash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,165 @@
+// Package apperror carries HTTP errors from handlers to a single place that
+// logs them and answers the client with an error page or JSON.
+package apperror
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"strings"
+
+	"DytForum/logging"
+)
+
+// Error is an error with the status code and message to show the user. Cause
+// is only logged, never sent to the client. Fields maps invalid input fields
+// to what is wrong with them.
+type Error struct {
+	Status  int
+	Message string
+	Cause   error
+	Fields  map[string]string
+}
+
+func (e *Error) Error() string {
+	if e.Cause != nil {
+		return fmt.Sprintf("%d %s: %v", e.Status, e.Message, e.Cause)
+	}
+	return fmt.Sprintf("%d %s", e.Status, e.Message)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Cause
+}
+
+// New returns an error with the given status and user message.
+func New(status int, message string) *Error {
+	return &Error{Status: status, Message: message}
+}
+
+// Wrap returns an error with the given status and user message caused by err.
+func Wrap(err error, status int, message string) *Error {
+	return &Error{Status: status, Message: message, Cause: err}
+}
+
+func BadRequest(message string) *Error {
+	return New(http.StatusBadRequest, message)
+}
+
+func Unauthorized(message string) *Error {
+	return New(http.StatusUnauthorized, message)
+}
+
+func Forbidden(message string) *Error {
+	return New(http.StatusForbidden, message)
+}
+
+func NotFound(message string) *Error {
+	return New(http.StatusNotFound, message)
+}
+
+// Invalid is a 422 error listing the fields that failed validation.
+func Invalid(fields map[string]string) *Error {
+	return &Error{Status: http.StatusUnprocessableEntity, Message: "Validation failed", Fields: fields}
+}
+
+// TooManyRequests is a 429 error; the caller sets Retry-After.
+func TooManyRequests(message string) *Error {
+	return New(http.StatusTooManyRequests, message)
+}
+
+func MethodNotAllowed() *Error {
+	return New(http.StatusMethodNotAllowed, "Method not allowed")
+}
+
+// Internal is a 500 error; message is shown to the user, err is logged.
+func Internal(err error, message string) *Error {
+	return Wrap(err, http.StatusInternalServerError, message)
+}
+
+// From turns any error into an *Error. Errors that are not already an
+// *Error become a generic 500 so their text never reaches the client.
+func From(err error) *Error {
+	var appErr *Error
+	if errors.As(err, &appErr) {
+		return appErr
+	}
+	return Internal(err, "Something went wrong")
+}
+
+// HTMLRenderer renders the HTML error page. It is set by the handlers once
+// the templates are loaded; until then errors are written as plain text.
+var HTMLRenderer func(w http.ResponseWriter, r *http.Request, e *Error) error
+
+// WantsJSON reports whether the client asked for a JSON response.
+func WantsJSON(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "application/json") ||
+		strings.HasPrefix(r.URL.Path, "/api/")
+}
+
+// Write logs err and sends it to the client.
+func Write(w http.ResponseWriter, r *http.Request, err error) {
+	e := From(err)
+	if e.Status >= http.StatusInternalServerError {
+		logging.FromContext(r.Context()).Error("request failed", "method", r.Method, "path", r.URL.Path, "status", e.Status, "err", e)
+	}
+
+	if WantsJSON(r) {
+		writeJSON(w, e)
+		return
+	}
+	if HTMLRenderer != nil {
+		renderErr := HTMLRenderer(w, r, e)
+		if renderErr == nil {
+			return
+		}
+		logging.FromContext(r.Context()).Error("failed to render error page", "err", renderErr)
+	}
+	http.Error(w, e.Message, e.Status)
+}
+
+func writeJSON(w http.ResponseWriter, e *Error) {
+	body := struct {
+		Error struct {
+			Status  int               `json:"status"`
+			Message string            `json:"message"`
+			Fields  map[string]string `json:"fields,omitempty"`
+		} `json:"error"`
+	}{}
+	body.Error.Status = e.Status
+	body.Error.Message = e.Message
+	body.Error.Fields = e.Fields
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(e.Status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// Handler is an HTTP handler that returns its error instead of writing it.
+type Handler func(w http.ResponseWriter, r *http.Request) error
+
+func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := h(w, r); err != nil {
+		Write(w, r, err)
+	}
+}
+
+// Recover turns a panic in a later handler into a 500 response and logs the
+// stack trace with the request ID.
+func Recover(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if v := recover(); v != nil {
+				if v == http.ErrAbortHandler {
+					panic(v)
+				}
+				logging.FromContext(r.Context()).Error("panic serving request", "method", r.Method, "path", r.URL.Path, "panic", v, "stack", string(debug.Stack()))
+				Write(w, r, New(http.StatusInternalServerError, "Something went wrong"))
+			}
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
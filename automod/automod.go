@@ -0,0 +1,180 @@
+// Package automod evaluates automoderation rules against new threads and
+// comments. It only decides; storing the outcome is up to the caller.
+package automod
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+
+	"DytForum/models"
+)
+
+// Actions a rule can take when it matches.
+const (
+	ActionApprove    = "approve"
+	ActionHold       = "hold"
+	ActionReject     = "reject"
+	ActionFlag       = "flag"
+	ActionShadowHide = "shadow_hide"
+)
+
+// Actions lists every action in the order they are offered to admins.
+var Actions = []string{ActionApprove, ActionHold, ActionReject, ActionFlag, ActionShadowHide}
+
+// Rule targets.
+const (
+	TargetThread  = "thread"
+	TargetComment = "comment"
+	TargetAll     = "all"
+)
+
+// Targets lists every rule target.
+var Targets = []string{TargetAll, TargetThread, TargetComment}
+
+// severity orders the actions that decide whether a post is shown. Flag is
+// not among them since it combines with any of them.
+var severity = map[string]int{
+	ActionApprove:    1,
+	ActionHold:       2,
+	ActionShadowHide: 3,
+	ActionReject:     4,
+}
+
+var linkPattern = regexp.MustCompile(`(?i)\bhttps?://|\bwww\.`)
+
+// Post is what the rules see of a new thread or comment and its author.
+type Post struct {
+	Type    string
+	Title   string
+	Content string
+	// AccountCreated is zero for accounts that predate creation times;
+	// they count as established.
+	AccountCreated time.Time
+	TrustLevel     int
+	// Repeats is how many earlier posts by the author have the same content.
+	Repeats int
+}
+
+// Validate checks that a rule is complete and its pattern compiles.
+func Validate(rule models.AutomodRule) map[string]string {
+	fields := map[string]string{}
+	if strings.TrimSpace(rule.Name) == "" {
+		fields["name"] = "is required"
+	}
+	if !contains(Targets, rule.Target) {
+		fields["target"] = "is not a known target"
+	}
+	if !contains(Actions, rule.Action) {
+		fields["action"] = "is not a known action"
+	}
+	if rule.Pattern != "" {
+		if _, err := regexp.Compile(rule.Pattern); err != nil {
+			fields["pattern"] = "is not a valid regular expression"
+		}
+	}
+	if rule.MinLinks < 0 || rule.MaxAccountDays < 0 || rule.MinRepeats < 0 {
+		fields["conditions"] = "cannot be negative"
+	}
+	if len(Words(rule.Words)) == 0 && rule.Pattern == "" && rule.MinLinks == 0 &&
+		rule.MaxAccountDays == 0 && rule.MaxTrustLevel == nil && rule.MinRepeats == 0 {
+		fields["conditions"] = "at least one condition is required"
+	}
+	return fields
+}
+
+// Words splits a rule's word list into lower case words and phrases.
+func Words(list string) []string {
+	var words []string
+	for _, w := range strings.FieldsFunc(list, func(r rune) bool { return r == ',' || r == '\n' }) {
+		if w = strings.ToLower(strings.TrimSpace(w)); w != "" {
+			words = append(words, w)
+		}
+	}
+	return words
+}
+
+// Match reports whether every condition the rule sets holds for the post.
+// Rules for another post type never match.
+func Match(rule models.AutomodRule, p Post) (bool, error) {
+	if rule.Target != TargetAll && rule.Target != p.Type {
+		return false, nil
+	}
+	text := p.Content
+	if p.Title != "" {
+		text = p.Title + "\n" + p.Content
+	}
+
+	if words := Words(rule.Words); len(words) > 0 && !containsWord(text, words) {
+		return false, nil
+	}
+	if rule.Pattern != "" {
+		re, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return false, fmt.Errorf("rule %d: %w", rule.ID, err)
+		}
+		if !re.MatchString(text) {
+			return false, nil
+		}
+	}
+	if rule.MinLinks > 0 && len(linkPattern.FindAllStringIndex(text, -1)) < rule.MinLinks {
+		return false, nil
+	}
+	if rule.MaxAccountDays > 0 && (p.AccountCreated.IsZero() || time.Since(p.AccountCreated) >= time.Duration(rule.MaxAccountDays)*24*time.Hour) {
+		return false, nil
+	}
+	if rule.MaxTrustLevel != nil && p.TrustLevel > *rule.MaxTrustLevel {
+		return false, nil
+	}
+	if rule.MinRepeats > 0 && p.Repeats < rule.MinRepeats {
+		return false, nil
+	}
+	return true, nil
+}
+
+// Decide combines the actions of the rules that matched a post. visibility
+// is the strictest of approve, hold, shadow_hide and reject, or empty when
+// none of them matched; flag reports whether moderators should be alerted.
+func Decide(actions []string) (visibility string, flag bool) {
+	for _, action := range actions {
+		if action == ActionFlag {
+			flag = true
+		} else if severity[action] > severity[visibility] {
+			visibility = action
+		}
+	}
+	return visibility, flag
+}
+
+// containsWord reports whether text contains one of the words as a whole
+// word, or one of the phrases anywhere, ignoring case.
+func containsWord(text string, words []string) bool {
+	lower := strings.ToLower(text)
+	tokens := map[string]bool{}
+	for _, token := range strings.FieldsFunc(lower, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		tokens[token] = true
+	}
+	for _, w := range words {
+		if strings.ContainsAny(w, " -'") {
+			if strings.Contains(lower, w) {
+				return true
+			}
+		} else if tokens[w] {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(list []string, v string) bool {
+	for _, item := range list {
+		if item == v {
+			return true
+		}
+	}
+	return false
+}
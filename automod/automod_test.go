@@ -0,0 +1,93 @@
+package automod
+
+import (
+	"testing"
+	"time"
+
+	"DytForum/models"
+)
+
+func TestMatch(t *testing.T) {
+	zero, one := 0, 1
+	week := time.Now().Add(-7 * 24 * time.Hour)
+	tests := []struct {
+		name string
+		rule models.AutomodRule
+		post Post
+		want bool
+	}{
+		{"other target", models.AutomodRule{Target: TargetThread, Words: "casino"},
+			Post{Type: TargetComment, Content: "casino"}, false},
+		{"word", models.AutomodRule{Target: TargetAll, Words: "casino, poker"},
+			Post{Type: TargetComment, Content: "Best POKER site"}, true},
+		{"word inside another word", models.AutomodRule{Target: TargetAll, Words: "diet"},
+			Post{Type: TargetComment, Content: "dietitian advice"}, false},
+		{"word in the title", models.AutomodRule{Target: TargetThread, Words: "casino"},
+			Post{Type: TargetThread, Title: "Casino", Content: "hello"}, true},
+		{"phrase", models.AutomodRule{Target: TargetAll, Words: "lose weight fast"},
+			Post{Type: TargetComment, Content: "How to LOSE WEIGHT FAST today"}, true},
+		{"pattern", models.AutomodRule{Target: TargetAll, Pattern: `\d{3}-\d{4}`},
+			Post{Type: TargetComment, Content: "call 555-1234"}, true},
+		{"pattern not found", models.AutomodRule{Target: TargetAll, Pattern: `\d{3}-\d{4}`},
+			Post{Type: TargetComment, Content: "call me"}, false},
+		{"enough links", models.AutomodRule{Target: TargetAll, MinLinks: 2},
+			Post{Type: TargetComment, Content: "https://a.example and www.b.example"}, true},
+		{"too few links", models.AutomodRule{Target: TargetAll, MinLinks: 2},
+			Post{Type: TargetComment, Content: "http://a.example"}, false},
+		{"new account", models.AutomodRule{Target: TargetAll, MaxAccountDays: 30},
+			Post{Type: TargetComment, AccountCreated: week}, true},
+		{"old account", models.AutomodRule{Target: TargetAll, MaxAccountDays: 3},
+			Post{Type: TargetComment, AccountCreated: week}, false},
+		{"unknown account age", models.AutomodRule{Target: TargetAll, MaxAccountDays: 30},
+			Post{Type: TargetComment}, false},
+		{"trust level zero", models.AutomodRule{Target: TargetAll, MaxTrustLevel: &zero},
+			Post{Type: TargetComment, TrustLevel: 0}, true},
+		{"trust level above", models.AutomodRule{Target: TargetAll, MaxTrustLevel: &one},
+			Post{Type: TargetComment, TrustLevel: 2}, false},
+		{"repeats", models.AutomodRule{Target: TargetAll, MinRepeats: 2},
+			Post{Type: TargetComment, Repeats: 2}, true},
+		{"too few repeats", models.AutomodRule{Target: TargetAll, MinRepeats: 2},
+			Post{Type: TargetComment, Repeats: 1}, false},
+		{"every condition must hold", models.AutomodRule{Target: TargetAll, Words: "casino", MinLinks: 1},
+			Post{Type: TargetComment, Content: "casino"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Match(tt.rule, tt.post)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("Match = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchInvalidPattern(t *testing.T) {
+	rule := models.AutomodRule{ID: 7, Target: TargetAll, Pattern: "("}
+	if _, err := Match(rule, Post{Type: TargetComment, Content: "x"}); err == nil {
+		t.Error("Match with an invalid pattern did not fail")
+	}
+}
+
+func TestDecide(t *testing.T) {
+	tests := []struct {
+		actions    []string
+		visibility string
+		flag       bool
+	}{
+		{nil, "", false},
+		{[]string{ActionFlag}, "", true},
+		{[]string{ActionApprove}, ActionApprove, false},
+		{[]string{ActionApprove, ActionHold}, ActionHold, false},
+		{[]string{ActionReject, ActionHold, ActionShadowHide}, ActionReject, false},
+		{[]string{ActionHold, ActionShadowHide, ActionFlag}, ActionShadowHide, true},
+	}
+	for _, tt := range tests {
+		visibility, flag := Decide(tt.actions)
+		if visibility != tt.visibility || flag != tt.flag {
+			t.Errorf("Decide(%v) = %q, %v, want %q, %v", tt.actions, visibility, flag, tt.visibility, tt.flag)
+		}
+	}
+}
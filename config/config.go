@@ -0,0 +1,418 @@
+// config/config.go
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"DytForum/ratelimit"
+
+	"github.com/joho/godotenv"
+)
+
+// DefaultRateLimits are the rate limit policies RATE_LIMITS can override.
+// The ".new" policies apply to accounts younger than NewAccountAge.
+var DefaultRateLimits = map[string]ratelimit.Policy{
+	"thread":       {Requests: 5, Per: 10 * time.Minute},
+	"thread.new":   {Requests: 2, Per: 10 * time.Minute},
+	"comment":      {Requests: 10, Per: time.Minute},
+	"comment.new":  {Requests: 3, Per: time.Minute},
+	"reaction":     {Requests: 30, Per: time.Minute},
+	"reaction.new": {Requests: 10, Per: time.Minute},
+	"report":       {Requests: 10, Per: time.Hour},
+	"report.new":   {Requests: 3, Per: time.Hour},
+}
+
+// Config holds every setting the server needs. It is loaded once in main and
+// passed to the packages that need it.
+type Config struct {
+	// Addr is the address the HTTP server listens on, e.g. ":8080".
+	Addr string
+	// DatabasePath is the SQLite database file.
+	DatabasePath string
+	// BaseURL is the public URL of the forum, used for OAuth redirect URLs.
+	BaseURL string
+
+	SessionKey    string
+	SessionMaxAge int
+	// CookieSecure marks session cookies as HTTPS-only. Unless set
+	// explicitly it follows the scheme of BaseURL.
+	CookieSecure bool
+
+	GoogleClientID     string
+	GoogleClientSecret string
+	GitHubClientID     string
+	GitHubClientSecret string
+	FacebookKey        string
+	FacebookSecret     string
+
+	ReadTimeout       time.Duration
+	ReadHeaderTimeout time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	// ShutdownTimeout is how long in-flight requests may take to finish
+	// after a termination signal.
+	ShutdownTimeout time.Duration
+	// ShutdownDelay is how long /readyz reports not ready before the server
+	// stops accepting connections, so load balancers can take it out first.
+	ShutdownDelay time.Duration
+
+	// TLSCertFile and TLSKeyFile enable HTTPS when both are set. The files
+	// are read again on SIGHUP.
+	TLSCertFile string
+	TLSKeyFile  string
+	// RedirectAddr, when set together with TLS, starts a plain HTTP listener
+	// that redirects every request to BaseURL.
+	RedirectAddr string
+
+	// DevTemplates reads the templates from the templates directory on
+	// every request instead of using the copies compiled into the binary.
+	DevTemplates bool
+
+	// LogFormat is "text" or "json"; LogLevel is debug, info, warn or error.
+	LogFormat string
+	LogLevel  string
+
+	// MetricsAllow is a comma separated list of networks in CIDR notation
+	// that may read /metrics. MetricsToken, when set, also admits requests
+	// carrying it as a bearer token from anywhere.
+	MetricsAllow string
+	MetricsToken string
+
+	// TrustedProxies is a comma separated list of networks in CIDR notation
+	// whose requests carry the client address in ClientIPHeader, e.g. the
+	// reverse proxy in front of the server. Requests from anywhere else are
+	// keyed on the connection's address.
+	TrustedProxies string
+	ClientIPHeader string
+
+	// RateLimitStore is "memory" to keep rate limits in each instance or
+	// "database" to share them between instances using the same database.
+	RateLimitStore string
+	// RateLimits overrides DefaultRateLimits, e.g. "comment=20/1m".
+	RateLimits string
+	// NewAccountAge is how long new accounts get the stricter limits.
+	NewAccountAge time.Duration
+}
+
+// Defaults returns the configuration used when nothing else is set.
+func Defaults() Config {
+	return Config{
+		Addr:          ":8080",
+		DatabasePath:  "forum.db",
+		BaseURL:       "http://localhost:8080",
+		SessionMaxAge: 3600 * 24,
+
+		ReadTimeout:       15 * time.Second,
+		ReadHeaderTimeout: 5 * time.Second,
+		WriteTimeout:      30 * time.Second,
+		IdleTimeout:       2 * time.Minute,
+		ShutdownTimeout:   30 * time.Second,
+
+		LogFormat: "text",
+		LogLevel:  "info",
+
+		MetricsAllow:   "127.0.0.1/32,::1/128",
+		ClientIPHeader: "X-Forwarded-For",
+
+		RateLimitStore: "memory",
+		NewAccountAge:  72 * time.Hour,
+	}
+}
+
+// Load builds the configuration from, in increasing priority: defaults, the
+// config file, environment variables and command line flags. The config file
+// uses the .env format and defaults to ".env"; a missing default file is not
+// an error.
+func Load(args []string) (Config, error) {
+	fs := flag.NewFlagSet("forum", flag.ContinueOnError)
+	configPath := fs.String("config", ".env", "path to the configuration file")
+	addr := fs.String("addr", "", "listen address (LISTEN_ADDR)")
+	dbPath := fs.String("db", "", "SQLite database path (DATABASE_PATH)")
+	baseURL := fs.String("base-url", "", "public URL of the forum (BASE_URL)")
+	cookieSecure := fs.Bool("cookie-secure", false, "send session cookies over HTTPS only (COOKIE_SECURE)")
+	certFile := fs.String("tls-cert", "", "TLS certificate file (TLS_CERT_FILE)")
+	keyFile := fs.String("tls-key", "", "TLS private key file (TLS_KEY_FILE)")
+	logFormat := fs.String("log-format", "", "log output format, text or json (LOG_FORMAT)")
+	logLevel := fs.String("log-level", "", "minimum log level (LOG_LEVEL)")
+	devTemplates := fs.Bool("dev-templates", false, "reload templates from disk on every request (DEV_TEMPLATES)")
+	redirectAddr := fs.String("redirect-addr", "", "address of the HTTP to HTTPS redirect listener (REDIRECT_ADDR)")
+	metricsAllow := fs.String("metrics-allow", "", "networks allowed to read /metrics (METRICS_ALLOW)")
+	trustedProxies := fs.String("trusted-proxies", "", "networks of reverse proxies trusted to report the client address (TRUSTED_PROXIES)")
+	if err := fs.Parse(args); err != nil {
+		return Config{}, err
+	}
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	cfg := Defaults()
+	values, err := godotenv.Read(*configPath)
+	if err != nil {
+		if set["config"] || !errors.Is(err, os.ErrNotExist) {
+			return Config{}, fmt.Errorf("reading %s: %v", *configPath, err)
+		}
+		values = map[string]string{}
+	}
+	lookup := func(key string) (string, bool) {
+		if v, ok := os.LookupEnv(key); ok {
+			return v, true
+		}
+		v, ok := values[key]
+		return v, ok
+	}
+	if err := cfg.apply(lookup); err != nil {
+		return Config{}, err
+	}
+
+	if set["addr"] {
+		cfg.Addr = *addr
+	}
+	if set["db"] {
+		cfg.DatabasePath = *dbPath
+	}
+	if set["base-url"] {
+		cfg.BaseURL = *baseURL
+	}
+	if set["cookie-secure"] {
+		cfg.CookieSecure = *cookieSecure
+	}
+	if set["tls-cert"] {
+		cfg.TLSCertFile = *certFile
+	}
+	if set["tls-key"] {
+		cfg.TLSKeyFile = *keyFile
+	}
+	if set["redirect-addr"] {
+		cfg.RedirectAddr = *redirectAddr
+	}
+	if set["log-format"] {
+		cfg.LogFormat = *logFormat
+	}
+	if set["log-level"] {
+		cfg.LogLevel = *logLevel
+	}
+	if set["dev-templates"] {
+		cfg.DevTemplates = *devTemplates
+	}
+	if set["metrics-allow"] {
+		cfg.MetricsAllow = *metricsAllow
+	}
+	if set["trusted-proxies"] {
+		cfg.TrustedProxies = *trustedProxies
+	}
+	if _, ok := lookup("COOKIE_SECURE"); !ok && !set["cookie-secure"] {
+		cfg.CookieSecure = hasHTTPSScheme(cfg.BaseURL)
+	}
+
+	return cfg, cfg.Validate()
+}
+
+// FromEnvironment returns the defaults overridden by the environment and the
+// .env file, without validation. It is meant for tools that only need a few
+// settings such as the database path.
+func FromEnvironment() Config {
+	cfg := Defaults()
+	values, _ := godotenv.Read()
+	lookup := func(key string) (string, bool) {
+		if v, ok := os.LookupEnv(key); ok {
+			return v, true
+		}
+		v, ok := values[key]
+		return v, ok
+	}
+	cfg.apply(lookup)
+	if _, ok := lookup("COOKIE_SECURE"); !ok {
+		cfg.CookieSecure = hasHTTPSScheme(cfg.BaseURL)
+	}
+	return cfg
+}
+
+// apply overrides cfg with every key lookup finds.
+func (c *Config) apply(lookup func(string) (string, bool)) error {
+	fields := map[string]*string{
+		"LISTEN_ADDR":          &c.Addr,
+		"DATABASE_PATH":        &c.DatabasePath,
+		"BASE_URL":             &c.BaseURL,
+		"SESSION_KEY":          &c.SessionKey,
+		"GOOGLE_CLIENT_ID":     &c.GoogleClientID,
+		"GOOGLE_CLIENT_SECRET": &c.GoogleClientSecret,
+		"GITHUB_CLIENT_ID":     &c.GitHubClientID,
+		"GITHUB_CLIENT_SECRET": &c.GitHubClientSecret,
+		"FACEBOOK_KEY":         &c.FacebookKey,
+		"FACEBOOK_SECRET":      &c.FacebookSecret,
+		"TLS_CERT_FILE":        &c.TLSCertFile,
+		"TLS_KEY_FILE":         &c.TLSKeyFile,
+		"REDIRECT_ADDR":        &c.RedirectAddr,
+		"LOG_FORMAT":           &c.LogFormat,
+		"LOG_LEVEL":            &c.LogLevel,
+		"METRICS_ALLOW":        &c.MetricsAllow,
+		"METRICS_TOKEN":        &c.MetricsToken,
+		"TRUSTED_PROXIES":      &c.TrustedProxies,
+		"CLIENT_IP_HEADER":     &c.ClientIPHeader,
+		"RATE_LIMIT_STORE":     &c.RateLimitStore,
+		"RATE_LIMITS":          &c.RateLimits,
+	}
+	for key, field := range fields {
+		if v, ok := lookup(key); ok {
+			*field = v
+		}
+	}
+
+	if v, ok := lookup("COOKIE_SECURE"); ok {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("COOKIE_SECURE: %v", err)
+		}
+		c.CookieSecure = b
+	}
+	if v, ok := lookup("DEV_TEMPLATES"); ok {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("DEV_TEMPLATES: %v", err)
+		}
+		c.DevTemplates = b
+	}
+	if v, ok := lookup("SESSION_MAX_AGE"); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("SESSION_MAX_AGE: %v", err)
+		}
+		c.SessionMaxAge = n
+	}
+
+	durations := map[string]*time.Duration{
+		"READ_TIMEOUT":        &c.ReadTimeout,
+		"READ_HEADER_TIMEOUT": &c.ReadHeaderTimeout,
+		"WRITE_TIMEOUT":       &c.WriteTimeout,
+		"IDLE_TIMEOUT":        &c.IdleTimeout,
+		"SHUTDOWN_TIMEOUT":    &c.ShutdownTimeout,
+		"SHUTDOWN_DELAY":      &c.ShutdownDelay,
+		"NEW_ACCOUNT_AGE":     &c.NewAccountAge,
+	}
+	for key, field := range durations {
+		if v, ok := lookup(key); ok {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				return fmt.Errorf("%s: %v", key, err)
+			}
+			*field = d
+		}
+	}
+	return nil
+}
+
+func hasHTTPSScheme(raw string) bool {
+	return strings.HasPrefix(strings.ToLower(raw), "https://")
+}
+
+// Validate checks that the configuration can be used to start the server.
+func (c Config) Validate() error {
+	var problems []string
+	if _, _, err := net.SplitHostPort(c.Addr); err != nil {
+		problems = append(problems, fmt.Sprintf("invalid listen address %q", c.Addr))
+	}
+	if c.DatabasePath == "" {
+		problems = append(problems, "database path is empty")
+	}
+	if u, err := url.Parse(c.BaseURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		problems = append(problems, fmt.Sprintf("base URL %q must be an absolute http(s) URL", c.BaseURL))
+	}
+	if c.SessionKey == "" {
+		problems = append(problems, "SESSION_KEY is not set")
+	}
+	if c.SessionMaxAge <= 0 {
+		problems = append(problems, "SESSION_MAX_AGE must be positive")
+	}
+	if c.ReadTimeout < 0 || c.ReadHeaderTimeout < 0 || c.WriteTimeout < 0 || c.IdleTimeout < 0 || c.ShutdownTimeout < 0 || c.ShutdownDelay < 0 {
+		problems = append(problems, "timeouts must not be negative")
+	}
+	if f := strings.ToLower(c.LogFormat); f != "text" && f != "json" {
+		problems = append(problems, fmt.Sprintf("LOG_FORMAT must be text or json, not %q", c.LogFormat))
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.LogLevel)); err != nil {
+		problems = append(problems, fmt.Sprintf("invalid LOG_LEVEL %q", c.LogLevel))
+	}
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		problems = append(problems, "TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	if c.RedirectAddr != "" {
+		if !c.TLSEnabled() || !hasHTTPSScheme(c.BaseURL) {
+			problems = append(problems, "REDIRECT_ADDR requires TLS and an https BASE_URL")
+		} else if _, _, err := net.SplitHostPort(c.RedirectAddr); err != nil {
+			problems = append(problems, fmt.Sprintf("invalid redirect address %q", c.RedirectAddr))
+		}
+	}
+	if _, err := c.MetricsNetworks(); err != nil {
+		problems = append(problems, fmt.Sprintf("METRICS_ALLOW: %v", err))
+	}
+	if _, err := c.TrustedProxyNetworks(); err != nil {
+		problems = append(problems, fmt.Sprintf("TRUSTED_PROXIES: %v", err))
+	}
+	if c.TrustedProxies != "" && strings.TrimSpace(c.ClientIPHeader) == "" {
+		problems = append(problems, "CLIENT_IP_HEADER must be set when TRUSTED_PROXIES is")
+	}
+	if c.RateLimitStore != "memory" && c.RateLimitStore != "database" {
+		problems = append(problems, fmt.Sprintf("RATE_LIMIT_STORE must be memory or database, not %q", c.RateLimitStore))
+	}
+	if _, err := c.RateLimitPolicies(); err != nil {
+		problems = append(problems, fmt.Sprintf("RATE_LIMITS: %v", err))
+	}
+	if c.NewAccountAge < 0 {
+		problems = append(problems, "NEW_ACCOUNT_AGE must not be negative")
+	}
+	if len(problems) > 0 {
+		return errors.New("invalid configuration: " + strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// TLSEnabled reports whether the server should serve HTTPS.
+func (c Config) TLSEnabled() bool {
+	return c.TLSCertFile != "" && c.TLSKeyFile != ""
+}
+
+// URL joins path to the public base URL.
+func (c Config) URL(path string) string {
+	return strings.TrimRight(c.BaseURL, "/") + path
+}
+
+// MetricsNetworks parses MetricsAllow.
+func (c Config) MetricsNetworks() ([]*net.IPNet, error) {
+	return parseNetworks(c.MetricsAllow)
+}
+
+// TrustedProxyNetworks parses TrustedProxies.
+func (c Config) TrustedProxyNetworks() ([]*net.IPNet, error) {
+	return parseNetworks(c.TrustedProxies)
+}
+
+// parseNetworks parses a comma separated list of networks in CIDR notation.
+func parseNetworks(list string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, field := range strings.Split(list, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		_, network, err := net.ParseCIDR(field)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// RateLimitPolicies returns DefaultRateLimits with RateLimits applied.
+func (c Config) RateLimitPolicies() (map[string]ratelimit.Policy, error) {
+	return ratelimit.ParsePolicies(c.RateLimits, DefaultRateLimits)
+}
@@ -0,0 +1,143 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeConfigFile writes a .env style config file and returns its path.
+func writeConfigFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "forum.env")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		env  map[string]string
+		args []string
+		want string
+	}{
+		{name: "default", want: "forum.db"},
+		{name: "file over default", file: "DATABASE_PATH=file.db", want: "file.db"},
+		{name: "environment over file", file: "DATABASE_PATH=file.db",
+			env: map[string]string{"DATABASE_PATH": "env.db"}, want: "env.db"},
+		{name: "flag over environment", file: "DATABASE_PATH=file.db",
+			env: map[string]string{"DATABASE_PATH": "env.db"}, args: []string{"-db", "flag.db"}, want: "flag.db"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("SESSION_KEY", "secret")
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			args := append([]string{"-config", writeConfigFile(t, tt.file)}, tt.args...)
+			cfg, err := Load(args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.DatabasePath != tt.want {
+				t.Errorf("DatabasePath = %q, want %q", cfg.DatabasePath, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadCookieSecure(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		env  map[string]string
+		args []string
+		want bool
+	}{
+		{name: "http base URL", want: false},
+		{name: "https base URL in the file", file: "BASE_URL=https://forum.example", want: true},
+		{name: "https base URL flag", file: "BASE_URL=http://forum.example",
+			args: []string{"-base-url", "https://forum.example"}, want: true},
+		{name: "http base URL flag", file: "BASE_URL=https://forum.example",
+			args: []string{"-base-url", "http://forum.example"}, want: false},
+		{name: "explicit in the environment", env: map[string]string{"COOKIE_SECURE": "false"},
+			args: []string{"-base-url", "https://forum.example"}, want: false},
+		{name: "explicit flag", args: []string{"-base-url", "https://forum.example", "-cookie-secure=false"}, want: false},
+		{name: "explicit flag over the environment", env: map[string]string{"COOKIE_SECURE": "false"},
+			args: []string{"-cookie-secure"}, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("SESSION_KEY", "secret")
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			args := append([]string{"-config", writeConfigFile(t, tt.file)}, tt.args...)
+			cfg, err := Load(args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.CookieSecure != tt.want {
+				t.Errorf("CookieSecure = %v, want %v", cfg.CookieSecure, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	t.Setenv("SESSION_KEY", "secret")
+	if _, err := Load([]string{"-config", filepath.Join(t.TempDir(), "missing.env")}); err == nil {
+		t.Error("Load with a missing -config file did not fail")
+	}
+	if _, err := Load([]string{"-config", writeConfigFile(t, "READ_TIMEOUT=soon")}); err == nil {
+		t.Error("Load with an invalid duration did not fail")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	valid := Defaults()
+	valid.SessionKey = "secret"
+	if err := valid.Validate(); err != nil {
+		t.Fatalf("Validate on the defaults with a session key: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		change func(c *Config)
+		want   string
+	}{
+		{"listen address", func(c *Config) { c.Addr = "8080" }, "invalid listen address"},
+		{"database path", func(c *Config) { c.DatabasePath = "" }, "database path is empty"},
+		{"relative base URL", func(c *Config) { c.BaseURL = "/forum" }, "must be an absolute http(s) URL"},
+		{"session key", func(c *Config) { c.SessionKey = "" }, "SESSION_KEY is not set"},
+		{"session max age", func(c *Config) { c.SessionMaxAge = 0 }, "SESSION_MAX_AGE must be positive"},
+		{"negative timeout", func(c *Config) { c.ShutdownDelay = -1 }, "timeouts must not be negative"},
+		{"log format", func(c *Config) { c.LogFormat = "xml" }, "LOG_FORMAT must be text or json"},
+		{"log level", func(c *Config) { c.LogLevel = "loud" }, "invalid LOG_LEVEL"},
+		{"half of TLS", func(c *Config) { c.TLSCertFile = "cert.pem" }, "must be set together"},
+		{"redirect without TLS", func(c *Config) { c.RedirectAddr = ":80" }, "REDIRECT_ADDR requires TLS"},
+		{"metrics network", func(c *Config) { c.MetricsAllow = "10.0.0.1" }, "METRICS_ALLOW"},
+		{"trusted proxy network", func(c *Config) { c.TrustedProxies = "proxy" }, "TRUSTED_PROXIES"},
+		{"client IP header", func(c *Config) {
+			c.TrustedProxies = "10.0.0.0/8"
+			c.ClientIPHeader = " "
+		}, "CLIENT_IP_HEADER must be set"},
+		{"rate limit store", func(c *Config) { c.RateLimitStore = "redis" }, "RATE_LIMIT_STORE"},
+		{"rate limits", func(c *Config) { c.RateLimits = "comment=0/1m" }, "RATE_LIMITS"},
+		{"new account age", func(c *Config) { c.NewAccountAge = -1 }, "NEW_ACCOUNT_AGE"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := valid
+			tt.change(&c)
+			err := c.Validate()
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Validate = %v, want an error mentioning %q", err, tt.want)
+			}
+		})
+	}
+}
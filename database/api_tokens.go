@@ -0,0 +1,84 @@
+package database
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"DytForum/models"
+)
+
+const apiTokenColumns = "id, user_id, name, prefix, scopes, created_at, expires_at, last_used_at, revoked_at"
+
+func scanAPIToken(row interface{ Scan(...interface{}) error }) (models.APIToken, error) {
+	var t models.APIToken
+	var scopes string
+	var lastUsed, revoked sql.NullTime
+	err := row.Scan(&t.ID, &t.UserID, &t.Name, &t.Prefix, &scopes, &t.CreatedAt, &t.ExpiresAt, &lastUsed, &revoked)
+	if scopes != "" {
+		t.Scopes = strings.Split(scopes, ",")
+	}
+	if lastUsed.Valid {
+		t.LastUsedAt = &lastUsed.Time
+	}
+	if revoked.Valid {
+		t.RevokedAt = &revoked.Time
+	}
+	return t, err
+}
+
+// CreateAPIToken stores a token by the hash of its secret and returns its ID.
+// The secret itself is never stored.
+func CreateAPIToken(userID int, name, tokenHash, prefix string, scopes []string, expiresAt time.Time) (int, error) {
+	res, err := DB.Exec(`
+		INSERT INTO api_tokens (user_id, name, token_hash, prefix, scopes, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		userID, name, tokenHash, prefix, strings.Join(scopes, ","), time.Now().UTC(), expiresAt.UTC())
+	if err != nil {
+		return 0, err
+	}
+	id, err := res.LastInsertId()
+	return int(id), err
+}
+
+// GetAPITokenByHash returns the token with the given secret hash, or
+// sql.ErrNoRows. Revoked and expired tokens are returned too; callers check.
+func GetAPITokenByHash(tokenHash string) (models.APIToken, error) {
+	return scanAPIToken(DB.QueryRow("SELECT "+apiTokenColumns+" FROM api_tokens WHERE token_hash = ?", tokenHash))
+}
+
+// ListAPITokens returns every token of a user, newest first.
+func ListAPITokens(userID int) ([]models.APIToken, error) {
+	rows, err := DB.Query("SELECT "+apiTokenColumns+" FROM api_tokens WHERE user_id = ? ORDER BY id DESC", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []models.APIToken
+	for rows.Next() {
+		t, err := scanAPIToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, t)
+	}
+	return tokens, rows.Err()
+}
+
+// RevokeAPIToken revokes one of the user's tokens and reports whether it
+// found an active token to revoke.
+func RevokeAPIToken(userID, tokenID int) (bool, error) {
+	res, err := DB.Exec("UPDATE api_tokens SET revoked_at = ? WHERE id = ? AND user_id = ? AND revoked_at IS NULL", time.Now().UTC(), tokenID, userID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// TouchAPIToken records that a token was just used.
+func TouchAPIToken(tokenID int, at time.Time) error {
+	_, err := DB.Exec("UPDATE api_tokens SET last_used_at = ? WHERE id = ?", at.UTC(), tokenID)
+	return err
+}
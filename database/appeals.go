@@ -0,0 +1,241 @@
+package database
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"DytForum/models"
+)
+
+// Appeal statuses. An upheld appeal leaves the action in place; an
+// overturned one reverses it.
+const (
+	AppealPending    = "pending"
+	AppealUpheld     = "upheld"
+	AppealOverturned = "overturned"
+)
+
+// AppealableActions lists the moderation log actions users can appeal.
+var AppealableActions = []string{ModUserSuspend, ModUserBan, ModThreadReject}
+
+// ErrAppealExists is returned when an action has already been appealed.
+var ErrAppealExists = errors.New("action was already appealed")
+
+// ErrAppealDecided is returned when deciding an appeal that is not pending.
+var ErrAppealDecided = errors.New("appeal was already decided")
+
+// modActionSubject is the SQL expression for the user a moderation log
+// entry affected: the target for user actions, otherwise the author in the
+// before snapshot.
+const modActionSubject = `CASE mod_actions.target_type
+	WHEN 'user' THEN mod_actions.target_id
+	ELSE json_extract(NULLIF(mod_actions.before, ''), '$.user_id') END`
+
+func appealableCondition() string {
+	return "mod_actions.action IN ('" + strings.Join(AppealableActions, "', '") + "')"
+}
+
+// ListAppealableActions returns the appealable actions taken against a user
+// since the given time, newest first.
+func ListAppealableActions(userID int, since time.Time) ([]models.ModAction, error) {
+	rows, err := DB.Query("SELECT "+modActionColumns+`
+		FROM mod_actions
+		LEFT JOIN users actors ON actors.id = mod_actions.actor_id
+		WHERE `+appealableCondition()+` AND `+modActionSubject+` = ? AND mod_actions.created_at >= ?
+		ORDER BY mod_actions.id DESC`, userID, since.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var actions []models.ModAction
+	for rows.Next() {
+		a, err := scanModAction(rows)
+		if err != nil {
+			return nil, err
+		}
+		actions = append(actions, a)
+	}
+	return actions, rows.Err()
+}
+
+// GetAppealableAction returns an appealable action taken against the user
+// since the given time, or sql.ErrNoRows.
+func GetAppealableAction(actionID, userID int, since time.Time) (models.ModAction, error) {
+	return scanModAction(DB.QueryRow("SELECT "+modActionColumns+`
+		FROM mod_actions
+		LEFT JOIN users actors ON actors.id = mod_actions.actor_id
+		WHERE mod_actions.id = ? AND `+appealableCondition()+` AND `+modActionSubject+` = ? AND mod_actions.created_at >= ?`,
+		actionID, userID, since.UTC()))
+}
+
+// CreateAppeal stores an appeal against a moderation log entry and returns
+// its ID. Each action can be appealed once; assigneeID may be zero when no
+// reviewer is available.
+func CreateAppeal(actionID, userID int, message string, assigneeID int) (int, error) {
+	var assignee interface{}
+	if assigneeID != 0 {
+		assignee = assigneeID
+	}
+	res, err := DB.Exec(`
+		INSERT INTO appeals (mod_action_id, user_id, message, status, assigned_to, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(mod_action_id) DO NOTHING`,
+		actionID, userID, message, AppealPending, assignee, time.Now().UTC())
+	if err != nil {
+		return 0, err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return 0, err
+	} else if n == 0 {
+		return 0, ErrAppealExists
+	}
+	id, err := res.LastInsertId()
+	return int(id), err
+}
+
+// PendingAppealCounts returns how many pending appeals each reviewer has.
+func PendingAppealCounts() (map[int]int, error) {
+	rows, err := DB.Query("SELECT assigned_to, COUNT(*) FROM appeals WHERE status = ? AND assigned_to IS NOT NULL GROUP BY assigned_to", AppealPending)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := map[int]int{}
+	for rows.Next() {
+		var userID, count int
+		if err := rows.Scan(&userID, &count); err != nil {
+			return nil, err
+		}
+		counts[userID] = count
+	}
+	return counts, rows.Err()
+}
+
+const appealColumns = `
+	appeals.id, appeals.user_id, COALESCE(appellants.username, ''), appeals.message, appeals.status,
+	COALESCE(appeals.assigned_to, 0), COALESCE(assignees.username, ''), COALESCE(deciders.username, ''),
+	appeals.decision_note, appeals.created_at, appeals.decided_at,` + modActionColumns + `
+	FROM appeals
+	INNER JOIN mod_actions ON mod_actions.id = appeals.mod_action_id
+	LEFT JOIN users actors ON actors.id = mod_actions.actor_id
+	LEFT JOIN users appellants ON appellants.id = appeals.user_id
+	LEFT JOIN users assignees ON assignees.id = appeals.assigned_to
+	LEFT JOIN users deciders ON deciders.id = appeals.decided_by`
+
+func scanAppeal(row interface{ Scan(...interface{}) error }) (models.Appeal, error) {
+	var a models.Appeal
+	m := &a.Action
+	err := row.Scan(&a.ID, &a.UserID, &a.Username, &a.Message, &a.Status,
+		&a.AssignedTo, &a.AssigneeName, &a.DecidedByName, &a.DecisionNote, &a.CreatedAt, &a.DecidedAt,
+		&m.ID, &m.ActorID, &m.ActorName, &m.Action, &m.TargetType, &m.TargetID, &m.Before, &m.After, &m.Reason, &m.CreatedAt)
+	return a, err
+}
+
+func queryAppeals(query string, args ...interface{}) ([]models.Appeal, error) {
+	rows, err := DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var appeals []models.Appeal
+	for rows.Next() {
+		a, err := scanAppeal(rows)
+		if err != nil {
+			return nil, err
+		}
+		appeals = append(appeals, a)
+	}
+	return appeals, rows.Err()
+}
+
+// ListUserAppeals returns every appeal a user filed, newest first.
+func ListUserAppeals(userID int) ([]models.Appeal, error) {
+	return queryAppeals("SELECT "+appealColumns+" WHERE appeals.user_id = ? ORDER BY appeals.id DESC", userID)
+}
+
+// ListAppeals returns the appeals with one of the statuses, or all of them,
+// oldest first. Appeals assigned to firstFor come before the others.
+func ListAppeals(statuses []string, firstFor int) ([]models.Appeal, error) {
+	query := "SELECT " + appealColumns
+	var args []interface{}
+	if len(statuses) > 0 {
+		query += " WHERE appeals.status IN (?" + strings.Repeat(", ?", len(statuses)-1) + ")"
+		for _, status := range statuses {
+			args = append(args, status)
+		}
+	}
+	query += " ORDER BY COALESCE(appeals.assigned_to, 0) = ? DESC, appeals.id"
+	args = append(args, firstFor)
+	return queryAppeals(query, args...)
+}
+
+// GetAppeal returns a single appeal, or sql.ErrNoRows.
+func GetAppeal(appealID int) (models.Appeal, error) {
+	return scanAppeal(DB.QueryRow("SELECT "+appealColumns+" WHERE appeals.id = ?", appealID))
+}
+
+// AppealDecision is a moderator's decision on an appeal. Overturning it
+// lifts SanctionID, if set, or approves Thread, restoring it first when
+// RestoreThread is set because it was deleted.
+type AppealDecision struct {
+	AppealID      int
+	DeciderID     int
+	Status        string
+	Note          string
+	SanctionID    int
+	Thread        *models.Thread
+	RestoreThread bool
+	// Log is the audit entry recorded for the decision.
+	Log models.ModAction
+}
+
+// DecideAppeal closes a pending appeal as upheld or overturned. The
+// decision, the reversal of an overturned action and the audit entry are
+// written in one transaction, so an appeal is never marked overturned while
+// the action still stands. Sanctions that already ended are left alone.
+func DecideAppeal(d AppealDecision) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`
+		UPDATE appeals SET status = ?, decided_by = ?, decision_note = ?, decided_at = ?
+		WHERE id = ? AND status = ?`,
+		d.Status, d.DeciderID, d.Note, time.Now().UTC(), d.AppealID, AppealPending)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrAppealDecided
+	}
+
+	if d.Status == AppealOverturned {
+		if d.SanctionID != 0 {
+			if err := revokeSanction(tx, d.SanctionID, d.DeciderID); err != nil && err != ErrSanctionInactive {
+				return err
+			}
+		}
+		if d.Thread != nil {
+			if d.RestoreThread {
+				err = restoreThread(tx, *d.Thread)
+			} else {
+				err = setThreadReview(tx, d.Thread.ID, ThreadApproved, "")
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if err := insertModAction(tx, d.Log); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
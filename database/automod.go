@@ -0,0 +1,295 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"DytForum/models"
+	"DytForum/permissions"
+)
+
+// Trust levels automoderation rules can test. They are worked out from the
+// author's published posts and role each time a post is checked.
+const (
+	TrustNew    = 0 // nothing published yet
+	TrustBasic  = 1 // at least one published thread or comment
+	TrustMember = 2 // TrustMemberPosts published posts over TrustMemberDays
+	TrustStaff  = 3 // the role can open the moderator panel
+)
+
+// Requirements of TrustMember.
+const (
+	TrustMemberPosts = 10
+	TrustMemberDays  = 30
+)
+
+// automodHitWindow is how far back a rule's recent hits are counted.
+const automodHitWindow = 7 * 24 * time.Hour
+
+const automodRuleColumns = `
+	automod_rules.id, automod_rules.name, automod_rules.target, automod_rules.words, automod_rules.pattern,
+	automod_rules.min_links, automod_rules.max_account_days, automod_rules.max_trust_level, automod_rules.min_repeats,
+	automod_rules.action, automod_rules.enabled, automod_rules.created_at`
+
+func scanAutomodRule(row interface{ Scan(...interface{}) error }, extra ...interface{}) (models.AutomodRule, error) {
+	var rule models.AutomodRule
+	var maxTrust sql.NullInt64
+	dest := []interface{}{&rule.ID, &rule.Name, &rule.Target, &rule.Words, &rule.Pattern,
+		&rule.MinLinks, &rule.MaxAccountDays, &maxTrust, &rule.MinRepeats,
+		&rule.Action, &rule.Enabled, &rule.CreatedAt}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return rule, err
+	}
+	if maxTrust.Valid {
+		level := int(maxTrust.Int64)
+		rule.MaxTrustLevel = &level
+	}
+	return rule, nil
+}
+
+// ListAutomodRules returns every rule with its hit statistics, in the order
+// they were created.
+func ListAutomodRules() ([]models.AutomodRule, error) {
+	rows, err := DB.Query("SELECT "+automodRuleColumns+`,
+			COUNT(automod_hits.id), COALESCE(SUM(automod_hits.created_at >= ?), 0), MAX(automod_hits.created_at)
+		FROM automod_rules
+		LEFT JOIN automod_hits ON automod_hits.rule_id = automod_rules.id
+		GROUP BY automod_rules.id
+		ORDER BY automod_rules.id`, time.Now().UTC().Add(-automodHitWindow))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rules []models.AutomodRule
+	for rows.Next() {
+		var lastHit sql.NullString
+		var hits, recent int
+		rule, err := scanAutomodRule(rows, &hits, &recent, &lastHit)
+		if err != nil {
+			return nil, err
+		}
+		rule.Hits, rule.RecentHits = hits, recent
+		if lastHit.Valid {
+			// MAX() loses the column type, so the driver hands back text.
+			if t, err := parseSQLiteTime(lastHit.String); err == nil {
+				rule.LastHitAt = &t
+			}
+		}
+		rules = append(rules, rule)
+	}
+	return rules, rows.Err()
+}
+
+// parseSQLiteTime parses a time the sqlite driver stored as text.
+func parseSQLiteTime(s string) (time.Time, error) {
+	for _, layout := range []string{"2006-01-02 15:04:05.999999999-07:00", "2006-01-02 15:04:05"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Parse(time.RFC3339Nano, s)
+}
+
+// ListEnabledAutomodRules returns the enabled rules that apply to a post
+// type.
+func ListEnabledAutomodRules(postType string) ([]models.AutomodRule, error) {
+	rows, err := DB.Query("SELECT "+automodRuleColumns+`
+		FROM automod_rules
+		WHERE automod_rules.enabled = 1 AND automod_rules.target IN ('all', ?)
+		ORDER BY automod_rules.id`, postType)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rules []models.AutomodRule
+	for rows.Next() {
+		rule, err := scanAutomodRule(rows)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, rows.Err()
+}
+
+// GetAutomodRule returns a single rule without statistics, or
+// sql.ErrNoRows.
+func GetAutomodRule(ruleID int) (models.AutomodRule, error) {
+	return scanAutomodRule(DB.QueryRow("SELECT "+automodRuleColumns+" FROM automod_rules WHERE automod_rules.id = ?", ruleID))
+}
+
+func automodTrustArg(rule models.AutomodRule) interface{} {
+	if rule.MaxTrustLevel == nil {
+		return nil
+	}
+	return *rule.MaxTrustLevel
+}
+
+// CreateAutomodRule stores a rule and returns its ID.
+func CreateAutomodRule(rule models.AutomodRule) (int, error) {
+	res, err := DB.Exec(`
+		INSERT INTO automod_rules (name, target, words, pattern, min_links, max_account_days, max_trust_level, min_repeats, action, enabled, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		rule.Name, rule.Target, rule.Words, rule.Pattern, rule.MinLinks, rule.MaxAccountDays, automodTrustArg(rule),
+		rule.MinRepeats, rule.Action, rule.Enabled, time.Now().UTC())
+	if err != nil {
+		return 0, err
+	}
+	id, err := res.LastInsertId()
+	return int(id), err
+}
+
+// UpdateAutomodRule replaces a rule's settings. Its hits are kept.
+func UpdateAutomodRule(rule models.AutomodRule) error {
+	_, err := DB.Exec(`
+		UPDATE automod_rules SET name = ?, target = ?, words = ?, pattern = ?, min_links = ?, max_account_days = ?,
+			max_trust_level = ?, min_repeats = ?, action = ?, enabled = ?
+		WHERE id = ?`,
+		rule.Name, rule.Target, rule.Words, rule.Pattern, rule.MinLinks, rule.MaxAccountDays, automodTrustArg(rule),
+		rule.MinRepeats, rule.Action, rule.Enabled, rule.ID)
+	return err
+}
+
+// DeleteAutomodRule removes a rule and its hits.
+func DeleteAutomodRule(ruleID int) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM automod_hits WHERE rule_id = ?", ruleID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM automod_rules WHERE id = ?", ruleID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// RecordAutomodHit notes that a rule matched a post.
+func RecordAutomodHit(hit models.AutomodHit) error {
+	_, err := DB.Exec(`
+		INSERT INTO automod_hits (rule_id, target_type, target_id, thread_id, user_id, action, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		hit.RuleID, hit.TargetType, hit.TargetID, hit.ThreadID, hit.UserID, hit.Action, time.Now().UTC())
+	return err
+}
+
+// ListAutomodHits returns a rule's latest hits, newest first.
+func ListAutomodHits(ruleID, limit int) ([]models.AutomodHit, error) {
+	rows, err := DB.Query(`
+		SELECT automod_hits.id, automod_hits.rule_id, automod_hits.target_type, automod_hits.target_id, automod_hits.thread_id,
+			automod_hits.user_id, COALESCE(users.username, ''), automod_hits.action, automod_hits.created_at
+		FROM automod_hits
+		LEFT JOIN users ON users.id = automod_hits.user_id
+		WHERE automod_hits.rule_id = ?
+		ORDER BY automod_hits.id DESC LIMIT ?`, ruleID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var hits []models.AutomodHit
+	for rows.Next() {
+		var h models.AutomodHit
+		if err := rows.Scan(&h.ID, &h.RuleID, &h.TargetType, &h.TargetID, &h.ThreadID, &h.UserID, &h.Username, &h.Action, &h.CreatedAt); err != nil {
+			return nil, err
+		}
+		hits = append(hits, h)
+	}
+	return hits, rows.Err()
+}
+
+// AccountCreatedAt returns when a user registered, or nil for accounts that
+// predate creation times.
+func AccountCreatedAt(userID int) (*time.Time, error) {
+	var created sql.NullTime
+	if err := DB.QueryRow("SELECT created_at FROM users WHERE id = ?", userID).Scan(&created); err != nil {
+		return nil, err
+	}
+	if !created.Valid {
+		return nil, nil
+	}
+	return &created.Time, nil
+}
+
+// UserTrustLevel works out a user's trust level.
+func UserTrustLevel(userID int) (int, error) {
+	role, err := GetUserRole(userID)
+	if err != nil {
+		return TrustNew, err
+	}
+	staff, err := RoleHasPermission(role, permissions.ModerationPanel)
+	if err != nil {
+		return TrustNew, err
+	}
+	if staff {
+		return TrustStaff, nil
+	}
+
+	var published int
+	err = DB.QueryRow(`
+		SELECT (SELECT COUNT(*) FROM threads WHERE user_id = ? AND approved = ?)
+			+ (SELECT COUNT(*) FROM comments WHERE user_id = ? AND approved = ?)`,
+		userID, ThreadApproved, userID, CommentVisible).Scan(&published)
+	if err != nil {
+		return TrustNew, err
+	}
+	if published == 0 {
+		return TrustNew, nil
+	}
+	if published < TrustMemberPosts {
+		return TrustBasic, nil
+	}
+	created, err := AccountCreatedAt(userID)
+	if err != nil {
+		return TrustNew, err
+	}
+	if created != nil && time.Since(*created) < TrustMemberDays*24*time.Hour {
+		return TrustBasic, nil
+	}
+	return TrustMember, nil
+}
+
+// CountRepeatedPosts returns how many threads and comments by the user have
+// exactly this content. excludeType and excludeID leave out the post being
+// checked when it is already stored.
+func CountRepeatedPosts(userID int, content, excludeType string, excludeID int) (int, error) {
+	var count int
+	err := DB.QueryRow(`
+		SELECT (SELECT COUNT(*) FROM threads WHERE user_id = ? AND TRIM(content) = ? AND NOT (? = 'thread' AND id = ?))
+			+ (SELECT COUNT(*) FROM comments WHERE user_id = ? AND TRIM(content) = ? AND NOT (? = 'comment' AND id = ?))`,
+		userID, content, excludeType, excludeID, userID, content, excludeType, excludeID).Scan(&count)
+	return count, err
+}
+
+// ListRecentPosts returns the latest threads and comments, newest first by
+// type, for testing rules against what was posted before.
+func ListRecentPosts(limit int) ([]models.Post, error) {
+	rows, err := DB.Query(`
+		SELECT * FROM (
+			SELECT 'thread', threads.id, threads.id, threads.user_id, COALESCE(users.username, ''), threads.title, threads.content
+			FROM threads LEFT JOIN users ON users.id = threads.user_id
+			ORDER BY threads.id DESC LIMIT ?)
+		UNION ALL
+		SELECT * FROM (
+			SELECT 'comment', comments.id, comments.thread_id, comments.user_id, COALESCE(users.username, ''), '', COALESCE(comments.content, '')
+			FROM comments LEFT JOIN users ON users.id = comments.user_id
+			ORDER BY comments.id DESC LIMIT ?)`, limit, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var posts []models.Post
+	for rows.Next() {
+		var p models.Post
+		if err := rows.Scan(&p.Type, &p.ID, &p.ThreadID, &p.UserID, &p.Username, &p.Title, &p.Content); err != nil {
+			return nil, err
+		}
+		posts = append(posts, p)
+	}
+	return posts, rows.Err()
+}
@@ -0,0 +1,128 @@
+package database
+
+import (
+	"errors"
+	"strconv"
+
+	"DytForum/models"
+)
+
+// Bulk moderation operations.
+const (
+	BulkApprove = "approve"
+	BulkReject  = "reject"
+	BulkDelete  = "delete"
+	BulkMove    = "move"
+	BulkPurge   = "purge"
+)
+
+// ErrBulkChanged is returned when some of a batch was deleted or changed
+// state after it was selected, so the batch no longer applies as a whole.
+var ErrBulkChanged = errors.New("content changed since it was selected")
+
+// BulkAction is a batch of threads and comments moderated together.
+type BulkAction struct {
+	Op         string
+	ThreadIDs  []int
+	CommentIDs []int
+	// CategoryID is where BulkMove moves the threads.
+	CategoryID int
+	// Note is the review note of rejected threads.
+	Note string
+	// Log is the audit entry recorded for the whole batch.
+	Log models.ModAction
+}
+
+// ApplyBulkAction applies a batch and records its audit entry in one
+// transaction, so either all of it happens or none of it. Approving
+// publishes queued threads and held comments; rejecting rejects threads
+// awaiting review and removes the comments; deleting and purging remove
+// both. If any item is gone or no longer in a state the operation applies
+// to, nothing changes and ErrBulkChanged is returned.
+func ApplyBulkAction(b BulkAction) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var threadQuery, commentQuery string
+	var threadArgs []interface{}
+	switch b.Op {
+	case BulkApprove:
+		threadQuery = "UPDATE threads SET approved = ?, review_note = '' WHERE id = ? AND " + QueuedThreads
+		threadArgs = []interface{}{ThreadApproved}
+		commentQuery = "UPDATE comments SET approved = " + strconv.Itoa(CommentVisible) +
+			" WHERE id = ? AND approved != " + strconv.Itoa(CommentVisible)
+	case BulkReject:
+		threadQuery = "UPDATE threads SET approved = ?, review_note = ? WHERE id = ? AND approved NOT IN (" +
+			strconv.Itoa(ThreadApproved) + ", " + strconv.Itoa(ThreadRejected) + ")"
+		threadArgs = []interface{}{ThreadRejected, b.Note}
+		commentQuery = "DELETE FROM comments WHERE id = ?"
+	case BulkDelete, BulkPurge:
+		threadQuery = "DELETE FROM threads WHERE id = ?"
+		commentQuery = "DELETE FROM comments WHERE id = ?"
+	case BulkMove:
+		threadQuery = "UPDATE threads SET category = ? WHERE id = ?"
+		threadArgs = []interface{}{strconv.Itoa(b.CategoryID)}
+	}
+
+	for _, id := range b.ThreadIDs {
+		if err := execOne(tx, threadQuery, append(threadArgs, id)...); err != nil {
+			return err
+		}
+	}
+	if commentQuery != "" {
+		for _, id := range b.CommentIDs {
+			if err := execOne(tx, commentQuery, id); err != nil {
+				return err
+			}
+		}
+	}
+	if err := insertModAction(tx, b.Log); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// execOne runs a statement that must change exactly one row of a batch.
+func execOne(db execer, query string, args ...interface{}) error {
+	res, err := db.Exec(query, args...)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n != 1 {
+		return ErrBulkChanged
+	}
+	return nil
+}
+
+// ListUserContent returns a user's threads and comments on threads matching
+// the SQL condition on threads.id, oldest first.
+func ListUserContent(userID int, threadFilter string, args ...interface{}) ([]models.Thread, []models.Comment, error) {
+	rows, err := DB.Query(`SELECT `+threadColumns+threadJoins+`
+		WHERE threads.user_id = ? AND `+threadFilter+`
+		ORDER BY threads.id`, append([]interface{}{userID}, args...)...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var threads []models.Thread
+	for rows.Next() {
+		t, err := scanThread(rows)
+		if err != nil {
+			return nil, nil, err
+		}
+		threads = append(threads, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	comments, err := queryComments("SELECT "+commentColumns+" FROM comments WHERE user_id = ? AND thread_id IN (SELECT threads.id FROM threads WHERE "+threadFilter+") ORDER BY id",
+		append([]interface{}{userID}, args...)...)
+	return threads, comments, err
+}
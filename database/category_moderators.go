@@ -0,0 +1,98 @@
+package database
+
+import "DytForum/models"
+
+// ModeratedThreadsFilter is an SQL condition on threads.id that keeps only
+// threads in categories assigned to the moderator given as its parameter.
+const ModeratedThreadsFilter = `threads.id IN (
+	SELECT thread_categories.thread_id FROM thread_categories
+	INNER JOIN category_moderators ON category_moderators.category_id = thread_categories.category_id
+	WHERE category_moderators.user_id = ?)`
+
+// AssignCategoryModerator makes a user moderator of a category.
+func AssignCategoryModerator(categoryID, userID int) error {
+	_, err := DB.Exec("INSERT INTO category_moderators (category_id, user_id) VALUES (?, ?) ON CONFLICT DO NOTHING", categoryID, userID)
+	return err
+}
+
+// RemoveCategoryModerator takes a category away from a moderator.
+func RemoveCategoryModerator(categoryID, userID int) error {
+	_, err := DB.Exec("DELETE FROM category_moderators WHERE category_id = ? AND user_id = ?", categoryID, userID)
+	return err
+}
+
+// DeleteCategory deletes a category together with its moderator
+// assignments, so a moderator is never left assigned to a missing category.
+func DeleteCategory(categoryID int) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM category_moderators WHERE category_id = ?", categoryID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM categories WHERE id = ?", categoryID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetCategoryModerators returns every category assignment.
+func GetCategoryModerators() ([]models.CategoryModerator, error) {
+	rows, err := DB.Query(`
+		SELECT categories.id, categories.name, users.id, users.username
+		FROM category_moderators
+		INNER JOIN categories ON categories.id = category_moderators.category_id
+		INNER JOIN users ON users.id = category_moderators.user_id
+		ORDER BY categories.name, users.username`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var assignments []models.CategoryModerator
+	for rows.Next() {
+		var a models.CategoryModerator
+		if err := rows.Scan(&a.CategoryID, &a.CategoryName, &a.UserID, &a.Username); err != nil {
+			return nil, err
+		}
+		assignments = append(assignments, a)
+	}
+	return assignments, rows.Err()
+}
+
+// GetModeratedCategories returns the categories assigned to a moderator.
+func GetModeratedCategories(userID int) ([]models.Category, error) {
+	rows, err := DB.Query(`
+		SELECT categories.id, categories.name FROM categories
+		INNER JOIN category_moderators ON category_moderators.category_id = categories.id
+		WHERE category_moderators.user_id = ?
+		ORDER BY categories.name`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var categories []models.Category
+	for rows.Next() {
+		var category models.Category
+		if err := rows.Scan(&category.ID, &category.Name); err != nil {
+			return nil, err
+		}
+		categories = append(categories, category)
+	}
+	return categories, rows.Err()
+}
+
+// ModeratesThread reports whether the thread belongs to a category assigned
+// to the moderator.
+func ModeratesThread(userID, threadID int) (bool, error) {
+	var count int
+	err := DB.QueryRow(`
+		SELECT COUNT(*) FROM thread_categories
+		INNER JOIN category_moderators ON category_moderators.category_id = thread_categories.category_id
+		WHERE thread_categories.thread_id = ? AND category_moderators.user_id = ?`, threadID, userID).Scan(&count)
+	return count > 0, err
+}
@@ -0,0 +1,94 @@
+package database
+
+import (
+	"DytForum/models"
+)
+
+// Comment states, stored in comments.approved. Comments are published
+// unless automoderation holds or shadow-hides them; the values match the
+// thread review states.
+const (
+	CommentHeld         = ThreadPending
+	CommentVisible      = ThreadApproved
+	CommentShadowHidden = ThreadShadowHidden
+)
+
+const commentColumns = `id, thread_id, user_id, COALESCE(content, ''), likes, dislikes, COALESCE(username, ''), approved, spam_score`
+
+func queryComments(query string, args ...interface{}) ([]models.Comment, error) {
+	rows, err := DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	comments := []models.Comment{}
+	for rows.Next() {
+		var c models.Comment
+		if err := rows.Scan(&c.ID, &c.ThreadID, &c.UserID, &c.Content, &c.Likes, &c.Dislikes, &c.Username, &c.Approved, &c.SpamScore); err != nil {
+			return nil, err
+		}
+		comments = append(comments, c)
+	}
+	return comments, rows.Err()
+}
+
+// ListComments returns the published comments of a thread, and the viewer's
+// own held or hidden ones, oldest first. After, when non-zero, only returns
+// comments with a larger ID.
+func ListComments(threadID, viewerID, after, limit int) ([]models.Comment, error) {
+	return queryComments("SELECT "+commentColumns+" FROM comments WHERE thread_id = ? AND (approved = ? OR user_id = ?) AND id > ? ORDER BY id LIMIT ?",
+		threadID, CommentVisible, viewerID, after, limit)
+}
+
+// CommentsAround returns a comment together with up to n comments written
+// before and after it in the same thread, oldest first.
+func CommentsAround(comment models.Comment, n int) ([]models.Comment, error) {
+	return queryComments(`
+		SELECT `+commentColumns+` FROM comments WHERE id IN (
+			SELECT id FROM (SELECT id FROM comments WHERE thread_id = ? AND id < ? ORDER BY id DESC LIMIT ?)
+			UNION SELECT id FROM (SELECT id FROM comments WHERE thread_id = ? AND id >= ? ORDER BY id LIMIT ?))
+		ORDER BY id`, comment.ThreadID, comment.ID, n, comment.ThreadID, comment.ID, n+1)
+}
+
+// GetComment returns one comment or sql.ErrNoRows.
+func GetComment(commentID int) (models.Comment, error) {
+	var c models.Comment
+	err := DB.QueryRow("SELECT "+commentColumns+" FROM comments WHERE id = ?", commentID).Scan(&c.ID, &c.ThreadID, &c.UserID, &c.Content, &c.Likes, &c.Dislikes, &c.Username, &c.Approved, &c.SpamScore)
+	return c, err
+}
+
+// CreateComment stores a comment in the given state and returns its ID.
+func CreateComment(userID, threadID int, content, username string, state int) (int, error) {
+	res, err := DB.Exec("INSERT INTO comments (user_id, thread_id, content, username, approved) VALUES (?, ?, ?, ?, ?)", userID, threadID, content, username, state)
+	if err != nil {
+		return 0, err
+	}
+	id, err := res.LastInsertId()
+	return int(id), err
+}
+
+// SetCommentState publishes, holds or hides a comment.
+func SetCommentState(commentID, state int) error {
+	_, err := DB.Exec("UPDATE comments SET approved = ? WHERE id = ?", state, commentID)
+	return err
+}
+
+// ListHeldComments returns the held and shadow-hidden comments on threads
+// matching the SQL condition on threads.id, oldest first.
+func ListHeldComments(threadFilter string, args ...interface{}) ([]models.Comment, error) {
+	args = append([]interface{}{CommentHeld, CommentShadowHidden}, args...)
+	return queryComments("SELECT "+commentColumns+" FROM comments WHERE approved IN (?, ?) AND thread_id IN (SELECT threads.id FROM threads WHERE "+threadFilter+") ORDER BY id", args...)
+}
+
+// UpdateComment replaces the content of a comment and sets its state.
+func UpdateComment(commentID int, content string, state int) error {
+	_, err := DB.Exec("UPDATE comments SET content = ?, approved = ? WHERE id = ?", content, state, commentID)
+	return err
+}
+
+// DeleteComment removes a comment.
+func DeleteComment(commentID int) error {
+	_, err := DB.Exec("DELETE FROM comments WHERE id = ?", commentID)
+	return err
+}
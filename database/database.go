@@ -3,24 +3,68 @@ package database
 import (
 	"database/sql"
 	"fmt"
+	"log/slog"
+	"strings"
 
 	"DytForum/models"
-
-	_ "github.com/mattn/go-sqlite3"
 )
 
 var DB *sql.DB
 
+// execer runs statements on DB or inside a transaction, for writes that
+// must happen together with others.
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// InitDB opens the database and brings its schema up to date.
 func InitDB(dataSourceName string) error {
+	if err := Open(dataSourceName); err != nil {
+		return err
+	}
+	return Setup()
+}
+
+// busyTimeout is how long, in milliseconds, a statement waits for another
+// connection's write lock before failing with "database is locked".
+const busyTimeout = 5000
+
+// Open connects to the database without touching the schema.
+func Open(dataSourceName string) error {
 	var err error
-	DB, err = sql.Open("sqlite3", dataSourceName)
+	DB, err = sql.Open(driverName, withBusyTimeout(dataSourceName))
 	if err != nil {
 		return err
 	}
-	if err = DB.Ping(); err != nil {
+	return DB.Ping()
+}
+
+// withBusyTimeout adds the busy timeout to a data source name that does not
+// set one.
+func withBusyTimeout(dsn string) string {
+	if strings.Contains(dsn, "_busy_timeout=") || strings.Contains(dsn, "_timeout=") {
+		return dsn
+	}
+	sep := "?"
+	if strings.Contains(dsn, "?") {
+		sep = "&"
+	}
+	return fmt.Sprintf("%s%s_busy_timeout=%d", dsn, sep, busyTimeout)
+}
+
+// Setup creates missing tables and applies pending migrations.
+func Setup() error {
+	if err := createTables(); err != nil {
+		return err
+	}
+	applied, err := Migrate()
+	if err != nil {
 		return err
 	}
-	return createTables()
+	for _, m := range applied {
+		slog.Info("applied migration", "version", m.Version, "name", m.Name)
+	}
+	return nil
 }
 
 func createTables() error {
@@ -79,6 +123,22 @@ func createTables() error {
 		role_name VARCHAR(20) NOT NULL UNIQUE
 	);
 	`
+	permissionsTable := `
+	CREATE TABLE IF NOT EXISTS permissions (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL UNIQUE,
+		description TEXT NOT NULL
+	);
+	`
+	rolePermissionsTable := `
+	CREATE TABLE IF NOT EXISTS role_permissions (
+		role_id INTEGER NOT NULL,
+		permission_id INTEGER NOT NULL,
+		PRIMARY KEY(role_id, permission_id),
+		FOREIGN KEY(role_id) REFERENCES roles(id),
+		FOREIGN KEY(permission_id) REFERENCES permissions(id)
+	);
+	`
 	moderatorRequestsTable := `
 	CREATE TABLE IF NOT EXISTS moderator_requests (
 		id INTEGER PRIMARY KEY AUTOINCREMENT,
@@ -94,6 +154,15 @@ CREATE TABLE IF NOT EXISTS categories (
 	name TEXT NOT NULL UNIQUE
 );
 `
+	categoryModeratorsTable := `
+	CREATE TABLE IF NOT EXISTS category_moderators (
+		category_id INTEGER NOT NULL,
+		user_id INTEGER NOT NULL,
+		PRIMARY KEY(category_id, user_id),
+		FOREIGN KEY(category_id) REFERENCES categories(id),
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	`
 	reportsTable := `
 	CREATE TABLE IF NOT EXISTS reports (
 		id INTEGER PRIMARY KEY AUTOINCREMENT,
@@ -104,6 +173,56 @@ CREATE TABLE IF NOT EXISTS categories (
 		FOREIGN KEY(user_id) REFERENCES users(id)
 	);
 	`
+	twoFactorTable := `
+	CREATE TABLE IF NOT EXISTS two_factor (
+		user_id INTEGER PRIMARY KEY,
+		secret TEXT NOT NULL,
+		enabled INTEGER DEFAULT 0,
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	`
+	recoveryCodesTable := `
+	CREATE TABLE IF NOT EXISTS recovery_codes (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL,
+		code_hash TEXT NOT NULL,
+		used INTEGER DEFAULT 0,
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	`
+	loginAttemptsTable := `
+	CREATE TABLE IF NOT EXISTS login_attempts (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		username TEXT NOT NULL,
+		ip TEXT NOT NULL,
+		success INTEGER NOT NULL,
+		created_at DATETIME NOT NULL
+	);
+	`
+	loginThrottlesTable := `
+	CREATE TABLE IF NOT EXISTS login_throttles (
+		key TEXT PRIMARY KEY,
+		failures INTEGER NOT NULL DEFAULT 0,
+		locked_until DATETIME NOT NULL,
+		last_failure DATETIME NOT NULL
+	);
+	`
+	notificationsTable := `
+	CREATE TABLE IF NOT EXISTS notifications (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL,
+		message TEXT NOT NULL,
+		is_read INTEGER DEFAULT 0,
+		created_at DATETIME NOT NULL,
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	`
+	settingsTable := `
+	CREATE TABLE IF NOT EXISTS settings (
+		key TEXT PRIMARY KEY,
+		value TEXT NOT NULL
+	);
+	`
 
 	_, err := DB.Exec(usersTable)
 	if err != nil {
@@ -126,6 +245,14 @@ CREATE TABLE IF NOT EXISTS categories (
 	if err != nil {
 		return fmt.Errorf("error creating roles table: %v", err)
 	}
+	_, err = DB.Exec(permissionsTable)
+	if err != nil {
+		return fmt.Errorf("error creating permissions table: %v", err)
+	}
+	_, err = DB.Exec(rolePermissionsTable)
+	if err != nil {
+		return fmt.Errorf("error creating role permissions table: %v", err)
+	}
 	_, err = DB.Exec(moderatorRequestsTable)
 	if err != nil {
 		return fmt.Errorf("error creating moderator requests table: %v", err)
@@ -134,14 +261,49 @@ CREATE TABLE IF NOT EXISTS categories (
 	if err != nil {
 		return fmt.Errorf("error creating categories table: %v", err)
 	}
+	_, err = DB.Exec(categoryModeratorsTable)
+	if err != nil {
+		return fmt.Errorf("error creating category moderators table: %v", err)
+	}
+	_, err = DB.Exec(threadCategoriesView)
+	if err != nil {
+		return fmt.Errorf("error creating thread categories view: %v", err)
+	}
 	_, err = DB.Exec(reportsTable)
 	if err != nil {
 		return fmt.Errorf("error creating reports table: %v", err)
 	}
+	_, err = DB.Exec(twoFactorTable)
+	if err != nil {
+		return fmt.Errorf("error creating two factor table: %v", err)
+	}
+	_, err = DB.Exec(recoveryCodesTable)
+	if err != nil {
+		return fmt.Errorf("error creating recovery codes table: %v", err)
+	}
+	_, err = DB.Exec(loginAttemptsTable)
+	if err != nil {
+		return fmt.Errorf("error creating login attempts table: %v", err)
+	}
+	_, err = DB.Exec(loginThrottlesTable)
+	if err != nil {
+		return fmt.Errorf("error creating login throttles table: %v", err)
+	}
+	_, err = DB.Exec(notificationsTable)
+	if err != nil {
+		return fmt.Errorf("error creating notifications table: %v", err)
+	}
+	_, err = DB.Exec(settingsTable)
+	if err != nil {
+		return fmt.Errorf("error creating settings table: %v", err)
+	}
 	_, err = DB.Exec(`INSERT INTO roles (role_name) VALUES ('user'), ('moderator'), ('admin') ON CONFLICT(role_name) DO NOTHING;`)
 	if err != nil {
 		return fmt.Errorf("error inserting roles: %v", err)
 	}
+	if err = seedPermissions(); err != nil {
+		return fmt.Errorf("error seeding permissions: %v", err)
+	}
 	return nil
 }
 
@@ -178,7 +340,7 @@ func GetUserByUsername(username string) (models.User, error) {
 }
 
 func GetThreadsByUserID(userID int) ([]models.Thread, error) {
-	rows, err := DB.Query("SELECT id, title, content, category, likes, dislikes FROM threads WHERE user_id = ?", userID)
+	rows, err := DB.Query("SELECT id, title, content, "+ThreadCategoryName+", likes, dislikes, approved, review_note FROM threads WHERE user_id = ?", userID)
 	if err != nil {
 		return nil, err
 	}
@@ -187,7 +349,7 @@ func GetThreadsByUserID(userID int) ([]models.Thread, error) {
 	var threads []models.Thread
 	for rows.Next() {
 		var thread models.Thread
-		err := rows.Scan(&thread.ID, &thread.Title, &thread.Content, &thread.Category, &thread.Likes, &thread.Dislikes)
+		err := rows.Scan(&thread.ID, &thread.Title, &thread.Content, &thread.Category, &thread.Likes, &thread.Dislikes, &thread.Approved, &thread.ReviewNote)
 		if err != nil {
 			return nil, err
 		}
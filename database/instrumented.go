@@ -0,0 +1,106 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"time"
+
+	"DytForum/metrics"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// driverName is the sqlite3 driver wrapped so every statement is timed.
+const driverName = "sqlite3_timed"
+
+var queryDuration = metrics.NewHistogramVec(
+	"forum_db_query_duration_seconds",
+	"Time spent executing database statements.",
+	metrics.DefaultBuckets, "op",
+)
+
+func init() {
+	sql.Register(driverName, timedDriver{&sqlite3.SQLiteDriver{}})
+	metrics.NewGaugeVecFunc("forum_db_connections", "Database connections in the pool by state.", func() ([]metrics.Sample, error) {
+		if DB == nil {
+			return nil, nil
+		}
+		s := DB.Stats()
+		return []metrics.Sample{
+			{LabelValues: []string{"open"}, Value: float64(s.OpenConnections)},
+			{LabelValues: []string{"in_use"}, Value: float64(s.InUse)},
+			{LabelValues: []string{"idle"}, Value: float64(s.Idle)},
+		}, nil
+	}, "state")
+	metrics.NewGaugeFunc("forum_db_wait_count", "Total connections waited for.", func() (float64, error) {
+		if DB == nil {
+			return 0, nil
+		}
+		return float64(DB.Stats().WaitCount), nil
+	})
+	metrics.NewGaugeFunc("forum_db_wait_duration_seconds", "Total time spent waiting for a connection.", func() (float64, error) {
+		if DB == nil {
+			return 0, nil
+		}
+		return DB.Stats().WaitDuration.Seconds(), nil
+	})
+}
+
+func observeQuery(op string, start time.Time) {
+	queryDuration.ObserveDuration(time.Since(start), op)
+}
+
+type timedDriver struct {
+	*sqlite3.SQLiteDriver
+}
+
+func (d timedDriver) Open(dsn string) (driver.Conn, error) {
+	conn, err := d.SQLiteDriver.Open(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return &timedConn{conn.(*sqlite3.SQLiteConn)}, nil
+}
+
+// timedConn times ExecContext and QueryContext and the statements it
+// prepares. Everything else goes straight to the sqlite3 connection.
+type timedConn struct {
+	*sqlite3.SQLiteConn
+}
+
+func (c *timedConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	defer observeQuery("exec", time.Now())
+	return c.SQLiteConn.ExecContext(ctx, query, args)
+}
+
+func (c *timedConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	defer observeQuery("query", time.Now())
+	return c.SQLiteConn.QueryContext(ctx, query, args)
+}
+
+func (c *timedConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	stmt, err := c.SQLiteConn.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return &timedStmt{stmt.(*sqlite3.SQLiteStmt)}, nil
+}
+
+func (c *timedConn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+type timedStmt struct {
+	*sqlite3.SQLiteStmt
+}
+
+func (s *timedStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	defer observeQuery("exec", time.Now())
+	return s.SQLiteStmt.ExecContext(ctx, args)
+}
+
+func (s *timedStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	defer observeQuery("query", time.Now())
+	return s.SQLiteStmt.QueryContext(ctx, args)
+}
@@ -0,0 +1,110 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"DytForum/models"
+)
+
+// RecordLoginAttempt stores a single password check for the security log.
+func RecordLoginAttempt(username, ip string, success bool) error {
+	ok := 0
+	if success {
+		ok = 1
+	}
+	_, err := DB.Exec("INSERT INTO login_attempts (username, ip, success, created_at) VALUES (?, ?, ?, ?)",
+		username, ip, ok, time.Now().UTC())
+	return err
+}
+
+// RecentFailedLogins returns the latest failed login attempts, newest first.
+func RecentFailedLogins(limit int) ([]models.LoginAttempt, error) {
+	rows, err := DB.Query("SELECT id, username, ip, success, created_at FROM login_attempts WHERE success = 0 ORDER BY id DESC LIMIT ?", limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var attempts []models.LoginAttempt
+	for rows.Next() {
+		var attempt models.LoginAttempt
+		var success int
+		if err := rows.Scan(&attempt.ID, &attempt.Username, &attempt.IP, &success, &attempt.CreatedAt); err != nil {
+			return nil, err
+		}
+		attempt.Success = success == 1
+		attempts = append(attempts, attempt)
+	}
+	return attempts, rows.Err()
+}
+
+// GetLoginThrottle returns the throttle state stored under key. Keys that
+// have no failures yet return a zero value.
+func GetLoginThrottle(key string) (models.LoginThrottle, error) {
+	throttle := models.LoginThrottle{Key: key}
+	err := DB.QueryRow("SELECT failures, locked_until, last_failure FROM login_throttles WHERE key = ?", key).
+		Scan(&throttle.Failures, &throttle.LockedUntil, &throttle.LastFailure)
+	if err == sql.ErrNoRows {
+		return throttle, nil
+	}
+	return throttle, err
+}
+
+// SaveLoginThrottle inserts or replaces the throttle state for its key.
+func SaveLoginThrottle(throttle models.LoginThrottle) error {
+	_, err := DB.Exec(`INSERT INTO login_throttles (key, failures, locked_until, last_failure) VALUES (?, ?, ?, ?)
+		ON CONFLICT(key) DO UPDATE SET failures = excluded.failures, locked_until = excluded.locked_until, last_failure = excluded.last_failure`,
+		throttle.Key, throttle.Failures, throttle.LockedUntil.UTC(), throttle.LastFailure.UTC())
+	return err
+}
+
+// ClearLoginThrottle forgets all failures recorded under key.
+func ClearLoginThrottle(key string) error {
+	_, err := DB.Exec("DELETE FROM login_throttles WHERE key = ?", key)
+	return err
+}
+
+// LockedLoginThrottles returns every throttle that is locked at the given time.
+func LockedLoginThrottles(now time.Time) ([]models.LoginThrottle, error) {
+	rows, err := DB.Query("SELECT key, failures, locked_until, last_failure FROM login_throttles WHERE locked_until > ? ORDER BY locked_until DESC", now.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var throttles []models.LoginThrottle
+	for rows.Next() {
+		var throttle models.LoginThrottle
+		if err := rows.Scan(&throttle.Key, &throttle.Failures, &throttle.LockedUntil, &throttle.LastFailure); err != nil {
+			return nil, err
+		}
+		throttles = append(throttles, throttle)
+	}
+	return throttles, rows.Err()
+}
+
+// CreateLoginChallenge stores the answer to a login challenge under nonce
+// until expires and forgets challenges that expired unanswered.
+func CreateLoginChallenge(nonce string, answer int, expires time.Time) error {
+	if _, err := DB.Exec("DELETE FROM login_challenges WHERE expires_at <= ?", time.Now().UTC()); err != nil {
+		return err
+	}
+	_, err := DB.Exec("INSERT INTO login_challenges (nonce, answer, expires_at) VALUES (?, ?, ?)", nonce, answer, expires.UTC())
+	return err
+}
+
+// TakeLoginChallenge returns the answer stored under nonce and deletes it,
+// so every challenge can be answered once. ok is false when the nonce is
+// unknown, already used or expired.
+func TakeLoginChallenge(nonce string) (answer int, ok bool, err error) {
+	var expires time.Time
+	err = DB.QueryRow("DELETE FROM login_challenges WHERE nonce = ? RETURNING answer, expires_at", nonce).Scan(&answer, &expires)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return answer, time.Now().Before(expires), nil
+}
@@ -0,0 +1,478 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// migration changes the schema after the base tables from createTables exist.
+// Versions must be unique and increasing; applied versions are recorded in
+// schema_migrations so each migration runs exactly once.
+type migration struct {
+	Version int
+	Name    string
+	Up      func(tx *sql.Tx) error
+}
+
+var migrations = []migration{
+	{1, "add users.disabled", func(tx *sql.Tx) error {
+		_, err := tx.Exec("ALTER TABLE users ADD COLUMN disabled INTEGER NOT NULL DEFAULT 0")
+		return err
+	}},
+	{2, "create threads search index", func(tx *sql.Tx) error {
+		statements := []string{
+			`CREATE VIRTUAL TABLE IF NOT EXISTS threads_search USING fts4(title, content)`,
+			`CREATE TRIGGER IF NOT EXISTS threads_search_insert AFTER INSERT ON threads BEGIN
+				INSERT INTO threads_search (docid, title, content) VALUES (new.id, new.title, new.content);
+			END`,
+			`CREATE TRIGGER IF NOT EXISTS threads_search_update AFTER UPDATE OF title, content ON threads BEGIN
+				DELETE FROM threads_search WHERE docid = old.id;
+				INSERT INTO threads_search (docid, title, content) VALUES (new.id, new.title, new.content);
+			END`,
+			`CREATE TRIGGER IF NOT EXISTS threads_search_delete AFTER DELETE ON threads BEGIN
+				DELETE FROM threads_search WHERE docid = old.id;
+			END`,
+			`INSERT INTO threads_search (docid, title, content) SELECT id, title, content FROM threads`,
+		}
+		for _, stmt := range statements {
+			if _, err := tx.Exec(stmt); err != nil {
+				return err
+			}
+		}
+		return nil
+	}},
+	{3, "create api_tokens", func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+		CREATE TABLE api_tokens (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			name TEXT NOT NULL,
+			token_hash TEXT NOT NULL UNIQUE,
+			prefix TEXT NOT NULL,
+			scopes TEXT NOT NULL,
+			created_at DATETIME NOT NULL,
+			expires_at DATETIME NOT NULL,
+			last_used_at DATETIME,
+			revoked_at DATETIME,
+			FOREIGN KEY(user_id) REFERENCES users(id)
+		)`)
+		return err
+	}},
+	{4, "add report workflow columns", func(tx *sql.Tx) error {
+		// Some databases already have a hand-added reports.status column
+		// holding 'pending' and 'approved'; keep it and map the values.
+		hasStatus, err := hasColumn(tx, "reports", "status")
+		if err != nil {
+			return err
+		}
+		var statements []string
+		if !hasStatus {
+			statements = append(statements, `ALTER TABLE reports ADD COLUMN status TEXT NOT NULL DEFAULT 'open'`)
+		}
+		statements = append(statements,
+			`UPDATE reports SET status = CASE status WHEN 'approved' THEN 'actioned' WHEN 'rejected' THEN 'dismissed' ELSE 'open' END`,
+			`ALTER TABLE reports ADD COLUMN duplicate_of INTEGER REFERENCES reports(id)`,
+			`ALTER TABLE reports ADD COLUMN assigned_to INTEGER REFERENCES users(id)`,
+			`ALTER TABLE reports ADD COLUMN resolution TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE reports ADD COLUMN resolution_note TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE reports ADD COLUMN resolved_by INTEGER REFERENCES users(id)`,
+			`ALTER TABLE reports ADD COLUMN created_at DATETIME`,
+			`ALTER TABLE reports ADD COLUMN resolved_at DATETIME`,
+			// Open reports about the same thread become duplicates of the first one.
+			`UPDATE reports SET duplicate_of = (
+				SELECT MIN(first.id) FROM reports first WHERE first.thread_id = reports.thread_id AND first.status = 'open')
+			WHERE status = 'open' AND id > (
+				SELECT MIN(first.id) FROM reports first WHERE first.thread_id = reports.thread_id AND first.status = 'open')`,
+			`CREATE INDEX reports_thread_status ON reports (thread_id, status)`,
+			`CREATE INDEX reports_duplicate_of ON reports (duplicate_of)`,
+		)
+		for _, stmt := range statements {
+			if _, err := tx.Exec(stmt); err != nil {
+				return err
+			}
+		}
+		return nil
+	}},
+	{5, "add report targets and reasons", func(tx *sql.Tx) error {
+		statements := []string{
+			`ALTER TABLE reports ADD COLUMN target_type TEXT NOT NULL DEFAULT 'thread'`,
+			`ALTER TABLE reports ADD COLUMN target_id INTEGER NOT NULL DEFAULT 0`,
+			`ALTER TABLE reports ADD COLUMN details TEXT NOT NULL DEFAULT ''`,
+			`UPDATE reports SET target_id = thread_id`,
+			`DROP INDEX reports_thread_status`,
+			`CREATE INDEX reports_target_status ON reports (target_type, target_id, status)`,
+			`CREATE TABLE report_reasons (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				name TEXT NOT NULL UNIQUE
+			)`,
+			// The reasons the report form used to offer.
+			`INSERT INTO report_reasons (name) VALUES ('irrelevant'), ('obscene'), ('illegal'), ('offensive')`,
+		}
+		for _, stmt := range statements {
+			if _, err := tx.Exec(stmt); err != nil {
+				return err
+			}
+		}
+		return nil
+	}},
+	{6, "create mod_actions", func(tx *sql.Tx) error {
+		statements := []string{
+			`CREATE TABLE mod_actions (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				actor_id INTEGER NOT NULL,
+				action TEXT NOT NULL,
+				target_type TEXT NOT NULL,
+				target_id INTEGER NOT NULL,
+				before TEXT NOT NULL DEFAULT '',
+				after TEXT NOT NULL DEFAULT '',
+				reason TEXT NOT NULL DEFAULT '',
+				created_at DATETIME NOT NULL,
+				FOREIGN KEY(actor_id) REFERENCES users(id)
+			)`,
+			`CREATE INDEX mod_actions_actor ON mod_actions (actor_id, id)`,
+			`CREATE INDEX mod_actions_action ON mod_actions (action, id)`,
+			// The log is append-only.
+			`CREATE TRIGGER mod_actions_no_update BEFORE UPDATE ON mod_actions BEGIN
+				SELECT RAISE(ABORT, 'mod_actions is append-only');
+			END`,
+			`CREATE TRIGGER mod_actions_no_delete BEFORE DELETE ON mod_actions BEGIN
+				SELECT RAISE(ABORT, 'mod_actions is append-only');
+			END`,
+		}
+		for _, stmt := range statements {
+			if _, err := tx.Exec(stmt); err != nil {
+				return err
+			}
+		}
+		return nil
+	}},
+	{7, "create user_sanctions and registration_bans", func(tx *sql.Tx) error {
+		statements := []string{
+			`CREATE TABLE user_sanctions (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				user_id INTEGER NOT NULL,
+				kind TEXT NOT NULL,
+				reason TEXT NOT NULL,
+				issued_by INTEGER NOT NULL,
+				created_at DATETIME NOT NULL,
+				expires_at DATETIME,
+				revoked_at DATETIME,
+				revoked_by INTEGER,
+				FOREIGN KEY(user_id) REFERENCES users(id),
+				FOREIGN KEY(issued_by) REFERENCES users(id)
+			)`,
+			`CREATE INDEX user_sanctions_user ON user_sanctions (user_id, kind)`,
+			`CREATE TABLE registration_bans (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				kind TEXT NOT NULL,
+				value TEXT NOT NULL,
+				reason TEXT NOT NULL,
+				created_by INTEGER NOT NULL,
+				created_at DATETIME NOT NULL,
+				expires_at DATETIME,
+				UNIQUE(kind, value)
+			)`,
+		}
+		for _, stmt := range statements {
+			if _, err := tx.Exec(stmt); err != nil {
+				return err
+			}
+		}
+		return nil
+	}},
+	{8, "create appeals", func(tx *sql.Tx) error {
+		statements := []string{
+			`CREATE TABLE appeals (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				mod_action_id INTEGER NOT NULL UNIQUE,
+				user_id INTEGER NOT NULL,
+				message TEXT NOT NULL,
+				status TEXT NOT NULL DEFAULT 'pending',
+				assigned_to INTEGER,
+				decided_by INTEGER,
+				decision_note TEXT NOT NULL DEFAULT '',
+				created_at DATETIME NOT NULL,
+				decided_at DATETIME,
+				FOREIGN KEY(mod_action_id) REFERENCES mod_actions(id),
+				FOREIGN KEY(user_id) REFERENCES users(id)
+			)`,
+			`CREATE INDEX appeals_status ON appeals (status, assigned_to)`,
+		}
+		for _, stmt := range statements {
+			if _, err := tx.Exec(stmt); err != nil {
+				return err
+			}
+		}
+		return nil
+	}},
+	{9, "add thread review notes", func(tx *sql.Tx) error {
+		_, err := tx.Exec("ALTER TABLE threads ADD COLUMN review_note TEXT NOT NULL DEFAULT ''")
+		return err
+	}},
+	{10, "create automoderation", func(tx *sql.Tx) error {
+		statements := []string{
+			// Accounts created before this migration keep a NULL creation
+			// time and count as established.
+			`ALTER TABLE users ADD COLUMN created_at DATETIME`,
+			`CREATE TRIGGER users_created_at AFTER INSERT ON users WHEN new.created_at IS NULL BEGIN
+				UPDATE users SET created_at = CURRENT_TIMESTAMP WHERE id = new.id;
+			END`,
+			`ALTER TABLE comments ADD COLUMN approved INTEGER NOT NULL DEFAULT 1`,
+			`CREATE TABLE automod_rules (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				name TEXT NOT NULL,
+				target TEXT NOT NULL,
+				words TEXT NOT NULL DEFAULT '',
+				pattern TEXT NOT NULL DEFAULT '',
+				min_links INTEGER NOT NULL DEFAULT 0,
+				max_account_days INTEGER NOT NULL DEFAULT 0,
+				max_trust_level INTEGER,
+				min_repeats INTEGER NOT NULL DEFAULT 0,
+				action TEXT NOT NULL,
+				enabled INTEGER NOT NULL DEFAULT 1,
+				created_at DATETIME NOT NULL
+			)`,
+			`CREATE TABLE automod_hits (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				rule_id INTEGER NOT NULL,
+				target_type TEXT NOT NULL,
+				target_id INTEGER NOT NULL,
+				thread_id INTEGER NOT NULL,
+				user_id INTEGER NOT NULL,
+				action TEXT NOT NULL,
+				created_at DATETIME NOT NULL,
+				FOREIGN KEY(rule_id) REFERENCES automod_rules(id)
+			)`,
+			`CREATE INDEX automod_hits_rule ON automod_hits (rule_id, created_at)`,
+		}
+		for _, stmt := range statements {
+			if _, err := tx.Exec(stmt); err != nil {
+				return err
+			}
+		}
+		return nil
+	}},
+	{11, "create spam filter", func(tx *sql.Tx) error {
+		statements := []string{
+			// Scores are percentages; NULL means the post was not scored.
+			`ALTER TABLE threads ADD COLUMN spam_score INTEGER`,
+			`ALTER TABLE comments ADD COLUMN spam_score INTEGER`,
+			`CREATE TABLE spam_examples (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				target_type TEXT NOT NULL,
+				target_id INTEGER NOT NULL,
+				spam INTEGER NOT NULL,
+				content TEXT NOT NULL,
+				created_at DATETIME NOT NULL,
+				UNIQUE(target_type, target_id)
+			)`,
+			`CREATE TABLE spam_tokens (
+				token TEXT PRIMARY KEY,
+				spam INTEGER NOT NULL DEFAULT 0,
+				ham INTEGER NOT NULL DEFAULT 0
+			)`,
+		}
+		for _, stmt := range statements {
+			if _, err := tx.Exec(stmt); err != nil {
+				return err
+			}
+		}
+		return nil
+	}},
+	{12, "create rate limits", func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`CREATE TABLE rate_limits (
+			key TEXT PRIMARY KEY,
+			tokens REAL NOT NULL,
+			updated DATETIME NOT NULL,
+			full_at DATETIME NOT NULL
+		)`); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`CREATE INDEX rate_limits_full_at ON rate_limits (full_at)`)
+		return err
+	}},
+	{13, "forget threads rejected for other reasons than spam", func(tx *sql.Tx) error {
+		// Every rejection used to be learned as spam.
+		_, err := tx.Exec(`DELETE FROM spam_examples WHERE target_type = 'thread' AND spam = 1 AND target_id IN (
+			SELECT threads.id FROM threads WHERE threads.approved = ? AND NOT `+spamRejection+`)`,
+			ThreadRejected, SpamRejectionReason+"%")
+		if err != nil {
+			return err
+		}
+		_, err = rebuildSpamTokens(tx)
+		return err
+	}},
+	{14, "remember last two-factor step", func(tx *sql.Tx) error {
+		_, err := tx.Exec(`ALTER TABLE two_factor ADD COLUMN last_step INTEGER NOT NULL DEFAULT 0`)
+		return err
+	}},
+	{15, "store thread categories as IDs", func(tx *sql.Tx) error {
+		// Threads created from the old form stored the category name.
+		_, err := tx.Exec(`UPDATE threads SET category = (
+			SELECT CAST(categories.id AS TEXT) FROM categories WHERE categories.name = threads.category)
+			WHERE category NOT IN (SELECT CAST(id AS TEXT) FROM categories)
+			AND category IN (SELECT name FROM categories)`)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`DROP VIEW IF EXISTS thread_categories`); err != nil {
+			return err
+		}
+		_, err = tx.Exec(threadCategoriesView)
+		return err
+	}},
+	{16, "create login challenges", func(tx *sql.Tx) error {
+		_, err := tx.Exec(`CREATE TABLE login_challenges (
+			nonce TEXT PRIMARY KEY,
+			answer INTEGER NOT NULL,
+			expires_at DATETIME NOT NULL
+		)`)
+		return err
+	}},
+}
+
+// hasColumn reports whether table has a column with the given name.
+func hasColumn(tx *sql.Tx, table, column string) (bool, error) {
+	var count int
+	err := tx.QueryRow("SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?", table, column).Scan(&count)
+	return count > 0, err
+}
+
+// MigrationStatus describes one known migration and whether it has run.
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	AppliedAt *time.Time
+}
+
+func ensureMigrationsTable() error {
+	_, err := DB.Exec(`
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at DATETIME NOT NULL
+	);
+	`)
+	return err
+}
+
+// Migrate applies every pending migration in order and returns the ones it ran.
+func Migrate() ([]MigrationStatus, error) {
+	if err := ensureMigrationsTable(); err != nil {
+		return nil, fmt.Errorf("error creating schema migrations table: %v", err)
+	}
+
+	statuses, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+
+	var applied []MigrationStatus
+	for i, m := range migrations {
+		if statuses[i].AppliedAt != nil {
+			continue
+		}
+		tx, err := DB.Begin()
+		if err != nil {
+			return applied, err
+		}
+		if err := m.Up(tx); err != nil {
+			tx.Rollback()
+			return applied, fmt.Errorf("migration %d (%s): %v", m.Version, m.Name, err)
+		}
+		now := time.Now().UTC()
+		if _, err := tx.Exec("INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)", m.Version, m.Name, now); err != nil {
+			tx.Rollback()
+			return applied, err
+		}
+		if err := tx.Commit(); err != nil {
+			return applied, err
+		}
+		applied = append(applied, MigrationStatus{Version: m.Version, Name: m.Name, AppliedAt: &now})
+	}
+	return applied, nil
+}
+
+// Migrations lists every known migration with the time it was applied, if any.
+func Migrations() ([]MigrationStatus, error) {
+	if err := ensureMigrationsTable(); err != nil {
+		return nil, err
+	}
+
+	rows, err := DB.Query("SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	appliedAt := map[int]time.Time{}
+	for rows.Next() {
+		var version int
+		var at time.Time
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, err
+		}
+		appliedAt[version] = at
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	statuses := make([]MigrationStatus, 0, len(migrations))
+	for _, m := range migrations {
+		status := MigrationStatus{Version: m.Version, Name: m.Name}
+		if at, ok := appliedAt[m.Version]; ok {
+			status.AppliedAt = &at
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// CheckMigrations returns how many known migrations have not run yet
+// without changing the database, unlike PendingMigrations, which creates
+// the migrations table when it is missing. It is meant for health checks.
+func CheckMigrations(ctx context.Context) (int, error) {
+	rows, err := DB.QueryContext(ctx, "SELECT version FROM schema_migrations")
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	applied := map[int]bool{}
+	for rows.Next() {
+		var version int
+		if err := rows.Scan(&version); err != nil {
+			return 0, err
+		}
+		applied[version] = true
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	pending := 0
+	for _, m := range migrations {
+		if !applied[m.Version] {
+			pending++
+		}
+	}
+	return pending, nil
+}
+
+// PendingMigrations returns how many known migrations have not run yet.
+func PendingMigrations() (int, error) {
+	statuses, err := Migrations()
+	if err != nil {
+		return 0, err
+	}
+	pending := 0
+	for _, status := range statuses {
+		if status.AppliedAt == nil {
+			pending++
+		}
+	}
+	return pending, nil
+}
@@ -0,0 +1,206 @@
+package database
+
+import (
+	"strings"
+	"time"
+
+	"DytForum/models"
+)
+
+// Actions recorded in the moderation audit log.
+const (
+	ModThreadApprove          = "thread.approve"
+	ModThreadReject           = "thread.reject"
+	ModThreadRequestChanges   = "thread.request_changes"
+	ModThreadDelete           = "thread.delete"
+	ModCommentApprove         = "comment.approve"
+	ModCommentDelete          = "comment.delete"
+	ModUserRole               = "user.role"
+	ModUserWarn               = "user.warn"
+	ModUserSuspend            = "user.suspend"
+	ModUserBan                = "user.ban"
+	ModSanctionRevoke         = "sanction.revoke"
+	ModRegistrationBanCreate  = "registration_ban.create"
+	ModRegistrationBanDelete  = "registration_ban.delete"
+	ModUserReset2FA           = "user.reset_2fa"
+	ModModeratorRequestAccept = "moderator_request.approve"
+	ModModeratorRequestReject = "moderator_request.reject"
+	ModCategoryCreate         = "category.create"
+	ModCategoryDelete         = "category.delete"
+	ModCategoryModeratorAdd   = "category_moderator.assign"
+	ModCategoryModeratorDrop  = "category_moderator.remove"
+	ModRoleCreate             = "role.create"
+	ModRolePermissions        = "role.permissions"
+	ModRoleDelete             = "role.delete"
+	ModReportAssign           = "report.assign"
+	ModReportResolve          = "report.resolve"
+	ModReportReasonCreate     = "report_reason.create"
+	ModReportReasonDelete     = "report_reason.delete"
+	ModTwoFactorPolicy        = "security.2fa_policy"
+	ModLoginUnlock            = "security.unlock"
+	ModAppealUphold           = "appeal.uphold"
+	ModAppealOverturn         = "appeal.overturn"
+	ModAutomodRuleCreate      = "automod_rule.create"
+	ModAutomodRuleUpdate      = "automod_rule.update"
+	ModAutomodRuleDelete      = "automod_rule.delete"
+	ModSpamThreshold          = "spam.threshold"
+	ModSpamRetrain            = "spam.retrain"
+	ModBulkApprove            = "bulk.approve"
+	ModBulkReject             = "bulk.reject"
+	ModBulkDelete             = "bulk.delete"
+	ModBulkMove               = "bulk.move"
+	ModUserPurge              = "user.purge"
+)
+
+// ModActions lists every audit log action for filters.
+var ModActions = []string{
+	ModThreadApprove, ModThreadReject, ModThreadRequestChanges, ModThreadDelete, ModCommentApprove, ModCommentDelete,
+	ModUserRole, ModUserWarn, ModUserSuspend, ModUserBan, ModSanctionRevoke, ModUserReset2FA,
+	ModRegistrationBanCreate, ModRegistrationBanDelete,
+	ModModeratorRequestAccept, ModModeratorRequestReject,
+	ModCategoryCreate, ModCategoryDelete, ModCategoryModeratorAdd, ModCategoryModeratorDrop,
+	ModRoleCreate, ModRolePermissions, ModRoleDelete,
+	ModReportAssign, ModReportResolve, ModReportReasonCreate, ModReportReasonDelete,
+	ModTwoFactorPolicy, ModLoginUnlock, ModAppealUphold, ModAppealOverturn,
+	ModAutomodRuleCreate, ModAutomodRuleUpdate, ModAutomodRuleDelete, ModSpamThreshold, ModSpamRetrain,
+	ModBulkApprove, ModBulkReject, ModBulkDelete, ModBulkMove, ModUserPurge,
+}
+
+// ModActionQuery filters the audit log. Zero values match everything; From
+// is inclusive and To exclusive. Before, when non-zero, only returns entries
+// with a smaller ID so callers can page through the log.
+type ModActionQuery struct {
+	ActorID int
+	Action  string
+	From    time.Time
+	To      time.Time
+	Before  int
+	Limit   int
+}
+
+const modActionColumns = `
+	mod_actions.id, mod_actions.actor_id, COALESCE(actors.username, ''), mod_actions.action,
+	mod_actions.target_type, mod_actions.target_id, mod_actions.before, mod_actions.after,
+	mod_actions.reason, mod_actions.created_at`
+
+func scanModAction(row interface{ Scan(...interface{}) error }) (models.ModAction, error) {
+	var a models.ModAction
+	err := row.Scan(&a.ID, &a.ActorID, &a.ActorName, &a.Action, &a.TargetType, &a.TargetID,
+		&a.Before, &a.After, &a.Reason, &a.CreatedAt)
+	return a, err
+}
+
+// CreateModAction appends an entry to the audit log.
+func CreateModAction(a models.ModAction) error {
+	return insertModAction(DB, a)
+}
+
+// insertModAction writes an audit entry through DB or a transaction.
+func insertModAction(db execer, a models.ModAction) error {
+	_, err := db.Exec(`
+		INSERT INTO mod_actions (actor_id, action, target_type, target_id, before, after, reason, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		a.ActorID, a.Action, a.TargetType, a.TargetID, a.Before, a.After, a.Reason, time.Now().UTC())
+	return err
+}
+
+// ListModActions returns the audit log entries matching q, newest first.
+func ListModActions(q ModActionQuery) ([]models.ModAction, error) {
+	var conditions []string
+	var args []interface{}
+	if q.ActorID != 0 {
+		conditions = append(conditions, "mod_actions.actor_id = ?")
+		args = append(args, q.ActorID)
+	}
+	if q.Action != "" {
+		conditions = append(conditions, "mod_actions.action = ?")
+		args = append(args, q.Action)
+	}
+	if !q.From.IsZero() {
+		conditions = append(conditions, "mod_actions.created_at >= ?")
+		args = append(args, q.From.UTC())
+	}
+	if !q.To.IsZero() {
+		conditions = append(conditions, "mod_actions.created_at < ?")
+		args = append(args, q.To.UTC())
+	}
+	if q.Before != 0 {
+		conditions = append(conditions, "mod_actions.id < ?")
+		args = append(args, q.Before)
+	}
+	query := "SELECT " + modActionColumns + `
+		FROM mod_actions
+		LEFT JOIN users actors ON actors.id = mod_actions.actor_id`
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY mod_actions.id DESC"
+	if q.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, q.Limit)
+	}
+
+	rows, err := DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var actions []models.ModAction
+	for rows.Next() {
+		a, err := scanModAction(rows)
+		if err != nil {
+			return nil, err
+		}
+		actions = append(actions, a)
+	}
+	return actions, rows.Err()
+}
+
+// ModActionActors returns the users that appear in the audit log, ordered by
+// username.
+func ModActionActors() ([]models.User, error) {
+	rows, err := DB.Query(`
+		SELECT id, username FROM users
+		WHERE id IN (SELECT DISTINCT actor_id FROM mod_actions)
+		ORDER BY username`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []models.User
+	for rows.Next() {
+		var user models.User
+		if err := rows.Scan(&user.ID, &user.Username); err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+// ModActionCounts counts the audit log entries per month and action since
+// the given time, newest month first. Actors and targets are left out so
+// the result can be published.
+func ModActionCounts(since time.Time) ([]models.ModActionCount, error) {
+	rows, err := DB.Query(`
+		SELECT substr(created_at, 1, 7) AS month, action, COUNT(*)
+		FROM mod_actions WHERE created_at >= ?
+		GROUP BY month, action
+		ORDER BY month DESC, action`, since.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var counts []models.ModActionCount
+	for rows.Next() {
+		var c models.ModActionCount
+		if err := rows.Scan(&c.Month, &c.Action, &c.Count); err != nil {
+			return nil, err
+		}
+		counts = append(counts, c)
+	}
+	return counts, rows.Err()
+}
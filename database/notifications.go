@@ -0,0 +1,40 @@
+package database
+
+import (
+	"time"
+
+	"DytForum/models"
+)
+
+// CreateNotification stores a message for a user that is shown on their profile.
+func CreateNotification(userID int, message string) error {
+	_, err := DB.Exec("INSERT INTO notifications (user_id, message, created_at) VALUES (?, ?, ?)", userID, message, time.Now().UTC())
+	return err
+}
+
+// GetNotifications returns the latest notifications of a user, newest first.
+func GetNotifications(userID, limit int) ([]models.Notification, error) {
+	rows, err := DB.Query("SELECT id, user_id, message, is_read, created_at FROM notifications WHERE user_id = ? ORDER BY id DESC LIMIT ?", userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var notifications []models.Notification
+	for rows.Next() {
+		var notification models.Notification
+		var read int
+		if err := rows.Scan(&notification.ID, &notification.UserID, &notification.Message, &read, &notification.CreatedAt); err != nil {
+			return nil, err
+		}
+		notification.Read = read == 1
+		notifications = append(notifications, notification)
+	}
+	return notifications, rows.Err()
+}
+
+// MarkNotificationsRead marks all notifications of a user as read.
+func MarkNotificationsRead(userID int) error {
+	_, err := DB.Exec("UPDATE notifications SET is_read = 1 WHERE user_id = ? AND is_read = 0", userID)
+	return err
+}
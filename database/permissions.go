@@ -0,0 +1,215 @@
+package database
+
+import (
+	"database/sql"
+
+	"DytForum/models"
+	"DytForum/permissions"
+)
+
+// seedPermissions adds permissions that are missing from the database. Only
+// newly added permissions get the default role mapping, so changes made by
+// admins survive restarts.
+func seedPermissions() error {
+	for _, def := range permissions.All {
+		res, err := DB.Exec("INSERT INTO permissions (name, description) VALUES (?, ?) ON CONFLICT(name) DO NOTHING", def.Name, def.Description)
+		if err != nil {
+			return err
+		}
+		added, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if added == 0 {
+			continue
+		}
+
+		roles := []string{permissions.AdminRole}
+		for role, perms := range permissions.Defaults {
+			for _, perm := range perms {
+				if perm == def.Name {
+					roles = append(roles, role)
+				}
+			}
+		}
+		for _, role := range roles {
+			_, err := DB.Exec(`INSERT INTO role_permissions (role_id, permission_id)
+				SELECT roles.id, permissions.id FROM roles, permissions
+				WHERE roles.role_name = ? AND permissions.name = ?
+				ON CONFLICT DO NOTHING`, role, def.Name)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// RoleHasPermission reports whether role grants perm. The admin role always
+// has every permission.
+func RoleHasPermission(role, perm string) (bool, error) {
+	if role == permissions.AdminRole {
+		return true, nil
+	}
+	var count int
+	err := DB.QueryRow(`
+		SELECT COUNT(*) FROM role_permissions
+		INNER JOIN roles ON roles.id = role_permissions.role_id
+		INNER JOIN permissions ON permissions.id = role_permissions.permission_id
+		WHERE roles.role_name = ? AND permissions.name = ?`, role, perm).Scan(&count)
+	return count > 0, err
+}
+
+// UsersWithPermission returns the users whose role grants perm, ordered by
+// username.
+func UsersWithPermission(perm string) ([]models.User, error) {
+	rows, err := DB.Query(`
+		SELECT id, username, role FROM users
+		WHERE role = ? OR role IN (
+			SELECT roles.role_name FROM role_permissions
+			INNER JOIN roles ON roles.id = role_permissions.role_id
+			INNER JOIN permissions ON permissions.id = role_permissions.permission_id
+			WHERE permissions.name = ?)
+		ORDER BY username`, permissions.AdminRole, perm)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []models.User
+	for rows.Next() {
+		var user models.User
+		if err := rows.Scan(&user.ID, &user.Username, &user.Role); err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+// GetUserRole returns the current role of a user.
+func GetUserRole(userID int) (string, error) {
+	var role string
+	err := DB.QueryRow("SELECT role FROM users WHERE id = ?", userID).Scan(&role)
+	return role, err
+}
+
+// GetRoles returns every role together with the names of its permissions.
+func GetRoles() ([]models.Role, error) {
+	rows, err := DB.Query(`
+		SELECT roles.id, roles.role_name, permissions.name FROM roles
+		LEFT JOIN role_permissions ON role_permissions.role_id = roles.id
+		LEFT JOIN permissions ON permissions.id = role_permissions.permission_id
+		ORDER BY roles.id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var roles []models.Role
+	for rows.Next() {
+		var id int
+		var name string
+		var perm sql.NullString
+		if err := rows.Scan(&id, &name, &perm); err != nil {
+			return nil, err
+		}
+		if len(roles) == 0 || roles[len(roles)-1].ID != id {
+			roles = append(roles, models.Role{ID: id, Name: name})
+		}
+		if perm.Valid {
+			last := &roles[len(roles)-1]
+			last.Permissions = append(last.Permissions, perm.String)
+		}
+	}
+	return roles, rows.Err()
+}
+
+// GetRoleByID returns a single role without its permissions.
+func GetRoleByID(roleID int) (models.Role, error) {
+	role := models.Role{ID: roleID}
+	err := DB.QueryRow("SELECT role_name FROM roles WHERE id = ?", roleID).Scan(&role.Name)
+	return role, err
+}
+
+// RoleExists reports whether a role with the given name exists.
+func RoleExists(name string) (bool, error) {
+	var count int
+	err := DB.QueryRow("SELECT COUNT(*) FROM roles WHERE role_name = ?", name).Scan(&count)
+	return count > 0, err
+}
+
+// CreateRole adds a custom role without any permissions.
+func CreateRole(name string) error {
+	_, err := DB.Exec("INSERT INTO roles (role_name) VALUES (?)", name)
+	return err
+}
+
+// DeleteRole removes a custom role. Users that had it fall back to the user role.
+func DeleteRole(roleID int) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var name string
+	if err := tx.QueryRow("SELECT role_name FROM roles WHERE id = ?", roleID).Scan(&name); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE users SET role = ? WHERE role = ?", permissions.UserRole, name); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM role_permissions WHERE role_id = ?", roleID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM roles WHERE id = ?", roleID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetRolePermissions returns the names of the permissions granted to a
+// role, ordered by name.
+func GetRolePermissions(role string) ([]string, error) {
+	rows, err := DB.Query(`
+		SELECT permissions.name FROM role_permissions
+		INNER JOIN roles ON roles.id = role_permissions.role_id
+		INNER JOIN permissions ON permissions.id = role_permissions.permission_id
+		WHERE roles.role_name = ? ORDER BY permissions.name`, role)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var perms []string
+	for rows.Next() {
+		var perm string
+		if err := rows.Scan(&perm); err != nil {
+			return nil, err
+		}
+		perms = append(perms, perm)
+	}
+	return perms, rows.Err()
+}
+
+// SetRolePermissions replaces the permissions granted to a role.
+func SetRolePermissions(roleID int, perms []string) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM role_permissions WHERE role_id = ?", roleID); err != nil {
+		return err
+	}
+	for _, perm := range perms {
+		_, err := tx.Exec(`INSERT INTO role_permissions (role_id, permission_id)
+			SELECT ?, id FROM permissions WHERE name = ?`, roleID, perm)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
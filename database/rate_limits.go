@@ -0,0 +1,73 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+
+	"DytForum/ratelimit"
+)
+
+// rateLimitPruneInterval is how often buckets that are full again are
+// deleted.
+const rateLimitPruneInterval = time.Minute
+
+// RateLimitStore keeps rate limit buckets in the database, so every
+// instance using the same database shares them.
+type RateLimitStore struct {
+	mu        sync.Mutex
+	lastPrune time.Time
+}
+
+// Take runs in a BEGIN IMMEDIATE transaction on its own connection: the
+// write lock is taken before the bucket is read, so concurrent requests for
+// the same key wait for each other on the busy timeout instead of reading
+// the same token or failing to upgrade a read lock.
+func (s *RateLimitStore) Take(key string, p ratelimit.Policy, now time.Time) (bool, time.Duration, error) {
+	ctx := context.Background()
+	conn, err := DB.Conn(ctx)
+	if err != nil {
+		return false, 0, err
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, "BEGIN IMMEDIATE"); err != nil {
+		return false, 0, err
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			conn.ExecContext(ctx, "ROLLBACK")
+		}
+	}()
+
+	var b ratelimit.Bucket
+	err = conn.QueryRowContext(ctx, "SELECT tokens, updated FROM rate_limits WHERE key = ?", key).Scan(&b.Tokens, &b.Updated)
+	if err != nil && err != sql.ErrNoRows {
+		return false, 0, err
+	}
+	b, ok, wait := p.Take(b, now)
+	_, err = conn.ExecContext(ctx, `INSERT INTO rate_limits (key, tokens, updated, full_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT(key) DO UPDATE SET tokens = excluded.tokens, updated = excluded.updated, full_at = excluded.full_at`,
+		key, b.Tokens, b.Updated.UTC(), p.FullAt(b).UTC())
+	if err != nil {
+		return false, 0, err
+	}
+
+	s.mu.Lock()
+	prune := now.Sub(s.lastPrune) >= rateLimitPruneInterval
+	if prune {
+		s.lastPrune = now
+	}
+	s.mu.Unlock()
+	if prune {
+		if _, err := conn.ExecContext(ctx, "DELETE FROM rate_limits WHERE full_at <= ?", now.UTC()); err != nil {
+			return false, 0, err
+		}
+	}
+	if _, err := conn.ExecContext(ctx, "COMMIT"); err != nil {
+		return false, 0, err
+	}
+	committed = true
+	return ok, wait, nil
+}
@@ -0,0 +1,50 @@
+package database
+
+import (
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"DytForum/ratelimit"
+)
+
+func TestRateLimitStoreConcurrentTakes(t *testing.T) {
+	if err := InitDB(filepath.Join(t.TempDir(), "forum.db")); err != nil {
+		t.Fatal(err)
+	}
+	defer DB.Close()
+
+	store := &RateLimitStore{}
+	policy := ratelimit.Policy{Requests: 10, Per: time.Hour}
+	now := time.Now()
+
+	const requests = 50
+	var allowed int64
+	var wg sync.WaitGroup
+	errs := make(chan error, requests)
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ok, _, err := store.Take("comment:user:1", policy, now)
+			if err != nil {
+				errs <- err
+				return
+			}
+			if ok {
+				atomic.AddInt64(&allowed, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Errorf("Take: %v", err)
+	}
+	if allowed != int64(policy.Requests) {
+		t.Errorf("%d of %d concurrent requests allowed, want %d", allowed, requests, policy.Requests)
+	}
+}
@@ -0,0 +1,77 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// Reaction values stored in likes.like_status.
+const (
+	ReactionNone    = 0
+	ReactionLike    = 1
+	ReactionDislike = -1
+)
+
+// SetThreadReaction records a user's like or dislike of a thread, replacing
+// any earlier one. ReactionNone removes the reaction. The thread's like and
+// dislike counters are updated in the same transaction.
+func SetThreadReaction(userID, threadID, reaction int) error {
+	return setReaction("threads", "thread_id", userID, threadID, reaction)
+}
+
+// SetCommentReaction is SetThreadReaction for comments.
+func SetCommentReaction(userID, commentID, reaction int) error {
+	return setReaction("comments", "comment_id", userID, commentID, reaction)
+}
+
+func getReaction(tx *sql.Tx, column string, userID, targetID int) (int, error) {
+	var reaction int
+	err := tx.QueryRow("SELECT like_status FROM likes WHERE "+column+" = ? AND user_id = ?", targetID, userID).Scan(&reaction)
+	if err == sql.ErrNoRows {
+		return ReactionNone, nil
+	}
+	return reaction, err
+}
+
+func setReaction(table, column string, userID, targetID, reaction int) error {
+	if reaction != ReactionNone && reaction != ReactionLike && reaction != ReactionDislike {
+		return fmt.Errorf("invalid reaction %d", reaction)
+	}
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	previous, err := getReaction(tx, column, userID, targetID)
+	if err != nil {
+		return err
+	}
+	if previous == reaction {
+		return nil
+	}
+	if previous != ReactionNone {
+		if _, err := tx.Exec("DELETE FROM likes WHERE "+column+" = ? AND user_id = ?", targetID, userID); err != nil {
+			return err
+		}
+		if _, err := tx.Exec("UPDATE "+table+" SET "+counterColumn(previous)+" = "+counterColumn(previous)+" - 1 WHERE id = ?", targetID); err != nil {
+			return err
+		}
+	}
+	if reaction != ReactionNone {
+		if _, err := tx.Exec("INSERT INTO likes ("+column+", user_id, like_status) VALUES (?, ?, ?)", targetID, userID, reaction); err != nil {
+			return err
+		}
+		if _, err := tx.Exec("UPDATE "+table+" SET "+counterColumn(reaction)+" = "+counterColumn(reaction)+" + 1 WHERE id = ?", targetID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func counterColumn(reaction int) string {
+	if reaction == ReactionLike {
+		return "likes"
+	}
+	return "dislikes"
+}
@@ -0,0 +1,50 @@
+package database
+
+import (
+	"database/sql"
+
+	"DytForum/models"
+)
+
+// ListReportReasons returns the reasons users can pick when reporting, in
+// the order they were added.
+func ListReportReasons() ([]models.ReportReason, error) {
+	rows, err := DB.Query("SELECT id, name FROM report_reasons ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var reasons []models.ReportReason
+	for rows.Next() {
+		var reason models.ReportReason
+		if err := rows.Scan(&reason.ID, &reason.Name); err != nil {
+			return nil, err
+		}
+		reasons = append(reasons, reason)
+	}
+	return reasons, rows.Err()
+}
+
+// ReportReasonExists reports whether name is one of the report reasons.
+func ReportReasonExists(name string) (bool, error) {
+	var id int
+	err := DB.QueryRow("SELECT id FROM report_reasons WHERE name = ?", name).Scan(&id)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// CreateReportReason adds a report reason.
+func CreateReportReason(name string) error {
+	_, err := DB.Exec("INSERT INTO report_reasons (name) VALUES (?)", name)
+	return err
+}
+
+// DeleteReportReason removes a report reason. Reports keep the reason text
+// they were filed with.
+func DeleteReportReason(reasonID int) error {
+	_, err := DB.Exec("DELETE FROM report_reasons WHERE id = ?", reasonID)
+	return err
+}
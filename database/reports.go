@@ -0,0 +1,327 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"DytForum/models"
+)
+
+// Report statuses. Open and in-review reports make up the moderation queue;
+// actioned and dismissed reports are closed and kept as history.
+const (
+	ReportOpen      = "open"
+	ReportInReview  = "in_review"
+	ReportActioned  = "actioned"
+	ReportDismissed = "dismissed"
+)
+
+// ReportStatuses lists every report status in workflow order.
+var ReportStatuses = []string{ReportOpen, ReportInReview, ReportActioned, ReportDismissed}
+
+// Actions a moderator can take when closing a report as actioned. They are
+// stored comma separated in reports.resolution.
+const (
+	ActionDeleteContent = "delete_content"
+	ActionWarnAuthor    = "warn_author"
+	ActionSuspendAuthor = "suspend_author"
+)
+
+// What a report can be about. Comment reports also record the thread the
+// comment belongs to; user reports are not tied to a thread.
+const (
+	ReportTargetThread  = "thread"
+	ReportTargetComment = "comment"
+	ReportTargetUser    = "user"
+)
+
+// ReportTargets lists every report target.
+var ReportTargets = []string{ReportTargetThread, ReportTargetComment, ReportTargetUser}
+
+// ErrReportClosed is returned when a closed report is assigned or resolved.
+var ErrReportClosed = errors.New("report is already closed")
+
+// ReportQuery selects reports for ListReports. Only the first report about
+// a piece of content is listed; later ones are counted in ReportCount.
+// ModeratorID, when non-zero, limits the list to threads and comments in
+// the categories assigned to that moderator; user reports are then left out.
+type ReportQuery struct {
+	Statuses    []string
+	ModeratorID int
+}
+
+const reportColumns = `
+	reports.id, reports.target_type, reports.target_id, reports.thread_id, reports.user_id,
+	reports.reason, reports.details, COALESCE(reporters.username, ''), COALESCE(threads.title, ''),
+	CASE reports.target_type
+		WHEN 'thread' THEN COALESCE(threads.content, '')
+		WHEN 'comment' THEN COALESCE(comments.content, '')
+		ELSE '' END,
+	COALESCE(authors.id, 0), COALESCE(authors.username, ''),
+	CASE reports.target_type
+		WHEN 'thread' THEN threads.id IS NULL
+		WHEN 'comment' THEN comments.id IS NULL
+		ELSE authors.id IS NULL END,
+	reports.status, COALESCE(reports.duplicate_of, 0), COALESCE(reports.assigned_to, 0),
+	COALESCE(assignees.username, ''), reports.resolution, reports.resolution_note,
+	COALESCE(resolvers.username, ''), reports.created_at, reports.resolved_at,
+	(SELECT COUNT(*) FROM reports duplicates WHERE duplicates.duplicate_of = reports.id) + 1`
+
+const reportJoins = `
+	FROM reports
+	LEFT JOIN users reporters ON reporters.id = reports.user_id
+	LEFT JOIN threads ON threads.id = reports.thread_id
+	LEFT JOIN comments ON reports.target_type = 'comment' AND comments.id = reports.target_id
+	LEFT JOIN users authors ON authors.id = CASE reports.target_type
+		WHEN 'thread' THEN threads.user_id
+		WHEN 'comment' THEN comments.user_id
+		ELSE reports.target_id END
+	LEFT JOIN users assignees ON assignees.id = reports.assigned_to
+	LEFT JOIN users resolvers ON resolvers.id = reports.resolved_by`
+
+func scanReport(row interface{ Scan(...interface{}) error }) (models.Report, error) {
+	var r models.Report
+	var resolution string
+	err := row.Scan(&r.ID, &r.TargetType, &r.TargetID, &r.ThreadID, &r.UserID,
+		&r.Reason, &r.Details, &r.Username, &r.Title, &r.Content, &r.AuthorID, &r.AuthorName, &r.ContentDeleted,
+		&r.Status, &r.DuplicateOf, &r.AssignedTo, &r.AssigneeName, &resolution, &r.ResolutionNote,
+		&r.ResolvedByName, &r.CreatedAt, &r.ResolvedAt, &r.ReportCount)
+	if resolution != "" {
+		r.Actions = strings.Split(resolution, ",")
+	}
+	return r, err
+}
+
+func queryReports(query string, args ...interface{}) ([]models.Report, error) {
+	rows, err := DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var reports []models.Report
+	for rows.Next() {
+		report, err := scanReport(rows)
+		if err != nil {
+			return nil, err
+		}
+		reports = append(reports, report)
+	}
+	return reports, rows.Err()
+}
+
+// ListReports returns the reports matching q, oldest first.
+func ListReports(q ReportQuery) ([]models.Report, error) {
+	conditions := []string{"reports.duplicate_of IS NULL"}
+	var args []interface{}
+	if len(q.Statuses) > 0 {
+		conditions = append(conditions, "reports.status IN (?"+strings.Repeat(", ?", len(q.Statuses)-1)+")")
+		for _, status := range q.Statuses {
+			args = append(args, status)
+		}
+	}
+	if q.ModeratorID != 0 {
+		conditions = append(conditions, ModeratedThreadsFilter)
+		args = append(args, q.ModeratorID)
+	}
+	return queryReports("SELECT "+reportColumns+reportJoins+" WHERE "+strings.Join(conditions, " AND ")+" ORDER BY reports.id", args...)
+}
+
+// GetReport returns a single report, or sql.ErrNoRows.
+func GetReport(reportID int) (models.Report, error) {
+	return scanReport(DB.QueryRow("SELECT "+reportColumns+reportJoins+" WHERE reports.id = ?", reportID))
+}
+
+// GetReportGroup returns a report together with the later reports about the
+// same content that were attached to it, oldest first.
+func GetReportGroup(reportID int) ([]models.Report, error) {
+	return queryReports("SELECT "+reportColumns+reportJoins+" WHERE reports.id = ? OR reports.duplicate_of = ? ORDER BY reports.id", reportID, reportID)
+}
+
+// NewReport is a user's report about a thread, comment or user.
+type NewReport struct {
+	TargetType string
+	TargetID   int
+	UserID     int
+	Reason     string
+	Details    string
+}
+
+// CreateReport stores a report and returns its ID. While the content already
+// has an open report, the new one is attached to it as a duplicate so
+// moderators handle the content once; reporting the same content twice
+// returns the user's earlier report. A report about a comment that does not
+// exist returns sql.ErrNoRows.
+func CreateReport(nr NewReport) (int, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var threadID int
+	switch nr.TargetType {
+	case ReportTargetThread:
+		threadID = nr.TargetID
+	case ReportTargetComment:
+		if err := tx.QueryRow("SELECT thread_id FROM comments WHERE id = ?", nr.TargetID).Scan(&threadID); err != nil {
+			return 0, err
+		}
+	case ReportTargetUser:
+	default:
+		return 0, fmt.Errorf("unknown report target %q", nr.TargetType)
+	}
+
+	var primaryID int
+	status := ReportOpen
+	err = tx.QueryRow(`
+		SELECT id, status FROM reports
+		WHERE target_type = ? AND target_id = ? AND duplicate_of IS NULL AND status IN (?, ?)
+		ORDER BY id LIMIT 1`, nr.TargetType, nr.TargetID, ReportOpen, ReportInReview).Scan(&primaryID, &status)
+	if err != nil && err != sql.ErrNoRows {
+		return 0, err
+	}
+
+	var duplicateOf interface{}
+	if primaryID != 0 {
+		var existingID int
+		err = tx.QueryRow("SELECT id FROM reports WHERE (id = ? OR duplicate_of = ?) AND user_id = ? LIMIT 1", primaryID, primaryID, nr.UserID).Scan(&existingID)
+		if err == nil {
+			return existingID, nil
+		}
+		if err != sql.ErrNoRows {
+			return 0, err
+		}
+		duplicateOf = primaryID
+	}
+
+	res, err := tx.Exec(`
+		INSERT INTO reports (target_type, target_id, thread_id, user_id, reason, details, status, duplicate_of, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		nr.TargetType, nr.TargetID, threadID, nr.UserID, nr.Reason, nr.Details, status, duplicateOf, time.Now().UTC())
+	if err != nil {
+		return 0, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	return int(id), tx.Commit()
+}
+
+// AssignReport hands an active report and its duplicates to a moderator and
+// marks it in review. An assigneeID of zero puts it back in the open queue.
+func AssignReport(reportID, assigneeID int) error {
+	var assignee interface{}
+	status := ReportOpen
+	if assigneeID != 0 {
+		assignee = assigneeID
+		status = ReportInReview
+	}
+	res, err := DB.Exec("UPDATE reports SET assigned_to = ?, status = ? WHERE (id = ? OR duplicate_of = ?) AND status IN (?, ?)",
+		assignee, status, reportID, reportID, ReportOpen, ReportInReview)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrReportClosed
+	}
+	return nil
+}
+
+// ReportResolution is how a moderator closes a report and what they do
+// about the reported content and its author.
+type ReportResolution struct {
+	ReportID    int
+	ModeratorID int
+	Status      string
+	Actions     []string
+	Note        string
+	// DeleteThreadID and DeleteCommentID are the reported thread or comment
+	// to delete, if any.
+	DeleteThreadID  int
+	DeleteCommentID int
+	// Sanctions are issued to the author. SanctionLog builds the audit entry
+	// of each once it has an ID.
+	Sanctions   []models.Sanction
+	SanctionLog func(s models.Sanction, sanctionID int) models.ModAction
+	// Logs are the audit entries of the other actions and Log the entry of
+	// the resolution itself.
+	Logs []models.ModAction
+	Log  models.ModAction
+}
+
+// ResolveReport closes an active report and its duplicates with status
+// actioned or dismissed, takes the actions and records the audit entries in
+// one transaction, so either all of it happens or none of it. It returns the
+// IDs of everyone who reported the content so they can be told about the
+// outcome. Only one of several concurrent calls succeeds; the others get
+// ErrReportClosed.
+func ResolveReport(res ReportResolution) ([]int, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+		UPDATE reports SET status = ?, resolution = ?, resolution_note = ?, resolved_by = ?, resolved_at = ?
+		WHERE (id = ? OR duplicate_of = ?) AND status IN (?, ?)`,
+		res.Status, strings.Join(res.Actions, ","), res.Note, res.ModeratorID, time.Now().UTC(),
+		res.ReportID, res.ReportID, ReportOpen, ReportInReview)
+	if err != nil {
+		return nil, err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return nil, err
+	} else if n == 0 {
+		return nil, ErrReportClosed
+	}
+
+	rows, err := tx.Query("SELECT DISTINCT user_id FROM reports WHERE id = ? OR duplicate_of = ?", res.ReportID, res.ReportID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var reporters []int
+	for rows.Next() {
+		var userID int
+		if err := rows.Scan(&userID); err != nil {
+			return nil, err
+		}
+		reporters = append(reporters, userID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if res.DeleteThreadID != 0 {
+		if _, err := tx.Exec("DELETE FROM threads WHERE id = ?", res.DeleteThreadID); err != nil {
+			return nil, err
+		}
+	}
+	if res.DeleteCommentID != 0 {
+		if _, err := tx.Exec("DELETE FROM comments WHERE id = ?", res.DeleteCommentID); err != nil {
+			return nil, err
+		}
+	}
+	logs := res.Logs
+	for _, s := range res.Sanctions {
+		id, err := insertSanction(tx, s)
+		if err != nil {
+			return nil, err
+		}
+		logs = append(logs, res.SanctionLog(s, id))
+	}
+	for _, a := range append(logs, res.Log) {
+		if err := insertModAction(tx, a); err != nil {
+			return nil, err
+		}
+	}
+	return reporters, tx.Commit()
+}
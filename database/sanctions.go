@@ -0,0 +1,227 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"DytForum/models"
+)
+
+// Sanction kinds. A warning only notifies the user; a suspension stops them
+// from posting, reacting and reporting until it expires; a ban also stops
+// them from logging in and is permanent unless it has an expiry.
+const (
+	SanctionWarning    = "warning"
+	SanctionSuspension = "suspension"
+	SanctionBan        = "ban"
+)
+
+// SanctionKinds lists every sanction kind from mildest to strictest.
+var SanctionKinds = []string{SanctionWarning, SanctionSuspension, SanctionBan}
+
+// Registration ban kinds.
+const (
+	RegistrationBanIP          = "ip"
+	RegistrationBanEmailDomain = "email_domain"
+)
+
+// ErrSanctionInactive is returned when revoking a sanction that already
+// expired or was revoked.
+var ErrSanctionInactive = errors.New("sanction is no longer active")
+
+// sanctionActive is the SQL condition for a suspension or ban that is in
+// force at the time bound to the placeholder.
+const sanctionActive = `(user_sanctions.revoked_at IS NULL AND (user_sanctions.expires_at IS NULL OR user_sanctions.expires_at > ?))`
+
+const sanctionColumns = `
+	user_sanctions.id, user_sanctions.user_id, COALESCE(users.username, ''), user_sanctions.kind,
+	user_sanctions.reason, user_sanctions.issued_by, COALESCE(issuers.username, ''),
+	user_sanctions.created_at, user_sanctions.expires_at, user_sanctions.revoked_at,
+	COALESCE(revokers.username, ''), user_sanctions.kind != 'warning' AND ` + sanctionActive + `
+	FROM user_sanctions
+	LEFT JOIN users ON users.id = user_sanctions.user_id
+	LEFT JOIN users issuers ON issuers.id = user_sanctions.issued_by
+	LEFT JOIN users revokers ON revokers.id = user_sanctions.revoked_by`
+
+func scanSanction(row interface{ Scan(...interface{}) error }) (models.Sanction, error) {
+	var s models.Sanction
+	err := row.Scan(&s.ID, &s.UserID, &s.Username, &s.Kind, &s.Reason, &s.IssuedBy, &s.IssuerName,
+		&s.CreatedAt, &s.ExpiresAt, &s.RevokedAt, &s.RevokedByName, &s.Active)
+	return s, err
+}
+
+func querySanctions(query string, args ...interface{}) ([]models.Sanction, error) {
+	rows, err := DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sanctions []models.Sanction
+	for rows.Next() {
+		s, err := scanSanction(rows)
+		if err != nil {
+			return nil, err
+		}
+		sanctions = append(sanctions, s)
+	}
+	return sanctions, rows.Err()
+}
+
+// CreateSanction stores a sanction and returns its ID. Only UserID, Kind,
+// Reason, IssuedBy and ExpiresAt are used.
+func CreateSanction(s models.Sanction) (int, error) {
+	return insertSanction(DB, s)
+}
+
+// insertSanction stores a sanction through DB or a transaction.
+func insertSanction(db execer, s models.Sanction) (int, error) {
+	var expires interface{}
+	if s.ExpiresAt != nil {
+		expires = s.ExpiresAt.UTC()
+	}
+	res, err := db.Exec(`
+		INSERT INTO user_sanctions (user_id, kind, reason, issued_by, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		s.UserID, s.Kind, s.Reason, s.IssuedBy, time.Now().UTC(), expires)
+	if err != nil {
+		return 0, err
+	}
+	id, err := res.LastInsertId()
+	return int(id), err
+}
+
+// GetSanction returns a single sanction, or sql.ErrNoRows.
+func GetSanction(sanctionID int) (models.Sanction, error) {
+	return scanSanction(DB.QueryRow("SELECT "+sanctionColumns+" WHERE user_sanctions.id = ?", time.Now().UTC(), sanctionID))
+}
+
+// ListUserSanctions returns every sanction a user received, newest first.
+func ListUserSanctions(userID int) ([]models.Sanction, error) {
+	return querySanctions("SELECT "+sanctionColumns+" WHERE user_sanctions.user_id = ? ORDER BY user_sanctions.id DESC",
+		time.Now().UTC(), userID)
+}
+
+// ListActiveSanctions returns the suspensions and bans in force, the ones
+// that end first listed first and permanent bans last.
+func ListActiveSanctions() ([]models.Sanction, error) {
+	now := time.Now().UTC()
+	return querySanctions("SELECT "+sanctionColumns+`
+		WHERE user_sanctions.kind != 'warning' AND `+sanctionActive+`
+		ORDER BY user_sanctions.expires_at IS NULL, user_sanctions.expires_at, user_sanctions.id`, now, now)
+}
+
+// ActiveRestriction returns the suspension or ban that currently limits a
+// user, preferring a ban and then the one that lasts longest. Sanctions stop
+// applying on their own once they expire. It returns sql.ErrNoRows when the
+// user may post.
+func ActiveRestriction(userID int) (models.Sanction, error) {
+	now := time.Now().UTC()
+	return scanSanction(DB.QueryRow("SELECT "+sanctionColumns+`
+		WHERE user_sanctions.user_id = ? AND user_sanctions.kind IN ('suspension', 'ban') AND `+sanctionActive+`
+		ORDER BY user_sanctions.kind = 'ban' DESC, user_sanctions.expires_at IS NULL DESC, user_sanctions.expires_at DESC
+		LIMIT 1`, now, userID, now))
+}
+
+// ActiveBan returns the ban that currently keeps a user from logging in, or
+// sql.ErrNoRows.
+func ActiveBan(userID int) (models.Sanction, error) {
+	s, err := ActiveRestriction(userID)
+	if err == nil && s.Kind != SanctionBan {
+		return models.Sanction{}, sql.ErrNoRows
+	}
+	return s, err
+}
+
+// RevokeSanction lifts a suspension or ban before it expires.
+func RevokeSanction(sanctionID, revokedBy int) error {
+	return revokeSanction(DB, sanctionID, revokedBy)
+}
+
+func revokeSanction(db execer, sanctionID, revokedBy int) error {
+	now := time.Now().UTC()
+	res, err := db.Exec(`
+		UPDATE user_sanctions SET revoked_at = ?, revoked_by = ?
+		WHERE id = ? AND kind != 'warning' AND `+sanctionActive,
+		now, revokedBy, sanctionID, now)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrSanctionInactive
+	}
+	return nil
+}
+
+const registrationBanColumns = `
+	registration_bans.id, registration_bans.kind, registration_bans.value, registration_bans.reason,
+	COALESCE(users.username, ''), registration_bans.created_at, registration_bans.expires_at
+	FROM registration_bans
+	LEFT JOIN users ON users.id = registration_bans.created_by`
+
+func scanRegistrationBan(row interface{ Scan(...interface{}) error }) (models.RegistrationBan, error) {
+	var b models.RegistrationBan
+	err := row.Scan(&b.ID, &b.Kind, &b.Value, &b.Reason, &b.CreatedByName, &b.CreatedAt, &b.ExpiresAt)
+	return b, err
+}
+
+// CreateRegistrationBan blocks registrations from an IP address or email
+// domain. Banning a value again replaces the earlier ban.
+func CreateRegistrationBan(kind, value, reason string, createdBy int, expiresAt *time.Time) error {
+	var expires interface{}
+	if expiresAt != nil {
+		expires = expiresAt.UTC()
+	}
+	_, err := DB.Exec(`
+		INSERT INTO registration_bans (kind, value, reason, created_by, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(kind, value) DO UPDATE SET reason = excluded.reason, created_by = excluded.created_by,
+			created_at = excluded.created_at, expires_at = excluded.expires_at`,
+		kind, value, reason, createdBy, time.Now().UTC(), expires)
+	return err
+}
+
+// ListRegistrationBans returns the registration bans that have not expired,
+// newest first.
+func ListRegistrationBans() ([]models.RegistrationBan, error) {
+	rows, err := DB.Query("SELECT "+registrationBanColumns+`
+		WHERE registration_bans.expires_at IS NULL OR registration_bans.expires_at > ?
+		ORDER BY registration_bans.id DESC`, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var bans []models.RegistrationBan
+	for rows.Next() {
+		b, err := scanRegistrationBan(rows)
+		if err != nil {
+			return nil, err
+		}
+		bans = append(bans, b)
+	}
+	return bans, rows.Err()
+}
+
+// GetRegistrationBan returns a single registration ban, or sql.ErrNoRows.
+func GetRegistrationBan(banID int) (models.RegistrationBan, error) {
+	return scanRegistrationBan(DB.QueryRow("SELECT "+registrationBanColumns+" WHERE registration_bans.id = ?", banID))
+}
+
+// DeleteRegistrationBan lifts a registration ban.
+func DeleteRegistrationBan(banID int) error {
+	_, err := DB.Exec("DELETE FROM registration_bans WHERE id = ?", banID)
+	return err
+}
+
+// MatchRegistrationBan returns the unexpired ban that covers the IP address
+// or email domain, or sql.ErrNoRows. domain must be lower case.
+func MatchRegistrationBan(ip, domain string) (models.RegistrationBan, error) {
+	return scanRegistrationBan(DB.QueryRow("SELECT "+registrationBanColumns+`
+		WHERE ((registration_bans.kind = ? AND registration_bans.value = ?) OR (registration_bans.kind = ? AND registration_bans.value = ?))
+			AND (registration_bans.expires_at IS NULL OR registration_bans.expires_at > ?)
+		LIMIT 1`, RegistrationBanIP, ip, RegistrationBanEmailDomain, domain, time.Now().UTC()))
+}
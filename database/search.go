@@ -0,0 +1,71 @@
+package database
+
+import (
+	"strings"
+
+	"DytForum/models"
+)
+
+// RebuildSearchIndex refills the threads search index from the threads table.
+func RebuildSearchIndex() (int, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM threads_search"); err != nil {
+		return 0, err
+	}
+	res, err := tx.Exec("INSERT INTO threads_search (docid, title, content) SELECT id, title, content FROM threads")
+	if err != nil {
+		return 0, err
+	}
+	count, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(count), tx.Commit()
+}
+
+// ftsQuery turns free text into a full-text query that matches every word
+// as a prefix. Quotes are dropped so user input cannot break the syntax.
+func ftsQuery(text string) string {
+	var terms []string
+	for _, word := range strings.Fields(text) {
+		word = strings.ReplaceAll(word, `"`, "")
+		if word != "" {
+			terms = append(terms, `"`+word+`"*`)
+		}
+	}
+	return strings.Join(terms, " ")
+}
+
+// SearchThreads returns approved threads whose title or content contain
+// every word of text.
+func SearchThreads(text string) ([]models.Thread, error) {
+	query := ftsQuery(text)
+	if query == "" {
+		return nil, nil
+	}
+	rows, err := DB.Query(`
+		SELECT threads.id, `+ThreadCategoryName+`, threads.title, threads.content
+		FROM threads_search
+		INNER JOIN threads ON threads.id = threads_search.docid
+		WHERE threads_search MATCH ? AND threads.approved = 1
+		ORDER BY threads.id DESC`, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var threads []models.Thread
+	for rows.Next() {
+		var thread models.Thread
+		if err := rows.Scan(&thread.ID, &thread.Category, &thread.Title, &thread.Content); err != nil {
+			return nil, err
+		}
+		threads = append(threads, thread)
+	}
+	return threads, rows.Err()
+}
@@ -0,0 +1,44 @@
+package database
+
+import "database/sql"
+
+// Setting keys stored in the settings table.
+const (
+	SettingRequireStaff2FA = "require_staff_2fa"
+	SettingSpamThreshold   = "spam_threshold"
+)
+
+// GetSetting returns the value stored for key, or def if it has never been set.
+func GetSetting(key, def string) (string, error) {
+	var value string
+	err := DB.QueryRow("SELECT value FROM settings WHERE key = ?", key).Scan(&value)
+	if err == sql.ErrNoRows {
+		return def, nil
+	}
+	return value, err
+}
+
+// SetSetting stores value under key, replacing any previous value.
+func SetSetting(key, value string) error {
+	_, err := DB.Exec(`INSERT INTO settings (key, value) VALUES (?, ?)
+		ON CONFLICT(key) DO UPDATE SET value = excluded.value`, key, value)
+	return err
+}
+
+// GetBoolSetting is GetSetting for "1"/"0" flags.
+func GetBoolSetting(key string, def bool) (bool, error) {
+	d := "0"
+	if def {
+		d = "1"
+	}
+	value, err := GetSetting(key, d)
+	return value == "1", err
+}
+
+// SetBoolSetting is SetSetting for "1"/"0" flags.
+func SetBoolSetting(key string, value bool) error {
+	if value {
+		return SetSetting(key, "1")
+	}
+	return SetSetting(key, "0")
+}
@@ -0,0 +1,240 @@
+package database
+
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+	"time"
+
+	"DytForum/spam"
+)
+
+// DefaultSpamThreshold is the score from which new posts are held for
+// review until an admin picks another.
+const DefaultSpamThreshold = 90
+
+// SpamRejectionReason is the stock rejection reason that marks a thread as
+// spam. Threads rejected for other reasons, like being off-topic, are not
+// spam and the classifier does not learn from them.
+const SpamRejectionReason = "Spam or advertising"
+
+// spamRejection is an SQL condition on threads, taking a LIKE pattern made
+// of SpamRejectionReason and "%", that keeps the ones rejected as spam.
+const spamRejection = "threads.review_note LIKE ?"
+
+// spamTokenBatch keeps token lookups below SQLite's limit on parameters.
+const spamTokenBatch = 500
+
+// SpamThreshold returns the score from which new posts are held.
+func SpamThreshold() (int, error) {
+	value, err := GetSetting(SettingSpamThreshold, strconv.Itoa(DefaultSpamThreshold))
+	if err != nil {
+		return DefaultSpamThreshold, err
+	}
+	threshold, err := strconv.Atoi(value)
+	if err != nil {
+		return DefaultSpamThreshold, nil
+	}
+	return threshold, nil
+}
+
+// SpamText is the text of a post the classifier learns from and scores.
+func SpamText(title, content string) string {
+	if title == "" {
+		return content
+	}
+	return title + "\n" + content
+}
+
+// addSpamTokens adds sign times one example's tokens to the counts of the
+// given kind.
+func addSpamTokens(tx *sql.Tx, tokens []string, isSpam bool, sign int) error {
+	column := "ham"
+	if isSpam {
+		column = "spam"
+	}
+	for _, token := range tokens {
+		_, err := tx.Exec(`INSERT INTO spam_tokens (token, `+column+`) VALUES (?, ?)
+			ON CONFLICT(token) DO UPDATE SET `+column+` = `+column+` + excluded.`+column, token, sign)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TrainSpam records a moderator's decision that a post is or is not spam
+// and updates the token counts. Deciding the same post again replaces the
+// earlier decision.
+func TrainSpam(targetType string, targetID int, isSpam bool, text string) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var wasSpam bool
+	var oldText string
+	err = tx.QueryRow("SELECT spam, content FROM spam_examples WHERE target_type = ? AND target_id = ?", targetType, targetID).Scan(&wasSpam, &oldText)
+	switch {
+	case err == sql.ErrNoRows:
+	case err != nil:
+		return err
+	default:
+		if err := addSpamTokens(tx, spam.Tokens(oldText), wasSpam, -1); err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec(`INSERT INTO spam_examples (target_type, target_id, spam, content, created_at) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(target_type, target_id) DO UPDATE SET spam = excluded.spam, content = excluded.content, created_at = excluded.created_at`,
+		targetType, targetID, isSpam, text, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	if err := addSpamTokens(tx, spam.Tokens(text), isSpam, 1); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM spam_tokens WHERE spam <= 0 AND ham <= 0"); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// RetrainSpam rebuilds the token counts from the recorded examples. With
+// history it first adds every approved thread as legitimate and every
+// thread rejected as spam or advertising as spam, keeping the decisions
+// already recorded. It returns the number of spam and legitimate examples.
+func RetrainSpam(history bool) (spam.Counts, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return spam.Counts{}, err
+	}
+	defer tx.Rollback()
+
+	if history {
+		_, err := tx.Exec(`INSERT OR IGNORE INTO spam_examples (target_type, target_id, spam, content, created_at)
+			SELECT 'thread', id, approved = ?, title || char(10) || content, ? FROM threads
+			WHERE approved = ? OR (approved = ? AND `+spamRejection+`)`,
+			ThreadRejected, time.Now().UTC(), ThreadApproved, ThreadRejected, SpamRejectionReason+"%")
+		if err != nil {
+			return spam.Counts{}, err
+		}
+	}
+	docs, err := rebuildSpamTokens(tx)
+	if err != nil {
+		return docs, err
+	}
+	return docs, tx.Commit()
+}
+
+// rebuildSpamTokens recounts the tokens of every recorded example.
+func rebuildSpamTokens(tx *sql.Tx) (spam.Counts, error) {
+	var docs spam.Counts
+	rows, err := tx.Query("SELECT spam, content FROM spam_examples")
+	if err != nil {
+		return docs, err
+	}
+	counts := map[string]*spam.Counts{}
+	for rows.Next() {
+		var isSpam bool
+		var text string
+		if err := rows.Scan(&isSpam, &text); err != nil {
+			rows.Close()
+			return docs, err
+		}
+		if isSpam {
+			docs.Spam++
+		} else {
+			docs.Ham++
+		}
+		for _, token := range spam.Tokens(text) {
+			c := counts[token]
+			if c == nil {
+				c = &spam.Counts{}
+				counts[token] = c
+			}
+			if isSpam {
+				c.Spam++
+			} else {
+				c.Ham++
+			}
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return docs, err
+	}
+
+	if _, err := tx.Exec("DELETE FROM spam_tokens"); err != nil {
+		return docs, err
+	}
+	stmt, err := tx.Prepare("INSERT INTO spam_tokens (token, spam, ham) VALUES (?, ?, ?)")
+	if err != nil {
+		return docs, err
+	}
+	defer stmt.Close()
+	for token, c := range counts {
+		if _, err := stmt.Exec(token, c.Spam, c.Ham); err != nil {
+			return docs, err
+		}
+	}
+	return docs, nil
+}
+
+// SpamModel loads the example totals and the counts of the given tokens.
+func SpamModel(tokens []string) (spam.Model, error) {
+	m := spam.Model{Tokens: map[string]spam.Counts{}}
+	err := DB.QueryRow("SELECT COALESCE(SUM(spam), 0), COALESCE(SUM(NOT spam), 0) FROM spam_examples").Scan(&m.Docs.Spam, &m.Docs.Ham)
+	if err != nil {
+		return m, err
+	}
+	for len(tokens) > 0 {
+		batch := tokens
+		if len(batch) > spamTokenBatch {
+			batch = batch[:spamTokenBatch]
+		}
+		tokens = tokens[len(batch):]
+
+		args := make([]interface{}, len(batch))
+		for i, token := range batch {
+			args[i] = token
+		}
+		rows, err := DB.Query("SELECT token, spam, ham FROM spam_tokens WHERE token IN (?"+strings.Repeat(", ?", len(batch)-1)+")", args...)
+		if err != nil {
+			return m, err
+		}
+		for rows.Next() {
+			var token string
+			var c spam.Counts
+			if err := rows.Scan(&token, &c.Spam, &c.Ham); err != nil {
+				rows.Close()
+				return m, err
+			}
+			m.Tokens[token] = c
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return m, err
+		}
+	}
+	return m, nil
+}
+
+// SpamStats returns the number of spam and legitimate examples and of
+// distinct tokens learned.
+func SpamStats() (docs spam.Counts, tokens int, err error) {
+	err = DB.QueryRow(`SELECT COALESCE(SUM(spam), 0), COALESCE(SUM(NOT spam), 0), (SELECT COUNT(*) FROM spam_tokens)
+		FROM spam_examples`).Scan(&docs.Spam, &docs.Ham, &tokens)
+	return docs, tokens, err
+}
+
+// SetSpamScore stores the classifier's score of a thread or comment.
+func SetSpamScore(targetType string, targetID, score int) error {
+	table := "threads"
+	if targetType == "comment" {
+		table = "comments"
+	}
+	_, err := DB.Exec("UPDATE "+table+" SET spam_score = ? WHERE id = ?", score, targetID)
+	return err
+}
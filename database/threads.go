@@ -0,0 +1,173 @@
+package database
+
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+
+	"DytForum/models"
+)
+
+// Thread review states, stored in threads.approved. Only approved threads
+// are public; the others are visible to their author and moderators.
+const (
+	ThreadPending          = 0
+	ThreadApproved         = 1
+	ThreadRejected         = 2
+	ThreadChangesRequested = 3
+	// ThreadShadowHidden threads look published to their author only.
+	ThreadShadowHidden = 4
+)
+
+// QueuedThreads is the SQL condition for threads in the approval queue:
+// pending and shadow-hidden ones.
+const QueuedThreads = "threads.approved IN (0, 4)"
+
+// Queued reports whether a thread in the review state is in the approval
+// queue, matching QueuedThreads.
+func Queued(state int) bool {
+	return state == ThreadPending || state == ThreadShadowHidden
+}
+
+// ThreadQuery selects threads for ListThreads. Threads come newest first;
+// Before, when non-zero, only returns threads with a smaller ID so callers
+// can page through the list.
+type ThreadQuery struct {
+	CategoryID int
+	Before     int
+	Limit      int
+	ViewerID   int
+}
+
+// threadCategoriesView maps threads to their categories. threads.category
+// holds the category ID as text.
+const threadCategoriesView = `
+	CREATE VIEW IF NOT EXISTS thread_categories AS
+	SELECT threads.id AS thread_id, categories.id AS category_id
+	FROM threads
+	INNER JOIN categories ON threads.category = CAST(categories.id AS TEXT)`
+
+// ThreadCategoryName is the SQL expression for the name of the category of
+// the thread in threads. Threads of a deleted category show the stored value.
+const ThreadCategoryName = `COALESCE((SELECT categories.name FROM categories
+	WHERE CAST(categories.id AS TEXT) = threads.category), threads.category)`
+
+const threadColumns = `
+	threads.id, threads.user_id, COALESCE(categories.id, 0), COALESCE(categories.name, threads.category),
+	threads.title, threads.content, threads.likes, threads.dislikes, COALESCE(users.username, ''), threads.approved,
+	threads.review_note, threads.spam_score`
+
+const threadJoins = `
+	FROM threads
+	LEFT JOIN thread_categories ON thread_categories.thread_id = threads.id
+	LEFT JOIN categories ON categories.id = thread_categories.category_id
+	LEFT JOIN users ON users.id = threads.user_id`
+
+func scanThread(row interface{ Scan(...interface{}) error }) (models.Thread, error) {
+	var t models.Thread
+	err := row.Scan(&t.ID, &t.UserID, &t.CategoryID, &t.Category, &t.Title, &t.Content, &t.Likes, &t.Dislikes, &t.Username, &t.Approved, &t.ReviewNote, &t.SpamScore)
+	return t, err
+}
+
+// ListThreads returns the approved threads, and the viewer's own threads in
+// any review state, matching q.
+func ListThreads(q ThreadQuery) ([]models.Thread, error) {
+	conditions := []string{"(threads.approved = 1 OR threads.user_id = ?)"}
+	args := []interface{}{q.ViewerID}
+	if q.CategoryID != 0 {
+		conditions = append(conditions, "categories.id = ?")
+		args = append(args, q.CategoryID)
+	}
+	if q.Before != 0 {
+		conditions = append(conditions, "threads.id < ?")
+		args = append(args, q.Before)
+	}
+	args = append(args, q.Limit)
+
+	rows, err := DB.Query(`SELECT `+threadColumns+threadJoins+`
+		WHERE `+strings.Join(conditions, " AND ")+`
+		ORDER BY threads.id DESC LIMIT ?`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	threads := []models.Thread{}
+	for rows.Next() {
+		t, err := scanThread(rows)
+		if err != nil {
+			return nil, err
+		}
+		threads = append(threads, t)
+	}
+	return threads, rows.Err()
+}
+
+// GetThread returns one thread whether or not it is approved. It returns
+// sql.ErrNoRows when there is no such thread.
+func GetThread(threadID int) (models.Thread, error) {
+	return scanThread(DB.QueryRow(`SELECT `+threadColumns+threadJoins+` WHERE threads.id = ?`, threadID))
+}
+
+// CreateThread stores a new thread awaiting approval and returns its ID.
+func CreateThread(userID, categoryID int, title, content string) (int, error) {
+	res, err := DB.Exec("INSERT INTO threads (category, title, content, user_id) VALUES (?, ?, ?, ?)", categoryID, title, content, userID)
+	if err != nil {
+		return 0, err
+	}
+	id, err := res.LastInsertId()
+	return int(id), err
+}
+
+// UpdateThread replaces the title and content of a thread and sends it back
+// to the moderation queue. The review note is kept so moderators can see
+// what was asked of a resubmitted thread. Shadow-hidden threads stay
+// hidden, so editing is no way out of a shadow-hide.
+func UpdateThread(threadID int, title, content string) error {
+	_, err := DB.Exec("UPDATE threads SET title = ?, content = ?, approved = CASE approved WHEN ? THEN ? ELSE ? END WHERE id = ?",
+		title, content, ThreadShadowHidden, ThreadShadowHidden, ThreadPending, threadID)
+	return err
+}
+
+// SetThreadReview moves a thread to a review state with the moderator's
+// note, which is shown to the author.
+func SetThreadReview(threadID, state int, note string) error {
+	return setThreadReview(DB, threadID, state, note)
+}
+
+func setThreadReview(db execer, threadID, state int, note string) error {
+	_, err := db.Exec("UPDATE threads SET approved = ?, review_note = ? WHERE id = ?", state, note, threadID)
+	return err
+}
+
+// DeleteThread removes a thread.
+func DeleteThread(threadID int) error {
+	_, err := DB.Exec("DELETE FROM threads WHERE id = ?", threadID)
+	return err
+}
+
+// RestoreThread puts a deleted thread back under its old ID, approved. Threads
+// rejected before rejections were kept are restored this way.
+func RestoreThread(t models.Thread) error {
+	return restoreThread(DB, t)
+}
+
+func restoreThread(db execer, t models.Thread) error {
+	category := t.Category
+	if t.CategoryID != 0 {
+		category = strconv.Itoa(t.CategoryID)
+	}
+	_, err := db.Exec("INSERT INTO threads (id, category, title, content, user_id, approved) VALUES (?, ?, ?, ?, ?, 1)",
+		t.ID, category, t.Title, t.Content, t.UserID)
+	return err
+}
+
+// CategoryExists reports whether a category with the ID exists.
+func CategoryExists(categoryID int) (bool, error) {
+	var id int
+	err := DB.QueryRow("SELECT id FROM categories WHERE id = ?", categoryID).Scan(&id)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
@@ -0,0 +1,130 @@
+package database
+
+import (
+	"database/sql"
+
+	"DytForum/models"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// GetTwoFactor returns the 2FA state of a user. A user that never started
+// enrollment gets a zero value with Enabled set to false.
+func GetTwoFactor(userID int) (models.TwoFactor, error) {
+	tf := models.TwoFactor{UserID: userID}
+	var enabled int
+	err := DB.QueryRow("SELECT secret, enabled, last_step FROM two_factor WHERE user_id = ?", userID).Scan(&tf.Secret, &enabled, &tf.LastStep)
+	if err == sql.ErrNoRows {
+		return tf, nil
+	}
+	if err != nil {
+		return tf, err
+	}
+	tf.Enabled = enabled == 1
+	return tf, nil
+}
+
+// SaveTwoFactorSecret stores a new, not yet enabled secret for the user.
+func SaveTwoFactorSecret(userID int, secret string) error {
+	_, err := DB.Exec(`INSERT INTO two_factor (user_id, secret, enabled) VALUES (?, ?, 0)
+		ON CONFLICT(user_id) DO UPDATE SET secret = excluded.secret, enabled = 0, last_step = 0`, userID, secret)
+	return err
+}
+
+// UseTwoFactorStep records that the user's code for a TOTP time step was
+// accepted. It reports false when a code of that or a later step was
+// already used, so the same code cannot log in twice even when two requests
+// race.
+func UseTwoFactorStep(userID int, step int64) (bool, error) {
+	res, err := DB.Exec("UPDATE two_factor SET last_step = ? WHERE user_id = ? AND last_step < ?", step, userID, step)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// EnableTwoFactor turns 2FA on and replaces the user's recovery codes.
+func EnableTwoFactor(userID int, recoveryCodes []string) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("UPDATE two_factor SET enabled = 1 WHERE user_id = ?", userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM recovery_codes WHERE user_id = ?", userID); err != nil {
+		return err
+	}
+	for _, code := range recoveryCodes {
+		hash, err := bcrypt.GenerateFromPassword([]byte(code), bcrypt.DefaultCost)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec("INSERT INTO recovery_codes (user_id, code_hash) VALUES (?, ?)", userID, hash); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// DisableTwoFactor removes the secret and all recovery codes of a user.
+func DisableTwoFactor(userID int) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM two_factor WHERE user_id = ?", userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM recovery_codes WHERE user_id = ?", userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UseRecoveryCode marks a matching unused recovery code as used and reports
+// whether one was found.
+func UseRecoveryCode(userID int, code string) (bool, error) {
+	rows, err := DB.Query("SELECT id, code_hash FROM recovery_codes WHERE user_id = ? AND used = 0", userID)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	matchID := 0
+	for rows.Next() {
+		var id int
+		var hash string
+		if err := rows.Scan(&id, &hash); err != nil {
+			return false, err
+		}
+		if bcrypt.CompareHashAndPassword([]byte(hash), []byte(code)) == nil {
+			matchID = id
+			break
+		}
+	}
+	rows.Close()
+	if matchID == 0 {
+		return false, nil
+	}
+
+	// A concurrent login may have used the same code since it was read.
+	res, err := DB.Exec("UPDATE recovery_codes SET used = 1 WHERE id = ? AND used = 0", matchID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return err == nil && n == 1, err
+}
+
+// CountRecoveryCodes returns how many unused recovery codes a user has left.
+func CountRecoveryCodes(userID int) (int, error) {
+	var count int
+	err := DB.QueryRow("SELECT COUNT(*) FROM recovery_codes WHERE user_id = ? AND used = 0", userID).Scan(&count)
+	return count, err
+}
@@ -0,0 +1,69 @@
+package database
+
+import (
+	"DytForum/models"
+)
+
+// CreateUser inserts a user with an already hashed password and returns its ID.
+func CreateUser(username, email, passwordHash, role string) (int, error) {
+	res, err := DB.Exec("INSERT INTO users (username, email, password, role) VALUES (?, ?, ?, ?)", username, email, passwordHash, role)
+	if err != nil {
+		return 0, err
+	}
+	id, err := res.LastInsertId()
+	return int(id), err
+}
+
+// ListUsers returns every user without password hashes, ordered by ID.
+func ListUsers() ([]models.User, error) {
+	rows, err := DB.Query("SELECT id, username, email, role, disabled FROM users ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []models.User
+	for rows.Next() {
+		var user models.User
+		var disabled int
+		if err := rows.Scan(&user.ID, &user.Username, &user.Email, &user.Role, &disabled); err != nil {
+			return nil, err
+		}
+		user.Disabled = disabled == 1
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+// GetUser returns a user without the password hash, or sql.ErrNoRows.
+func GetUser(userID int) (models.User, error) {
+	var user models.User
+	var disabled int
+	err := DB.QueryRow("SELECT id, username, email, role, disabled FROM users WHERE id = ?", userID).Scan(&user.ID, &user.Username, &user.Email, &user.Role, &disabled)
+	user.Disabled = disabled == 1
+	return user, err
+}
+
+// GetUserStatus returns the current role of a user and whether the account is disabled.
+func GetUserStatus(userID int) (string, bool, error) {
+	var role string
+	var disabled int
+	err := DB.QueryRow("SELECT role, disabled FROM users WHERE id = ?", userID).Scan(&role, &disabled)
+	return role, disabled == 1, err
+}
+
+// SetUserDisabled enables or disables logging in to an account.
+func SetUserDisabled(userID int, disabled bool) error {
+	value := 0
+	if disabled {
+		value = 1
+	}
+	_, err := DB.Exec("UPDATE users SET disabled = ? WHERE id = ?", value, userID)
+	return err
+}
+
+// SetUserPassword replaces the password hash of a user.
+func SetUserPassword(userID int, passwordHash string) error {
+	_, err := DB.Exec("UPDATE users SET password = ? WHERE id = ?", passwordHash, userID)
+	return err
+}
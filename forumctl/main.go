@@ -0,0 +1,320 @@
+// forumctl/main.go
+//
+// forumctl manages the forum database from the command line:
+//
+//	forumctl [-db forum.db] <command> [flags]
+//
+// Run "forumctl help" for the list of commands.
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"DytForum/config"
+	"DytForum/database"
+	"DytForum/permissions"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+type command struct {
+	name    string
+	usage   string
+	migrate bool // whether the schema must be up to date before running
+	run     func(args []string) error
+}
+
+var commands = []command{
+	{"create-admin", "create an admin account (-username, -email, -password)", true, createAdmin},
+	{"create-user", "create an account (-username, -email, -password, -role)", true, createUser},
+	{"list-users", "list all accounts", true, listUsers},
+	{"disable-user", "prevent an account from logging in (-username)", true, disableUser},
+	{"enable-user", "allow a disabled account to log in again (-username)", true, enableUser},
+	{"set-role", "change the role of an account (-username, -role)", true, setRole},
+	{"reset-password", "set a new password (-username, -password)", true, resetPassword},
+	{"migrate", "apply pending schema migrations (-status to only list them)", false, migrate},
+	{"reindex", "rebuild the thread search index", true, reindex},
+	{"spam-retrain", "rebuild the spam filter (-history to learn from past threads)", true, spamRetrain},
+}
+
+func main() {
+	dbPath := flag.String("db", config.FromEnvironment().DatabasePath, "path to the SQLite database")
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() == 0 || flag.Arg(0) == "help" {
+		usage()
+		os.Exit(2)
+	}
+
+	name := flag.Arg(0)
+	for _, cmd := range commands {
+		if cmd.name != name {
+			continue
+		}
+		if err := database.Open(*dbPath); err != nil {
+			fail("failed to open database: %v", err)
+		}
+		defer database.DB.Close()
+		if cmd.migrate {
+			if err := database.Setup(); err != nil {
+				fail("failed to prepare database: %v", err)
+			}
+		}
+		if err := cmd.run(flag.Args()[1:]); err != nil {
+			fail("%s: %v", name, err)
+		}
+		return
+	}
+
+	fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+	usage()
+	os.Exit(2)
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: forumctl [-db path] <command> [flags]")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "commands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-15s %s\n", cmd.name, cmd.usage)
+	}
+}
+
+func fail(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "forumctl: "+format+"\n", args...)
+	os.Exit(1)
+}
+
+// randomPassword is used when no -password flag is given.
+func randomPassword() (string, error) {
+	buf := make([]byte, 12)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// passwordOrRandom hashes password, generating and printing one if it is empty.
+func passwordOrRandom(password string) (string, error) {
+	if password == "" {
+		var err error
+		password, err = randomPassword()
+		if err != nil {
+			return "", err
+		}
+		fmt.Printf("generated password: %s\n", password)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(hash), err
+}
+
+func userID(username string) (int, error) {
+	if username == "" {
+		return 0, fmt.Errorf("-username is required")
+	}
+	user, err := database.GetUserByUsername(username)
+	if err != nil {
+		return 0, fmt.Errorf("user %q not found", username)
+	}
+	return user.ID, nil
+}
+
+func createAdmin(args []string) error {
+	return createAccount("create-admin", args, permissions.AdminRole, false)
+}
+
+func createUser(args []string) error {
+	return createAccount("create-user", args, permissions.UserRole, true)
+}
+
+func createAccount(name string, args []string, role string, roleFlag bool) error {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	username := fs.String("username", "", "account name")
+	email := fs.String("email", "", "email address")
+	password := fs.String("password", "", "password (generated when empty)")
+	if roleFlag {
+		fs.StringVar(&role, "role", role, "role of the new account")
+	}
+	fs.Parse(args)
+
+	if *username == "" || *email == "" {
+		return fmt.Errorf("-username and -email are required")
+	}
+	exists, err := database.RoleExists(role)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("unknown role %q", role)
+	}
+
+	hash, err := passwordOrRandom(*password)
+	if err != nil {
+		return err
+	}
+	id, err := database.CreateUser(*username, *email, hash, role)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("created %s %q with ID %d\n", role, *username, id)
+	return nil
+}
+
+func listUsers(args []string) error {
+	users, err := database.ListUsers()
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tUSERNAME\tEMAIL\tROLE\tSTATUS")
+	for _, user := range users {
+		status := "active"
+		if user.Disabled {
+			status = "disabled"
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\n", user.ID, user.Username, user.Email, user.Role, status)
+	}
+	return tw.Flush()
+}
+
+func disableUser(args []string) error {
+	return setDisabled("disable-user", args, true)
+}
+
+func enableUser(args []string) error {
+	return setDisabled("enable-user", args, false)
+}
+
+func setDisabled(name string, args []string, disabled bool) error {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	username := fs.String("username", "", "account name")
+	fs.Parse(args)
+
+	id, err := userID(*username)
+	if err != nil {
+		return err
+	}
+	if err := database.SetUserDisabled(id, disabled); err != nil {
+		return err
+	}
+	if disabled {
+		fmt.Printf("disabled %q\n", *username)
+	} else {
+		fmt.Printf("enabled %q\n", *username)
+	}
+	return nil
+}
+
+func setRole(args []string) error {
+	fs := flag.NewFlagSet("set-role", flag.ExitOnError)
+	username := fs.String("username", "", "account name")
+	role := fs.String("role", "", "new role")
+	fs.Parse(args)
+
+	id, err := userID(*username)
+	if err != nil {
+		return err
+	}
+	exists, err := database.RoleExists(*role)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("unknown role %q", *role)
+	}
+	if err := database.UpdateUserRole(id, *role); err != nil {
+		return err
+	}
+	fmt.Printf("%q is now %s\n", *username, *role)
+	return nil
+}
+
+func resetPassword(args []string) error {
+	fs := flag.NewFlagSet("reset-password", flag.ExitOnError)
+	username := fs.String("username", "", "account name")
+	password := fs.String("password", "", "new password (generated when empty)")
+	fs.Parse(args)
+
+	id, err := userID(*username)
+	if err != nil {
+		return err
+	}
+	hash, err := passwordOrRandom(*password)
+	if err != nil {
+		return err
+	}
+	if err := database.SetUserPassword(id, hash); err != nil {
+		return err
+	}
+	fmt.Printf("password of %q has been reset\n", *username)
+	return nil
+}
+
+func migrate(args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	status := fs.Bool("status", false, "only list migrations and whether they have been applied")
+	fs.Parse(args)
+
+	if *status {
+		statuses, err := database.Migrations()
+		if err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "VERSION\tNAME\tAPPLIED")
+		for _, s := range statuses {
+			applied := "pending"
+			if s.AppliedAt != nil {
+				applied = s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Fprintf(tw, "%d\t%s\t%s\n", s.Version, s.Name, applied)
+		}
+		return tw.Flush()
+	}
+
+	if err := database.Setup(); err != nil {
+		return err
+	}
+	pending, err := database.PendingMigrations()
+	if err != nil {
+		return err
+	}
+	if pending > 0 {
+		return fmt.Errorf("%d migrations are still pending", pending)
+	}
+	fmt.Println("database schema is up to date")
+	return nil
+}
+
+func reindex(args []string) error {
+	count, err := database.RebuildSearchIndex()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("indexed %d threads\n", count)
+	return nil
+}
+
+func spamRetrain(args []string) error {
+	fs := flag.NewFlagSet("spam-retrain", flag.ExitOnError)
+	history := fs.Bool("history", false, "also learn from every approved thread and every thread rejected as spam")
+	fs.Parse(args)
+
+	docs, err := database.RetrainSpam(*history)
+	if err != nil {
+		return err
+	}
+	_, tokens, err := database.SpamStats()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("trained on %d spam and %d legitimate posts, %d words\n", docs.Spam, docs.Ham, tokens)
+	return nil
+}
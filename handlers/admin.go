@@ -1,67 +1,70 @@
 package handlers
 
 import (
-	"html/template"
-	"log"
 	"net/http"
 	"strconv"
 
+	"DytForum/apperror"
 	"DytForum/database"
 	"DytForum/models"
-	"DytForum/session"
 
 	"github.com/gorilla/mux"
 )
 
-func AdminPanelHandler(w http.ResponseWriter, r *http.Request) {
-	session, _ := session.Store.Get(r, "session-name")
-	role := session.Values["role"]
-	if role != "admin" {
-		http.Error(w, "You must be an admin to access this page", http.StatusForbidden)
-		return
-	}
-
+func AdminPanelHandler(w http.ResponseWriter, r *http.Request) error {
 	moderatorRequests, err := fetchModeratorRequests()
 	if err != nil {
-		http.Error(w, "Failed to fetch moderator requests", http.StatusInternalServerError)
-		return
+		return apperror.Internal(err, "Failed to fetch moderator requests")
 	}
 
 	users, err := fetchUsers()
 	if err != nil {
-		http.Error(w, "Failed to fetch users", http.StatusInternalServerError)
-		return
+		return apperror.Internal(err, "Failed to fetch users")
 	}
 
-	reports, err := fetchReports()
+	reports, err := database.ListReports(database.ReportQuery{Statuses: []string{database.ReportOpen, database.ReportInReview}})
 	if err != nil {
-		http.Error(w, "Failed to fetch reports", http.StatusInternalServerError)
-		return
+		return apperror.Internal(err, "Failed to fetch reports")
 	}
 
 	categories, err := fetchCategories()
 	if err != nil {
-		http.Error(w, "Failed to fetch categories", http.StatusInternalServerError)
-		return
+		return apperror.Internal(err, "Failed to fetch categories")
 	}
 
-	tmpl := template.Must(template.ParseFiles("templates/admin_panel.html"))
-	data := struct {
-		ModeratorRequests []models.ModeratorRequest
-		Users             []models.User
-		Reports           []models.Report
-		Categories        []models.Category
-	}{
-		ModeratorRequests: moderatorRequests,
-		Users:             users,
-		Reports:           reports,
-		Categories:        categories,
+	categoryModerators, err := database.GetCategoryModerators()
+	if err != nil {
+		return apperror.Internal(err, "Failed to fetch category moderators")
+	}
+
+	roles, err := database.GetRoles()
+	if err != nil {
+		return apperror.Internal(err, "Failed to fetch roles")
 	}
-	err = tmpl.Execute(w, data)
+
+	requireStaff2FA, err := database.GetBoolSetting(database.SettingRequireStaff2FA, false)
 	if err != nil {
-		http.Error(w, "Failed to render admin panel", http.StatusInternalServerError)
-		log.Printf("Failed to render admin panel: %v", err)
+		return apperror.Internal(err, "Failed to fetch settings")
+	}
+
+	data := struct {
+		ModeratorRequests  []models.ModeratorRequest
+		Users              []models.User
+		Reports            []models.Report
+		Categories         []models.Category
+		CategoryModerators []models.CategoryModerator
+		Roles              []models.Role
+		RequireStaff2FA    bool
+	}{
+		ModeratorRequests:  moderatorRequests,
+		Users:              users,
+		Reports:            reports,
+		Categories:         categories,
+		CategoryModerators: categoryModerators,
+		Roles:              roles,
+		RequireStaff2FA:    requireStaff2FA,
 	}
+	return renderTemplate(w, r, adminPanelPage, data)
 }
 
 func fetchModeratorRequests() ([]models.ModeratorRequest, error) {
@@ -85,7 +88,11 @@ func fetchModeratorRequests() ([]models.ModeratorRequest, error) {
 }
 
 func fetchUsers() ([]models.User, error) {
-	rows, err := database.DB.Query("SELECT id, username, email, role FROM users")
+	rows, err := database.DB.Query(`
+		SELECT users.id, users.username, users.email, users.role, COALESCE(two_factor.enabled, 0)
+		FROM users
+		LEFT JOIN two_factor ON two_factor.user_id = users.id
+	`)
 	if err != nil {
 		return nil, err
 	}
@@ -94,162 +101,204 @@ func fetchUsers() ([]models.User, error) {
 	var users []models.User
 	for rows.Next() {
 		var user models.User
-		err := rows.Scan(&user.ID, &user.Username, &user.Email, &user.Role)
+		var twoFactor int
+		err := rows.Scan(&user.ID, &user.Username, &user.Email, &user.Role, &twoFactor)
 		if err != nil {
 			return nil, err
 		}
+		user.TwoFactorEnabled = twoFactor == 1
 		users = append(users, user)
 	}
 
 	return users, nil
 }
 
-func fetchReports() ([]models.Report, error) {
-	rows, err := database.DB.Query(`
-		SELECT 
-			reports.id, reports.thread_id, reports.user_id, reports.reason, 
-			users.username, threads.title, threads.content 
-		FROM reports 
-		INNER JOIN users ON reports.user_id = users.id 
-		INNER JOIN threads ON reports.thread_id = threads.id
-	`)
+func PromoteUserHandler(w http.ResponseWriter, r *http.Request) error {
+	vars := mux.Vars(r)
+	userID, err := strconv.Atoi(vars["id"])
 	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var reports []models.Report
-	for rows.Next() {
-		var report models.Report
-		err := rows.Scan(&report.ID, &report.ThreadID, &report.UserID, &report.Reason, &report.Username, &report.Title, &report.Content)
-		if err != nil {
-			return nil, err
-		}
-		reports = append(reports, report)
+		return apperror.BadRequest("Invalid user ID")
 	}
 
-	return reports, nil
-}
-
-func PromoteUserHandler(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	userID, err := strconv.Atoi(vars["id"])
+	before, err := database.GetUserRole(userID)
 	if err != nil {
-		http.Error(w, "Invalid user ID", http.StatusBadRequest)
-		return
+		return apperror.NotFound("User not found")
 	}
 
 	_, err = database.DB.Exec("UPDATE users SET role = 'moderator' WHERE id = ?", userID)
 	if err != nil {
-		http.Error(w, "Failed to promote user", http.StatusInternalServerError)
-		return
+		return apperror.Internal(err, "Failed to promote user")
+	}
+	if err := recordModAction(r, database.ModUserRole, "user", userID, map[string]string{"role": before}, map[string]string{"role": "moderator"}, ""); err != nil {
+		return err
 	}
 
 	http.Redirect(w, r, "/admin/panel", http.StatusSeeOther)
+	return nil
 }
 
-func DemoteUserHandler(w http.ResponseWriter, r *http.Request) {
+func DemoteUserHandler(w http.ResponseWriter, r *http.Request) error {
 	vars := mux.Vars(r)
 	userID, err := strconv.Atoi(vars["id"])
 	if err != nil {
-		http.Error(w, "Invalid user ID", http.StatusBadRequest)
-		return
+		return apperror.BadRequest("Invalid user ID")
+	}
+
+	before, err := database.GetUserRole(userID)
+	if err != nil {
+		return apperror.NotFound("User not found")
 	}
 
 	_, err = database.DB.Exec("UPDATE users SET role = 'user' WHERE id = ?", userID)
 	if err != nil {
-		http.Error(w, "Failed to demote user", http.StatusInternalServerError)
-		return
+		return apperror.Internal(err, "Failed to demote user")
+	}
+	if err := recordModAction(r, database.ModUserRole, "user", userID, map[string]string{"role": before}, map[string]string{"role": "user"}, ""); err != nil {
+		return err
 	}
 
 	http.Redirect(w, r, "/admin/panel", http.StatusSeeOther)
+	return nil
 }
 
-func ApproveModeratorHandler(w http.ResponseWriter, r *http.Request) {
+func ApproveModeratorHandler(w http.ResponseWriter, r *http.Request) error {
 	vars := mux.Vars(r)
 	userID, err := strconv.Atoi(vars["id"])
 	if err != nil {
-		http.Error(w, "Invalid user ID", http.StatusBadRequest)
-		return
+		return apperror.BadRequest("Invalid user ID")
+	}
+
+	before, err := database.GetUserRole(userID)
+	if err != nil {
+		return apperror.NotFound("User not found")
 	}
 
 	_, err = database.DB.Exec("UPDATE users SET role = 'moderator' WHERE id = ?", userID)
 	if err != nil {
-		http.Error(w, "Failed to approve moderator request", http.StatusInternalServerError)
-		return
+		return apperror.Internal(err, "Failed to approve moderator request")
 	}
 
 	_, err = database.DB.Exec("UPDATE moderator_requests SET status = 'approved' WHERE user_id = ?", userID)
 	if err != nil {
-		http.Error(w, "Failed to update moderator request status", http.StatusInternalServerError)
-		return
+		return apperror.Internal(err, "Failed to update moderator request status")
+	}
+	if err := recordModAction(r, database.ModModeratorRequestAccept, "user", userID, map[string]string{"role": before}, map[string]string{"role": "moderator"}, ""); err != nil {
+		return err
 	}
 
 	http.Redirect(w, r, "/admin/panel", http.StatusSeeOther)
+	return nil
 }
 
-func RejectModeratorHandler(w http.ResponseWriter, r *http.Request) {
+func RejectModeratorHandler(w http.ResponseWriter, r *http.Request) error {
 	vars := mux.Vars(r)
 	userID, err := strconv.Atoi(vars["id"])
 	if err != nil {
-		http.Error(w, "Invalid user ID", http.StatusBadRequest)
-		return
+		return apperror.BadRequest("Invalid user ID")
 	}
 
 	_, err = database.DB.Exec("DELETE FROM moderator_requests WHERE user_id = ?", userID)
 	if err != nil {
-		http.Error(w, "Failed to reject moderator request", http.StatusInternalServerError)
-		return
+		return apperror.Internal(err, "Failed to reject moderator request")
+	}
+	if err := recordModAction(r, database.ModModeratorRequestReject, "user", userID, nil, nil, ""); err != nil {
+		return err
 	}
 
 	http.Redirect(w, r, "/admin/panel", http.StatusSeeOther)
+	return nil
 }
 
-func CreateCategoryHandler(w http.ResponseWriter, r *http.Request) {
+func CreateCategoryHandler(w http.ResponseWriter, r *http.Request) error {
 	if r.Method == "POST" {
 		categoryName := r.FormValue("category")
-		_, err := database.DB.Exec("INSERT INTO categories (name) VALUES (?)", categoryName)
+		res, err := database.DB.Exec("INSERT INTO categories (name) VALUES (?)", categoryName)
 		if err != nil {
-			http.Error(w, "Failed to create category", http.StatusInternalServerError)
-			return
+			return apperror.Internal(err, "Failed to create category")
+		}
+		categoryID, _ := res.LastInsertId()
+		if err := recordModAction(r, database.ModCategoryCreate, "category", int(categoryID), nil, map[string]string{"name": categoryName}, ""); err != nil {
+			return err
 		}
 		http.Redirect(w, r, "/admin/panel", http.StatusSeeOther)
 	} else {
-		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return apperror.MethodNotAllowed()
 	}
+	return nil
 }
 
-func DeleteCategoryHandler(w http.ResponseWriter, r *http.Request) {
+func DeleteCategoryHandler(w http.ResponseWriter, r *http.Request) error {
 	if r.Method == "GET" {
-		tmpl := template.Must(template.ParseFiles("templates/delete_category.html"))
-		tmpl.Execute(w, nil)
+		return renderTemplate(w, r, deleteCategoryPage, nil)
 	} else if r.Method == "POST" {
 		categoryID, err := strconv.Atoi(r.FormValue("category_id"))
 		if err != nil {
-			http.Error(w, "Invalid category ID", http.StatusBadRequest)
-			return
+			return apperror.BadRequest("Invalid category ID")
 		}
-		_, err = database.DB.Exec("DELETE FROM categories WHERE id = ?", categoryID)
+		var categoryName string
+		err = database.DB.QueryRow("SELECT name FROM categories WHERE id = ?", categoryID).Scan(&categoryName)
 		if err != nil {
-			http.Error(w, "Failed to delete category", http.StatusInternalServerError)
-			return
+			return apperror.NotFound("Category not found")
+		}
+		if err := database.DeleteCategory(categoryID); err != nil {
+			return apperror.Internal(err, "Failed to delete category")
+		}
+		if err := recordModAction(r, database.ModCategoryDelete, "category", categoryID, map[string]string{"name": categoryName}, nil, ""); err != nil {
+			return err
 		}
 		http.Redirect(w, r, "/admin/panel", http.StatusSeeOther)
 	}
+	return nil
+}
+
+// AssignCategoryModeratorHandler makes a user moderator of a category.
+func AssignCategoryModeratorHandler(w http.ResponseWriter, r *http.Request) error {
+	categoryID, err := strconv.Atoi(r.FormValue("category_id"))
+	if err != nil {
+		return apperror.BadRequest("Invalid category ID")
+	}
+	userID, err := strconv.Atoi(r.FormValue("user_id"))
+	if err != nil {
+		return apperror.BadRequest("Invalid user ID")
+	}
+
+	if err := database.AssignCategoryModerator(categoryID, userID); err != nil {
+		return apperror.Internal(err, "Failed to assign moderator")
+	}
+	if err := recordModAction(r, database.ModCategoryModeratorAdd, "category", categoryID, nil, map[string]int{"user_id": userID}, ""); err != nil {
+		return err
+	}
+
+	http.Redirect(w, r, "/admin/panel", http.StatusSeeOther)
+	return nil
 }
 
-func ListModeratorRequestsHandler(w http.ResponseWriter, r *http.Request) {
-	session, _ := session.Store.Get(r, "session-name")
-	role := session.Values["role"]
-	if role != "admin" {
-		http.Error(w, "You must be an admin to access this page", http.StatusForbidden)
-		return
+// RemoveCategoryModeratorHandler takes a category away from a moderator.
+func RemoveCategoryModeratorHandler(w http.ResponseWriter, r *http.Request) error {
+	categoryID, err := strconv.Atoi(r.FormValue("category_id"))
+	if err != nil {
+		return apperror.BadRequest("Invalid category ID")
+	}
+	userID, err := strconv.Atoi(r.FormValue("user_id"))
+	if err != nil {
+		return apperror.BadRequest("Invalid user ID")
+	}
+
+	if err := database.RemoveCategoryModerator(categoryID, userID); err != nil {
+		return apperror.Internal(err, "Failed to remove moderator")
 	}
+	if err := recordModAction(r, database.ModCategoryModeratorDrop, "category", categoryID, map[string]int{"user_id": userID}, nil, ""); err != nil {
+		return err
+	}
+
+	http.Redirect(w, r, "/admin/panel", http.StatusSeeOther)
+	return nil
+}
 
+func ListModeratorRequestsHandler(w http.ResponseWriter, r *http.Request) error {
 	rows, err := database.DB.Query("SELECT users.id, users.username, moderator_requests.reason FROM users INNER JOIN moderator_requests ON users.id = moderator_requests.user_id WHERE moderator_requests.status = 'pending'")
 	if err != nil {
-		http.Error(w, "Server error, unable to retrieve requests.", http.StatusInternalServerError)
-		return
+		return apperror.Internal(err, "Server error, unable to retrieve requests.")
 	}
 	defer rows.Close()
 
@@ -258,12 +307,10 @@ func ListModeratorRequestsHandler(w http.ResponseWriter, r *http.Request) {
 		var request models.ModeratorRequest
 		err := rows.Scan(&request.UserID, &request.Username, &request.Reason)
 		if err != nil {
-			http.Error(w, "Server error, unable to process request.", http.StatusInternalServerError)
-			return
+			return apperror.Internal(err, "Server error, unable to process request.")
 		}
 		requests = append(requests, request)
 	}
 
-	tmpl := template.Must(template.ParseFiles("templates/moderator_requests.html"))
-	tmpl.Execute(w, struct{ Requests []models.ModeratorRequest }{Requests: requests})
+	return renderTemplate(w, r, moderatorRequestsPage, struct{ Requests []models.ModeratorRequest }{Requests: requests})
 }
@@ -0,0 +1,179 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"DytForum/apperror"
+	"DytForum/database"
+	"DytForum/middleware"
+	"DytForum/models"
+	"DytForum/permissions"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+	maxBodyBytes    = 1 << 20
+)
+
+// apiList is the envelope of every list response. NextCursor is empty on
+// the last page.
+type apiList struct {
+	Data       interface{} `json:"data"`
+	NextCursor string      `json:"next_cursor,omitempty"`
+}
+
+// apiItem is the envelope of every single object response.
+type apiItem struct {
+	Data interface{} `json:"data"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	return json.NewEncoder(w).Encode(v)
+}
+
+// decodeJSON reads a JSON request body into v. Requiring the JSON content
+// type also keeps plain HTML forms on other sites from posting to the API
+// with the visitor's session cookie.
+func decodeJSON(w http.ResponseWriter, r *http.Request, v interface{}) error {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "application/json" {
+		return apperror.New(http.StatusUnsupportedMediaType, "Content-Type must be application/json")
+	}
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodyBytes))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return apperror.New(http.StatusRequestEntityTooLarge, "Request body is too large")
+		}
+		return apperror.BadRequest("Invalid JSON: " + err.Error())
+	}
+	if dec.Decode(&struct{}{}) != io.EOF {
+		return apperror.BadRequest("Request body must contain a single JSON object")
+	}
+	return nil
+}
+
+// pageParams reads the limit and cursor query parameters. The cursor is the
+// ID of the last item of the previous page.
+func pageParams(r *http.Request) (cursor, limit int, err error) {
+	limit = defaultPageSize
+	if v := r.URL.Query().Get("limit"); v != "" {
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxPageSize {
+			return 0, 0, apperror.Invalid(map[string]string{"limit": "must be between 1 and " + strconv.Itoa(maxPageSize)})
+		}
+	}
+	if v := r.URL.Query().Get("cursor"); v != "" {
+		raw, decodeErr := base64.RawURLEncoding.DecodeString(v)
+		if decodeErr == nil {
+			cursor, decodeErr = strconv.Atoi(string(raw))
+		}
+		if decodeErr != nil || cursor <= 0 {
+			return 0, 0, apperror.Invalid(map[string]string{"cursor": "is not valid"})
+		}
+	}
+	return cursor, limit, nil
+}
+
+// nextCursor returns the cursor of the page after one ending with lastID,
+// or "" when the page was not full and so is the last one.
+func nextCursor(count, limit, lastID int) string {
+	if count < limit {
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(lastID)))
+}
+
+// pathID reads a numeric route variable.
+func pathID(r *http.Request, name string) (int, error) {
+	id, err := strconv.Atoi(mux.Vars(r)[name])
+	if err != nil {
+		return 0, apperror.BadRequest("Invalid " + name)
+	}
+	return id, nil
+}
+
+// apiPrincipal returns the user behind an API request; routes that need one
+// are wrapped in middleware.APIRequire, so ok is only false on public routes.
+func apiPrincipal(r *http.Request) (middleware.Principal, bool) {
+	return middleware.PrincipalFrom(r.Context())
+}
+
+// principalCan reports whether the principal's role grants perm and, for
+// token requests, the token has the scope perm needs.
+func principalCan(p middleware.Principal, perm string) (bool, error) {
+	if !p.HasScope(permissions.ScopeFor(perm)) {
+		return false, nil
+	}
+	return database.RoleHasPermission(p.Role, perm)
+}
+
+// principalScope is currentModerationScope for API requests.
+func principalScope(p middleware.Principal) (moderationScope, error) {
+	all, err := principalCan(p, permissions.ModerateAllCategories)
+	return moderationScope{UserID: p.UserID, All: all}, err
+}
+
+// validateText records in fields when value, already trimmed, is empty or
+// longer than max characters.
+func validateText(fields map[string]string, name, value string, max int) {
+	switch {
+	case value == "":
+		fields[name] = "is required"
+	case len([]rune(value)) > max:
+		fields[name] = "must be at most " + strconv.Itoa(max) + " characters"
+	}
+}
+
+type apiMe struct {
+	ID          int      `json:"id"`
+	Username    string   `json:"username"`
+	Email       string   `json:"email"`
+	Role        string   `json:"role"`
+	Permissions []string `json:"permissions"`
+}
+
+// APIMeHandler returns the current user and what their role allows.
+func APIMeHandler(w http.ResponseWriter, r *http.Request) error {
+	p, _ := apiPrincipal(r)
+	user, err := database.GetUser(p.UserID)
+	if err != nil {
+		return apperror.Internal(err, "Failed to fetch user")
+	}
+	me := apiMe{ID: p.UserID, Username: user.Username, Email: user.Email, Role: p.Role, Permissions: []string{}}
+	for _, perm := range permissions.All {
+		allowed, err := principalCan(p, perm.Name)
+		if err != nil {
+			return apperror.Internal(err, "Failed to check permissions")
+		}
+		if allowed {
+			me.Permissions = append(me.Permissions, perm.Name)
+		}
+	}
+	return writeJSON(w, http.StatusOK, apiItem{Data: me})
+}
+
+// APICategoriesHandler lists every category.
+func APICategoriesHandler(w http.ResponseWriter, r *http.Request) error {
+	categories, err := fetchCategories()
+	if err != nil {
+		return apperror.Internal(err, "Failed to fetch categories")
+	}
+	if categories == nil {
+		categories = []models.Category{}
+	}
+	return writeJSON(w, http.StatusOK, apiList{Data: categories})
+}
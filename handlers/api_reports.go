@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"net/http"
+
+	"DytForum/apperror"
+	"DytForum/database"
+	"DytForum/models"
+)
+
+const maxReportDetailsLength = 1000
+
+// reportInput is a report filed through the API or the report form.
+// ThreadID is the older way of reporting a thread and is used when
+// TargetType is empty.
+type reportInput struct {
+	TargetType string `json:"target_type"`
+	TargetID   int    `json:"target_id"`
+	ThreadID   int    `json:"thread_id,omitempty"`
+	Reason     string `json:"reason"`
+	Details    string `json:"details"`
+}
+
+// APICreateReportHandler reports a thread, comment or user to the moderators.
+func APICreateReportHandler(w http.ResponseWriter, r *http.Request) error {
+	p, _ := apiPrincipal(r)
+	if err := checkCanPost(p.UserID); err != nil {
+		return err
+	}
+	var in reportInput
+	if err := decodeJSON(w, r, &in); err != nil {
+		return err
+	}
+	report, _, err := reportFromInput(p.UserID, in)
+	if err != nil {
+		return err
+	}
+
+	reportID, err := database.CreateReport(report)
+	if err != nil {
+		return apperror.Internal(err, "Failed to save report")
+	}
+	created, err := database.GetReport(reportID)
+	if err != nil {
+		return apperror.Internal(err, "Failed to fetch report")
+	}
+	return writeJSON(w, http.StatusCreated, apiItem{Data: created})
+}
+
+// APIReportReasonsHandler lists the reasons a report can give.
+func APIReportReasonsHandler(w http.ResponseWriter, r *http.Request) error {
+	reasons, err := database.ListReportReasons()
+	if err != nil {
+		return apperror.Internal(err, "Failed to fetch report reasons")
+	}
+	if reasons == nil {
+		reasons = []models.ReportReason{}
+	}
+	return writeJSON(w, http.StatusOK, apiList{Data: reasons})
+}
+
+// APIListReportsHandler lists the open and in-review reports in the
+// moderator's categories.
+func APIListReportsHandler(w http.ResponseWriter, r *http.Request) error {
+	p, _ := apiPrincipal(r)
+	scope, err := principalScope(p)
+	if err != nil {
+		return apperror.Internal(err, "Failed to check permissions")
+	}
+	reports, err := fetchPendingReports(scope)
+	if err != nil {
+		return apperror.Internal(err, "Failed to retrieve reports")
+	}
+	if reports == nil {
+		reports = []models.Report{}
+	}
+	return writeJSON(w, http.StatusOK, apiList{Data: reports})
+}
@@ -0,0 +1,439 @@
+package handlers
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"DytForum/apperror"
+	"DytForum/database"
+	"DytForum/middleware"
+	"DytForum/models"
+	"DytForum/permissions"
+)
+
+const (
+	maxTitleLength   = 200
+	maxContentLength = 20000
+	maxCommentLength = 10000
+)
+
+// APIListThreadsHandler lists approved threads newest first, optionally in
+// one category. Logged in users also see their own pending threads.
+func APIListThreadsHandler(w http.ResponseWriter, r *http.Request) error {
+	cursor, limit, err := pageParams(r)
+	if err != nil {
+		return err
+	}
+	q := database.ThreadQuery{Before: cursor, Limit: limit}
+	if v := r.URL.Query().Get("category_id"); v != "" {
+		if q.CategoryID, err = strconv.Atoi(v); err != nil {
+			return apperror.Invalid(map[string]string{"category_id": "must be a number"})
+		}
+	}
+	if p, ok := apiPrincipal(r); ok {
+		q.ViewerID = p.UserID
+	}
+
+	threads, err := database.ListThreads(q)
+	if err != nil {
+		return apperror.Internal(err, "Failed to fetch threads")
+	}
+	for i := range threads {
+		threads[i] = threadForViewer(r, threads[i])
+	}
+	resp := apiList{Data: threads}
+	if len(threads) > 0 {
+		resp.NextCursor = nextCursor(len(threads), limit, threads[len(threads)-1].ID)
+	}
+	return writeJSON(w, http.StatusOK, resp)
+}
+
+// apiThread loads a thread the request may see: approved threads, the
+// author's own and, for moderators, pending threads in their categories.
+// Anything else is reported as missing so pending threads stay hidden.
+func apiThread(r *http.Request, threadID int) (models.Thread, error) {
+	thread, err := database.GetThread(threadID)
+	if err == sql.ErrNoRows {
+		return thread, apperror.NotFound("Thread not found")
+	}
+	if err != nil {
+		return thread, apperror.Internal(err, "Failed to fetch thread")
+	}
+	if thread.Approved == database.ThreadApproved {
+		return thread, nil
+	}
+	p, ok := apiPrincipal(r)
+	if !ok {
+		return thread, apperror.NotFound("Thread not found")
+	}
+	if p.UserID == thread.UserID {
+		return thread, nil
+	}
+	visible, err := principalModerates(p, permissions.ThreadApprove, threadID)
+	if err != nil {
+		return thread, apperror.Internal(err, "Failed to check permissions")
+	}
+	if !visible {
+		return thread, apperror.NotFound("Thread not found")
+	}
+	return thread, nil
+}
+
+// threadForViewer shows a shadow-hidden thread to its author as published,
+// as the thread pages do, so the API does not reveal the shadow-hide.
+// Moderators see the real state.
+func threadForViewer(r *http.Request, t models.Thread) models.Thread {
+	if t.Approved == database.ThreadShadowHidden {
+		if p, ok := apiPrincipal(r); ok && p.UserID == t.UserID {
+			t.Approved = database.ThreadApproved
+		}
+	}
+	return t
+}
+
+// commentForViewer is threadForViewer for comments.
+func commentForViewer(r *http.Request, c models.Comment) models.Comment {
+	if c.Approved == database.CommentShadowHidden {
+		if p, ok := apiPrincipal(r); ok && p.UserID == c.UserID {
+			c.Approved = database.CommentVisible
+		}
+	}
+	return c
+}
+
+// principalModerates reports whether the principal holds perm and the thread
+// is in a category they moderate.
+func principalModerates(p middleware.Principal, perm string, threadID int) (bool, error) {
+	allowed, err := principalCan(p, perm)
+	if err != nil || !allowed {
+		return false, err
+	}
+	scope, err := principalScope(p)
+	if err != nil {
+		return false, err
+	}
+	return scope.allowsThread(threadID)
+}
+
+// APIGetThreadHandler returns one thread.
+func APIGetThreadHandler(w http.ResponseWriter, r *http.Request) error {
+	threadID, err := pathID(r, "id")
+	if err != nil {
+		return err
+	}
+	thread, err := apiThread(r, threadID)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, apiItem{Data: threadForViewer(r, thread)})
+}
+
+type threadInput struct {
+	CategoryID int    `json:"category_id"`
+	Title      string `json:"title"`
+	Content    string `json:"content"`
+}
+
+// APICreateThreadHandler creates a thread. It waits for moderator approval
+// unless the automoderation rules decide otherwise.
+func APICreateThreadHandler(w http.ResponseWriter, r *http.Request) error {
+	p, _ := apiPrincipal(r)
+	if err := checkCanPost(p.UserID); err != nil {
+		return err
+	}
+	var in threadInput
+	if err := decodeJSON(w, r, &in); err != nil {
+		return err
+	}
+	in.Title = strings.TrimSpace(in.Title)
+	in.Content = strings.TrimSpace(in.Content)
+
+	fields := map[string]string{}
+	validateText(fields, "title", in.Title, maxTitleLength)
+	validateText(fields, "content", in.Content, maxContentLength)
+	exists, err := database.CategoryExists(in.CategoryID)
+	if err != nil {
+		return apperror.Internal(err, "Failed to fetch categories")
+	}
+	if !exists {
+		fields["category_id"] = "does not exist"
+	}
+	if len(fields) > 0 {
+		return apperror.Invalid(fields)
+	}
+
+	threadID, err := createThread(r, p.UserID, in.CategoryID, in.Title, in.Content)
+	if err != nil {
+		return err
+	}
+	postsTotal.Inc("thread")
+
+	thread, err := database.GetThread(threadID)
+	if err != nil {
+		return apperror.Internal(err, "Failed to fetch thread")
+	}
+	w.Header().Set("Location", "/api/v1/threads/"+strconv.Itoa(threadID))
+	return writeJSON(w, http.StatusCreated, apiItem{Data: threadForViewer(r, thread)})
+}
+
+type threadUpdate struct {
+	Title   *string `json:"title"`
+	Content *string `json:"content"`
+}
+
+// APIUpdateThreadHandler lets the author change the title or content of a
+// thread that was not rejected. The edited thread goes back to the
+// moderation queue.
+func APIUpdateThreadHandler(w http.ResponseWriter, r *http.Request) error {
+	p, _ := apiPrincipal(r)
+	if err := checkCanPost(p.UserID); err != nil {
+		return err
+	}
+	threadID, err := pathID(r, "id")
+	if err != nil {
+		return err
+	}
+	thread, err := apiThread(r, threadID)
+	if err != nil {
+		return err
+	}
+	if thread.UserID != p.UserID {
+		return apperror.Forbidden("Only the author can edit a thread")
+	}
+	if thread.Approved == database.ThreadRejected {
+		return apperror.Forbidden("Rejected threads cannot be edited; appeal the rejection instead")
+	}
+
+	var in threadUpdate
+	if err := decodeJSON(w, r, &in); err != nil {
+		return err
+	}
+	if in.Title != nil {
+		thread.Title = strings.TrimSpace(*in.Title)
+	}
+	if in.Content != nil {
+		thread.Content = strings.TrimSpace(*in.Content)
+	}
+	fields := map[string]string{}
+	validateText(fields, "title", thread.Title, maxTitleLength)
+	validateText(fields, "content", thread.Content, maxContentLength)
+	if len(fields) > 0 {
+		return apperror.Invalid(fields)
+	}
+
+	if err := database.UpdateThread(threadID, thread.Title, thread.Content); err != nil {
+		return apperror.Internal(err, "Failed to update thread")
+	}
+	if thread.Approved != database.ThreadShadowHidden {
+		thread.Approved = database.ThreadPending
+	}
+	return writeJSON(w, http.StatusOK, apiItem{Data: threadForViewer(r, thread)})
+}
+
+// APIListCommentsHandler lists the comments of a thread oldest first.
+func APIListCommentsHandler(w http.ResponseWriter, r *http.Request) error {
+	threadID, err := pathID(r, "id")
+	if err != nil {
+		return err
+	}
+	if _, err := apiThread(r, threadID); err != nil {
+		return err
+	}
+	cursor, limit, err := pageParams(r)
+	if err != nil {
+		return err
+	}
+
+	p, _ := apiPrincipal(r)
+	comments, err := database.ListComments(threadID, p.UserID, cursor, limit)
+	if err != nil {
+		return apperror.Internal(err, "Failed to fetch comments")
+	}
+	for i := range comments {
+		comments[i] = commentForViewer(r, comments[i])
+	}
+	resp := apiList{Data: comments}
+	if len(comments) > 0 {
+		resp.NextCursor = nextCursor(len(comments), limit, comments[len(comments)-1].ID)
+	}
+	return writeJSON(w, http.StatusOK, resp)
+}
+
+type commentInput struct {
+	Content string `json:"content"`
+}
+
+// APICreateCommentHandler adds a comment to a thread.
+func APICreateCommentHandler(w http.ResponseWriter, r *http.Request) error {
+	p, _ := apiPrincipal(r)
+	if err := checkCanPost(p.UserID); err != nil {
+		return err
+	}
+	threadID, err := pathID(r, "id")
+	if err != nil {
+		return err
+	}
+	if _, err := apiThread(r, threadID); err != nil {
+		return err
+	}
+
+	var in commentInput
+	if err := decodeJSON(w, r, &in); err != nil {
+		return err
+	}
+	in.Content = strings.TrimSpace(in.Content)
+	fields := map[string]string{}
+	validateText(fields, "content", in.Content, maxCommentLength)
+	if len(fields) > 0 {
+		return apperror.Invalid(fields)
+	}
+
+	commentID, err := createComment(r, p.UserID, threadID, in.Content, p.Username)
+	if err != nil {
+		return err
+	}
+	postsTotal.Inc("comment")
+
+	comment, err := database.GetComment(commentID)
+	if err != nil {
+		return apperror.Internal(err, "Failed to fetch comment")
+	}
+	return writeJSON(w, http.StatusCreated, apiItem{Data: commentForViewer(r, comment)})
+}
+
+// apiComment loads a comment on a thread the request may see.
+func apiComment(r *http.Request, commentID int) (models.Comment, error) {
+	comment, err := database.GetComment(commentID)
+	if err == sql.ErrNoRows {
+		return comment, apperror.NotFound("Comment not found")
+	}
+	if err != nil {
+		return comment, apperror.Internal(err, "Failed to fetch comment")
+	}
+	if _, err := apiThread(r, comment.ThreadID); err != nil {
+		return comment, err
+	}
+	return comment, nil
+}
+
+// APIUpdateCommentHandler lets the author change a comment.
+func APIUpdateCommentHandler(w http.ResponseWriter, r *http.Request) error {
+	p, _ := apiPrincipal(r)
+	if err := checkCanPost(p.UserID); err != nil {
+		return err
+	}
+	commentID, err := pathID(r, "id")
+	if err != nil {
+		return err
+	}
+	comment, err := apiComment(r, commentID)
+	if err != nil {
+		return err
+	}
+	if comment.UserID != p.UserID {
+		return apperror.Forbidden("Only the author can edit a comment")
+	}
+
+	var in commentInput
+	if err := decodeJSON(w, r, &in); err != nil {
+		return err
+	}
+	content := strings.TrimSpace(in.Content)
+	fields := map[string]string{}
+	validateText(fields, "content", content, maxCommentLength)
+	if len(fields) > 0 {
+		return apperror.Invalid(fields)
+	}
+
+	if comment, err = editComment(r, comment, content); err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, apiItem{Data: commentForViewer(r, comment)})
+}
+
+type reactionInput struct {
+	Reaction string `json:"reaction"`
+}
+
+var reactionValues = map[string]int{
+	"like":    database.ReactionLike,
+	"dislike": database.ReactionDislike,
+	"none":    database.ReactionNone,
+}
+
+func decodeReaction(w http.ResponseWriter, r *http.Request) (int, error) {
+	var in reactionInput
+	if err := decodeJSON(w, r, &in); err != nil {
+		return 0, err
+	}
+	reaction, ok := reactionValues[in.Reaction]
+	if !ok {
+		return 0, apperror.Invalid(map[string]string{"reaction": "must be like, dislike or none"})
+	}
+	return reaction, nil
+}
+
+// APIThreadReactionHandler sets or clears the user's reaction to a thread
+// and returns the thread with its new counts.
+func APIThreadReactionHandler(w http.ResponseWriter, r *http.Request) error {
+	p, _ := apiPrincipal(r)
+	if err := checkCanPost(p.UserID); err != nil {
+		return err
+	}
+	threadID, err := pathID(r, "id")
+	if err != nil {
+		return err
+	}
+	if _, err := apiThread(r, threadID); err != nil {
+		return err
+	}
+	reaction, err := decodeReaction(w, r)
+	if err != nil {
+		return err
+	}
+
+	if err := database.SetThreadReaction(p.UserID, threadID, reaction); err != nil {
+		return apperror.Internal(err, "Failed to update reaction")
+	}
+	if reaction != database.ReactionNone {
+		reactionsTotal.Inc("thread", reactionName(reaction))
+	}
+	thread, err := database.GetThread(threadID)
+	if err != nil {
+		return apperror.Internal(err, "Failed to fetch thread")
+	}
+	return writeJSON(w, http.StatusOK, apiItem{Data: threadForViewer(r, thread)})
+}
+
+// APICommentReactionHandler is APIThreadReactionHandler for comments.
+func APICommentReactionHandler(w http.ResponseWriter, r *http.Request) error {
+	p, _ := apiPrincipal(r)
+	if err := checkCanPost(p.UserID); err != nil {
+		return err
+	}
+	commentID, err := pathID(r, "id")
+	if err != nil {
+		return err
+	}
+	if _, err := apiComment(r, commentID); err != nil {
+		return err
+	}
+	reaction, err := decodeReaction(w, r)
+	if err != nil {
+		return err
+	}
+
+	if err := database.SetCommentReaction(p.UserID, commentID, reaction); err != nil {
+		return apperror.Internal(err, "Failed to update reaction")
+	}
+	if reaction != database.ReactionNone {
+		reactionsTotal.Inc("comment", reactionName(reaction))
+	}
+	comment, err := database.GetComment(commentID)
+	if err != nil {
+		return apperror.Internal(err, "Failed to fetch comment")
+	}
+	return writeJSON(w, http.StatusOK, apiItem{Data: commentForViewer(r, comment)})
+}
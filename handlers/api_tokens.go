@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"DytForum/apitoken"
+	"DytForum/apperror"
+	"DytForum/database"
+	"DytForum/models"
+	"DytForum/permissions"
+	"DytForum/session"
+)
+
+const maxTokenNameLength = 100
+
+// tokenLifetimes are the expiry choices offered on the tokens page, in days.
+var tokenLifetimes = []int{7, 30, 90, 365}
+
+// APITokensHandler lists the current user's personal API tokens and creates
+// new ones. A new token is shown once, right after it is created; only its
+// hash is stored.
+func APITokensHandler(w http.ResponseWriter, r *http.Request) error {
+	session, _ := session.Store.Get(r, "session-name")
+	userID, ok := session.Values["userID"].(int)
+	if !ok {
+		return apperror.Unauthorized("You must be logged in to access this page")
+	}
+
+	var newToken string
+	if r.Method == "POST" {
+		name := strings.TrimSpace(r.FormValue("name"))
+		if name == "" || len([]rune(name)) > maxTokenNameLength {
+			return apperror.BadRequest("Token name must be between 1 and 100 characters")
+		}
+		scopes, err := parseScopes(r.Form["scopes"])
+		if err != nil {
+			return err
+		}
+		days, err := strconv.Atoi(r.FormValue("expires_days"))
+		if err != nil || !validLifetime(days) {
+			return apperror.BadRequest("Invalid expiry")
+		}
+
+		token, prefix, hash, err := apitoken.Generate()
+		if err != nil {
+			return apperror.Internal(err, "Failed to create token")
+		}
+		expiresAt := time.Now().AddDate(0, 0, days)
+		if _, err := database.CreateAPIToken(userID, name, hash, prefix, scopes, expiresAt); err != nil {
+			return apperror.Internal(err, "Failed to create token")
+		}
+		newToken = token
+	}
+
+	tokens, err := database.ListAPITokens(userID)
+	if err != nil {
+		return apperror.Internal(err, "Failed to load tokens")
+	}
+	data := struct {
+		Tokens    []models.APIToken
+		NewToken  string
+		Scopes    []string
+		Lifetimes []int
+		Now       time.Time
+	}{
+		Tokens:    tokens,
+		NewToken:  newToken,
+		Scopes:    permissions.Scopes,
+		Lifetimes: tokenLifetimes,
+		Now:       time.Now(),
+	}
+	return renderTemplate(w, r, apiTokensPage, data)
+}
+
+// RevokeAPITokenHandler revokes one of the current user's tokens.
+func RevokeAPITokenHandler(w http.ResponseWriter, r *http.Request) error {
+	session, _ := session.Store.Get(r, "session-name")
+	userID, ok := session.Values["userID"].(int)
+	if !ok {
+		return apperror.Unauthorized("You must be logged in to access this page")
+	}
+	tokenID, err := pathID(r, "id")
+	if err != nil {
+		return err
+	}
+	revoked, err := database.RevokeAPIToken(userID, tokenID)
+	if err != nil {
+		return apperror.Internal(err, "Failed to revoke token")
+	}
+	if !revoked {
+		return apperror.NotFound("Token not found")
+	}
+	http.Redirect(w, r, "/account/tokens", http.StatusSeeOther)
+	return nil
+}
+
+func parseScopes(values []string) ([]string, error) {
+	var scopes []string
+	for _, known := range permissions.Scopes {
+		for _, v := range values {
+			if v == known {
+				scopes = append(scopes, known)
+				break
+			}
+		}
+	}
+	if len(scopes) == 0 || len(scopes) != len(values) {
+		return nil, apperror.BadRequest("Choose at least one valid scope")
+	}
+	return scopes, nil
+}
+
+func validLifetime(days int) bool {
+	for _, d := range tokenLifetimes {
+		if d == days {
+			return true
+		}
+	}
+	return false
+}
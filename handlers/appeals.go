@@ -0,0 +1,361 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"DytForum/apperror"
+	"DytForum/database"
+	"DytForum/logging"
+	"DytForum/models"
+	"DytForum/permissions"
+	"DytForum/session"
+)
+
+const (
+	maxAppealLength = 2000
+	// appealWindow is how long after an action it can still be appealed.
+	appealWindow = 30 * 24 * time.Hour
+)
+
+// bannedUserKey holds the ID of a banned user who entered the right
+// password, so they can read the ban notice and appeal without logging in.
+const bannedUserKey = "bannedUserID"
+
+// appealStatusFilters are the choices of the appeal queue's status filter.
+var appealStatusFilters = map[string][]string{
+	database.AppealPending: {database.AppealPending},
+	"decided":              {database.AppealUpheld, database.AppealOverturned},
+	"all":                  nil,
+}
+
+// sanctionSnapshot is the part of a sanction's moderation log entry that
+// appeals need.
+type sanctionSnapshot struct {
+	SanctionID int        `json:"sanction_id"`
+	Kind       string     `json:"kind"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+}
+
+// appellantID returns the user who may file appeals: the logged in user or
+// a banned user who was turned away at login.
+func appellantID(r *http.Request) (int, bool) {
+	session, _ := session.Store.Get(r, "session-name")
+	if auth, _ := session.Values["authenticated"].(bool); auth {
+		if userID, ok := session.Values["userID"].(int); ok {
+			return userID, true
+		}
+	}
+	userID, ok := session.Values[bannedUserKey].(int)
+	return userID, ok
+}
+
+// appealSubject describes an appealable action to the user it affected.
+func appealSubject(a models.ModAction) string {
+	switch a.Action {
+	case database.ModThreadReject:
+		var thread models.Thread
+		json.Unmarshal([]byte(a.Before), &thread)
+		return fmt.Sprintf("the rejection of your thread %q", thread.Title)
+	case database.ModUserSuspend, database.ModUserBan:
+		var s sanctionSnapshot
+		json.Unmarshal([]byte(a.After), &s)
+		until := "permanent"
+		if s.ExpiresAt != nil {
+			until = "until " + s.ExpiresAt.UTC().Format("2006-01-02 15:04") + " UTC"
+		}
+		return fmt.Sprintf("your %s (%s)", s.Kind, until)
+	}
+	return a.Action
+}
+
+// chooseAppealReviewer picks the reviewer with the fewest pending appeals,
+// leaving out the moderator who acted and the appellant. It returns zero
+// when nobody else can review appeals.
+func chooseAppealReviewer(actorID, userID int) (int, error) {
+	reviewers, err := database.UsersWithPermission(permissions.AppealReview)
+	if err != nil {
+		return 0, err
+	}
+	counts, err := database.PendingAppealCounts()
+	if err != nil {
+		return 0, err
+	}
+	chosen := 0
+	for _, reviewer := range reviewers {
+		if reviewer.ID == actorID || reviewer.ID == userID {
+			continue
+		}
+		if chosen == 0 || counts[reviewer.ID] < counts[chosen] {
+			chosen = reviewer.ID
+		}
+	}
+	return chosen, nil
+}
+
+// AppealsHandler lists the recent actions taken against the user with their
+// appeals and files new appeals, one per action.
+func AppealsHandler(w http.ResponseWriter, r *http.Request) error {
+	userID, ok := appellantID(r)
+	if !ok {
+		return apperror.Unauthorized("You must be logged in to appeal")
+	}
+	since := time.Now().Add(-appealWindow)
+
+	if r.Method == "POST" {
+		actionID, err := strconv.Atoi(r.FormValue("action_id"))
+		if err != nil {
+			return apperror.BadRequest("Invalid action")
+		}
+		message := strings.TrimSpace(r.FormValue("message"))
+		fields := map[string]string{}
+		validateText(fields, "message", message, maxAppealLength)
+		if len(fields) > 0 {
+			return apperror.Invalid(fields)
+		}
+
+		action, err := database.GetAppealableAction(actionID, userID, since)
+		if err == sql.ErrNoRows {
+			return apperror.NotFound("There is no such action you can appeal")
+		}
+		if err != nil {
+			return apperror.Internal(err, "Failed to fetch the action")
+		}
+		reviewerID, err := chooseAppealReviewer(action.ActorID, userID)
+		if err != nil {
+			return apperror.Internal(err, "Failed to find a reviewer")
+		}
+		appealID, err := database.CreateAppeal(action.ID, userID, message, reviewerID)
+		if err == database.ErrAppealExists {
+			return apperror.BadRequest("You have already appealed this action")
+		}
+		if err != nil {
+			return apperror.Internal(err, "Failed to save the appeal")
+		}
+		if reviewerID != 0 {
+			notice := fmt.Sprintf("Appeal #%d was assigned to you for review.", appealID)
+			if err := database.CreateNotification(reviewerID, notice); err != nil {
+				logging.FromContext(r.Context()).Error("failed to notify reviewer", "appeal_id", appealID, "user_id", reviewerID, "err", err)
+			}
+		}
+		http.Redirect(w, r, "/appeals", http.StatusSeeOther)
+		return nil
+	}
+
+	actions, err := database.ListAppealableActions(userID, since)
+	if err != nil {
+		return apperror.Internal(err, "Failed to fetch actions")
+	}
+	appeals, err := database.ListUserAppeals(userID)
+	if err != nil {
+		return apperror.Internal(err, "Failed to fetch appeals")
+	}
+	appealed := map[int]bool{}
+	for _, appeal := range appeals {
+		appealed[appeal.Action.ID] = true
+	}
+
+	type actionRow struct {
+		models.ModAction
+		Subject string
+	}
+	type appealRow struct {
+		models.Appeal
+		Subject string
+	}
+	var open []actionRow
+	for _, action := range actions {
+		if !appealed[action.ID] {
+			open = append(open, actionRow{action, appealSubject(action)})
+		}
+	}
+	var filed []appealRow
+	for _, appeal := range appeals {
+		filed = append(filed, appealRow{appeal, appealSubject(appeal.Action)})
+	}
+
+	data := struct {
+		Actions    []actionRow
+		Appeals    []appealRow
+		WindowDays int
+	}{
+		Actions:    open,
+		Appeals:    filed,
+		WindowDays: int(appealWindow / (24 * time.Hour)),
+	}
+	return renderTemplate(w, r, appealsPage, data)
+}
+
+// ListAppealsHandler shows the appeal queue, with the appeals assigned to
+// the current moderator first.
+func ListAppealsHandler(w http.ResponseWriter, r *http.Request) error {
+	status := r.URL.Query().Get("status")
+	if status == "" {
+		status = database.AppealPending
+	}
+	statuses, ok := appealStatusFilters[status]
+	if !ok {
+		return apperror.BadRequest("Invalid status")
+	}
+
+	session, _ := session.Store.Get(r, "session-name")
+	userID := session.Values["userID"].(int)
+	appeals, err := database.ListAppeals(statuses, userID)
+	if err != nil {
+		return apperror.Internal(err, "Failed to fetch appeals")
+	}
+
+	data := struct {
+		Appeals  []models.Appeal
+		Status   string
+		Statuses []string
+		UserID   int
+	}{
+		Appeals:  appeals,
+		Status:   status,
+		Statuses: []string{database.AppealPending, "decided", "all"},
+		UserID:   userID,
+	}
+	return renderTemplate(w, r, moderatorAppealsPage, data)
+}
+
+// AppealDetailHandler shows an appeal with the action it is about.
+func AppealDetailHandler(w http.ResponseWriter, r *http.Request) error {
+	appeal, err := appealFromPath(r)
+	if err != nil {
+		return err
+	}
+
+	var thread *models.Thread
+	if appeal.Action.Action == database.ModThreadReject {
+		thread = &models.Thread{}
+		json.Unmarshal([]byte(appeal.Action.Before), thread)
+	}
+	session, _ := session.Store.Get(r, "session-name")
+	userID := session.Values["userID"].(int)
+
+	data := struct {
+		Appeal    models.Appeal
+		Subject   string
+		Thread    *models.Thread
+		CanDecide bool
+		OwnAction bool
+	}{
+		Appeal:    appeal,
+		Subject:   appealSubject(appeal.Action),
+		Thread:    thread,
+		CanDecide: appeal.Status == database.AppealPending && appeal.Action.ActorID != userID && appeal.UserID != userID,
+		OwnAction: appeal.Action.ActorID == userID,
+	}
+	return renderTemplate(w, r, moderatorAppealPage, data)
+}
+
+// DecideAppealHandler upholds or overturns a pending appeal. Overturning
+// lifts the suspension or ban, or approves the rejected thread.
+// Moderators cannot decide appeals against their own actions.
+func DecideAppealHandler(w http.ResponseWriter, r *http.Request) error {
+	appeal, err := appealFromPath(r)
+	if err != nil {
+		return err
+	}
+	session, _ := session.Store.Get(r, "session-name")
+	userID := session.Values["userID"].(int)
+	if appeal.Action.ActorID == userID {
+		return apperror.Forbidden("Another moderator must decide appeals against your own actions")
+	}
+	if appeal.UserID == userID {
+		return apperror.Forbidden("You cannot decide your own appeal")
+	}
+
+	status := r.FormValue("decision")
+	if status != database.AppealUpheld && status != database.AppealOverturned {
+		return apperror.BadRequest("Invalid decision")
+	}
+	note := strings.TrimSpace(r.FormValue("note"))
+	if len([]rune(note)) > maxAppealLength {
+		return apperror.Invalid(map[string]string{"note": "must be at most " + strconv.Itoa(maxAppealLength) + " characters"})
+	}
+
+	decision := database.AppealDecision{AppealID: appeal.ID, DeciderID: userID, Status: status, Note: note}
+	logAction := database.ModAppealUphold
+	outcome := "rejected"
+	if status == database.AppealOverturned {
+		logAction = database.ModAppealOverturn
+		outcome = "accepted and the decision was reversed"
+		if err := planReversal(&decision, appeal.Action); err != nil {
+			return err
+		}
+	}
+	decision.Log = newModAction(r, logAction, "appeal", appeal.ID,
+		map[string]interface{}{"status": database.AppealPending, "mod_action_id": appeal.Action.ID},
+		map[string]interface{}{"status": status}, note)
+
+	err = database.DecideAppeal(decision)
+	if err == database.ErrAppealDecided {
+		return apperror.BadRequest("This appeal was already decided")
+	}
+	if err != nil {
+		return apperror.Internal(err, "Failed to save the decision")
+	}
+	if decision.Thread != nil {
+		// The thread is legitimate after all, even if the spam filter
+		// learned it as spam when it was rejected.
+		trainSpam(r, "thread", decision.Thread.ID, false, decision.Thread.Title, decision.Thread.Content)
+	}
+
+	message := fmt.Sprintf("Your appeal against %s was %s.", appealSubject(appeal.Action), outcome)
+	if note != "" {
+		message += " " + note
+	}
+	if err := database.CreateNotification(appeal.UserID, message); err != nil {
+		logging.FromContext(r.Context()).Error("failed to notify appellant", "appeal_id", appeal.ID, "user_id", appeal.UserID, "err", err)
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/moderator/appeals/%d", appeal.ID), http.StatusSeeOther)
+	return nil
+}
+
+// planReversal works out what overturning an appealed action undoes.
+func planReversal(d *database.AppealDecision, a models.ModAction) error {
+	switch a.Action {
+	case database.ModUserSuspend, database.ModUserBan:
+		var s sanctionSnapshot
+		if err := json.Unmarshal([]byte(a.After), &s); err != nil {
+			return apperror.Internal(err, "Failed to read the sanction")
+		}
+		d.SanctionID = s.SanctionID
+	case database.ModThreadReject:
+		var thread models.Thread
+		if err := json.Unmarshal([]byte(a.Before), &thread); err != nil {
+			return apperror.Internal(err, "Failed to read the thread")
+		}
+		_, err := database.GetThread(thread.ID)
+		if err != nil && err != sql.ErrNoRows {
+			return apperror.Internal(err, "Failed to fetch the thread")
+		}
+		// Threads rejected before rejected threads were kept are restored.
+		d.Thread = &thread
+		d.RestoreThread = err == sql.ErrNoRows
+	}
+	return nil
+}
+
+func appealFromPath(r *http.Request) (models.Appeal, error) {
+	appealID, err := pathID(r, "id")
+	if err != nil {
+		return models.Appeal{}, err
+	}
+	appeal, err := database.GetAppeal(appealID)
+	if err == sql.ErrNoRows {
+		return models.Appeal{}, apperror.NotFound("Appeal not found")
+	}
+	if err != nil {
+		return models.Appeal{}, apperror.Internal(err, "Failed to fetch appeal")
+	}
+	return appeal, nil
+}
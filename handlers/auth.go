@@ -1,187 +1,195 @@
 package handlers
 
 import (
-	"html/template"
-	"log"
+	"database/sql"
 	"net/http"
 
+	"DytForum/apperror"
 	"DytForum/database"
+	"DytForum/logging"
+	"DytForum/middleware"
 	"DytForum/models"
 	"DytForum/session"
 
 	"golang.org/x/crypto/bcrypt"
 )
 
-func RegisterHandler(w http.ResponseWriter, r *http.Request) {
+func RegisterHandler(w http.ResponseWriter, r *http.Request) error {
 	if r.Method == "GET" {
-		tmpl := template.Must(template.ParseFiles("templates/register.html"))
-		tmpl.Execute(w, nil)
+		return renderTemplate(w, r, registerPage, nil)
 	} else if r.Method == "POST" {
 		username := r.FormValue("username")
 		email := r.FormValue("email")
 		password := r.FormValue("password")
 		isModerator := r.FormValue("moderator") == "on"
+		if err := checkRegistrationBan(r, email); err != nil {
+			return err
+		}
 
 		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 		if err != nil {
-			http.Error(w, "Server error, unable to create your account.", http.StatusInternalServerError)
-			return
+			return apperror.Internal(err, "Server error, unable to create your account.")
 		}
 
-		_, err = database.DB.Exec("INSERT INTO users (username, email, password, role) VALUES (?, ?, ?, 'user')", username, email, hashedPassword)
+		userID, err := database.CreateUser(username, email, string(hashedPassword), "user")
 		if err != nil {
-			log.Printf("RegisterHandler: Failed to insert user: %v", err)
-			http.Error(w, "Server error, unable to create your account.", http.StatusInternalServerError)
-			return
+			return apperror.Internal(err, "Server error, unable to create your account.")
 		}
+		registrationsTotal.Inc("password")
 
 		if isModerator {
-			var userID int
-			err = database.DB.QueryRow("SELECT id FROM users WHERE username = ?", username).Scan(&userID)
-			if err != nil {
-				http.Error(w, "Server error, unable to process your request.", http.StatusInternalServerError)
-				return
-			}
-
 			_, err = database.DB.Exec("INSERT INTO moderator_requests (user_id, reason, status) VALUES (?, ?, 'pending')", userID, "Applied during registration")
 			if err != nil {
-				http.Error(w, "Server error, unable to submit your moderator request.", http.StatusInternalServerError)
-				return
+				return apperror.Internal(err, "Server error, unable to submit your moderator request.")
 			}
 		}
 
 		http.Redirect(w, r, "/login", http.StatusSeeOther)
 	}
+	return nil
 }
 
-func LoginHandler(w http.ResponseWriter, r *http.Request) {
+func LoginHandler(w http.ResponseWriter, r *http.Request) error {
+	page := loginPage
 	if r.Method == "GET" {
-		tmpl := template.Must(template.ParseFiles("templates/login.html"))
-		tmpl.Execute(w, nil)
+		needChallenge, _ := challengeRequired(ipThrottleKey(middleware.ClientIP(r)))
+		return renderLoginForm(w, r, page, http.StatusOK, "", needChallenge)
 	} else if r.Method == "POST" {
 		username := r.FormValue("username")
 		password := r.FormValue("password")
+		ip := middleware.ClientIP(r)
+		if ok, err := checkLoginThrottle(w, r, page, username, ip); !ok {
+			return err
+		}
+
 		var storedPassword, role string
-		var userID int
-		err := database.DB.QueryRow("SELECT id, password, role FROM users WHERE username = ?", username).Scan(&userID, &storedPassword, &role)
+		var userID, disabled int
+		err := database.DB.QueryRow("SELECT id, password, role, disabled FROM users WHERE username = ?", username).Scan(&userID, &storedPassword, &role, &disabled)
 		if err != nil {
-			http.Error(w, "Invalid username or password", http.StatusUnauthorized)
-			return
+			return failLogin(w, r, page, username, ip)
 		}
 
 		if err := bcrypt.CompareHashAndPassword([]byte(storedPassword), []byte(password)); err != nil {
-			http.Error(w, "Invalid username or password", http.StatusUnauthorized)
-			return
+			return failLogin(w, r, page, username, ip)
 		}
-
-		session, _ := session.Store.Get(r, "session-name")
-		session.Values["authenticated"] = true
-		session.Values["username"] = username
-		session.Values["userID"] = userID
-		session.Values["role"] = role
-		log.Printf("Login successful for user: %s with userID: %d and role: %s", username, userID, role)
-		err = session.Save(r, w)
-		if err != nil {
-			log.Printf("Failed to save session: %v", err)
-			http.Error(w, "Failed to save session", http.StatusInternalServerError)
-			return
+		if disabled == 1 {
+			return renderLoginForm(w, r, page, http.StatusForbidden, "This account has been disabled.", false)
+		}
+		ban, err := database.ActiveBan(userID)
+		if err == nil {
+			// Remember who the user is so they can still appeal the ban.
+			session, _ := session.Store.Get(r, "session-name")
+			session.Values[bannedUserKey] = userID
+			session.Save(r, w)
+			return renderBanNotice(w, r, ban)
+		}
+		if err != sql.ErrNoRows {
+			return apperror.Internal(err, "Failed to check account status")
 		}
 
-		if role == "admin" {
-			http.Redirect(w, r, "/admin/panel", http.StatusSeeOther)
-		} else {
-			http.Redirect(w, r, "/index", http.StatusSeeOther)
+		if err := beginLogin(w, r, userID, username, role); err != nil {
+			return apperror.Internal(err, "Failed to save session")
 		}
 	}
+	return nil
 }
 
-func IndexHandler(w http.ResponseWriter, r *http.Request) {
+func IndexHandler(w http.ResponseWriter, r *http.Request) error {
 	categories, err := fetchCategories()
 	if err != nil {
-		http.Error(w, "Failed to fetch categories", http.StatusInternalServerError)
-		return
+		return apperror.Internal(err, "Failed to fetch categories")
 	}
 
-	threads, err := fetchThreads()
+	query := r.URL.Query().Get("q")
+	var threads []models.Thread
+	if query != "" {
+		threads, err = database.SearchThreads(query)
+	} else {
+		threads, err = fetchThreads()
+	}
 	if err != nil {
-		http.Error(w, "Failed to fetch threads", http.StatusInternalServerError)
-		return
+		return apperror.Internal(err, "Failed to fetch threads")
 	}
 
-	tmpl := template.Must(template.ParseFiles("templates/index.html"))
 	data := struct {
 		Categories []models.Category
 		Threads    []models.Thread
+		Query      string
 	}{
 		Categories: categories,
 		Threads:    threads,
+		Query:      query,
 	}
-	err = tmpl.Execute(w, data)
-	if err != nil {
-		http.Error(w, "Failed to render template", http.StatusInternalServerError)
-	}
+	return renderTemplate(w, r, indexPage, data)
 }
 
-func LogoutHandler(w http.ResponseWriter, r *http.Request) {
+func LogoutHandler(w http.ResponseWriter, r *http.Request) error {
 	session, _ := session.Store.Get(r, "session-name")
 
-	// Revoke user's authentication by clearing session values
-	session.Values["authenticated"] = false
-	session.Values["username"] = ""
+	// Drop the whole session, including a half-finished two-factor login,
+	// so nothing survives on a shared browser.
+	for key := range session.Values {
+		delete(session.Values, key)
+	}
+	session.Options.MaxAge = -1
 	session.Save(r, w)
 
 	// Redirect the user to the login page or any other appropriate page
 	http.Redirect(w, r, "/login", http.StatusSeeOther)
+	return nil
 }
 
 // DebugSessionHandler to print session values for debugging
-func DebugSessionHandler(w http.ResponseWriter, r *http.Request) {
+func DebugSessionHandler(w http.ResponseWriter, r *http.Request) error {
 	session, _ := session.Store.Get(r, "session-name")
-	log.Printf("Session values: %v", session.Values)
+	logging.FromContext(r.Context()).Debug("session values", "values", session.Values)
 	w.Write([]byte("Check server logs for session values"))
+	return nil
 }
 
-func AdminLoginHandler(w http.ResponseWriter, r *http.Request) {
+func AdminLoginHandler(w http.ResponseWriter, r *http.Request) error {
+	page := adminLoginPage
 	if r.Method == "GET" {
-		tmpl := template.Must(template.ParseFiles("templates/admin.html"))
-		tmpl.Execute(w, nil)
+		needChallenge, _ := challengeRequired(ipThrottleKey(middleware.ClientIP(r)))
+		return renderLoginForm(w, r, page, http.StatusOK, "", needChallenge)
 	} else if r.Method == "POST" {
 		username := r.FormValue("username")
 		password := r.FormValue("password")
+		ip := middleware.ClientIP(r)
+		if ok, err := checkLoginThrottle(w, r, page, username, ip); !ok {
+			return err
+		}
 
 		var storedPassword string
-		err := database.DB.QueryRow("SELECT password FROM users WHERE username = ? AND role = 'admin'", username).Scan(&storedPassword)
+		var userID int
+		err := database.DB.QueryRow("SELECT id, password FROM users WHERE username = ? AND role = 'admin' AND disabled = 0", username).Scan(&userID, &storedPassword)
 		if err != nil {
-			http.Error(w, "Invalid username or password", http.StatusUnauthorized)
-			return
+			return failLogin(w, r, page, username, ip)
 		}
 
 		err = bcrypt.CompareHashAndPassword([]byte(storedPassword), []byte(password))
 		if err != nil {
-			http.Error(w, "Invalid username or password", http.StatusUnauthorized)
-			return
+			return failLogin(w, r, page, username, ip)
 		}
 
-		// Create a session to store admin role information
-		session, _ := session.Store.Get(r, "session-name")
-		session.Values["role"] = "admin"
-		session.Save(r, w)
-
-		http.Redirect(w, r, "/admin/panel", http.StatusSeeOther)
+		if err := beginLogin(w, r, userID, username, "admin"); err != nil {
+			return apperror.Internal(err, "Failed to save session")
+		}
 	}
+	return nil
 }
 
-func AdminLogoutHandler(w http.ResponseWriter, r *http.Request) {
+func AdminLogoutHandler(w http.ResponseWriter, r *http.Request) error {
 	// Çıkış işlemleri yapılabilir (session temizleme vb.)
 	// Örneğin:
 	session, _ := session.Store.Get(r, "session-name")
 	session.Options.MaxAge = -1 // Session'ı sonlandır
 	err := session.Save(r, w)
 	if err != nil {
-		http.Error(w, "Failed to logout", http.StatusInternalServerError)
-		return
+		return apperror.Internal(err, "Failed to logout")
 	}
 
 	// Çıkış sonrası yönlendirme
 	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+	return nil
 }
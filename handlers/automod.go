@@ -0,0 +1,467 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"DytForum/apperror"
+	"DytForum/automod"
+	"DytForum/database"
+	"DytForum/logging"
+	"DytForum/models"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// automodTestPosts is how many of the latest threads, and as many
+	// comments, a rule is tested against.
+	automodTestPosts = 200
+	automodHitsShown = 50
+	// automodReportReason is the reason of the reports rules file when they
+	// flag a post.
+	automodReportReason = "Automoderation"
+)
+
+type automodTrustLevel struct {
+	Level int
+	Name  string
+}
+
+// automodTrustLevels names the trust levels for the rule form.
+var automodTrustLevels = []automodTrustLevel{
+	{database.TrustNew, "new: nothing published yet"},
+	{database.TrustBasic, "basic: has published posts"},
+	{database.TrustMember, fmt.Sprintf("member: %d published posts over %d days", database.TrustMemberPosts, database.TrustMemberDays)},
+	{database.TrustStaff, "staff"},
+}
+
+// automodDecision is what the enabled rules made of a new post.
+type automodDecision struct {
+	Visibility string
+	Flag       bool
+	Matched    []models.AutomodRule
+}
+
+// ruleNames lists the names of the matched rules for notes and reports.
+func (d automodDecision) ruleNames() string {
+	names := make([]string, len(d.Matched))
+	for i, rule := range d.Matched {
+		names[i] = rule.Name
+	}
+	return strings.Join(names, ", ")
+}
+
+// threadState is the review state a new thread starts in.
+func (d automodDecision) threadState() int {
+	switch d.Visibility {
+	case automod.ActionApprove:
+		return database.ThreadApproved
+	case automod.ActionReject:
+		return database.ThreadRejected
+	case automod.ActionShadowHide:
+		return database.ThreadShadowHidden
+	}
+	return database.ThreadPending
+}
+
+// commentState is the state a new comment is stored in. Rejected comments
+// are not stored at all.
+func (d automodDecision) commentState() int {
+	switch d.Visibility {
+	case automod.ActionHold:
+		return database.CommentHeld
+	case automod.ActionShadowHide:
+		return database.CommentShadowHidden
+	}
+	return database.CommentVisible
+}
+
+// automodAuthor fills in what the rules look at of a post's author.
+func automodAuthor(p *automod.Post, userID int) error {
+	created, err := database.AccountCreatedAt(userID)
+	if err != nil {
+		return err
+	}
+	if created != nil {
+		p.AccountCreated = *created
+	}
+	p.TrustLevel, err = database.UserTrustLevel(userID)
+	return err
+}
+
+// checkAutomod runs the enabled rules against a new post before it is
+// stored, or an edit before it is saved; postID is the edited post's ID and
+// zero for a new one. A rule that fails to evaluate is logged and skipped so
+// a bad rule cannot stop people from posting.
+func checkAutomod(r *http.Request, postType string, postID, userID int, title, content string) (automodDecision, error) {
+	var d automodDecision
+	rules, err := database.ListEnabledAutomodRules(postType)
+	if err != nil || len(rules) == 0 {
+		return d, err
+	}
+	post := automod.Post{Type: postType, Title: title, Content: content}
+	if err := automodAuthor(&post, userID); err != nil {
+		return d, err
+	}
+	if post.Repeats, err = database.CountRepeatedPosts(userID, strings.TrimSpace(content), postType, postID); err != nil {
+		return d, err
+	}
+
+	var actions []string
+	for _, rule := range rules {
+		matched, err := automod.Match(rule, post)
+		if err != nil {
+			logging.FromContext(r.Context()).Error("failed to evaluate automoderation rule", "rule_id", rule.ID, "err", err)
+			continue
+		}
+		if matched {
+			d.Matched = append(d.Matched, rule)
+			actions = append(actions, rule.Action)
+		}
+	}
+	d.Visibility, d.Flag = automod.Decide(actions)
+	return d, nil
+}
+
+// applyAutomod records what the rules did to a stored post: the hits, a
+// report when a rule flagged it and, for an automatically rejected thread,
+// a moderation log entry the author can appeal. post.ID is zero for a
+// rejected comment. Failures are logged since the post is already handled.
+func applyAutomod(r *http.Request, d automodDecision, post models.Post) {
+	log := logging.FromContext(r.Context())
+	for _, rule := range d.Matched {
+		hit := models.AutomodHit{
+			RuleID: rule.ID, TargetType: post.Type, TargetID: post.ID,
+			ThreadID: post.ThreadID, UserID: post.UserID, Action: rule.Action,
+		}
+		if err := database.RecordAutomodHit(hit); err != nil {
+			log.Error("failed to record automoderation hit", "rule_id", rule.ID, "err", err)
+		}
+	}
+
+	if d.Flag && post.ID != 0 {
+		_, err := database.CreateReport(database.NewReport{
+			TargetType: post.Type,
+			TargetID:   post.ID,
+			Reason:     automodReportReason,
+			Details:    "Matched rules: " + d.ruleNames(),
+		})
+		if err != nil {
+			log.Error("failed to file automoderation report", "target_type", post.Type, "target_id", post.ID, "err", err)
+		}
+	}
+
+	if post.Type == automod.TargetThread && d.Visibility == automod.ActionReject {
+		reason := "Rejected automatically: " + d.ruleNames()
+		thread, err := database.GetThread(post.ID)
+		if err == nil {
+			err = database.CreateModAction(models.ModAction{
+				Action:     database.ModThreadReject,
+				TargetType: "thread",
+				TargetID:   post.ID,
+				Before:     modSnapshot(thread),
+				After:      modSnapshot(map[string]interface{}{"approved": database.ThreadRejected, "review_note": reason}),
+				Reason:     reason,
+			})
+		}
+		if err != nil {
+			log.Error("failed to record automatic rejection", "thread_id", post.ID, "err", err)
+		}
+		notifyThreadAuthor(r, thread, fmt.Sprintf("Your thread %q was rejected automatically. You can appeal the decision.", post.Title))
+	}
+}
+
+// createThread stores a new thread in the state the automoderation rules
+// and the spam classifier pick for it and returns its ID.
+func createThread(r *http.Request, userID, categoryID int, title, content string) (int, error) {
+	d, err := checkAutomod(r, automod.TargetThread, 0, userID, title, content)
+	if err != nil {
+		return 0, apperror.Internal(err, "Failed to check thread")
+	}
+	check := checkSpam(r, title, content)
+	d.holdSpam(check)
+	threadID, err := database.CreateThread(userID, categoryID, title, content)
+	if err != nil {
+		return 0, apperror.Internal(err, "Failed to create thread")
+	}
+	if state := d.threadState(); state != database.ThreadPending {
+		note := ""
+		if state == database.ThreadRejected {
+			note = "Rejected automatically: " + d.ruleNames()
+		}
+		if err := database.SetThreadReview(threadID, state, note); err != nil {
+			return 0, apperror.Internal(err, "Failed to create thread")
+		}
+	}
+	saveSpamScore(r, check, automod.TargetThread, threadID)
+	applyAutomod(r, d, models.Post{Type: automod.TargetThread, ID: threadID, ThreadID: threadID, UserID: userID, Title: title, Content: content})
+	return threadID, nil
+}
+
+// createComment stores a new comment, held or hidden if the automoderation
+// rules or the spam classifier say so, and returns its ID. A rejected
+// comment is not stored.
+func createComment(r *http.Request, userID, threadID int, content, username string) (int, error) {
+	d, err := checkAutomod(r, automod.TargetComment, 0, userID, "", content)
+	if err != nil {
+		return 0, apperror.Internal(err, "Failed to check comment")
+	}
+	check := checkSpam(r, "", content)
+	d.holdSpam(check)
+	post := models.Post{Type: automod.TargetComment, ThreadID: threadID, UserID: userID, Content: content}
+	if d.Visibility == automod.ActionReject {
+		applyAutomod(r, d, post)
+		return 0, apperror.Forbidden("Your comment was rejected by automatic moderation")
+	}
+	post.ID, err = database.CreateComment(userID, threadID, content, username, d.commentState())
+	if err != nil {
+		return 0, apperror.Internal(err, "Failed to create comment")
+	}
+	saveSpamScore(r, check, automod.TargetComment, post.ID)
+	applyAutomod(r, d, post)
+	return post.ID, nil
+}
+
+// editComment saves the author's new content of a comment after running it
+// through the automoderation rules and the spam classifier like a new
+// comment. A rejected edit is not saved, and editing never publishes a
+// comment that is held or hidden.
+func editComment(r *http.Request, comment models.Comment, content string) (models.Comment, error) {
+	d, err := checkAutomod(r, automod.TargetComment, comment.ID, comment.UserID, "", content)
+	if err != nil {
+		return comment, apperror.Internal(err, "Failed to check comment")
+	}
+	check := checkSpam(r, "", content)
+	d.holdSpam(check)
+	post := models.Post{Type: automod.TargetComment, ThreadID: comment.ThreadID, UserID: comment.UserID, Content: content}
+	if d.Visibility == automod.ActionReject {
+		applyAutomod(r, d, post)
+		return comment, apperror.Forbidden("Your edit was rejected by automatic moderation")
+	}
+	state := d.commentState()
+	if state == database.CommentVisible {
+		state = comment.Approved
+	}
+	if err := database.UpdateComment(comment.ID, content, state); err != nil {
+		return comment, apperror.Internal(err, "Failed to update comment")
+	}
+	post.ID = comment.ID
+	saveSpamScore(r, check, automod.TargetComment, comment.ID)
+	applyAutomod(r, d, post)
+	comment.Content, comment.Approved = content, state
+	return comment, nil
+}
+
+// automodRuleFromForm reads the rule form. Invalid numbers are reported
+// with the other validation errors.
+func automodRuleFromForm(r *http.Request) (models.AutomodRule, map[string]string) {
+	rule := models.AutomodRule{
+		Name:    strings.TrimSpace(r.FormValue("name")),
+		Target:  r.FormValue("target"),
+		Words:   strings.TrimSpace(r.FormValue("words")),
+		Pattern: strings.TrimSpace(r.FormValue("pattern")),
+		Action:  r.FormValue("action"),
+		Enabled: r.FormValue("enabled") != "",
+	}
+	fields := map[string]string{}
+	number := func(name string) int {
+		v := strings.TrimSpace(r.FormValue(name))
+		if v == "" {
+			return 0
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			fields[name] = "must be a number"
+		}
+		return n
+	}
+	rule.MinLinks = number("min_links")
+	rule.MaxAccountDays = number("max_account_days")
+	rule.MinRepeats = number("min_repeats")
+	if r.FormValue("max_trust_level") != "" {
+		level := number("max_trust_level")
+		rule.MaxTrustLevel = &level
+	}
+	if len(rule.Name) > 100 {
+		fields["name"] = "must be at most 100 characters"
+	}
+	for name, msg := range automod.Validate(rule) {
+		if _, ok := fields[name]; !ok {
+			fields[name] = msg
+		}
+	}
+	return rule, fields
+}
+
+// testAutomodRule runs a rule against the latest threads and comments. The
+// authors' account age and trust level are taken as they are now.
+func testAutomodRule(rule models.AutomodRule) ([]models.Post, int, error) {
+	posts, err := database.ListRecentPosts(automodTestPosts)
+	if err != nil {
+		return nil, 0, err
+	}
+	authors := map[int]automod.Post{}
+	var matches []models.Post
+	for _, p := range posts {
+		post, ok := authors[p.UserID]
+		if !ok {
+			// Posts of deleted accounts are checked as by a new account.
+			if err := automodAuthor(&post, p.UserID); err != nil && err != sql.ErrNoRows {
+				return nil, 0, err
+			}
+			authors[p.UserID] = post
+		}
+		post.Type, post.Title, post.Content = p.Type, p.Title, p.Content
+		repeats, err := database.CountRepeatedPosts(p.UserID, strings.TrimSpace(p.Content), p.Type, p.ID)
+		if err != nil {
+			return nil, 0, err
+		}
+		post.Repeats = repeats
+		matched, err := automod.Match(rule, post)
+		if err != nil {
+			return nil, 0, err
+		}
+		if matched {
+			matches = append(matches, p)
+		}
+	}
+	return matches, len(posts), nil
+}
+
+// AutomodRulesHandler lists the automoderation rules with how often each
+// matched.
+func AutomodRulesHandler(w http.ResponseWriter, r *http.Request) error {
+	rules, err := database.ListAutomodRules()
+	if err != nil {
+		return apperror.Internal(err, "Failed to fetch rules")
+	}
+	return renderTemplate(w, r, adminAutomodPage, struct {
+		Rules []models.AutomodRule
+	}{
+		Rules: rules,
+	})
+}
+
+// AutomodRuleHandler shows the form for a new rule, or an existing one with
+// its latest hits, and saves it. The form's Test button runs the rule as
+// entered against past posts instead of saving it.
+func AutomodRuleHandler(w http.ResponseWriter, r *http.Request) error {
+	var rule models.AutomodRule
+	if _, ok := mux.Vars(r)["id"]; ok {
+		ruleID, err := pathID(r, "id")
+		if err != nil {
+			return err
+		}
+		rule, err = database.GetAutomodRule(ruleID)
+		if err == sql.ErrNoRows {
+			return apperror.NotFound("Rule not found")
+		}
+		if err != nil {
+			return apperror.Internal(err, "Failed to fetch rule")
+		}
+	} else {
+		rule = models.AutomodRule{Target: automod.TargetAll, Action: automod.ActionHold, Enabled: true}
+	}
+
+	type testResult struct {
+		Matches []models.Post
+		Checked int
+	}
+	var test *testResult
+	if r.Method == "POST" {
+		before := rule
+		submitted, fields := automodRuleFromForm(r)
+		if len(fields) > 0 {
+			return apperror.Invalid(fields)
+		}
+		submitted.ID = rule.ID
+		rule = submitted
+
+		if r.FormValue("test") != "" {
+			matches, checked, err := testAutomodRule(rule)
+			if err != nil {
+				return apperror.Internal(err, "Failed to test rule")
+			}
+			test = &testResult{Matches: matches, Checked: checked}
+		} else {
+			if rule.ID == 0 {
+				id, err := database.CreateAutomodRule(rule)
+				if err != nil {
+					return apperror.Internal(err, "Failed to save rule")
+				}
+				rule.ID = id
+				if err := recordModAction(r, database.ModAutomodRuleCreate, "automod_rule", id, nil, rule, ""); err != nil {
+					return err
+				}
+			} else {
+				if err := database.UpdateAutomodRule(rule); err != nil {
+					return apperror.Internal(err, "Failed to save rule")
+				}
+				if err := recordModAction(r, database.ModAutomodRuleUpdate, "automod_rule", rule.ID, before, rule, ""); err != nil {
+					return err
+				}
+			}
+			http.Redirect(w, r, "/admin/automod", http.StatusSeeOther)
+			return nil
+		}
+	}
+
+	// maxTrust is the selected trust level, or -1 for any.
+	maxTrust := -1
+	if rule.MaxTrustLevel != nil {
+		maxTrust = *rule.MaxTrustLevel
+	}
+	var hits []models.AutomodHit
+	if rule.ID != 0 {
+		var err error
+		if hits, err = database.ListAutomodHits(rule.ID, automodHitsShown); err != nil {
+			return apperror.Internal(err, "Failed to fetch hits")
+		}
+	}
+
+	return renderTemplate(w, r, adminAutomodRulePage, struct {
+		Rule        models.AutomodRule
+		Targets     []string
+		Actions     []string
+		TrustLevels []automodTrustLevel
+		MaxTrust    int
+		Test        *testResult
+		Hits        []models.AutomodHit
+	}{
+		Rule:        rule,
+		Targets:     automod.Targets,
+		Actions:     automod.Actions,
+		TrustLevels: automodTrustLevels,
+		MaxTrust:    maxTrust,
+		Test:        test,
+		Hits:        hits,
+	})
+}
+
+// DeleteAutomodRuleHandler removes a rule with its hit statistics.
+func DeleteAutomodRuleHandler(w http.ResponseWriter, r *http.Request) error {
+	ruleID, err := pathID(r, "id")
+	if err != nil {
+		return err
+	}
+	rule, err := database.GetAutomodRule(ruleID)
+	if err == sql.ErrNoRows {
+		return apperror.NotFound("Rule not found")
+	}
+	if err != nil {
+		return apperror.Internal(err, "Failed to fetch rule")
+	}
+	if err := database.DeleteAutomodRule(ruleID); err != nil {
+		return apperror.Internal(err, "Failed to delete rule")
+	}
+	if err := recordModAction(r, database.ModAutomodRuleDelete, "automod_rule", ruleID, rule, nil, ""); err != nil {
+		return err
+	}
+
+	http.Redirect(w, r, "/admin/automod", http.StatusSeeOther)
+	return nil
+}
@@ -0,0 +1,297 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"DytForum/apperror"
+	"DytForum/database"
+	"DytForum/models"
+	"DytForum/permissions"
+	"DytForum/session"
+)
+
+// maxBulkItems caps how many threads and comments one batch may select.
+const maxBulkItems = 200
+
+// bulkOperation describes one of the bulk moderation actions.
+type bulkOperation struct {
+	Label      string
+	Permission string
+	LogAction  string
+}
+
+var bulkOperations = map[string]bulkOperation{
+	database.BulkApprove: {"Approve", permissions.ThreadApprove, database.ModBulkApprove},
+	database.BulkReject:  {"Reject", permissions.ThreadApprove, database.ModBulkReject},
+	database.BulkDelete:  {"Delete", permissions.ThreadDelete, database.ModBulkDelete},
+	database.BulkMove:    {"Move", permissions.ThreadApprove, database.ModBulkMove},
+	database.BulkPurge:   {"Purge all content", permissions.ThreadDelete, database.ModUserPurge},
+}
+
+// moveCategories returns the categories threads may be moved into: every
+// category, or only the moderator's own.
+func moveCategories(scope moderationScope) ([]models.Category, error) {
+	if scope.All {
+		return fetchCategories()
+	}
+	return database.GetModeratedCategories(scope.UserID)
+}
+
+// formIDs parses the repeated form value key as IDs, each listed once.
+func formIDs(r *http.Request, key string) ([]int, error) {
+	var ids []int
+	seen := map[int]bool{}
+	for _, v := range r.Form[key] {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, apperror.BadRequest("Invalid " + key + " ID")
+		}
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// bulkSelection loads the threads and comments picked in the moderator
+// panel after checking that each is in the moderator's categories.
+func bulkSelection(r *http.Request) ([]models.Thread, []models.Comment, error) {
+	threadIDs, err := formIDs(r, "thread")
+	if err != nil {
+		return nil, nil, err
+	}
+	commentIDs, err := formIDs(r, "comment")
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(threadIDs)+len(commentIDs) > maxBulkItems {
+		return nil, nil, apperror.BadRequest(fmt.Sprintf("Select at most %d threads and comments at once", maxBulkItems))
+	}
+
+	var threads []models.Thread
+	for _, id := range threadIDs {
+		if err := checkThreadInScope(r, id); err != nil {
+			return nil, nil, err
+		}
+		thread, err := database.GetThread(id)
+		if err == sql.ErrNoRows {
+			return nil, nil, apperror.NotFound(fmt.Sprintf("Thread %d not found", id))
+		}
+		if err != nil {
+			return nil, nil, apperror.Internal(err, "Failed to fetch thread")
+		}
+		threads = append(threads, thread)
+	}
+
+	var comments []models.Comment
+	for _, id := range commentIDs {
+		comment, err := database.GetComment(id)
+		if err == sql.ErrNoRows {
+			return nil, nil, apperror.NotFound(fmt.Sprintf("Comment %d not found", id))
+		}
+		if err != nil {
+			return nil, nil, apperror.Internal(err, "Failed to fetch comment")
+		}
+		if err := checkThreadInScope(r, comment.ThreadID); err != nil {
+			return nil, nil, err
+		}
+		comments = append(comments, comment)
+	}
+	return threads, comments, nil
+}
+
+// BulkModerationHandler approves, rejects, deletes or moves the threads and
+// comments selected in the moderator panel, or purges everything a user
+// posted in the moderator's categories. The first request shows what will
+// change; resubmitted with confirm set, the batch is applied in one
+// transaction and recorded as a single audit log entry.
+func BulkModerationHandler(w http.ResponseWriter, r *http.Request) error {
+	if err := r.ParseForm(); err != nil {
+		return apperror.BadRequest("Invalid form")
+	}
+	op := r.FormValue("op")
+	operation, ok := bulkOperations[op]
+	if !ok {
+		return apperror.BadRequest("Invalid action")
+	}
+	if !currentUserCan(r, operation.Permission) {
+		return apperror.Forbidden("You do not have permission to access this page")
+	}
+	scope := currentModerationScope(r)
+
+	var threads []models.Thread
+	var comments []models.Comment
+	var user models.User
+	var err error
+	if op == database.BulkPurge {
+		userID, err := strconv.Atoi(r.FormValue("user_id"))
+		if err != nil {
+			return apperror.BadRequest("Invalid user ID")
+		}
+		session, _ := session.Store.Get(r, "session-name")
+		moderatorID, ok := session.Values["userID"].(int)
+		if !ok {
+			return apperror.Unauthorized("You must be logged in to access this page")
+		}
+		if err := checkSanctionTarget(userID, moderatorID); err != nil {
+			return err
+		}
+		user, err = database.GetUser(userID)
+		if err != nil {
+			return apperror.Internal(err, "Failed to fetch the user")
+		}
+		filter, args := scope.threadFilter()
+		threads, comments, err = database.ListUserContent(userID, filter, args...)
+		if err != nil {
+			return apperror.Internal(err, "Failed to fetch the user's posts")
+		}
+	} else {
+		threads, comments, err = bulkSelection(r)
+		if err != nil {
+			return err
+		}
+	}
+	if len(threads) == 0 && len(comments) == 0 {
+		return apperror.BadRequest("Nothing to do: select at least one thread or comment")
+	}
+
+	batch := database.BulkAction{Op: op}
+	var category models.Category
+	var after interface{}
+	var reason string
+	switch op {
+	case database.BulkApprove:
+		for _, t := range threads {
+			if !database.Queued(t.Approved) {
+				return apperror.BadRequest(fmt.Sprintf("Thread %q is not awaiting approval", t.Title))
+			}
+		}
+		for _, c := range comments {
+			if c.Approved == database.CommentVisible {
+				return apperror.BadRequest(fmt.Sprintf("Comment %d is already published", c.ID))
+			}
+		}
+		after = map[string]interface{}{"approved": database.ThreadApproved}
+	case database.BulkReject:
+		for _, t := range threads {
+			if t.Approved == database.ThreadApproved || t.Approved == database.ThreadRejected {
+				return apperror.BadRequest(fmt.Sprintf("Thread %q is not awaiting review", t.Title))
+			}
+		}
+		if reason, err = rejectionReason(r); err != nil {
+			return err
+		}
+		batch.Note = reason
+		after = map[string]interface{}{"approved": database.ThreadRejected, "review_note": reason}
+	case database.BulkMove:
+		if len(comments) > 0 {
+			return apperror.BadRequest("Only threads can be moved")
+		}
+		categories, err := moveCategories(scope)
+		if err != nil {
+			return apperror.Internal(err, "Failed to fetch categories")
+		}
+		categoryID, _ := strconv.Atoi(r.FormValue("category"))
+		for _, c := range categories {
+			if c.ID == categoryID {
+				category = c
+			}
+		}
+		if category.ID == 0 {
+			return apperror.Invalid(map[string]string{"category": "must be a category you moderate"})
+		}
+		batch.CategoryID = category.ID
+		after = map[string]interface{}{"category": category.Name}
+	case database.BulkPurge:
+		reason = strings.TrimSpace(r.FormValue("reason"))
+		fields := map[string]string{}
+		validateText(fields, "reason", reason, maxReviewNoteLength)
+		if len(fields) > 0 {
+			return apperror.Invalid(fields)
+		}
+	}
+
+	if r.FormValue("confirm") == "" {
+		return renderTemplate(w, r, moderatorBulkPage, struct {
+			Op       string
+			Label    string
+			Threads  []models.Thread
+			Comments []models.Comment
+			User     models.User
+			Category models.Category
+			// Reason is what will be recorded; the form fields it came
+			// from are resubmitted with the confirmation.
+			Reason      string
+			ReasonField string
+			NoteField   string
+			Spam        bool
+		}{
+			Op:          op,
+			Label:       operation.Label,
+			Threads:     threads,
+			Comments:    comments,
+			User:        user,
+			Category:    category,
+			Reason:      reason,
+			ReasonField: r.FormValue("reason"),
+			NoteField:   r.FormValue("note"),
+			Spam:        op == database.BulkReject && rejectedAsSpam(r, reason),
+		})
+	}
+
+	for _, t := range threads {
+		batch.ThreadIDs = append(batch.ThreadIDs, t.ID)
+	}
+	for _, c := range comments {
+		batch.CommentIDs = append(batch.CommentIDs, c.ID)
+	}
+	before := map[string]interface{}{"threads": threads, "comments": comments}
+	if op == database.BulkPurge {
+		batch.Log = newModAction(r, operation.LogAction, "user", user.ID, before, nil, reason)
+	} else {
+		batch.Log = newModAction(r, operation.LogAction, "bulk", 0, before, after, reason)
+	}
+	err = database.ApplyBulkAction(batch)
+	if err == database.ErrBulkChanged {
+		return apperror.BadRequest("Some of the selected content changed in the meantime; nothing was done, please select it again")
+	}
+	if err != nil {
+		return apperror.Internal(err, "Failed to apply the bulk action")
+	}
+
+	// Authors and the spam filter hear about each item as they would from
+	// the single actions.
+	asSpam := op == database.BulkReject && rejectedAsSpam(r, reason)
+	for _, t := range threads {
+		switch op {
+		case database.BulkApprove:
+			trainSpam(r, "thread", t.ID, false, t.Title, t.Content)
+			notifyThreadAuthor(r, t, fmt.Sprintf("Your thread %q was approved.", t.Title))
+		case database.BulkReject:
+			if asSpam {
+				trainSpam(r, "thread", t.ID, true, t.Title, t.Content)
+			}
+			notifyThreadAuthor(r, t, fmt.Sprintf("Your thread %q was rejected: %s.", t.Title, reason))
+		}
+	}
+	for _, c := range comments {
+		switch {
+		case op == database.BulkApprove:
+			trainSpam(r, "comment", c.ID, false, "", c.Content)
+		case op != database.BulkMove && c.Approved != database.CommentVisible:
+			trainSpam(r, "comment", c.ID, true, "", c.Content)
+		}
+	}
+
+	if op == database.BulkPurge {
+		http.Redirect(w, r, fmt.Sprintf("/moderator/users/%d", user.ID), http.StatusSeeOther)
+		return nil
+	}
+	http.Redirect(w, r, "/moderator/panel", http.StatusSeeOther)
+	return nil
+}
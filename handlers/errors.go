@@ -0,0 +1,38 @@
+package handlers
+
+import (
+	"net/http"
+
+	"DytForum/apperror"
+	"DytForum/requestid"
+)
+
+// NotFoundHandler answers requests that match no route.
+func NotFoundHandler(w http.ResponseWriter, r *http.Request) error {
+	return apperror.NotFound("The page you are looking for does not exist.")
+}
+
+// MethodNotAllowedHandler answers requests to a route with the wrong method.
+func MethodNotAllowedHandler(w http.ResponseWriter, r *http.Request) error {
+	return apperror.MethodNotAllowed()
+}
+
+// renderErrorPage is the HTML renderer for apperror.Write.
+func renderErrorPage(w http.ResponseWriter, r *http.Request, e *apperror.Error) error {
+	data := struct {
+		Status    int
+		Title     string
+		Message   string
+		RequestID string
+		LoginLink bool
+		Fields    map[string]string
+	}{
+		Status:    e.Status,
+		Title:     http.StatusText(e.Status),
+		Message:   e.Message,
+		RequestID: requestid.FromContext(r.Context()),
+		LoginLink: e.Status == http.StatusUnauthorized,
+		Fields:    e.Fields,
+	}
+	return renderTemplateStatus(w, r, e.Status, errorPage, data)
+}
@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"DytForum/database"
+	"DytForum/logging"
+	"DytForum/server"
+)
+
+// uploadDir is where thread pictures are stored.
+const uploadDir = "static/uploads"
+
+// readinessTimeout bounds how long all readiness checks together may take.
+const readinessTimeout = 2 * time.Second
+
+// checkResult is public, so it has no error text; why a check failed is
+// logged instead.
+type checkResult struct {
+	Status    string  `json:"status"`
+	LatencyMS float64 `json:"latency_ms"`
+}
+
+type healthResponse struct {
+	Status string                 `json:"status"`
+	Checks map[string]checkResult `json:"checks,omitempty"`
+}
+
+// readinessChecks must all pass before the load balancer sends traffic.
+var readinessChecks = []struct {
+	name  string
+	check func(ctx context.Context) error
+}{
+	{"database", func(ctx context.Context) error {
+		return database.DB.PingContext(ctx)
+	}},
+	{"migrations", func(ctx context.Context) error {
+		pending, err := database.CheckMigrations(ctx)
+		if err != nil {
+			return err
+		}
+		if pending > 0 {
+			return fmt.Errorf("%d pending migrations", pending)
+		}
+		return nil
+	}},
+	{"uploads", func(ctx context.Context) error {
+		f, err := os.CreateTemp(uploadDir, ".readyz-*")
+		if err != nil {
+			return err
+		}
+		f.Close()
+		return os.Remove(f.Name())
+	}},
+	{"templates", func(ctx context.Context) error {
+		if !TemplatesLoaded() {
+			return errors.New("templates are not loaded")
+		}
+		return nil
+	}},
+}
+
+// HealthzHandler reports that the process is up. It does not look at any
+// dependency so a slow database never gets the process restarted.
+func HealthzHandler(w http.ResponseWriter, r *http.Request) error {
+	writeHealth(w, http.StatusOK, healthResponse{Status: "ok"})
+	return nil
+}
+
+// ReadyzHandler runs every readiness check and answers 503 if one fails or
+// the server is shutting down.
+func ReadyzHandler(w http.ResponseWriter, r *http.Request) error {
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+
+	resp := healthResponse{Status: "ready", Checks: map[string]checkResult{}}
+	for _, c := range readinessChecks {
+		start := time.Now()
+		err := c.check(ctx)
+		result := checkResult{Status: "ok", LatencyMS: float64(time.Since(start).Microseconds()) / 1000}
+		if err != nil {
+			logging.FromContext(r.Context()).Warn("readiness check failed", "check", c.name, "err", err)
+			result.Status = "fail"
+			resp.Status = "not ready"
+		}
+		resp.Checks[c.name] = result
+	}
+	if server.ShuttingDown() {
+		resp.Status = "shutting down"
+	}
+
+	status := http.StatusOK
+	if resp.Status != "ready" {
+		status = http.StatusServiceUnavailable
+	}
+	writeHealth(w, status, resp)
+	return nil
+}
+
+func writeHealth(w http.ResponseWriter, status int, resp healthResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
@@ -1,8 +1,11 @@
 package handlers
 
 import (
+	"net/http"
+
 	"DytForum/database"
 	"DytForum/models"
+	"DytForum/session"
 )
 
 func fetchCategories() ([]models.Category, error) {
@@ -26,7 +29,7 @@ func fetchCategories() ([]models.Category, error) {
 }
 
 func fetchThreads() ([]models.Thread, error) {
-	rows, err := database.DB.Query("SELECT id, category, title, content FROM threads WHERE approved = 1")
+	rows, err := database.DB.Query("SELECT id, " + database.ThreadCategoryName + ", title, content FROM threads WHERE approved = 1")
 	if err != nil {
 		return nil, err
 	}
@@ -44,3 +47,19 @@ func fetchThreads() ([]models.Thread, error) {
 
 	return threads, nil
 }
+
+// currentUserCan reports whether the logged in user's role grants perm.
+// Guests and lookup failures are treated as not allowed.
+func currentUserCan(r *http.Request, perm string) bool {
+	session, _ := session.Store.Get(r, "session-name")
+	userID, ok := session.Values["userID"].(int)
+	if !ok {
+		return false
+	}
+	role, err := database.GetUserRole(userID)
+	if err != nil {
+		return false
+	}
+	allowed, err := database.RoleHasPermission(role, perm)
+	return err == nil && allowed
+}
@@ -1,11 +1,9 @@
 package handlers
 
 import (
-	"html/template"
 	"net/http"
 )
 
-func HomeHandler(w http.ResponseWriter, r *http.Request) {
-	tmpl := template.Must(template.ParseFiles("templates/home.html"))
-	tmpl.Execute(w, nil)
+func HomeHandler(w http.ResponseWriter, r *http.Request) error {
+	return renderTemplate(w, r, homePage, nil)
 }
@@ -1,179 +1,79 @@
 package handlers
 
 import (
-	"log"
 	"net/http"
 	"strconv"
 
+	"DytForum/apperror"
 	"DytForum/database"
 	"DytForum/session"
 )
 
 // LikeThread handles HTTP requests to like or dislike a thread.
-func LikeThread(w http.ResponseWriter, r *http.Request) {
+func LikeThread(w http.ResponseWriter, r *http.Request) error {
 	session, _ := session.Store.Get(r, "session-name")
 	if auth, ok := session.Values["authenticated"].(bool); !ok || !auth {
-		http.Error(w, "You must be logged in to like or dislike a thread", http.StatusUnauthorized)
-		return
+		return apperror.Unauthorized("You must be logged in to like or dislike a thread")
 	}
 
 	userID, ok := session.Values["userID"].(int)
 	if !ok {
-		log.Println("User ID not found in session")
-		http.Error(w, "User ID not found in session", http.StatusInternalServerError)
-		return
+		return apperror.New(http.StatusInternalServerError, "User ID not found in session")
 	}
-
-	log.Printf("User ID: %d", userID)
-
-	threadID := r.FormValue("thread_id")
-	likeStatus := r.FormValue("like_status")
-
-	if threadID == "" || likeStatus == "" {
-		http.Error(w, "Thread ID and like/dislike status are required", http.StatusBadRequest)
-		return
+	if err := checkCanPost(userID); err != nil {
+		return err
 	}
 
-	likeStatusInt, err := strconv.Atoi(likeStatus)
-	if err != nil || (likeStatusInt != 1 && likeStatusInt != -1) {
-		http.Error(w, "Invalid like/dislike status", http.StatusBadRequest)
-		return
+	threadID, err := strconv.Atoi(r.FormValue("thread_id"))
+	if err != nil {
+		return apperror.BadRequest("Thread ID and like/dislike status are required")
 	}
 
-	// Check if the user has already liked or disliked the thread
-	var existingLikeStatus int
-	err = database.DB.QueryRow("SELECT like_status FROM likes WHERE thread_id = ? AND user_id = ?", threadID, userID).Scan(&existingLikeStatus)
-	if err == nil {
-		if existingLikeStatus == likeStatusInt {
-			// User is trying to like or dislike the thread again with the same status, do nothing
-			http.Redirect(w, r, r.Referer(), http.StatusSeeOther) // Redirect back to the referring page
-			return
-		} else {
-			_, err := database.DB.Exec("DELETE FROM likes WHERE thread_id = ? AND user_id = ?", threadID, userID)
-			if err != nil {
-				log.Printf("Failed to delete previous like/dislike: %v", err)
-				http.Error(w, "Failed to delete previous like/dislike", http.StatusInternalServerError)
-				return
-			}
-			if existingLikeStatus == 1 {
-				_, err = database.DB.Exec("UPDATE threads SET likes = likes - 1 WHERE id = ?", threadID)
-			} else {
-				_, err = database.DB.Exec("UPDATE threads SET dislikes = dislikes - 1 WHERE id = ?", threadID)
-			}
-			if err != nil {
-				log.Printf("Failed to update thread likes/dislikes: %v", err)
-				http.Error(w, "Failed to update thread likes/dislikes", http.StatusInternalServerError)
-				return
-			}
-		}
+	likeStatusInt, err := strconv.Atoi(r.FormValue("like_status"))
+	if err != nil || (likeStatusInt != database.ReactionLike && likeStatusInt != database.ReactionDislike) {
+		return apperror.BadRequest("Invalid like/dislike status")
 	}
 
-	_, err = database.DB.Exec("INSERT INTO likes (thread_id, user_id, like_status) VALUES (?, ?, ?)", threadID, userID, likeStatusInt)
-	if err != nil {
-		log.Printf("Failed to create like/dislike: %v", err)
-		http.Error(w, "Failed to create like/dislike", http.StatusInternalServerError)
-		return
-	}
-	if likeStatusInt == 1 {
-		_, err = database.DB.Exec("UPDATE threads SET likes = likes + 1 WHERE id = ?", threadID)
-	} else {
-		_, err = database.DB.Exec("UPDATE threads SET dislikes = dislikes + 1 WHERE id = ?", threadID)
-	}
-	if err != nil {
-		log.Printf("Failed to update thread likes/dislikes: %v", err)
-		http.Error(w, "Failed to update thread likes/dislikes", http.StatusInternalServerError)
-		return
+	if err := database.SetThreadReaction(userID, threadID, likeStatusInt); err != nil {
+		return apperror.Internal(err, "Failed to update thread likes/dislikes")
 	}
+	reactionsTotal.Inc("thread", reactionName(likeStatusInt))
 
 	http.Redirect(w, r, r.Referer(), http.StatusSeeOther)
+	return nil
 }
 
 // LikeComment handles HTTP requests to like or dislike a comment.
-func LikeComment(w http.ResponseWriter, r *http.Request) {
+func LikeComment(w http.ResponseWriter, r *http.Request) error {
 	session, _ := session.Store.Get(r, "session-name")
 	if auth, ok := session.Values["authenticated"].(bool); !ok || !auth {
-		http.Error(w, "You must be logged in to like or dislike a comment", http.StatusUnauthorized)
-		return
+		return apperror.Unauthorized("You must be logged in to like or dislike a comment")
 	}
 
 	userID, ok := session.Values["userID"].(int)
 	if !ok {
-		log.Println("User ID not found in session")
-		http.Error(w, "User ID not found in session", http.StatusInternalServerError)
-		return
+		return apperror.New(http.StatusInternalServerError, "User ID not found in session")
 	}
-
-	log.Printf("User ID: %d", userID)
-
-	commentID := r.FormValue("comment_id")
-	likeStatus := r.FormValue("like_status")
-
-	if commentID == "" || likeStatus == "" {
-		http.Error(w, "Comment ID and like/dislike status are required", http.StatusBadRequest)
-		return
+	if err := checkCanPost(userID); err != nil {
+		return err
 	}
 
-	likeStatusInt, err := strconv.Atoi(likeStatus)
-	if err != nil || (likeStatusInt != 1 && likeStatusInt != -1) {
-		http.Error(w, "Invalid like/dislike status", http.StatusBadRequest)
-		return
+	commentID, err := strconv.Atoi(r.FormValue("comment_id"))
+	if err != nil {
+		return apperror.BadRequest("Comment ID and like/dislike status are required")
 	}
 
-	// Check if the user has already liked or disliked the comment
-	var existingLikeStatus int
-	err = database.DB.QueryRow("SELECT like_status FROM likes WHERE comment_id = ? AND user_id = ?", commentID, userID).Scan(&existingLikeStatus)
-	if err == nil {
-		if existingLikeStatus == likeStatusInt {
-			// User is trying to like or dislike the comment again with the same status, do nothing
-			http.Redirect(w, r, r.Referer(), http.StatusSeeOther) // Redirect back to the referring page
-			return
-		} else {
-			// User is changing their like/dislike status
-			_, err := database.DB.Exec("UPDATE likes SET like_status = ? WHERE comment_id = ? AND user_id = ?", likeStatusInt, commentID, userID)
-			if err != nil {
-				log.Printf("Failed to update like/dislike: %v", err)
-				http.Error(w, "Failed to update like/dislike", http.StatusInternalServerError)
-				return
-			}
-
-			// Delete the previous like/dislike if it exists
-			if existingLikeStatus != 0 {
-				var updateCount int
-				if existingLikeStatus == 1 {
-					updateCount = -1
-				} else {
-					updateCount = 1
-				}
-				_, err := database.DB.Exec("UPDATE comments SET likes = likes + ?, dislikes = dislikes - ? WHERE id = ?", updateCount, updateCount, commentID)
-				if err != nil {
-					log.Printf("Failed to update comment likes/dislikes: %v", err)
-					http.Error(w, "Failed to update comment likes/dislikes", http.StatusInternalServerError)
-					return
-				}
-			}
-		}
-	} else {
-		// User hasn't liked or disliked the comment yet, insert new like/dislike
-		_, err := database.DB.Exec("INSERT INTO likes (comment_id, user_id, like_status) VALUES (?, ?, ?)", commentID, userID, likeStatusInt)
-		if err != nil {
-			log.Printf("Failed to create like/dislike: %v", err)
-			http.Error(w, "Failed to create like/dislike", http.StatusInternalServerError)
-			return
-		}
+	likeStatusInt, err := strconv.Atoi(r.FormValue("like_status"))
+	if err != nil || (likeStatusInt != database.ReactionLike && likeStatusInt != database.ReactionDislike) {
+		return apperror.BadRequest("Invalid like/dislike status")
+	}
 
-		// Update the comment's likes/dislikes count based on the new status
-		if likeStatusInt == 1 {
-			_, err = database.DB.Exec("UPDATE comments SET likes = likes + 1 WHERE id = ?", commentID)
-		} else {
-			_, err = database.DB.Exec("UPDATE comments SET dislikes = dislikes + 1 WHERE id = ?", commentID)
-		}
-		if err != nil {
-			log.Printf("Failed to update comment likes/dislikes: %v", err)
-			http.Error(w, "Failed to update comment likes/dislikes", http.StatusInternalServerError)
-			return
-		}
+	if err := database.SetCommentReaction(userID, commentID, likeStatusInt); err != nil {
+		return apperror.Internal(err, "Failed to update comment likes/dislikes")
 	}
+	reactionsTotal.Inc("comment", reactionName(likeStatusInt))
 
 	// Redirect back to the referring page after successful like/dislike
 	http.Redirect(w, r, r.Referer(), http.StatusSeeOther)
+	return nil
 }
@@ -0,0 +1,345 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"DytForum/apperror"
+	"DytForum/database"
+	"DytForum/logging"
+	"DytForum/models"
+	"DytForum/session"
+)
+
+const (
+	// Failures within failureWindow count towards a lockout; older ones are forgotten.
+	failureWindow = time.Hour
+	// Number of failures after which the login form asks for a challenge answer.
+	challengeThreshold = 3
+)
+
+// lockout says when a throttle key gets locked: after threshold failures,
+// for base at first and twice as long with every further failure, up to max.
+type lockout struct {
+	threshold int
+	base, max time.Duration
+}
+
+var (
+	// Account lockouts stay short, so guessing someone's password cannot
+	// keep them out of their account for long; the challenge and the IP
+	// lockout do the rest.
+	accountLockout = lockout{threshold: 5, base: 15 * time.Second, max: 5 * time.Minute}
+	ipLockout      = lockout{threshold: 20, base: time.Minute, max: time.Hour}
+)
+
+// LoginChallenge is the CAPTCHA-style check shown after repeated failed
+// logins. Replace Challenge to plug in an external CAPTCHA service.
+type LoginChallenge interface {
+	// Prompt prepares a new challenge for the request and returns the
+	// question shown on the login form.
+	Prompt(w http.ResponseWriter, r *http.Request) (string, error)
+	// Verify checks the answer submitted with the login form.
+	Verify(w http.ResponseWriter, r *http.Request) bool
+}
+
+var Challenge LoginChallenge = arithmeticChallenge{}
+
+// challengeLifetime is how long a challenge question may be answered.
+const challengeLifetime = 10 * time.Minute
+
+// arithmeticChallenge asks a small addition. The answer is kept in the
+// database under a random nonce; the session only holds the nonce, since
+// session cookies are signed but readable. Each nonce is answered once.
+type arithmeticChallenge struct{}
+
+func (arithmeticChallenge) Prompt(w http.ResponseWriter, r *http.Request) (string, error) {
+	a, err := rand.Int(rand.Reader, big.NewInt(10))
+	if err != nil {
+		return "", err
+	}
+	b, err := rand.Int(rand.Reader, big.NewInt(10))
+	if err != nil {
+		return "", err
+	}
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	nonce := hex.EncodeToString(buf)
+	if err := database.CreateLoginChallenge(nonce, int(a.Int64()+b.Int64()), time.Now().Add(challengeLifetime)); err != nil {
+		return "", err
+	}
+
+	session, _ := session.Store.Get(r, "session-name")
+	session.Values["challengeNonce"] = nonce
+	if err := session.Save(r, w); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("What is %d + %d?", a.Int64(), b.Int64()), nil
+}
+
+func (arithmeticChallenge) Verify(w http.ResponseWriter, r *http.Request) bool {
+	session, _ := session.Store.Get(r, "session-name")
+	nonce, ok := session.Values["challengeNonce"].(string)
+	if !ok {
+		return false
+	}
+	delete(session.Values, "challengeNonce")
+	session.Save(r, w)
+
+	expected, ok, err := database.TakeLoginChallenge(nonce)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("failed to check login challenge", "err", err)
+		return false
+	}
+	answer, err := strconv.Atoi(strings.TrimSpace(r.FormValue("challenge")))
+	return ok && err == nil && answer == expected
+}
+
+func accountThrottleKey(username string) string {
+	return "account:" + strings.ToLower(username)
+}
+
+func ipThrottleKey(ip string) string {
+	return "ip:" + ip
+}
+
+// loginLockedFor returns how long the longest active lockout among keys lasts.
+func loginLockedFor(keys ...string) (time.Duration, error) {
+	now := time.Now()
+	var wait time.Duration
+	for _, key := range keys {
+		throttle, err := database.GetLoginThrottle(key)
+		if err != nil {
+			return 0, err
+		}
+		if d := throttle.LockedUntil.Sub(now); d > wait {
+			wait = d
+		}
+	}
+	return wait, nil
+}
+
+// challengeRequired reports whether any of the keys has enough recent failures
+// to require a challenge answer.
+func challengeRequired(keys ...string) (bool, error) {
+	now := time.Now()
+	for _, key := range keys {
+		throttle, err := database.GetLoginThrottle(key)
+		if err != nil {
+			return false, err
+		}
+		if now.Sub(throttle.LastFailure) < failureWindow && throttle.Failures >= challengeThreshold {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// duration doubles the base lockout for every failure past the threshold.
+func (l lockout) duration(failures int) time.Duration {
+	d := l.base
+	for i := l.threshold; i < failures && d < l.max; i++ {
+		d *= 2
+	}
+	if d > l.max {
+		d = l.max
+	}
+	return d
+}
+
+// addLoginFailure increments the failure count for key and locks it once the
+// lockout threshold is reached. It returns the number of recent failures.
+func addLoginFailure(key string, l lockout) (int, error) {
+	now := time.Now()
+	throttle, err := database.GetLoginThrottle(key)
+	if err != nil {
+		return 0, err
+	}
+	if now.Sub(throttle.LastFailure) >= failureWindow {
+		throttle.Failures = 0
+	}
+	throttle.Failures++
+	throttle.LastFailure = now
+
+	if throttle.Failures >= l.threshold {
+		throttle.LockedUntil = now.Add(l.duration(throttle.Failures))
+	}
+	return throttle.Failures, database.SaveLoginThrottle(throttle)
+}
+
+// recordLoginFailure logs a failed login and updates the account and IP
+// throttles. The account owner is notified when their account first gets
+// locked.
+func recordLoginFailure(username, ip string) error {
+	if err := database.RecordLoginAttempt(username, ip, false); err != nil {
+		return err
+	}
+	if _, err := addLoginFailure(ipThrottleKey(ip), ipLockout); err != nil {
+		return err
+	}
+	failures, err := addLoginFailure(accountThrottleKey(username), accountLockout)
+	if err != nil || failures != accountLockout.threshold {
+		return err
+	}
+
+	var userID int
+	if err := database.DB.QueryRow("SELECT id FROM users WHERE username = ?", username).Scan(&userID); err != nil {
+		// Unknown usernames have nobody to notify.
+		return nil
+	}
+	slog.Warn("account locked after repeated failed logins", "username", username, "ip", ip)
+	return database.CreateNotification(userID, fmt.Sprintf(
+		"Your account was briefly locked after several failed login attempts (last from %s). If this wasn't you, consider changing your password and enabling two-factor authentication.", ip))
+}
+
+// recordLoginSuccess logs a successful login and resets the account throttle.
+// The owner is told about failed attempts made since their last login.
+func recordLoginSuccess(userID int, username, ip string) error {
+	if err := database.RecordLoginAttempt(username, ip, true); err != nil {
+		return err
+	}
+
+	key := accountThrottleKey(username)
+	throttle, err := database.GetLoginThrottle(key)
+	if err != nil {
+		return err
+	}
+	if throttle.Failures > 0 {
+		err := database.CreateNotification(userID, fmt.Sprintf(
+			"There were %d failed login attempts on your account before this login.", throttle.Failures))
+		if err != nil {
+			return err
+		}
+	}
+	return database.ClearLoginThrottle(key)
+}
+
+// renderLoginForm renders a login template with an optional error message and
+// challenge question.
+func renderLoginForm(w http.ResponseWriter, r *http.Request, page string, status int, message string, withChallenge bool) error {
+	data := struct {
+		Error     string
+		Challenge string
+	}{
+		Error: message,
+	}
+	if withChallenge {
+		prompt, err := Challenge.Prompt(w, r)
+		if err != nil {
+			return apperror.Internal(err, "Failed to prepare login challenge")
+		}
+		data.Challenge = prompt
+	}
+
+	return renderTemplateStatus(w, r, status, page, data)
+}
+
+// checkLoginThrottle runs before a password is checked. It returns false if
+// the account or IP is locked or the challenge answer is missing or wrong,
+// after rendering the form again; the error is set only if that failed.
+func checkLoginThrottle(w http.ResponseWriter, r *http.Request, page, username, ip string) (bool, error) {
+	keys := []string{accountThrottleKey(username), ipThrottleKey(ip)}
+
+	wait, err := loginLockedFor(keys...)
+	if err != nil {
+		return false, apperror.Internal(err, "Failed to check login throttle")
+	}
+	if wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+		return false, renderLoginForm(w, r, page, http.StatusTooManyRequests,
+			fmt.Sprintf("Too many failed login attempts. Try again in %s.", wait.Round(time.Second)), false)
+	}
+
+	needChallenge, err := challengeRequired(keys...)
+	if err != nil {
+		return false, apperror.Internal(err, "Failed to check login throttle")
+	}
+	if needChallenge && !Challenge.Verify(w, r) {
+		return false, renderLoginForm(w, r, page, http.StatusUnauthorized, "Please answer the security question.", true)
+	}
+	return true, nil
+}
+
+// failLogin records a failed attempt and renders the form with an error.
+func failLogin(w http.ResponseWriter, r *http.Request, page, username, ip string) error {
+	if err := recordLoginFailure(username, ip); err != nil {
+		logging.FromContext(r.Context()).Error("failed to record login failure", "username", username, "err", err)
+	}
+	needChallenge, _ := challengeRequired(accountThrottleKey(username), ipThrottleKey(ip))
+	return renderLoginForm(w, r, page, http.StatusUnauthorized, "Invalid username or password", needChallenge)
+}
+
+// lockedLogins is one table of locked throttle keys on the security page.
+type lockedLogins struct {
+	Title  string
+	Column string
+	Locked []lockedLogin
+}
+
+type lockedLogin struct {
+	models.LoginThrottle
+	// Name is the username or IP address without the key prefix.
+	Name string
+}
+
+// AdminSecurityHandler lists currently locked accounts and IP addresses
+// together with the most recent failed logins.
+func AdminSecurityHandler(w http.ResponseWriter, r *http.Request) error {
+	locked, err := database.LockedLoginThrottles(time.Now())
+	if err != nil {
+		return apperror.Internal(err, "Failed to fetch locked accounts")
+	}
+
+	failed, err := database.RecentFailedLogins(50)
+	if err != nil {
+		return apperror.Internal(err, "Failed to fetch failed logins")
+	}
+
+	accounts := lockedLogins{Title: "Accounts", Column: "Username"}
+	addresses := lockedLogins{Title: "Addresses", Column: "IP Address"}
+	for _, throttle := range locked {
+		if name, ok := strings.CutPrefix(throttle.Key, accountThrottleKey("")); ok {
+			accounts.Locked = append(accounts.Locked, lockedLogin{throttle, name})
+		} else if name, ok := strings.CutPrefix(throttle.Key, ipThrottleKey("")); ok {
+			addresses.Locked = append(addresses.Locked, lockedLogin{throttle, name})
+		}
+	}
+
+	data := struct {
+		Accounts  lockedLogins
+		Addresses lockedLogins
+		Failed    []models.LoginAttempt
+	}{
+		Accounts:  accounts,
+		Addresses: addresses,
+		Failed:    failed,
+	}
+	return renderTemplate(w, r, adminSecurityPage, data)
+}
+
+// UnlockLoginHandler lets an admin lift a lockout before it expires.
+func UnlockLoginHandler(w http.ResponseWriter, r *http.Request) error {
+	key := r.FormValue("key")
+	if key == "" {
+		return apperror.BadRequest("Missing key")
+	}
+
+	if err := database.ClearLoginThrottle(key); err != nil {
+		return apperror.Internal(err, "Failed to unlock")
+	}
+	if err := recordModAction(r, database.ModLoginUnlock, "login_throttle", 0, nil, map[string]string{"key": key}, ""); err != nil {
+		return err
+	}
+
+	http.Redirect(w, r, "/admin/security", http.StatusSeeOther)
+	return nil
+}
@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"net"
+	"net/http"
+	"strings"
+
+	"DytForum/apperror"
+	"DytForum/config"
+	"DytForum/database"
+	"DytForum/metrics"
+	"DytForum/middleware"
+)
+
+var (
+	loginsTotal = metrics.NewCounterVec(
+		"forum_logins_total", "Successful logins by method.", "method")
+	registrationsTotal = metrics.NewCounterVec(
+		"forum_registrations_total", "New accounts by method.", "method")
+	postsTotal = metrics.NewCounterVec(
+		"forum_posts_total", "Threads and comments created.", "kind")
+	reactionsTotal = metrics.NewCounterVec(
+		"forum_reactions_total", "Likes and dislikes given.", "target", "reaction")
+)
+
+func init() {
+	metrics.NewGaugeFunc("forum_pending_threads", "Threads waiting for moderator approval.", func() (float64, error) {
+		return countRows("SELECT COUNT(*) FROM threads WHERE " + database.QueuedThreads)
+	})
+	metrics.NewGaugeFunc("forum_open_reports", "Reported content no moderator has picked up yet.", func() (float64, error) {
+		return countRows("SELECT COUNT(*) FROM reports WHERE status = 'open' AND duplicate_of IS NULL")
+	})
+}
+
+func countRows(query string) (float64, error) {
+	if database.DB == nil {
+		return 0, nil
+	}
+	var n int
+	err := database.DB.QueryRow(query).Scan(&n)
+	return float64(n), err
+}
+
+// reactionName is the label for a like_status value.
+func reactionName(status int) string {
+	if status == 1 {
+		return "like"
+	}
+	return "dislike"
+}
+
+var metricsAccess struct {
+	networks []*net.IPNet
+	token    string
+}
+
+// ConfigureMetrics sets who may read /metrics.
+func ConfigureMetrics(cfg config.Config) error {
+	networks, err := cfg.MetricsNetworks()
+	if err != nil {
+		return err
+	}
+	metricsAccess.networks = networks
+	metricsAccess.token = cfg.MetricsToken
+	return nil
+}
+
+// MetricsHandler writes every metric in the Prometheus text format. It only
+// answers clients from an allowed network or with the configured token.
+func MetricsHandler(w http.ResponseWriter, r *http.Request) error {
+	if !metricsAllowed(r) {
+		return apperror.Forbidden("You are not allowed to read metrics")
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	metrics.Write(w)
+	return nil
+}
+
+func metricsAllowed(r *http.Request) bool {
+	if metricsAccess.token != "" {
+		if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok &&
+			subtle.ConstantTimeCompare([]byte(token), []byte(metricsAccess.token)) == 1 {
+			return true
+		}
+	}
+	ip := net.ParseIP(middleware.ClientIP(r))
+	if ip == nil {
+		return false
+	}
+	for _, network := range metricsAccess.networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,246 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"DytForum/apperror"
+	"DytForum/database"
+	"DytForum/logging"
+	"DytForum/models"
+	"DytForum/session"
+)
+
+const (
+	modLogPageSize = 100
+	// transparencyMonths is how far back the public transparency page goes.
+	transparencyMonths = 12
+)
+
+// recordModAction appends an entry for the logged in user to the moderation
+// audit log. before and after are snapshots of the changed state and are
+// stored as JSON; pass nil when there is nothing to record. If the entry
+// cannot be written the request fails, so an unlogged action never looks
+// like a success; actions that must not happen without their entry record
+// it in their own transaction with newModAction instead.
+func recordModAction(r *http.Request, action, targetType string, targetID int, before, after interface{}, reason string) error {
+	entry := newModAction(r, action, targetType, targetID, before, after, reason)
+	if err := database.CreateModAction(entry); err != nil {
+		return apperror.Internal(err, "The action was taken but could not be recorded in the moderation log")
+	}
+	return nil
+}
+
+// newModAction builds an audit log entry for the current moderator without
+// saving it, for callers that record it in their own transaction.
+func newModAction(r *http.Request, action, targetType string, targetID int, before, after interface{}, reason string) models.ModAction {
+	session, _ := session.Store.Get(r, "session-name")
+	actorID, _ := session.Values["userID"].(int)
+
+	return models.ModAction{
+		ActorID:    actorID,
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetID,
+		Before:     modSnapshot(before),
+		After:      modSnapshot(after),
+		Reason:     reason,
+	}
+}
+
+func modSnapshot(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
+
+// modLogQuery reads the moderator, action and date filters shared by the
+// log page and the CSV export. Dates are YYYY-MM-DD and both ends are
+// inclusive.
+func modLogQuery(r *http.Request) (database.ModActionQuery, error) {
+	var q database.ModActionQuery
+	fields := map[string]string{}
+
+	if v := r.FormValue("actor"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			fields["actor"] = "must be a user ID"
+		}
+		q.ActorID = id
+	}
+	if v := r.FormValue("action"); v != "" {
+		known := false
+		for _, action := range database.ModActions {
+			known = known || action == v
+		}
+		if !known {
+			fields["action"] = "is not a known action"
+		}
+		q.Action = v
+	}
+	if v := r.FormValue("from"); v != "" {
+		from, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			fields["from"] = "must be a date like 2024-01-31"
+		}
+		q.From = from
+	}
+	if v := r.FormValue("to"); v != "" {
+		to, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			fields["to"] = "must be a date like 2024-01-31"
+		}
+		q.To = to.AddDate(0, 0, 1)
+	}
+
+	if len(fields) > 0 {
+		return q, apperror.Invalid(fields)
+	}
+	return q, nil
+}
+
+// ModLogHandler shows the moderation audit log, newest first, one page at a
+// time.
+func ModLogHandler(w http.ResponseWriter, r *http.Request) error {
+	q, err := modLogQuery(r)
+	if err != nil {
+		return err
+	}
+	if v := r.FormValue("before"); v != "" {
+		if q.Before, err = strconv.Atoi(v); err != nil {
+			return apperror.BadRequest("Invalid page")
+		}
+	}
+	q.Limit = modLogPageSize
+
+	entries, err := database.ListModActions(q)
+	if err != nil {
+		return apperror.Internal(err, "Failed to fetch the moderation log")
+	}
+	actors, err := database.ModActionActors()
+	if err != nil {
+		return apperror.Internal(err, "Failed to fetch moderators")
+	}
+
+	// The filters without the page, for the export and next page links.
+	filters := url.Values{}
+	for _, name := range []string{"actor", "action", "from", "to"} {
+		if v := r.FormValue(name); v != "" {
+			filters.Set(name, v)
+		}
+	}
+	var nextPage string
+	if len(entries) == modLogPageSize {
+		next := url.Values{}
+		for k, v := range filters {
+			next[k] = v
+		}
+		next.Set("before", strconv.Itoa(entries[len(entries)-1].ID))
+		nextPage = next.Encode()
+	}
+
+	data := struct {
+		Entries  []models.ModAction
+		Actors   []models.User
+		Actions  []string
+		ActorID  int
+		Action   string
+		From     string
+		To       string
+		Filters  string
+		NextPage string
+	}{
+		Entries:  entries,
+		Actors:   actors,
+		Actions:  database.ModActions,
+		ActorID:  q.ActorID,
+		Action:   q.Action,
+		From:     r.FormValue("from"),
+		To:       r.FormValue("to"),
+		Filters:  filters.Encode(),
+		NextPage: nextPage,
+	}
+	return renderTemplate(w, r, adminModLogPage, data)
+}
+
+// ModLogCSVHandler exports every log entry matching the filters as CSV.
+func ModLogCSVHandler(w http.ResponseWriter, r *http.Request) error {
+	q, err := modLogQuery(r)
+	if err != nil {
+		return err
+	}
+	entries, err := database.ListModActions(q)
+	if err != nil {
+		return apperror.Internal(err, "Failed to fetch the moderation log")
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="mod-log.csv"`)
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"id", "time", "actor_id", "actor", "action", "target_type", "target_id", "before", "after", "reason"})
+	for _, e := range entries {
+		cw.Write([]string{
+			strconv.Itoa(e.ID), e.CreatedAt.UTC().Format(time.RFC3339), strconv.Itoa(e.ActorID), csvCell(e.ActorName),
+			e.Action, e.TargetType, strconv.Itoa(e.TargetID), csvCell(e.Before), csvCell(e.After), csvCell(e.Reason),
+		})
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		logging.FromContext(r.Context()).Error("failed to write moderation log export", "error", err)
+	}
+	return nil
+}
+
+// csvCell keeps spreadsheets from running user supplied text as a formula
+// by prefixing cells that start with a formula character with a quote.
+func csvCell(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+// TransparencyHandler publishes how many moderation actions of each kind
+// were taken per month. It names neither the moderators nor the targets.
+func TransparencyHandler(w http.ResponseWriter, r *http.Request) error {
+	now := time.Now().UTC()
+	since := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC).AddDate(0, -(transparencyMonths - 1), 0)
+	counts, err := database.ModActionCounts(since)
+	if err != nil {
+		return apperror.Internal(err, "Failed to fetch moderation statistics")
+	}
+
+	type month struct {
+		Month  string
+		Total  int
+		Counts []models.ModActionCount
+	}
+	var months []month
+	for _, c := range counts {
+		if len(months) == 0 || months[len(months)-1].Month != c.Month {
+			months = append(months, month{Month: c.Month})
+		}
+		last := &months[len(months)-1]
+		last.Total += c.Count
+		last.Counts = append(last.Counts, c)
+	}
+
+	data := struct {
+		Months []month
+		Since  string
+	}{
+		Months: months,
+		Since:  since.Format("2006-01"),
+	}
+	return renderTemplate(w, r, transparencyPage, data)
+}
@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"database/sql"
+	"net/http"
+
+	"DytForum/apperror"
+	"DytForum/database"
+	"DytForum/permissions"
+	"DytForum/session"
+)
+
+// moderationScope describes which threads the current moderator may act on:
+// every thread, or only those in categories assigned to them.
+type moderationScope struct {
+	UserID int
+	All    bool
+}
+
+func currentModerationScope(r *http.Request) moderationScope {
+	session, _ := session.Store.Get(r, "session-name")
+	userID, _ := session.Values["userID"].(int)
+	return moderationScope{
+		UserID: userID,
+		All:    currentUserCan(r, permissions.ModerateAllCategories),
+	}
+}
+
+// threadFilter returns an SQL condition on threads.id and its arguments.
+func (s moderationScope) threadFilter() (string, []interface{}) {
+	if s.All {
+		return "1 = 1", nil
+	}
+	return database.ModeratedThreadsFilter, []interface{}{s.UserID}
+}
+
+// moderatorID is the ModeratorID to query with: zero when the scope covers
+// every category.
+func (s moderationScope) moderatorID() int {
+	if s.All {
+		return 0
+	}
+	return s.UserID
+}
+
+func (s moderationScope) allowsThread(threadID int) (bool, error) {
+	if s.All {
+		return true, nil
+	}
+	return database.ModeratesThread(s.UserID, threadID)
+}
+
+// checkThreadInScope returns a 403 error when the thread is outside the
+// current moderator's categories.
+func checkThreadInScope(r *http.Request, threadID int) error {
+	allowed, err := currentModerationScope(r).allowsThread(threadID)
+	if err != nil {
+		return apperror.Internal(err, "Failed to check permissions")
+	}
+	if !allowed {
+		return apperror.Forbidden("You do not moderate this category")
+	}
+	return nil
+}
+
+// checkReportInScope is checkThreadInScope for the thread a report is about.
+func checkReportInScope(r *http.Request, reportID int) error {
+	var threadID int
+	err := database.DB.QueryRow("SELECT thread_id FROM reports WHERE id = ?", reportID).Scan(&threadID)
+	if err == sql.ErrNoRows {
+		return apperror.NotFound("Report not found")
+	}
+	if err != nil {
+		return apperror.Internal(err, "Failed to fetch report")
+	}
+	return checkThreadInScope(r, threadID)
+}
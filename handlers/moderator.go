@@ -1,153 +1,278 @@
 package handlers
 
 import (
-	"html/template"
-	"log"
+	"database/sql"
+	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
 
+	"DytForum/apperror"
 	"DytForum/database"
+	"DytForum/logging"
 	"DytForum/models"
+	"DytForum/permissions"
 	"DytForum/session"
 
 	"github.com/gorilla/mux"
 )
 
-func ApproveThreadHandler(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	threadID, err := strconv.Atoi(vars["id"])
+// maxReviewNoteLength caps the rejection reasons and change requests shown
+// to authors.
+const maxReviewNoteLength = 500
+
+// threadRejectionReasons are the stock reasons offered when rejecting a
+// thread. Moderators can add a note or write their own reason instead.
+var threadRejectionReasons = []string{
+	"Off-topic for this category",
+	"Duplicate of an existing thread",
+	database.SpamRejectionReason,
+	"Breaks the forum rules",
+	"Not enough information to start a discussion",
+}
+
+// reviewThread loads a thread for one of the review handlers after checking
+// that it is in the moderator's categories.
+func reviewThread(r *http.Request) (models.Thread, error) {
+	threadID, err := pathID(r, "id")
 	if err != nil {
-		http.Error(w, "Invalid thread ID", http.StatusBadRequest)
-		return
+		return models.Thread{}, err
+	}
+	if err := checkThreadInScope(r, threadID); err != nil {
+		return models.Thread{}, err
+	}
+	thread, err := database.GetThread(threadID)
+	if err == sql.ErrNoRows {
+		return thread, apperror.NotFound("Thread not found")
 	}
-
-	_, err = database.DB.Exec("UPDATE threads SET approved = 1 WHERE id = ?", threadID)
 	if err != nil {
-		http.Error(w, "Failed to approve thread", http.StatusInternalServerError)
-		return
+		return thread, apperror.Internal(err, "Failed to fetch thread")
 	}
+	return thread, nil
+}
 
-	http.Redirect(w, r, "/moderator/panel", http.StatusSeeOther)
+// notifyThreadAuthor tells the author what happened to their thread. A
+// failure is logged but does not undo the review.
+func notifyThreadAuthor(r *http.Request, thread models.Thread, message string) {
+	if err := database.CreateNotification(thread.UserID, message); err != nil {
+		logging.FromContext(r.Context()).Error("failed to notify thread author", "thread_id", thread.ID, "user_id", thread.UserID, "err", err)
+	}
 }
 
-func RejectThreadHandler(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	threadID, err := strconv.Atoi(vars["id"])
+// ReviewThreadHandler shows a thread in the approval queue with the forms to
+// reject it or ask the author for changes.
+func ReviewThreadHandler(w http.ResponseWriter, r *http.Request) error {
+	thread, err := reviewThread(r)
 	if err != nil {
-		http.Error(w, "Invalid thread ID", http.StatusBadRequest)
-		return
+		return err
 	}
+	data := struct {
+		Thread  models.Thread
+		Reasons []string
+	}{
+		Thread:  thread,
+		Reasons: threadRejectionReasons,
+	}
+	return renderTemplate(w, r, moderatorThreadPage, data)
+}
 
-	_, err = database.DB.Exec("DELETE FROM threads WHERE id = ?", threadID)
+// ApproveThreadHandler publishes a thread from the approval queue. Rejected
+// threads are only approved by overturning the rejection on appeal.
+func ApproveThreadHandler(w http.ResponseWriter, r *http.Request) error {
+	thread, err := reviewThread(r)
 	if err != nil {
-		http.Error(w, "Failed to delete thread", http.StatusInternalServerError)
-		return
+		return err
+	}
+	if !database.Queued(thread.Approved) {
+		return apperror.BadRequest("This thread is not awaiting approval")
 	}
 
+	if err := database.SetThreadReview(thread.ID, database.ThreadApproved, ""); err != nil {
+		return apperror.Internal(err, "Failed to approve thread")
+	}
+	if err := recordModAction(r, database.ModThreadApprove, "thread", thread.ID, thread, nil, ""); err != nil {
+		return err
+	}
+	trainSpam(r, "thread", thread.ID, false, thread.Title, thread.Content)
+	notifyThreadAuthor(r, thread, fmt.Sprintf("Your thread %q was approved.", thread.Title))
+
 	http.Redirect(w, r, "/moderator/panel", http.StatusSeeOther)
+	return nil
 }
 
-func ApproveReportHandler(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	reportID, err := strconv.Atoi(vars["id"])
+// rejectionReason reads a rejection reason from the form: one of the stock
+// reasons, a note, or both joined.
+func rejectionReason(r *http.Request) (string, error) {
+	reason := r.FormValue("reason")
+	known := reason == ""
+	for _, stock := range threadRejectionReasons {
+		known = known || stock == reason
+	}
+	if !known {
+		return "", apperror.BadRequest("Invalid reason")
+	}
+	if note := strings.TrimSpace(r.FormValue("note")); note != "" {
+		if reason != "" {
+			reason += ": "
+		}
+		reason += note
+	}
+	fields := map[string]string{}
+	validateText(fields, "reason", reason, maxReviewNoteLength)
+	if len(fields) > 0 {
+		return "", apperror.Invalid(fields)
+	}
+	return reason, nil
+}
+
+// rejectedAsSpam reports whether a rejection teaches the spam filter: only
+// when the stock spam reason was picked or the moderator marked the thread
+// as spam, so that off-topic or duplicate threads are not learned as spam.
+func rejectedAsSpam(r *http.Request, reason string) bool {
+	return strings.HasPrefix(reason, database.SpamRejectionReason) || r.FormValue("spam") != ""
+}
+
+// RejectThreadHandler rejects a thread awaiting review. The thread is kept
+// so its author can read the reason and appeal; it is never published.
+func RejectThreadHandler(w http.ResponseWriter, r *http.Request) error {
+	thread, err := reviewThread(r)
 	if err != nil {
-		http.Error(w, "Invalid report ID", http.StatusBadRequest)
-		return
+		return err
+	}
+	if thread.Approved == database.ThreadApproved || thread.Approved == database.ThreadRejected {
+		return apperror.BadRequest("Only threads awaiting review can be rejected")
 	}
 
-	// Example: Update report status in database or perform other actions
-	// For example purposes, let's assume we update the status in reports table
-	_, err = database.DB.Exec("UPDATE reports SET status = 'approved' WHERE id = ?", reportID)
+	reason, err := rejectionReason(r)
 	if err != nil {
-		http.Error(w, "Failed to approve report", http.StatusInternalServerError)
-		return
+		return err
 	}
 
+	if err := database.SetThreadReview(thread.ID, database.ThreadRejected, reason); err != nil {
+		return apperror.Internal(err, "Failed to reject thread")
+	}
+	if err := recordModAction(r, database.ModThreadReject, "thread", thread.ID, thread,
+		map[string]interface{}{"approved": database.ThreadRejected, "review_note": reason}, reason); err != nil {
+		return err
+	}
+	if rejectedAsSpam(r, reason) {
+		trainSpam(r, "thread", thread.ID, true, thread.Title, thread.Content)
+	}
+	notifyThreadAuthor(r, thread, fmt.Sprintf("Your thread %q was rejected: %s. You can appeal the decision.", thread.Title, reason))
+
 	http.Redirect(w, r, "/moderator/panel", http.StatusSeeOther)
+	return nil
 }
 
-func RejectReportHandler(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	reportID, err := strconv.Atoi(vars["id"])
+// RequestChangesHandler sends a pending thread back to its author with a
+// note on what to change. The author's resubmission returns it to the queue.
+func RequestChangesHandler(w http.ResponseWriter, r *http.Request) error {
+	thread, err := reviewThread(r)
 	if err != nil {
-		http.Error(w, "Invalid report ID", http.StatusBadRequest)
-		return
+		return err
+	}
+	if thread.Approved != database.ThreadPending {
+		return apperror.BadRequest("Changes can only be requested for pending threads")
 	}
 
-	// Example: Delete report from database or mark it as rejected
-	// For example purposes, let's assume we delete the report from reports table
-	_, err = database.DB.Exec("DELETE FROM reports WHERE id = ?", reportID)
-	if err != nil {
-		http.Error(w, "Failed to reject report", http.StatusInternalServerError)
-		return
+	note := strings.TrimSpace(r.FormValue("note"))
+	fields := map[string]string{}
+	validateText(fields, "note", note, maxReviewNoteLength)
+	if len(fields) > 0 {
+		return apperror.Invalid(fields)
 	}
 
+	if err := database.SetThreadReview(thread.ID, database.ThreadChangesRequested, note); err != nil {
+		return apperror.Internal(err, "Failed to update thread")
+	}
+	if err := recordModAction(r, database.ModThreadRequestChanges, "thread", thread.ID, thread,
+		map[string]interface{}{"approved": database.ThreadChangesRequested, "review_note": note}, note); err != nil {
+		return err
+	}
+	notifyThreadAuthor(r, thread, fmt.Sprintf("A moderator asked for changes to your thread %q: %s", thread.Title, note))
+
 	http.Redirect(w, r, "/moderator/panel", http.StatusSeeOther)
+	return nil
 }
 
-func ModeratorRequestHandler(w http.ResponseWriter, r *http.Request) {
+func ModeratorRequestHandler(w http.ResponseWriter, r *http.Request) error {
 	session, _ := session.Store.Get(r, "session-name")
 	if auth, ok := session.Values["authenticated"].(bool); !ok || !auth {
-		http.Error(w, "You must be logged in to request to become a moderator", http.StatusUnauthorized)
-		return
+		return apperror.Unauthorized("You must be logged in to request to become a moderator")
 	}
 
 	if r.Method == "GET" {
-		tmpl := template.Must(template.ParseFiles("templates/moderator_request.html"))
-		tmpl.Execute(w, nil)
+		return renderTemplate(w, r, moderatorRequestPage, nil)
 	} else if r.Method == "POST" {
 		reason := r.FormValue("reason")
 		userID := session.Values["userID"].(int)
 
 		_, err := database.DB.Exec("INSERT INTO moderator_requests (user_id, reason, status) VALUES (?, ?, 'pending')", userID, reason)
 		if err != nil {
-			http.Error(w, "Server error, unable to submit your request.", http.StatusInternalServerError)
-			return
+			return apperror.Internal(err, "Server error, unable to submit your request.")
 		}
 
 		http.Redirect(w, r, "/profile", http.StatusSeeOther)
 	}
+	return nil
 }
 
-func ModeratorPanelHandler(w http.ResponseWriter, r *http.Request) {
-	session, _ := session.Store.Get(r, "session-name")
-	role := session.Values["role"]
-	if role != "moderator" {
-		http.Error(w, "You must be a moderator to access this page", http.StatusForbidden)
-		return
-	}
+func ModeratorPanelHandler(w http.ResponseWriter, r *http.Request) error {
+	scope := currentModerationScope(r)
 
 	// Fetch pending threads and reports
-	pendingThreads, err := fetchPendingThreads()
+	pendingThreads, err := fetchPendingThreads(scope)
 	if err != nil {
-		http.Error(w, "Failed to fetch pending threads", http.StatusInternalServerError)
-		return
+		return apperror.Internal(err, "Failed to fetch pending threads")
 	}
 
-	pendingReports, err := fetchPendingReports()
+	pendingReports, err := fetchPendingReports(scope)
 	if err != nil {
-		http.Error(w, "Failed to fetch pending reports", http.StatusInternalServerError)
-		return
+		return apperror.Internal(err, "Failed to fetch pending reports")
 	}
 
-	tmpl := template.Must(template.ParseFiles("templates/moderator_panel.html"))
-	data := struct {
-		PendingThreads []models.Thread
-		PendingReports []models.Report
-	}{
-		PendingThreads: pendingThreads,
-		PendingReports: pendingReports,
+	filter, args := scope.threadFilter()
+	heldComments, err := database.ListHeldComments(filter, args...)
+	if err != nil {
+		return apperror.Internal(err, "Failed to fetch held comments")
 	}
-	err = tmpl.Execute(w, data)
+
+	// Moderators of some categories see theirs listed; they are also the
+	// only places their threads can be moved to.
+	categories, err := moveCategories(scope)
 	if err != nil {
-		http.Error(w, "Failed to render moderator panel", http.StatusInternalServerError)
-		log.Printf("Failed to render moderator panel: %v", err)
-		return
+		return apperror.Internal(err, "Failed to fetch categories")
 	}
+
+	data := struct {
+		PendingThreads   []models.Thread
+		PendingReports   []models.Report
+		HeldComments     []models.Comment
+		AllCategories    bool
+		Categories       []models.Category
+		RejectionReasons []string
+		CanApprove       bool
+		CanDelete        bool
+		CanSanction      bool
+		CanAppeals       bool
+	}{
+		PendingThreads:   pendingThreads,
+		PendingReports:   pendingReports,
+		HeldComments:     heldComments,
+		AllCategories:    scope.All,
+		Categories:       categories,
+		RejectionReasons: threadRejectionReasons,
+		CanApprove:       currentUserCan(r, permissions.ThreadApprove),
+		CanDelete:        currentUserCan(r, permissions.ThreadDelete),
+		CanSanction:      currentUserCan(r, permissions.UserSuspend),
+		CanAppeals:       currentUserCan(r, permissions.AppealReview),
+	}
+	return renderTemplate(w, r, moderatorPanelPage, data)
 }
 
-func fetchPendingThreads() ([]models.Thread, error) {
-	rows, err := database.DB.Query("SELECT id, category, title, content, likes, dislikes, user_id, approved FROM threads WHERE approved = 0")
+func fetchPendingThreads(scope moderationScope) ([]models.Thread, error) {
+	filter, args := scope.threadFilter()
+	rows, err := database.DB.Query("SELECT id, "+database.ThreadCategoryName+", title, content, likes, dislikes, user_id, approved, review_note, spam_score FROM threads WHERE "+database.QueuedThreads+" AND "+filter, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -156,7 +281,7 @@ func fetchPendingThreads() ([]models.Thread, error) {
 	var pendingThreads []models.Thread
 	for rows.Next() {
 		var thread models.Thread
-		err := rows.Scan(&thread.ID, &thread.Category, &thread.Title, &thread.Content, &thread.Likes, &thread.Dislikes, &thread.UserID, &thread.Approved)
+		err := rows.Scan(&thread.ID, &thread.Category, &thread.Title, &thread.Content, &thread.Likes, &thread.Dislikes, &thread.UserID, &thread.Approved, &thread.ReviewNote, &thread.SpamScore)
 		if err != nil {
 			return nil, err
 		}
@@ -166,46 +291,92 @@ func fetchPendingThreads() ([]models.Thread, error) {
 	return pendingThreads, nil
 }
 
-func fetchPendingReports() ([]models.Report, error) {
-	rows, err := database.DB.Query(`
-		SELECT 
-			reports.id, reports.thread_id, reports.user_id, reports.reason, 
-			users.username, threads.title, threads.content 
-		FROM reports 
-		INNER JOIN users ON reports.user_id = users.id 
-		INNER JOIN threads ON reports.thread_id = threads.id
-	`)
+// fetchPendingReports returns the reports in scope that still need a
+// moderator.
+func fetchPendingReports(scope moderationScope) ([]models.Report, error) {
+	return database.ListReports(database.ReportQuery{
+		Statuses:    []string{database.ReportOpen, database.ReportInReview},
+		ModeratorID: scope.moderatorID(),
+	})
+}
+
+func DeleteThreadHandler(w http.ResponseWriter, r *http.Request) error {
+	vars := mux.Vars(r)
+	threadID, err := strconv.Atoi(vars["id"])
 	if err != nil {
-		return nil, err
+		return apperror.BadRequest("Invalid thread ID")
+	}
+	if err := checkThreadInScope(r, threadID); err != nil {
+		return err
 	}
-	defer rows.Close()
 
-	var pendingReports []models.Report
-	for rows.Next() {
-		var report models.Report
-		err := rows.Scan(&report.ID, &report.ThreadID, &report.UserID, &report.Reason, &report.Username, &report.Title, &report.Content)
-		if err != nil {
-			return nil, err
-		}
-		pendingReports = append(pendingReports, report)
+	thread, err := database.GetThread(threadID)
+	if err != nil {
+		return apperror.NotFound("Thread not found")
 	}
 
-	return pendingReports, nil
+	if err := database.DeleteThread(threadID); err != nil {
+		return apperror.Internal(err, "Failed to delete thread")
+	}
+	if err := recordModAction(r, database.ModThreadDelete, "thread", threadID, thread, nil, ""); err != nil {
+		return err
+	}
+
+	http.Redirect(w, r, "/moderator/panel", http.StatusSeeOther)
+	return nil
 }
 
-func DeleteThreadHandler(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	threadID, err := strconv.Atoi(vars["id"])
+// heldComment loads a comment for the moderation handlers after checking
+// that its thread is in the moderator's categories.
+func heldComment(r *http.Request) (models.Comment, error) {
+	commentID, err := pathID(r, "id")
 	if err != nil {
-		http.Error(w, "Invalid thread ID", http.StatusBadRequest)
-		return
+		return models.Comment{}, err
 	}
+	comment, err := database.GetComment(commentID)
+	if err == sql.ErrNoRows {
+		return comment, apperror.NotFound("Comment not found")
+	}
+	if err != nil {
+		return comment, apperror.Internal(err, "Failed to fetch comment")
+	}
+	return comment, checkThreadInScope(r, comment.ThreadID)
+}
 
-	_, err = database.DB.Exec("DELETE FROM threads WHERE id = ?", threadID)
+// ApproveCommentHandler publishes a comment automoderation held or hid.
+func ApproveCommentHandler(w http.ResponseWriter, r *http.Request) error {
+	comment, err := heldComment(r)
 	if err != nil {
-		http.Error(w, "Failed to delete thread", http.StatusInternalServerError)
-		return
+		return err
+	}
+	if err := database.SetCommentState(comment.ID, database.CommentVisible); err != nil {
+		return apperror.Internal(err, "Failed to approve comment")
+	}
+	if err := recordModAction(r, database.ModCommentApprove, "comment", comment.ID, comment, nil, ""); err != nil {
+		return err
+	}
+	trainSpam(r, "comment", comment.ID, false, "", comment.Content)
+
+	http.Redirect(w, r, "/moderator/panel", http.StatusSeeOther)
+	return nil
+}
+
+// DeleteCommentHandler removes a comment from the held queue.
+func DeleteCommentHandler(w http.ResponseWriter, r *http.Request) error {
+	comment, err := heldComment(r)
+	if err != nil {
+		return err
+	}
+	if err := database.DeleteComment(comment.ID); err != nil {
+		return apperror.Internal(err, "Failed to delete comment")
+	}
+	if err := recordModAction(r, database.ModCommentDelete, "comment", comment.ID, comment, nil, ""); err != nil {
+		return err
+	}
+	if comment.Approved != database.CommentVisible {
+		trainSpam(r, "comment", comment.ID, true, "", comment.Content)
 	}
 
 	http.Redirect(w, r, "/moderator/panel", http.StatusSeeOther)
+	return nil
 }
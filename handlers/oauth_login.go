@@ -1,18 +1,18 @@
 package handlers
 
 import (
+	"database/sql"
 	"encoding/gob"
 	"encoding/json"
 	"fmt"
-	"log"
 	"net/http"
-	"os"
 
+	"DytForum/apperror"
+	"DytForum/config"
 	"DytForum/database"
 	"DytForum/models"
 	"DytForum/session"
 
-	"github.com/joho/godotenv"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/facebook"
 	"golang.org/x/oauth2/github"
@@ -25,34 +25,29 @@ var (
 	facebookOauthConfig *oauth2.Config
 )
 
-func GoogleLogin(w http.ResponseWriter, r *http.Request) {
+func GoogleLogin(w http.ResponseWriter, r *http.Request) error {
 	url := googleOauthConfig.AuthCodeURL("state-token", oauth2.AccessTypeOffline)
 	http.Redirect(w, r, url, http.StatusTemporaryRedirect)
+	return nil
 }
 
-func GoogleCallback(w http.ResponseWriter, r *http.Request) {
+func GoogleCallback(w http.ResponseWriter, r *http.Request) error {
 	code := r.URL.Query().Get("code")
 	token, err := googleOauthConfig.Exchange(r.Context(), code)
 	if err != nil {
-		http.Error(w, "Failed to exchange Google token", http.StatusInternalServerError)
-		log.Printf("Google token exchange error: %v", err)
-		return
+		return apperror.Internal(err, "Failed to exchange Google token")
 	}
 
 	client := googleOauthConfig.Client(r.Context(), token)
 	resp, err := client.Get("https://www.googleapis.com/oauth2/v2/userinfo")
 	if err != nil {
-		http.Error(w, "Failed to get Google user info", http.StatusInternalServerError)
-		log.Printf("Google user info error: %v", err)
-		return
+		return apperror.Internal(err, "Failed to get Google user info")
 	}
 	defer resp.Body.Close()
 
 	var googleUserInfo map[string]interface{}
 	if err := json.NewDecoder(resp.Body).Decode(&googleUserInfo); err != nil {
-		http.Error(w, "Failed to decode Google user info", http.StatusInternalServerError)
-		log.Printf("Google user info decode error: %v", err)
-		return
+		return apperror.Internal(err, "Failed to decode Google user info")
 	}
 
 	user := models.GoogleUserInfo{
@@ -60,38 +55,36 @@ func GoogleCallback(w http.ResponseWriter, r *http.Request) {
 		Email: googleUserInfo["email"].(string),
 	}
 
+	if err := checkOAuthRegistrationBan(r, user.Email); err != nil {
+		return err
+	}
+
 	session, err := session.Store.Get(r, "session-name")
 	if err != nil {
-		http.Error(w, "Failed to get session", http.StatusInternalServerError)
-		log.Printf("Session error: %v", err)
-		return
+		return apperror.Internal(err, "Failed to get session")
 	}
 	session.Values["username"] = user.Name
 	session.Values["authenticated"] = true
 	if err := session.Save(r, w); err != nil {
-		http.Error(w, "Failed to save session", http.StatusInternalServerError)
-		log.Printf("Session save error: %v", err)
-		return
+		return apperror.Internal(err, "Failed to save session")
 	}
 
 	// Kullanıcıyı veritabanında kontrol et ve ekle/güncelle
 	_, err = database.DB.Exec(`INSERT INTO users (username, email, password) VALUES (?, ?, 'oauth')
                                ON CONFLICT(email) DO UPDATE SET username=excluded.username`, user.Name, user.Email)
 	if err != nil {
-		http.Error(w, "Failed to insert/update user", http.StatusInternalServerError)
-		log.Printf("Database error: %v", err)
-		return
+		return apperror.Internal(err, "Failed to insert/update user")
 	}
 
+	loginsTotal.Inc("google")
 	http.Redirect(w, r, "/index", http.StatusSeeOther)
+	return nil
 }
 
-func Profile(w http.ResponseWriter, r *http.Request) {
+func Profile(w http.ResponseWriter, r *http.Request) error {
 	session, err := session.Store.Get(r, "session-name")
 	if err != nil {
-		http.Error(w, "Failed to get session", http.StatusInternalServerError)
-		log.Printf("Session error: %v", err)
-		return
+		return apperror.Internal(err, "Failed to get session")
 	}
 
 	// Check for Google access token in session
@@ -104,72 +97,61 @@ func Profile(w http.ResponseWriter, r *http.Request) {
 	if googleOK {
 		// Use googleAccessToken to fetch user profile data from Google APIs if needed
 		fmt.Fprintf(w, "Google Profile Page\nAccess Token: %s", googleAccessToken)
-		return
+		return nil
 	}
 
 	// Example: Using GitHub access token
 	if githubOK {
 		// Use githubAccessToken to fetch user profile data from GitHub APIs if needed
 		fmt.Fprintf(w, "GitHub Profile Page\nAccess Token: %s", githubAccessToken)
-		return
+		return nil
 	}
 
-	http.Error(w, "Access token not found in session", http.StatusInternalServerError)
-	log.Println("Access token not found in session")
+	return apperror.New(http.StatusInternalServerError, "Access token not found in session")
 }
 
-func ProtectedEndpoint(w http.ResponseWriter, r *http.Request) {
+func ProtectedEndpoint(w http.ResponseWriter, r *http.Request) error {
 	session, err := session.Store.Get(r, "session-name")
 	if err != nil {
-		http.Error(w, "Failed to get session", http.StatusInternalServerError)
-		log.Printf("Error getting session: %v", err) // Log the error
-		return
+		return apperror.Internal(err, "Failed to get session")
 	}
 
 	accessToken, ok := session.Values["accessToken"].(string)
 	if !ok {
-		http.Error(w, "Access token not found in session", http.StatusInternalServerError)
-		log.Println("Access token not found in session") // Log the error
-		return
+		return apperror.New(http.StatusInternalServerError, "Access token not found in session")
 	}
 
 	// Use accessToken to make authenticated requests or perform actions
 	fmt.Fprintf(w, "Access Token: %s", accessToken)
+	return nil
 }
 
-func GitHubLogin(w http.ResponseWriter, r *http.Request) {
+func GitHubLogin(w http.ResponseWriter, r *http.Request) error {
 	url := githubOauthConfig.AuthCodeURL("state-token")
 	http.Redirect(w, r, url, http.StatusTemporaryRedirect)
+	return nil
 }
 
-func GitHubCallback(w http.ResponseWriter, r *http.Request) {
+func GitHubCallback(w http.ResponseWriter, r *http.Request) error {
 	code := r.URL.Query().Get("code")
 	if code == "" {
-		http.Error(w, "Code not found", http.StatusBadRequest)
-		log.Println("Code not found in URL")
-		return
+		return apperror.BadRequest("Code not found")
 	}
 	token, err := githubOauthConfig.Exchange(r.Context(), code)
 	if err != nil {
-		http.Error(w, "Failed to exchange GitHub token", http.StatusInternalServerError)
-		log.Printf("GitHub token exchange error: %v", err)
-		return
+		return apperror.Internal(err, "Failed to exchange GitHub token")
 	}
 
 	client := githubOauthConfig.Client(r.Context(), token)
 	resp, err := client.Get("https://api.github.com/user")
 	if err != nil {
-		http.Error(w, "Failed to get GitHub user info", http.StatusInternalServerError)
-		log.Printf("GitHub user info error: %v", err)
-		return
+		return apperror.Internal(err, "Failed to get GitHub user info")
 	}
 	defer resp.Body.Close()
 
 	var githubUserInfo map[string]interface{}
 	if err := json.NewDecoder(resp.Body).Decode(&githubUserInfo); err != nil {
-		http.Error(w, "Failed to decode GitHub user info", http.StatusInternalServerError)
-		log.Printf("GitHub user info decode error: %v", err)
-		return
+		return apperror.Internal(err, "Failed to decode GitHub user info")
 	}
 
 	user := models.GitHubUserInfo{
@@ -179,60 +161,56 @@ func GitHubCallback(w http.ResponseWriter, r *http.Request) {
 	if email, ok := githubUserInfo["email"].(string); ok {
 		user.Email = email
 	}
+
+	if err := checkOAuthRegistrationBan(r, user.Email); err != nil {
+		return err
+	}
+
 	session, err := session.Store.Get(r, "session-name")
 	if err != nil {
-		http.Error(w, "Failed to get session", http.StatusInternalServerError)
-		log.Printf("Session error: %v", err)
-		return
+		return apperror.Internal(err, "Failed to get session")
 	}
 	session.Values["username"] = user.Login
 	session.Values["authenticated"] = true
 	if err := session.Save(r, w); err != nil {
-		http.Error(w, "Failed to save session", http.StatusInternalServerError)
-		log.Printf("Session save error: %v", err)
-		return
+		return apperror.Internal(err, "Failed to save session")
 	}
 
 	// Kullanıcıyı veritabanında kontrol et ve ekle/güncelle
 	_, err = database.DB.Exec(`INSERT INTO users (username, email, password) VALUES (?, ?, 'oauth')
                                ON CONFLICT(email) DO UPDATE SET username=excluded.username`, user.Login, user.Email)
 	if err != nil {
-		http.Error(w, "Failed to insert/update user", http.StatusInternalServerError)
-		log.Printf("Database error: %v", err)
-		return
+		return apperror.Internal(err, "Failed to insert/update user")
 	}
 
+	loginsTotal.Inc("github")
 	http.Redirect(w, r, "/index", http.StatusSeeOther)
+	return nil
 }
 
-func FacebookLogin(w http.ResponseWriter, r *http.Request) {
+func FacebookLogin(w http.ResponseWriter, r *http.Request) error {
 	url := facebookOauthConfig.AuthCodeURL("")
 	http.Redirect(w, r, url, http.StatusTemporaryRedirect)
+	return nil
 }
 
-func FacebookCallback(w http.ResponseWriter, r *http.Request) {
+func FacebookCallback(w http.ResponseWriter, r *http.Request) error {
 	code := r.URL.Query().Get("code")
 	token, err := facebookOauthConfig.Exchange(r.Context(), code)
 	if err != nil {
-		http.Error(w, "Failed to exchange Facebook token", http.StatusInternalServerError)
-		log.Printf("Facebook token exchange error: %v", err)
-		return
+		return apperror.Internal(err, "Failed to exchange Facebook token")
 	}
 
 	client := facebookOauthConfig.Client(r.Context(), token)
 	resp, err := client.Get("https://graph.facebook.com/me?fields=id,name,email")
 	if err != nil {
-		http.Error(w, "Failed to get Facebook user info", http.StatusInternalServerError)
-		log.Printf("Facebook user info error: %v", err)
-		return
+		return apperror.Internal(err, "Failed to get Facebook user info")
 	}
 	defer resp.Body.Close()
 
 	var facebookUserInfo map[string]interface{}
 	if err := json.NewDecoder(resp.Body).Decode(&facebookUserInfo); err != nil {
-		http.Error(w, "Failed to decode Facebook user info", http.StatusInternalServerError)
-		log.Printf("Facebook user info decode error: %v", err)
-		return
+		return apperror.Internal(err, "Failed to decode Facebook user info")
 	}
 
 	user := models.FacebookUserInfo{
@@ -240,64 +218,72 @@ func FacebookCallback(w http.ResponseWriter, r *http.Request) {
 		Email: facebookUserInfo["email"].(string),
 	}
 
+	if err := checkOAuthRegistrationBan(r, user.Email); err != nil {
+		return err
+	}
+
 	session, err := session.Store.Get(r, "session-name")
 	if err != nil {
-		http.Error(w, "Failed to get session", http.StatusInternalServerError)
-		log.Printf("Session error: %v", err)
-		return
+		return apperror.Internal(err, "Failed to get session")
 	}
 	session.Values["username"] = user.Name
 	session.Values["authenticated"] = true
 	if err := session.Save(r, w); err != nil {
-		http.Error(w, "Failed to save session", http.StatusInternalServerError)
-		log.Printf("Session save error: %v", err)
-		return
+		return apperror.Internal(err, "Failed to save session")
 	}
 
 	// Kullanıcıyı veritabanında kontrol et ve ekle/güncelle
 	_, err = database.DB.Exec(`INSERT INTO users (username, email, password) VALUES (?, ?, 'oauth')
                                ON CONFLICT(email) DO UPDATE SET username=excluded.username`, user.Name, user.Email)
 	if err != nil {
-		http.Error(w, "Failed to insert/update user", http.StatusInternalServerError)
-		log.Printf("Database error: %v", err)
-		return
+		return apperror.Internal(err, "Failed to insert/update user")
 	}
 
+	loginsTotal.Inc("facebook")
 	http.Redirect(w, r, "/index", http.StatusSeeOther)
+	return nil
+}
+
+// checkOAuthRegistrationBan applies the registration bans when an OAuth login
+// is about to create a new account; existing accounts can still sign in.
+func checkOAuthRegistrationBan(r *http.Request, email string) error {
+	var id int
+	err := database.DB.QueryRow("SELECT id FROM users WHERE email = ?", email).Scan(&id)
+	if err == nil {
+		return nil
+	}
+	if err != sql.ErrNoRows {
+		return apperror.Internal(err, "Failed to look up user")
+	}
+	return checkRegistrationBan(r, email)
 }
 
 func init() {
 	gob.Register(models.User{})
+}
 
-	err := godotenv.Load()
-	if err != nil {
-		log.Fatalf("Error loading .env file %v", err)
-	}
-
+// ConfigureOAuth sets up the OAuth providers. Redirect URLs are built from
+// the public base URL of the forum.
+func ConfigureOAuth(cfg config.Config) {
 	googleOauthConfig = &oauth2.Config{
-		RedirectURL:  "http://localhost:8080/auth/google/callback",
-		ClientID:     os.Getenv("GOOGLE_CLIENT_ID"),
-		ClientSecret: os.Getenv("GOOGLE_CLIENT_SECRET"),
+		RedirectURL:  cfg.URL("/auth/google/callback"),
+		ClientID:     cfg.GoogleClientID,
+		ClientSecret: cfg.GoogleClientSecret,
 		Scopes:       []string{"profile", "email"},
 		Endpoint:     google.Endpoint,
 	}
 	githubOauthConfig = &oauth2.Config{
-		RedirectURL:  "http://localhost:8080/auth/github/callback",
-		ClientID:     os.Getenv("GITHUB_CLIENT_ID"),
-		ClientSecret: os.Getenv("GITHUB_CLIENT_SECRET"),
+		RedirectURL:  cfg.URL("/auth/github/callback"),
+		ClientID:     cfg.GitHubClientID,
+		ClientSecret: cfg.GitHubClientSecret,
 		Scopes:       []string{"user:email"},
 		Endpoint:     github.Endpoint,
 	}
 	facebookOauthConfig = &oauth2.Config{
-		ClientID:     os.Getenv("FACEBOOK_KEY"),
-		ClientSecret: os.Getenv("FACEBOOK_SECRET"),
-		RedirectURL:  "http://localhost:8080/auth/facebook/callback",
+		ClientID:     cfg.FacebookKey,
+		ClientSecret: cfg.FacebookSecret,
+		RedirectURL:  cfg.URL("/auth/facebook/callback"),
 		Endpoint:     facebook.Endpoint,
 		Scopes:       []string{"email"},
 	}
-	// SESSION_SECRET'ın doğru yüklendiğini kontrol edelim
-	sessionSecret := os.Getenv("SESSION_SECRET")
-	if sessionSecret == "" {
-		log.Fatal("SESSION_SECRET is not set")
-	}
 }
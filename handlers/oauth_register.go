@@ -2,37 +2,31 @@ package handlers
 
 import (
 	"encoding/json"
-	"log"
 	"net/http"
 
+	"DytForum/apperror"
 	"DytForum/database"
 	"DytForum/models"
 	"DytForum/session"
 )
 
-func GoogleRegisterCallback(w http.ResponseWriter, r *http.Request) {
+func GoogleRegisterCallback(w http.ResponseWriter, r *http.Request) error {
 	code := r.URL.Query().Get("code")
 	token, err := googleOauthConfig.Exchange(r.Context(), code)
 	if err != nil {
-		http.Error(w, "Failed to exchange Google token", http.StatusInternalServerError)
-		log.Printf("Google token exchange error: %v", err)
-		return
+		return apperror.Internal(err, "Failed to exchange Google token")
 	}
 
 	client := googleOauthConfig.Client(r.Context(), token)
 	resp, err := client.Get("https://www.googleapis.com/oauth2/v2/userinfo")
 	if err != nil {
-		http.Error(w, "Failed to get Google user info", http.StatusInternalServerError)
-		log.Printf("Google user info error: %v", err)
-		return
+		return apperror.Internal(err, "Failed to get Google user info")
 	}
 	defer resp.Body.Close()
 
 	var googleUserInfo map[string]interface{}
 	if err := json.NewDecoder(resp.Body).Decode(&googleUserInfo); err != nil {
-		http.Error(w, "Failed to decode Google user info", http.StatusInternalServerError)
-		log.Printf("Google user info decode error: %v", err)
-		return
+		return apperror.Internal(err, "Failed to decode Google user info")
 	}
 
 	// Google'dan gelen kullanıcı bilgilerini al
@@ -45,59 +39,51 @@ func GoogleRegisterCallback(w http.ResponseWriter, r *http.Request) {
 	var existingEmail string
 	err = database.DB.QueryRow("SELECT email FROM users WHERE email = ?", user.Email).Scan(&existingEmail)
 	if err == nil {
-		http.Error(w, "Email address already in use", http.StatusBadRequest)
-		return
+		return apperror.BadRequest("Email address already in use")
+	}
+	if err := checkRegistrationBan(r, user.Email); err != nil {
+		return err
 	}
 
 	// Kullanıcıyı veritabanına kaydet
 	_, err = database.DB.Exec("INSERT INTO users (email, username, password) VALUES (?, ?, 'oauth')", user.Email, user.Name)
 	if err != nil {
-		http.Error(w, "Failed to insert user", http.StatusInternalServerError)
-		log.Printf("Database error: %v", err)
-		return
+		return apperror.Internal(err, "Failed to insert user")
 	}
 
 	// Oturumu başlat ve kullanıcıyı yönlendir
 	session, err := session.Store.Get(r, "session-name")
 	if err != nil {
-		http.Error(w, "Failed to get session", http.StatusInternalServerError)
-		log.Printf("Session error: %v", err)
-		return
+		return apperror.Internal(err, "Failed to get session")
 	}
 	session.Values["username"] = user.Name
 	session.Values["authenticated"] = true
 	if err := session.Save(r, w); err != nil {
-		http.Error(w, "Failed to save session", http.StatusInternalServerError)
-		log.Printf("Session save error: %v", err)
-		return
+		return apperror.Internal(err, "Failed to save session")
 	}
 
+	registrationsTotal.Inc("google")
 	http.Redirect(w, r, "/index", http.StatusSeeOther)
+	return nil
 }
 
-func GitHubRegisterCallback(w http.ResponseWriter, r *http.Request) {
+func GitHubRegisterCallback(w http.ResponseWriter, r *http.Request) error {
 	code := r.URL.Query().Get("code")
 	token, err := githubOauthConfig.Exchange(r.Context(), code)
 	if err != nil {
-		http.Error(w, "Failed to exchange GitHub token", http.StatusInternalServerError)
-		log.Printf("GitHub token exchange error: %v", err)
-		return
+		return apperror.Internal(err, "Failed to exchange GitHub token")
 	}
 
 	client := githubOauthConfig.Client(r.Context(), token)
 	resp, err := client.Get("https://api.github.com/user")
 	if err != nil {
-		http.Error(w, "Failed to get GitHub user info", http.StatusInternalServerError)
-		log.Printf("GitHub user info error: %v", err)
-		return
+		return apperror.Internal(err, "Failed to get GitHub user info")
 	}
 	defer resp.Body.Close()
 
 	var githubUserInfo map[string]interface{}
 	if err := json.NewDecoder(resp.Body).Decode(&githubUserInfo); err != nil {
-		http.Error(w, "Failed to decode GitHub user info", http.StatusInternalServerError)
-		log.Printf("GitHub user info decode error: %v", err)
-		return
+		return apperror.Internal(err, "Failed to decode GitHub user info")
 	}
 
 	// GitHub'dan gelen kullanıcı bilgilerini al
@@ -113,59 +99,51 @@ func GitHubRegisterCallback(w http.ResponseWriter, r *http.Request) {
 	var existingEmail string
 	err = database.DB.QueryRow("SELECT email FROM users WHERE email = ?", user.Email).Scan(&existingEmail)
 	if err == nil {
-		http.Error(w, "Email address already in use", http.StatusBadRequest)
-		return
+		return apperror.BadRequest("Email address already in use")
+	}
+	if err := checkRegistrationBan(r, user.Email); err != nil {
+		return err
 	}
 
 	// Kullanıcıyı veritabanına kaydet
 	_, err = database.DB.Exec("INSERT INTO users (email, username, password) VALUES (?, ?, 'oauth')", user.Email, user.Login)
 	if err != nil {
-		http.Error(w, "Failed to insert user", http.StatusInternalServerError)
-		log.Printf("Database error: %v", err)
-		return
+		return apperror.Internal(err, "Failed to insert user")
 	}
 
 	// Oturumu başlat ve kullanıcıyı yönlendir
 	session, err := session.Store.Get(r, "session-name")
 	if err != nil {
-		http.Error(w, "Failed to get session", http.StatusInternalServerError)
-		log.Printf("Session error: %v", err)
-		return
+		return apperror.Internal(err, "Failed to get session")
 	}
 	session.Values["username"] = user.Login
 	session.Values["authenticated"] = true
 	if err := session.Save(r, w); err != nil {
-		http.Error(w, "Failed to save session", http.StatusInternalServerError)
-		log.Printf("Session save error: %v", err)
-		return
+		return apperror.Internal(err, "Failed to save session")
 	}
 
+	registrationsTotal.Inc("github")
 	http.Redirect(w, r, "/index", http.StatusSeeOther)
+	return nil
 }
 
-func FacebookRegisterCallback(w http.ResponseWriter, r *http.Request) {
+func FacebookRegisterCallback(w http.ResponseWriter, r *http.Request) error {
 	code := r.URL.Query().Get("code")
 	token, err := facebookOauthConfig.Exchange(r.Context(), code)
 	if err != nil {
-		http.Error(w, "Failed to exchange Facebook token", http.StatusInternalServerError)
-		log.Printf("Facebook token exchange error: %v", err)
-		return
+		return apperror.Internal(err, "Failed to exchange Facebook token")
 	}
 
 	client := facebookOauthConfig.Client(r.Context(), token)
 	resp, err := client.Get("https://graph.facebook.com/me?fields=id,name,email")
 	if err != nil {
-		http.Error(w, "Failed to get Facebook user info", http.StatusInternalServerError)
-		log.Printf("Facebook user info error: %v", err)
-		return
+		return apperror.Internal(err, "Failed to get Facebook user info")
 	}
 	defer resp.Body.Close()
 
 	var facebookUserInfo map[string]interface{}
 	if err := json.NewDecoder(resp.Body).Decode(&facebookUserInfo); err != nil {
-		http.Error(w, "Failed to decode Facebook user info", http.StatusInternalServerError)
-		log.Printf("Facebook user info decode error: %v", err)
-		return
+		return apperror.Internal(err, "Failed to decode Facebook user info")
 	}
 
 	// Facebook'tan gelen kullanıcı bilgilerini al
@@ -178,32 +156,30 @@ func FacebookRegisterCallback(w http.ResponseWriter, r *http.Request) {
 	var existingEmail string
 	err = database.DB.QueryRow("SELECT email FROM users WHERE email = ?", user.Email).Scan(&existingEmail)
 	if err == nil {
-		http.Error(w, "Email address already in use", http.StatusBadRequest)
-		return
+		return apperror.BadRequest("Email address already in use")
+	}
+	if err := checkRegistrationBan(r, user.Email); err != nil {
+		return err
 	}
 
 	// Kullanıcıyı veritabanına kaydet
 	_, err = database.DB.Exec("INSERT INTO users (email, username, password) VALUES (?, ?, 'oauth')", user.Email, user.Name)
 	if err != nil {
-		http.Error(w, "Failed to insert user", http.StatusInternalServerError)
-		log.Printf("Database error: %v", err)
-		return
+		return apperror.Internal(err, "Failed to insert user")
 	}
 
 	// Oturumu başlat ve kullanıcıyı yönlendir
 	session, err := session.Store.Get(r, "session-name")
 	if err != nil {
-		http.Error(w, "Failed to get session", http.StatusInternalServerError)
-		log.Printf("Session error: %v", err)
-		return
+		return apperror.Internal(err, "Failed to get session")
 	}
 	session.Values["username"] = user.Name
 	session.Values["authenticated"] = true
 	if err := session.Save(r, w); err != nil {
-		http.Error(w, "Failed to save session", http.StatusInternalServerError)
-		log.Printf("Session save error: %v", err)
-		return
+		return apperror.Internal(err, "Failed to save session")
 	}
 
+	registrationsTotal.Inc("facebook")
 	http.Redirect(w, r, "/index", http.StatusSeeOther)
+	return nil
 }
@@ -0,0 +1,195 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+
+	"DytForum/database"
+	"DytForum/models"
+	"DytForum/openapi"
+)
+
+// apiErrorBody is the error envelope apperror writes for JSON clients.
+type apiErrorBody struct {
+	Error struct {
+		Status  int               `json:"status"`
+		Message string            `json:"message"`
+		Fields  map[string]string `json:"fields,omitempty"`
+	} `json:"error"`
+}
+
+var (
+	apiDocOnce sync.Once
+	apiDoc     *openapi.Document
+)
+
+// APIDocument describes every /api/v1 route. Paths are relative to the
+// /api/v1 server URL and use plain {id} parameters.
+func APIDocument() *openapi.Document {
+	apiDocOnce.Do(func() { apiDoc = buildAPIDocument() })
+	return apiDoc
+}
+
+func buildAPIDocument() *openapi.Document {
+	doc := openapi.New(openapi.Info{
+		Title:       "DytForum API",
+		Version:     "1",
+		Description: "JSON API of the forum. Authenticate with the session cookie or a personal API token.",
+	})
+	doc.Servers = []openapi.Server{{URL: "/api/v1"}}
+	doc.Components.SecuritySchemes = map[string]openapi.SecurityScheme{
+		"session": {Type: "apiKey", In: "cookie", Name: "session-name"},
+		"token":   {Type: "http", Scheme: "bearer"},
+	}
+
+	thread := doc.Ref("Thread", models.Thread{})
+	comment := doc.Ref("Comment", models.Comment{})
+	category := doc.Ref("Category", models.Category{})
+	report := doc.Ref("Report", models.Report{})
+	doc.Components.Schemas["Report"].Properties["status"].Enum = database.ReportStatuses
+	doc.Components.Schemas["Report"].Properties["target_type"].Enum = database.ReportTargets
+	reason := doc.Ref("ReportReason", models.ReportReason{})
+	me := doc.Ref("Me", apiMe{})
+	errorBody := doc.Ref("Error", apiErrorBody{})
+	threadIn := doc.Ref("ThreadInput", threadInput{})
+	threadPatch := doc.Ref("ThreadUpdate", threadUpdate{})
+	commentIn := doc.Ref("CommentInput", commentInput{})
+	reactionIn := doc.Ref("ReactionInput", reactionInput{})
+	doc.Components.Schemas["ReactionInput"].Properties["reaction"].Enum = []string{"like", "dislike", "none"}
+	reportIn := doc.Ref("ReportInput", reportInput{})
+	doc.Components.Schemas["ReportInput"].Properties["target_type"].Enum = database.ReportTargets
+
+	item := func(s *openapi.Schema) *openapi.Schema {
+		return &openapi.Schema{Type: "object", Properties: map[string]*openapi.Schema{"data": s}}
+	}
+	list := func(s *openapi.Schema) *openapi.Schema {
+		return &openapi.Schema{Type: "object", Properties: map[string]*openapi.Schema{
+			"data":        {Type: "array", Items: s},
+			"next_cursor": {Type: "string"},
+		}}
+	}
+	body := func(s *openapi.Schema) *openapi.RequestBody {
+		return &openapi.RequestBody{Required: true, Content: map[string]openapi.MediaType{"application/json": {Schema: s}}}
+	}
+	errorDescriptions := map[int]string{
+		400: "Malformed request",
+		401: "Not logged in or invalid token",
+		403: "Missing permission or token scope",
+		404: "Not found",
+		415: "Body is not JSON",
+		422: "Validation failed",
+		429: "Rate limited; retry after the number of seconds in Retry-After",
+	}
+	responses := func(status int, description string, s *openapi.Schema, errors ...int) map[string]openapi.Response {
+		rs := map[string]openapi.Response{
+			strconv.Itoa(status): {Description: description, Content: map[string]openapi.MediaType{"application/json": {Schema: s}}},
+		}
+		for _, code := range errors {
+			rs[strconv.Itoa(code)] = openapi.Response{
+				Description: errorDescriptions[code],
+				Content:     map[string]openapi.MediaType{"application/json": {Schema: errorBody}},
+			}
+		}
+		return rs
+	}
+	auth := []map[string][]string{{"session": {}}, {"token": {}}}
+	idParam := openapi.Parameter{Name: "id", In: "path", Required: true, Schema: &openapi.Schema{Type: "integer"}}
+	pageParams := []openapi.Parameter{
+		{Name: "limit", In: "query", Description: "Items per page, 1 to 100, default 20", Schema: &openapi.Schema{Type: "integer"}},
+		{Name: "cursor", In: "query", Description: "next_cursor of the previous page", Schema: &openapi.Schema{Type: "string"}},
+	}
+
+	doc.Add("GET", "/me", openapi.Operation{
+		Summary: "Current user and the permissions of their role", Tags: []string{"users"}, Security: auth,
+		Responses: responses(200, "The current user", item(me), 401),
+	})
+	doc.Add("GET", "/categories", openapi.Operation{
+		Summary: "List categories", Tags: []string{"categories"},
+		Responses: responses(200, "Every category", list(category)),
+	})
+	doc.Add("GET", "/threads", openapi.Operation{
+		Summary: "List approved threads, newest first", Tags: []string{"threads"},
+		Description: "Logged in users also see their own pending threads.",
+		Parameters: append([]openapi.Parameter{
+			{Name: "category_id", In: "query", Schema: &openapi.Schema{Type: "integer"}},
+		}, pageParams...),
+		Responses: responses(200, "A page of threads", list(thread), 422),
+	})
+	doc.Add("POST", "/threads", openapi.Operation{
+		Summary: "Create a thread", Tags: []string{"threads"}, Security: auth,
+		Description: "New threads wait for moderator approval. Needs thread.create and the write scope.",
+		RequestBody: body(threadIn),
+		Responses:   responses(201, "The new thread", item(thread), 400, 401, 403, 415, 422, 429),
+	})
+	doc.Add("GET", "/threads/{id}", openapi.Operation{
+		Summary: "Get a thread", Tags: []string{"threads"},
+		Parameters: []openapi.Parameter{idParam},
+		Responses:  responses(200, "The thread", item(thread), 404),
+	})
+	doc.Add("PATCH", "/threads/{id}", openapi.Operation{
+		Summary: "Edit your thread", Tags: []string{"threads"}, Security: auth,
+		Description: "Only the author may edit. The edited thread goes back to the moderation queue.",
+		Parameters:  []openapi.Parameter{idParam},
+		RequestBody: body(threadPatch),
+		Responses:   responses(200, "The updated thread", item(thread), 400, 401, 403, 404, 415, 422),
+	})
+	doc.Add("GET", "/threads/{id}/comments", openapi.Operation{
+		Summary: "List the comments of a thread, oldest first", Tags: []string{"comments"},
+		Parameters: append([]openapi.Parameter{idParam}, pageParams...),
+		Responses:  responses(200, "A page of comments", list(comment), 404, 422),
+	})
+	doc.Add("POST", "/threads/{id}/comments", openapi.Operation{
+		Summary: "Comment on a thread", Tags: []string{"comments"}, Security: auth,
+		Parameters:  []openapi.Parameter{idParam},
+		RequestBody: body(commentIn),
+		Responses:   responses(201, "The new comment", item(comment), 400, 401, 403, 404, 415, 422, 429),
+	})
+	doc.Add("PUT", "/threads/{id}/reaction", openapi.Operation{
+		Summary: "Like, dislike or clear your reaction to a thread", Tags: []string{"reactions"}, Security: auth,
+		Parameters:  []openapi.Parameter{idParam},
+		RequestBody: body(reactionIn),
+		Responses:   responses(200, "The thread with updated counts", item(thread), 400, 401, 403, 404, 415, 422, 429),
+	})
+	doc.Add("PATCH", "/comments/{id}", openapi.Operation{
+		Summary: "Edit your comment", Tags: []string{"comments"}, Security: auth,
+		Description: "The new content goes through automoderation and the spam filter like a new comment: " +
+			"an edit the rules reject is refused with 403, and a held or hidden comment stays so.",
+		Parameters:  []openapi.Parameter{idParam},
+		RequestBody: body(commentIn),
+		Responses:   responses(200, "The updated comment", item(comment), 400, 401, 403, 404, 415, 422),
+	})
+	doc.Add("PUT", "/comments/{id}/reaction", openapi.Operation{
+		Summary: "Like, dislike or clear your reaction to a comment", Tags: []string{"reactions"}, Security: auth,
+		Parameters:  []openapi.Parameter{idParam},
+		RequestBody: body(reactionIn),
+		Responses:   responses(200, "The comment with updated counts", item(comment), 400, 401, 403, 404, 415, 422, 429),
+	})
+	doc.Add("POST", "/reports", openapi.Operation{
+		Summary: "Report a thread, comment or user to the moderators", Tags: []string{"reports"}, Security: auth,
+		Description: "reason must be one of GET /report-reasons. thread_id is an older shorthand for target_type thread. " +
+			"A report about content that is already reported is attached to the open report; reporting the same content twice returns your earlier report.",
+		RequestBody: body(reportIn),
+		Responses:   responses(201, "The new report", item(report), 400, 401, 403, 404, 415, 422, 429),
+	})
+	doc.Add("GET", "/reports", openapi.Operation{
+		Summary: "List reports in the categories you moderate", Tags: []string{"reports"}, Security: auth,
+		Description: "Needs report.view and, for tokens, the moderate scope.",
+		Responses:   responses(200, "Open and in-review reports, oldest first", list(report), 401, 403),
+	})
+	doc.Add("GET", "/report-reasons", openapi.Operation{
+		Summary: "List the reasons a report can give", Tags: []string{"reports"},
+		Responses: responses(200, "Every report reason", list(reason)),
+	})
+	return doc
+}
+
+// APIOpenAPIHandler serves the OpenAPI document.
+func APIOpenAPIHandler(w http.ResponseWriter, r *http.Request) error {
+	return writeJSON(w, http.StatusOK, APIDocument())
+}
+
+// APIDocsHandler shows the interactive API documentation.
+func APIDocsHandler(w http.ResponseWriter, r *http.Request) error {
+	return renderTemplate(w, r, apiDocsPage, nil)
+}
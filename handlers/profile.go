@@ -1,27 +1,25 @@
 package handlers
 
 import (
-	"html/template"
-	"log"
 	"net/http"
 
+	"DytForum/apperror"
 	"DytForum/database"
+	"DytForum/logging"
 	"DytForum/models"
+	"DytForum/permissions"
 	"DytForum/session"
 )
 
-func ProfileHandler(w http.ResponseWriter, r *http.Request) {
+func ProfileHandler(w http.ResponseWriter, r *http.Request) error {
 	session, err := session.Store.Get(r, "session-name")
 	if err != nil {
-		log.Printf("Failed to get session: %v", err)
-		http.Error(w, "Failed to get session", http.StatusInternalServerError)
-		return
+		return apperror.Internal(err, "Failed to get session")
 	}
 
 	auth, ok := session.Values["authenticated"].(bool)
 	if !ok || !auth {
-		http.Error(w, "You must be logged in to access this page", http.StatusUnauthorized)
-		return
+		return apperror.Unauthorized("You must be logged in to access this page")
 	}
 
 	username := session.Values["username"].(string)
@@ -29,51 +27,47 @@ func ProfileHandler(w http.ResponseWriter, r *http.Request) {
 	// Kullanıcının veritabanındaki bilgilerini al
 	user, err := database.GetUserByUsername(username)
 	if err != nil {
-		log.Printf("Failed to retrieve user: %v", err)
-		http.Error(w, "Server error, unable to retrieve your profile", http.StatusInternalServerError)
-		return
+		return apperror.Internal(err, "Server error, unable to retrieve your profile")
 	}
 
 	// Kullanıcının thread'lerini ve yorumlarını al
 	threads, err := database.GetThreadsByUserID(user.ID)
 	if err != nil {
-		log.Printf("Failed to retrieve threads: %v", err)
-		http.Error(w, "Server error, unable to retrieve your threads", http.StatusInternalServerError)
-		return
+		return apperror.Internal(err, "Server error, unable to retrieve your threads")
 	}
 
 	comments, err := database.GetCommentsByUserID(user.ID)
 	if err != nil {
-		log.Printf("Failed to retrieve comments: %v", err)
-		http.Error(w, "Server error, unable to retrieve your comments", http.StatusInternalServerError)
-		return
+		return apperror.Internal(err, "Server error, unable to retrieve your comments")
 	}
 
-	data := struct {
-		Username string
-		Email    string
-		Role     string
-		Threads  []models.Thread
-		Comments []models.Comment
-	}{
-		Username: user.Username,
-		Email:    user.Email,
-		Role:     user.Role,
-		Threads:  threads,
-		Comments: comments,
-	}
-
-	tmpl, err := template.ParseFiles("templates/profile.html")
+	notifications, err := database.GetNotifications(user.ID, 20)
 	if err != nil {
-		log.Printf("Failed to parse template: %v", err)
-		http.Error(w, "Failed to parse template", http.StatusInternalServerError)
-		return
+		return apperror.Internal(err, "Server error, unable to retrieve your notifications")
+	}
+	if err := database.MarkNotificationsRead(user.ID); err != nil {
+		logging.FromContext(r.Context()).Error("failed to mark notifications read", "user_id", user.ID, "err", err)
 	}
 
-	err = tmpl.Execute(w, data)
-	if err != nil {
-		log.Printf("Failed to execute template: %v", err)
-		http.Error(w, "Failed to execute template", http.StatusInternalServerError)
-		return
+	data := struct {
+		Username      string
+		Email         string
+		Role          string
+		Threads       []models.Thread
+		Comments      []models.Comment
+		Notifications []models.Notification
+		CanModerate   bool
+		CanAdminister bool
+	}{
+		Username:      user.Username,
+		Email:         user.Email,
+		Role:          user.Role,
+		Threads:       threads,
+		Comments:      comments,
+		Notifications: notifications,
+		CanModerate:   currentUserCan(r, permissions.ModerationPanel),
+		CanAdminister: currentUserCan(r, permissions.AdminPanel),
 	}
+
+	return renderTemplate(w, r, profilePage, data)
 }
@@ -0,0 +1,208 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"DytForum/apperror"
+	"DytForum/permissions"
+	"DytForum/session"
+)
+
+// Every page a handler renders is declared here with pageTemplate so
+// LoadTemplates can refuse to start when one of them is missing.
+var (
+	adminAutomodPage          = pageTemplate("admin_automod.html")
+	adminAutomodRulePage      = pageTemplate("admin_automod_rule.html")
+	adminSpamPage             = pageTemplate("admin_spam.html")
+	adminLoginPage            = pageTemplate("admin.html")
+	adminModLogPage           = pageTemplate("admin_mod_log.html")
+	adminPanelPage            = pageTemplate("admin_panel.html")
+	adminRegistrationBansPage = pageTemplate("admin_registration_bans.html")
+	adminReportReasonsPage    = pageTemplate("admin_report_reasons.html")
+	adminRolesPage            = pageTemplate("admin_roles.html")
+	adminSecurityPage         = pageTemplate("admin_security.html")
+	apiDocsPage               = pageTemplate("api_docs.html")
+	appealsPage               = pageTemplate("appeals.html")
+	apiTokensPage             = pageTemplate("api_tokens.html")
+	bannedPage                = pageTemplate("banned.html")
+	createThreadPage          = pageTemplate("create_thread.html")
+	deleteCategoryPage        = pageTemplate("delete_category.html")
+	errorPage                 = pageTemplate("error.html")
+	homePage                  = pageTemplate("home.html")
+	indexPage                 = pageTemplate("index.html")
+	loginPage                 = pageTemplate("login.html")
+	moderatorAppealPage       = pageTemplate("moderator_appeal.html")
+	moderatorAppealsPage      = pageTemplate("moderator_appeals.html")
+	moderatorBulkPage         = pageTemplate("moderator_bulk.html")
+	moderatorPanelPage        = pageTemplate("moderator_panel.html")
+	moderatorReportPage       = pageTemplate("moderator_report.html")
+	moderatorReportsPage      = pageTemplate("moderator_reports.html")
+	moderatorRequestPage      = pageTemplate("moderator_request.html")
+	moderatorRequestsPage     = pageTemplate("moderator_requests.html")
+	moderatorSanctionsPage    = pageTemplate("moderator_sanctions.html")
+	moderatorThreadPage       = pageTemplate("moderator_thread.html")
+	moderatorUserPage         = pageTemplate("moderator_user.html")
+	profilePage               = pageTemplate("profile.html")
+	registerPage              = pageTemplate("register.html")
+	reportPage                = pageTemplate("report.html")
+	threadPage                = pageTemplate("threads.html")
+	transparencyPage          = pageTemplate("transparency.html")
+	twoFactorLoginPage        = pageTemplate("two_factor_login.html")
+	twoFactorRecoveryPage     = pageTemplate("two_factor_recovery.html")
+	twoFactorSetupPage        = pageTemplate("two_factor_setup.html")
+)
+
+var referencedPages []string
+
+func pageTemplate(name string) string {
+	referencedPages = append(referencedPages, name)
+	return name
+}
+
+// layout is what the base layout is executed with: the page's own data,
+// which the page's blocks see as dot, and the visitor for the nav bar.
+type layout struct {
+	Data interface{}
+	Nav  navData
+}
+
+// navData is what the "nav" partial shows about the visitor.
+type navData struct {
+	Authenticated bool
+	Username      string
+	CanModerate   bool
+	CanAdminister bool
+}
+
+var templateRegistry struct {
+	sync.RWMutex
+	fsys  fs.FS
+	dev   bool
+	pages map[string]*template.Template
+}
+
+// LoadTemplates parses every page in fsys together with the base layout and
+// the partials. With dev set the templates are parsed again on every request
+// so edits show up without a restart.
+func LoadTemplates(fsys fs.FS, dev bool) error {
+	pages, err := parseTemplates(fsys)
+	if err != nil {
+		return err
+	}
+
+	var missing []string
+	for _, name := range referencedPages {
+		if _, ok := pages[name]; !ok {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return fmt.Errorf("missing templates: %s", strings.Join(missing, ", "))
+	}
+
+	templateRegistry.Lock()
+	defer templateRegistry.Unlock()
+	templateRegistry.fsys = fsys
+	templateRegistry.dev = dev
+	templateRegistry.pages = pages
+	apperror.HTMLRenderer = renderErrorPage
+	return nil
+}
+
+// TemplatesLoaded reports whether LoadTemplates has succeeded.
+func TemplatesLoaded() bool {
+	templateRegistry.RLock()
+	defer templateRegistry.RUnlock()
+	return templateRegistry.pages != nil
+}
+
+func parseTemplates(fsys fs.FS) (map[string]*template.Template, error) {
+	shared := []string{"layouts/*.html", "partials/*.html"}
+	base, err := template.New("").ParseFS(fsys, shared...)
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := fs.Glob(fsys, "*.html")
+	if err != nil {
+		return nil, err
+	}
+	pages := make(map[string]*template.Template, len(files))
+	for _, file := range files {
+		tmpl, err := base.Clone()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := tmpl.ParseFS(fsys, file); err != nil {
+			return nil, err
+		}
+		pages[path.Base(file)] = tmpl
+	}
+	return pages, nil
+}
+
+func lookupTemplate(name string) (*template.Template, error) {
+	templateRegistry.RLock()
+	fsys, dev, pages := templateRegistry.fsys, templateRegistry.dev, templateRegistry.pages
+	templateRegistry.RUnlock()
+
+	if dev {
+		var err error
+		if pages, err = parseTemplates(fsys); err != nil {
+			return nil, err
+		}
+	}
+	tmpl, ok := pages[name]
+	if !ok {
+		return nil, fmt.Errorf("template %s not found", name)
+	}
+	return tmpl, nil
+}
+
+// currentNav describes the visitor for the navigation bar.
+func currentNav(r *http.Request) navData {
+	session, _ := session.Store.Get(r, "session-name")
+	if auth, _ := session.Values["authenticated"].(bool); !auth {
+		return navData{}
+	}
+	username, _ := session.Values["username"].(string)
+	return navData{
+		Authenticated: true,
+		Username:      username,
+		CanModerate:   currentUserCan(r, permissions.ModerationPanel),
+		CanAdminister: currentUserCan(r, permissions.AdminPanel),
+	}
+}
+
+// renderTemplate renders a page inside the base layout.
+func renderTemplate(w http.ResponseWriter, r *http.Request, name string, data interface{}) error {
+	return renderTemplateStatus(w, r, http.StatusOK, name, data)
+}
+
+// renderTemplateStatus is renderTemplate with a custom status code. The page
+// is rendered into a buffer first so a failing template never produces a
+// half-written response.
+func renderTemplateStatus(w http.ResponseWriter, r *http.Request, status int, name string, data interface{}) error {
+	tmpl, err := lookupTemplate(name)
+	if err != nil {
+		return apperror.Internal(fmt.Errorf("loading template %s: %v", name, err), "Failed to render page")
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.ExecuteTemplate(&buf, "base", layout{Data: data, Nav: currentNav(r)}); err != nil {
+		return apperror.Internal(fmt.Errorf("executing template %s: %v", name, err), "Failed to render page")
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	buf.WriteTo(w)
+	return nil
+}
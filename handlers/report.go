@@ -1,128 +1,608 @@
 package handlers
 
 import (
-	"html/template"
-	"log"
+	"database/sql"
+	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
 
+	"DytForum/apperror"
 	"DytForum/database"
+	"DytForum/logging"
 	"DytForum/models"
+	"DytForum/permissions"
 	"DytForum/session"
 )
 
-func ReportThreadHandler(w http.ResponseWriter, r *http.Request) {
+const maxResolutionNoteLength = 2000
+
+// reportContextComments is how many comments before and after a reported
+// comment the report page shows.
+const reportContextComments = 3
+
+// reportStatusFilters are the choices of the report queue's status filter.
+// "active" shows the reports that still need a moderator.
+var reportStatusFilters = map[string][]string{
+	"active":                 {database.ReportOpen, database.ReportInReview},
+	database.ReportOpen:      {database.ReportOpen},
+	database.ReportInReview:  {database.ReportInReview},
+	database.ReportActioned:  {database.ReportActioned},
+	database.ReportDismissed: {database.ReportDismissed},
+	"all":                    nil,
+}
+
+// reportActions maps each resolution action to the permission it needs on
+// top of report.resolve.
+var reportActions = []struct {
+	Name       string
+	Label      string
+	Permission string
+}{
+	{database.ActionDeleteContent, "Delete the content", permissions.ThreadDelete},
+	{database.ActionWarnAuthor, "Warn the author", permissions.ReportResolve},
+	{database.ActionSuspendAuthor, "Suspend the author", permissions.UserSuspend},
+}
+
+// reportTarget is the thread, comment or user a report form is about.
+type reportTarget struct {
+	Type       string
+	ID         int
+	ThreadID   int
+	Title      string
+	Content    string
+	AuthorName string
+}
+
+// ReportHandler shows the report form for a thread, comment or user and
+// files the submitted report.
+func ReportHandler(w http.ResponseWriter, r *http.Request) error {
 	session, _ := session.Store.Get(r, "session-name")
 	if auth, ok := session.Values["authenticated"].(bool); !ok || !auth {
-		http.Error(w, "You must be logged in to report a thread", http.StatusUnauthorized)
-		return
+		return apperror.Unauthorized("You must be logged in to report content")
 	}
-
 	userID := session.Values["userID"].(int)
 
-	// Parse thread_id from form value
-	threadID, err := strconv.Atoi(r.FormValue("thread_id"))
+	if r.Method == "POST" {
+		if err := checkCanPost(userID); err != nil {
+			return err
+		}
+		targetID, err := strconv.Atoi(r.FormValue("target_id"))
+		if err != nil {
+			return apperror.BadRequest("Invalid report target")
+		}
+		report, target, err := reportFromInput(userID, reportInput{
+			TargetType: r.FormValue("target_type"),
+			TargetID:   targetID,
+			Reason:     r.FormValue("reason"),
+			Details:    r.FormValue("details"),
+		})
+		if err != nil {
+			return err
+		}
+		if _, err := database.CreateReport(report); err != nil {
+			return apperror.Internal(err, "Failed to save report")
+		}
+
+		if target.ThreadID != 0 {
+			http.Redirect(w, r, fmt.Sprintf("/thread?id=%d", target.ThreadID), http.StatusSeeOther)
+		} else {
+			http.Redirect(w, r, "/index", http.StatusSeeOther)
+		}
+		return nil
+	}
+
+	targetID, err := strconv.Atoi(r.URL.Query().Get("id"))
 	if err != nil {
-		http.Error(w, "Invalid thread ID", http.StatusBadRequest)
-		return
+		return apperror.BadRequest("Invalid report target")
 	}
+	target, err := lookupReportTarget(r.URL.Query().Get("type"), targetID)
+	if err != nil {
+		return err
+	}
+	reasons, err := database.ListReportReasons()
+	if err != nil {
+		return apperror.Internal(err, "Failed to fetch report reasons")
+	}
+	data := struct {
+		Target  reportTarget
+		Reasons []models.ReportReason
+	}{
+		Target:  target,
+		Reasons: reasons,
+	}
+	return renderTemplate(w, r, reportPage, data)
+}
 
-	// Validate reason field
-	reason := r.FormValue("reason")
-	if reason == "" {
-		http.Error(w, "Reason cannot be empty", http.StatusBadRequest)
-		return
+// reportFromInput validates a report filed by userID and looks up what it is
+// about. Field errors use the JSON names of reportInput.
+func reportFromInput(userID int, in reportInput) (database.NewReport, reportTarget, error) {
+	if in.TargetType == "" && in.ThreadID != 0 {
+		in.TargetType, in.TargetID = database.ReportTargetThread, in.ThreadID
 	}
+	in.Reason = strings.TrimSpace(in.Reason)
+	in.Details = strings.TrimSpace(in.Details)
 
-	// Insert report into database
-	_, err = database.DB.Exec("INSERT INTO reports (thread_id, user_id, reason) VALUES (?, ?, ?)", threadID, userID, reason)
+	fields := map[string]string{}
+	if !validReportTarget(in.TargetType) {
+		fields["target_type"] = "must be one of " + strings.Join(database.ReportTargets, ", ")
+	} else if in.TargetType == database.ReportTargetUser && in.TargetID == userID {
+		fields["target_id"] = "cannot be yourself"
+	}
+	if in.Reason == "" {
+		fields["reason"] = "is required"
+	} else if known, err := database.ReportReasonExists(in.Reason); err != nil {
+		return database.NewReport{}, reportTarget{}, apperror.Internal(err, "Failed to check report reason")
+	} else if !known {
+		fields["reason"] = "is not a report reason"
+	}
+	if len([]rune(in.Details)) > maxReportDetailsLength {
+		fields["details"] = "must be at most " + strconv.Itoa(maxReportDetailsLength) + " characters"
+	}
+	if len(fields) > 0 {
+		return database.NewReport{}, reportTarget{}, apperror.Invalid(fields)
+	}
+
+	target, err := lookupReportTarget(in.TargetType, in.TargetID)
 	if err != nil {
-		http.Error(w, "Failed to report thread", http.StatusInternalServerError)
-		return
+		return database.NewReport{}, reportTarget{}, err
+	}
+	report := database.NewReport{
+		TargetType: in.TargetType,
+		TargetID:   in.TargetID,
+		UserID:     userID,
+		Reason:     in.Reason,
+		Details:    in.Details,
 	}
+	return report, target, nil
+}
 
-	// Redirect to the index page after reporting
-	http.Redirect(w, r, "/index", http.StatusSeeOther)
+func validReportTarget(targetType string) bool {
+	for _, t := range database.ReportTargets {
+		if t == targetType {
+			return true
+		}
+	}
+	return false
 }
 
-func ListReportsHandler(w http.ResponseWriter, r *http.Request) {
-	session, err := session.Store.Get(r, "session-name")
+// lookupReportTarget finds the content a report is about. Only approved
+// threads and the comments on them can be reported.
+func lookupReportTarget(targetType string, targetID int) (reportTarget, error) {
+	target := reportTarget{Type: targetType, ID: targetID}
+	switch targetType {
+	case database.ReportTargetThread, database.ReportTargetComment:
+		threadID := targetID
+		if targetType == database.ReportTargetComment {
+			comment, err := database.GetComment(targetID)
+			if err == sql.ErrNoRows {
+				return target, apperror.NotFound("Comment not found")
+			}
+			if err != nil {
+				return target, apperror.Internal(err, "Failed to fetch comment")
+			}
+			threadID = comment.ThreadID
+			target.Content = comment.Content
+			target.AuthorName = comment.Username
+		}
+		thread, err := database.GetThread(threadID)
+		if err == nil && thread.Approved != 1 {
+			err = sql.ErrNoRows
+		}
+		if err == sql.ErrNoRows {
+			return target, apperror.NotFound("Thread not found")
+		}
+		if err != nil {
+			return target, apperror.Internal(err, "Failed to fetch thread")
+		}
+		target.ThreadID = thread.ID
+		target.Title = thread.Title
+		if targetType == database.ReportTargetThread {
+			target.Content = thread.Content
+			target.AuthorName = thread.Username
+		}
+	case database.ReportTargetUser:
+		user, err := database.GetUser(targetID)
+		if err == sql.ErrNoRows {
+			return target, apperror.NotFound("User not found")
+		}
+		if err != nil {
+			return target, apperror.Internal(err, "Failed to fetch user")
+		}
+		target.AuthorName = user.Username
+	default:
+		return target, apperror.BadRequest("Invalid report target")
+	}
+	return target, nil
+}
+
+// ListReportsHandler shows the report queue of the current moderator,
+// filtered by status.
+func ListReportsHandler(w http.ResponseWriter, r *http.Request) error {
+	status := r.URL.Query().Get("status")
+	if status == "" {
+		status = "active"
+	}
+	statuses, ok := reportStatusFilters[status]
+	if !ok {
+		return apperror.BadRequest("Unknown report status")
+	}
+
+	scope := currentModerationScope(r)
+	reports, err := database.ListReports(database.ReportQuery{Statuses: statuses, ModeratorID: scope.moderatorID()})
 	if err != nil {
-		log.Printf("ListReportsHandler: Failed to get session: %v", err)
-		http.Error(w, "Failed to get session", http.StatusInternalServerError)
-		return
+		return apperror.Internal(err, "Failed to retrieve reports")
 	}
 
-	role, ok := session.Values["role"].(string)
-	if !ok || role != "moderator" {
-		http.Error(w, "You must be a moderator to access this page", http.StatusForbidden)
-		return
+	data := struct {
+		Reports  []models.Report
+		Status   string
+		Statuses []string
+	}{
+		Reports:  reports,
+		Status:   status,
+		Statuses: append([]string{"active"}, append(database.ReportStatuses, "all")...),
 	}
+	return renderTemplate(w, r, moderatorReportsPage, data)
+}
 
-	rows, err := database.DB.Query(`
-		SELECT 
-			reports.id, reports.thread_id, reports.user_id, reports.reason, 
-			users.username, threads.title, threads.content 
-		FROM reports 
-		INNER JOIN users ON reports.user_id = users.id 
-		INNER JOIN threads ON reports.thread_id = threads.id
-	`)
+// ReportDetailHandler shows a report with the reported content, every
+// report filed about it and the assignment and resolution forms.
+func ReportDetailHandler(w http.ResponseWriter, r *http.Request) error {
+	report, err := scopedReport(r)
 	if err != nil {
-		log.Printf("ListReportsHandler: Failed to retrieve reports: %v", err)
-		http.Error(w, "Failed to retrieve reports", http.StatusInternalServerError)
-		return
-	}
-	defer rows.Close()
-
-	var reports []struct {
-		ID       int
-		ThreadID int
-		UserID   int
-		Reason   string
-		Username string
-		Title    string
-		Content  string
-	}
-	for rows.Next() {
-		var report struct {
-			ID       int
-			ThreadID int
-			UserID   int
-			Reason   string
-			Username string
-			Title    string
-			Content  string
-		}
-		err := rows.Scan(&report.ID, &report.ThreadID, &report.UserID, &report.Reason, &report.Username, &report.Title, &report.Content)
-		if err != nil {
-			log.Printf("ListReportsHandler: Failed to scan report: %v", err)
-			http.Error(w, "Failed to scan report", http.StatusInternalServerError)
-			return
+		return err
+	}
+	if report.DuplicateOf != 0 {
+		http.Redirect(w, r, fmt.Sprintf("/moderator/reports/%d", report.DuplicateOf), http.StatusSeeOther)
+		return nil
+	}
+
+	group, err := database.GetReportGroup(report.ID)
+	if err != nil {
+		return apperror.Internal(err, "Failed to retrieve reports")
+	}
+	moderators, err := database.UsersWithPermission(permissions.ReportResolve)
+	if err != nil {
+		return apperror.Internal(err, "Failed to fetch moderators")
+	}
+
+	var context []models.Comment
+	var userThreads []models.Thread
+	var userComments []models.Comment
+	if !report.ContentDeleted {
+		switch report.TargetType {
+		case database.ReportTargetComment:
+			comment, err := database.GetComment(report.TargetID)
+			if err != nil {
+				return apperror.Internal(err, "Failed to fetch comment")
+			}
+			if context, err = database.CommentsAround(comment, reportContextComments); err != nil {
+				return apperror.Internal(err, "Failed to fetch comments")
+			}
+		case database.ReportTargetUser:
+			if userThreads, err = database.GetThreadsByUserID(report.TargetID); err != nil {
+				return apperror.Internal(err, "Failed to fetch threads")
+			}
+			if userComments, err = database.GetCommentsByUserID(report.TargetID); err != nil {
+				return apperror.Internal(err, "Failed to fetch comments")
+			}
 		}
-		reports = append(reports, report)
 	}
 
-	tmpl := template.Must(template.ParseFiles("templates/moderator_panel.html"))
-	var pendingThreads []models.Thread
+	type action struct {
+		Name    string
+		Label   string
+		Allowed bool
+	}
+	var actions []action
+	for _, a := range reportActions {
+		if a.Name == database.ActionDeleteContent && report.TargetType == database.ReportTargetUser {
+			continue
+		}
+		actions = append(actions, action{a.Name, a.Label, currentUserCan(r, a.Permission)})
+	}
 
+	session, _ := session.Store.Get(r, "session-name")
+	userID, _ := session.Values["userID"].(int)
 	data := struct {
-		PendingThreads []models.Thread
-		Reports        []struct {
-			ID       int
-			ThreadID int
-			UserID   int
-			Reason   string
-			Username string
-			Title    string
-			Content  string
-		}
+		Report       models.Report
+		Reports      []models.Report
+		Context      []models.Comment
+		UserThreads  []models.Thread
+		UserComments []models.Comment
+		Active       bool
+		CanResolve   bool
+		Moderators   []models.User
+		Actions      []action
+		UserID       int
+		Durations    []int
+		SuspendDays  int
+		CanSanction  bool
 	}{
-		PendingThreads: pendingThreads, // Assuming you have pendingThreads defined elsewhere
-		Reports:        reports,
+		Report:       report,
+		Reports:      group,
+		Context:      context,
+		UserThreads:  userThreads,
+		UserComments: userComments,
+		Active:       reportActive(report),
+		CanResolve:   currentUserCan(r, permissions.ReportResolve),
+		Moderators:   moderators,
+		Actions:      actions,
+		UserID:       userID,
+		Durations:    sanctionDurations,
+		SuspendDays:  defaultSuspensionDays,
+		CanSanction:  currentUserCan(r, permissions.UserSuspend),
+	}
+	return renderTemplate(w, r, moderatorReportPage, data)
+}
+
+// AssignReportHandler assigns an active report to a moderator, which puts
+// it in review, or releases it back to the open queue.
+func AssignReportHandler(w http.ResponseWriter, r *http.Request) error {
+	report, err := scopedReport(r)
+	if err != nil {
+		return err
+	}
+
+	assigneeID := 0
+	if value := r.FormValue("assignee"); value != "" {
+		if assigneeID, err = strconv.Atoi(value); err != nil {
+			return apperror.BadRequest("Invalid moderator")
+		}
+		role, err := database.GetUserRole(assigneeID)
+		if err == sql.ErrNoRows {
+			return apperror.BadRequest("Invalid moderator")
+		}
+		if err != nil {
+			return apperror.Internal(err, "Failed to fetch moderator")
+		}
+		allowed, err := database.RoleHasPermission(role, permissions.ReportResolve)
+		if err != nil {
+			return apperror.Internal(err, "Failed to check permissions")
+		}
+		if !allowed {
+			return apperror.BadRequest("That user cannot resolve reports")
+		}
+	}
+
+	err = database.AssignReport(report.ID, assigneeID)
+	if err == database.ErrReportClosed {
+		return apperror.BadRequest("This report is already closed")
+	}
+	if err != nil {
+		return apperror.Internal(err, "Failed to assign report")
+	}
+	after := database.ReportOpen
+	if assigneeID != 0 {
+		after = database.ReportInReview
+	}
+	if err := recordModAction(r, database.ModReportAssign, "report", report.ID,
+		map[string]interface{}{"status": report.Status, "assigned_to": report.AssignedTo},
+		map[string]interface{}{"status": after, "assigned_to": assigneeID}, ""); err != nil {
+		return err
 	}
-	err = tmpl.Execute(w, data)
+	http.Redirect(w, r, fmt.Sprintf("/moderator/reports/%d", report.ID), http.StatusSeeOther)
+	return nil
+}
+
+// ResolveReportHandler closes an active report. Dismissing it takes no
+// action; actioning it runs the chosen actions against the reported thread
+// and its author. Everyone who reported the thread is notified either way.
+func ResolveReportHandler(w http.ResponseWriter, r *http.Request) error {
+	report, err := scopedReport(r)
+	if err != nil {
+		return err
+	}
+	if !reportActive(report) {
+		return apperror.BadRequest("This report is already closed")
+	}
+
+	status := r.FormValue("status")
+	note := strings.TrimSpace(r.FormValue("note"))
+	if len([]rune(note)) > maxResolutionNoteLength {
+		return apperror.BadRequest("The note must be at most 2000 characters")
+	}
+	actions, err := chosenReportActions(r, r.Form["actions"])
 	if err != nil {
-		log.Printf("ListReportsHandler: Failed to execute template: %v", err)
-		http.Error(w, "Failed to execute template", http.StatusInternalServerError)
+		return err
+	}
+	switch status {
+	case database.ReportActioned:
+		if len(actions) == 0 {
+			return apperror.BadRequest("Choose at least one action")
+		}
+	case database.ReportDismissed:
+		if len(actions) > 0 {
+			return apperror.BadRequest("A dismissed report cannot have actions")
+		}
+	default:
+		return apperror.BadRequest("Invalid resolution")
+	}
+	if len(actions) > 0 && report.ContentDeleted {
+		return apperror.BadRequest("The reported content no longer exists; dismiss the report instead")
+	}
+
+	suspendDays := defaultSuspensionDays
+	if value := r.FormValue("suspend_days"); value != "" {
+		suspendDays, err = strconv.Atoi(value)
+		if err != nil || suspendDays < 1 || suspendDays > maxSuspensionDays {
+			return apperror.BadRequest(fmt.Sprintf("Suspensions last between 1 and %d days", maxSuspensionDays))
+		}
+	}
+
+	session, _ := session.Store.Get(r, "session-name")
+	moderatorID := session.Values["userID"].(int)
+	for _, action := range actions {
+		if err := checkReportAction(report, action, moderatorID); err != nil {
+			return err
+		}
+	}
+
+	resolution := database.ReportResolution{
+		ReportID:    report.ID,
+		ModeratorID: moderatorID,
+		Status:      status,
+		Actions:     actions,
+		Note:        note,
+		Log: newModAction(r, database.ModReportResolve, "report", report.ID,
+			map[string]interface{}{"status": report.Status},
+			map[string]interface{}{"status": status, "actions": actions}, note),
+	}
+	for _, action := range actions {
+		if err := addReportAction(r, &resolution, report, action, suspendDays); err != nil {
+			return err
+		}
+	}
+	reporters, err := database.ResolveReport(resolution)
+	if err == database.ErrReportClosed {
+		return apperror.BadRequest("This report is already closed")
+	}
+	if err != nil {
+		return apperror.Internal(err, "Failed to resolve report")
+	}
+	for _, s := range resolution.Sanctions {
+		notifySanctioned(r, s)
+	}
+
+	outcome := "found that it does not break the forum rules"
+	if status == database.ReportActioned {
+		outcome = "took action"
+	}
+	message := fmt.Sprintf("Thanks for your report about %s. A moderator reviewed it and %s.", reportSubject(report, false), outcome)
+	for _, reporterID := range reporters {
+		if reporterID == 0 {
+			// Filed by automoderation.
+			continue
+		}
+		if err := database.CreateNotification(reporterID, message); err != nil {
+			logging.FromContext(r.Context()).Error("failed to notify reporter", "report_id", report.ID, "user_id", reporterID, "err", err)
+		}
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/moderator/reports/%d", report.ID), http.StatusSeeOther)
+	return nil
+}
+
+// scopedReport loads the report named in the URL and checks that it is in
+// the current moderator's categories.
+func scopedReport(r *http.Request) (models.Report, error) {
+	reportID, err := pathID(r, "id")
+	if err != nil {
+		return models.Report{}, err
+	}
+	if err := checkReportInScope(r, reportID); err != nil {
+		return models.Report{}, err
+	}
+	report, err := database.GetReport(reportID)
+	if err != nil {
+		return models.Report{}, apperror.Internal(err, "Failed to fetch report")
+	}
+	return report, nil
+}
+
+func reportActive(report models.Report) bool {
+	return report.Status == database.ReportOpen || report.Status == database.ReportInReview
+}
+
+// chosenReportActions validates the submitted actions, keeping the order of
+// reportActions, and checks that the moderator may take each of them.
+func chosenReportActions(r *http.Request, values []string) ([]string, error) {
+	var actions []string
+	for _, a := range reportActions {
+		for _, v := range values {
+			if v != a.Name {
+				continue
+			}
+			if !currentUserCan(r, a.Permission) {
+				return nil, apperror.Forbidden("You are not allowed to " + strings.ToLower(a.Label))
+			}
+			actions = append(actions, a.Name)
+			break
+		}
+	}
+	if len(actions) != len(values) {
+		return nil, apperror.BadRequest("Invalid action")
+	}
+	return actions, nil
+}
+
+// checkReportAction refuses actions that cannot be applied to the report's
+// author, before any action has run.
+func checkReportAction(report models.Report, action string, moderatorID int) error {
+	if action == database.ActionDeleteContent && report.TargetType == database.ReportTargetUser {
+		return apperror.BadRequest("A user report has no content to delete")
+	}
+	if action != database.ActionSuspendAuthor {
+		return nil
+	}
+	return checkSanctionTarget(report.AuthorID, moderatorID)
+}
+
+// addReportAction adds one action to a report resolution together with
+// its audit entry, which refers to the report. Suspensions last
+// suspendDays.
+func addReportAction(r *http.Request, res *database.ReportResolution, report models.Report, action string, suspendDays int) error {
+	reason := fmt.Sprintf("report %d", report.ID)
+	if res.Note != "" {
+		reason += ": " + res.Note
+	}
+	switch action {
+	case database.ActionDeleteContent:
+		if report.TargetType == database.ReportTargetComment {
+			comment, err := database.GetComment(report.TargetID)
+			if err != nil {
+				return apperror.Internal(err, "Failed to fetch comment")
+			}
+			res.DeleteCommentID = comment.ID
+			res.Logs = append(res.Logs, newModAction(r, database.ModCommentDelete, "comment", comment.ID, comment, nil, reason))
+			break
+		}
+		thread, err := database.GetThread(report.ThreadID)
+		if err != nil {
+			return apperror.Internal(err, "Failed to fetch thread")
+		}
+		res.DeleteThreadID = thread.ID
+		res.Logs = append(res.Logs, newModAction(r, database.ModThreadDelete, "thread", thread.ID, thread, nil, reason))
+	case database.ActionWarnAuthor, database.ActionSuspendAuthor:
+		sanction := models.Sanction{
+			UserID:   report.AuthorID,
+			Kind:     database.SanctionWarning,
+			Reason:   fmt.Sprintf("Report %d about %s", report.ID, reportSubject(report, true)),
+			IssuedBy: res.ModeratorID,
+		}
+		if res.Note != "" {
+			sanction.Reason += ": " + res.Note
+		}
+		if action == database.ActionSuspendAuthor {
+			sanction.Kind = database.SanctionSuspension
+			sanction.ExpiresAt = sanctionExpiry(suspendDays)
+		}
+		res.Sanctions = append(res.Sanctions, sanction)
+		res.SanctionLog = func(s models.Sanction, sanctionID int) models.ModAction {
+			return newSanctionAction(r, s, sanctionID)
+		}
+	}
+	return nil
+}
+
+// reportSubject describes what a report is about for notifications, either
+// to the reporter or, with own set, to the author.
+func reportSubject(report models.Report, own bool) string {
+	switch report.TargetType {
+	case database.ReportTargetComment:
+		if own {
+			return fmt.Sprintf("your comment in %q", report.Title)
+		}
+		return fmt.Sprintf("a comment in %q", report.Title)
+	case database.ReportTargetUser:
+		if own {
+			return "your behaviour on the forum"
+		}
+		return "the user " + report.AuthorName
+	}
+	if own {
+		return fmt.Sprintf("your thread %q", report.Title)
 	}
+	return fmt.Sprintf("the thread %q", report.Title)
 }
@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+
+	"DytForum/apperror"
+	"DytForum/database"
+	"DytForum/models"
+)
+
+const maxReportReasonLength = 50
+
+// ReportReasonsHandler lists the reasons users can pick when reporting and
+// adds new ones.
+func ReportReasonsHandler(w http.ResponseWriter, r *http.Request) error {
+	if r.Method == "POST" {
+		name := strings.TrimSpace(r.FormValue("name"))
+		if name == "" || len([]rune(name)) > maxReportReasonLength {
+			return apperror.BadRequest("Reason names must be between 1 and 50 characters")
+		}
+		exists, err := database.ReportReasonExists(name)
+		if err != nil {
+			return apperror.Internal(err, "Failed to check report reasons")
+		}
+		if exists {
+			return apperror.BadRequest("That reason already exists")
+		}
+		if err := database.CreateReportReason(name); err != nil {
+			return apperror.Internal(err, "Failed to add report reason")
+		}
+		if err := recordModAction(r, database.ModReportReasonCreate, "report_reason", 0, nil, map[string]string{"name": name}, ""); err != nil {
+			return err
+		}
+		http.Redirect(w, r, "/admin/report-reasons", http.StatusSeeOther)
+		return nil
+	}
+
+	reasons, err := database.ListReportReasons()
+	if err != nil {
+		return apperror.Internal(err, "Failed to fetch report reasons")
+	}
+	data := struct {
+		Reasons []models.ReportReason
+	}{
+		Reasons: reasons,
+	}
+	return renderTemplate(w, r, adminReportReasonsPage, data)
+}
+
+// DeleteReportReasonHandler stops offering a report reason. Existing reports
+// keep the reason they were filed with.
+func DeleteReportReasonHandler(w http.ResponseWriter, r *http.Request) error {
+	reasonID, err := pathID(r, "id")
+	if err != nil {
+		return err
+	}
+	if err := database.DeleteReportReason(reasonID); err != nil {
+		return apperror.Internal(err, "Failed to delete report reason")
+	}
+	if err := recordModAction(r, database.ModReportReasonDelete, "report_reason", reasonID, nil, nil, ""); err != nil {
+		return err
+	}
+	http.Redirect(w, r, "/admin/report-reasons", http.StatusSeeOther)
+	return nil
+}
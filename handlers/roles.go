@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"DytForum/apperror"
+	"DytForum/database"
+	"DytForum/models"
+	"DytForum/permissions"
+
+	"github.com/gorilla/mux"
+)
+
+var roleNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_-]{1,19}$`)
+
+// RolesHandler lists roles with their permissions and creates custom roles.
+func RolesHandler(w http.ResponseWriter, r *http.Request) error {
+	if r.Method == "POST" {
+		name := strings.ToLower(strings.TrimSpace(r.FormValue("name")))
+		if !roleNamePattern.MatchString(name) {
+			return apperror.BadRequest("Role names must be 2-20 lowercase letters, digits, '-' or '_'")
+		}
+		if err := database.CreateRole(name); err != nil {
+			return apperror.Internal(err, "Failed to create role")
+		}
+		if err := recordModAction(r, database.ModRoleCreate, "role", 0, nil, map[string]string{"name": name}, ""); err != nil {
+			return err
+		}
+		http.Redirect(w, r, "/admin/roles", http.StatusSeeOther)
+		return nil
+	}
+
+	roles, err := database.GetRoles()
+	if err != nil {
+		return apperror.Internal(err, "Failed to fetch roles")
+	}
+
+	type roleRow struct {
+		models.Role
+		Builtin  bool
+		Editable bool
+		Granted  map[string]bool
+	}
+	var rows []roleRow
+	for _, role := range roles {
+		row := roleRow{
+			Role:     role,
+			Builtin:  permissions.IsBuiltinRole(role.Name),
+			Editable: role.Name != permissions.AdminRole,
+			Granted:  map[string]bool{},
+		}
+		for _, perm := range role.Permissions {
+			row.Granted[perm] = true
+		}
+		rows = append(rows, row)
+	}
+
+	data := struct {
+		Roles       []roleRow
+		Permissions []permissions.Definition
+	}{
+		Roles:       rows,
+		Permissions: permissions.All,
+	}
+	return renderTemplate(w, r, adminRolesPage, data)
+}
+
+// UpdateRolePermissionsHandler replaces the permissions of a role with the
+// checked boxes of the submitted form. The admin role cannot be edited.
+func UpdateRolePermissionsHandler(w http.ResponseWriter, r *http.Request) error {
+	vars := mux.Vars(r)
+	roleID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		return apperror.BadRequest("Invalid role ID")
+	}
+
+	role, err := database.GetRoleByID(roleID)
+	if err != nil {
+		return apperror.NotFound("Role not found")
+	}
+	if role.Name == permissions.AdminRole {
+		return apperror.BadRequest("The admin role always has every permission")
+	}
+
+	if err := r.ParseForm(); err != nil {
+		return apperror.BadRequest("Failed to parse form data")
+	}
+	before, err := database.GetRolePermissions(role.Name)
+	if err != nil {
+		return apperror.Internal(err, "Failed to fetch permissions")
+	}
+	if err := database.SetRolePermissions(roleID, r.Form["permission"]); err != nil {
+		return apperror.Internal(err, "Failed to update permissions")
+	}
+	if err := recordModAction(r, database.ModRolePermissions, "role", roleID,
+		map[string]interface{}{"name": role.Name, "permissions": before},
+		map[string]interface{}{"name": role.Name, "permissions": r.Form["permission"]}, ""); err != nil {
+		return err
+	}
+
+	http.Redirect(w, r, "/admin/roles", http.StatusSeeOther)
+	return nil
+}
+
+// DeleteRoleHandler removes a custom role. Built-in roles cannot be deleted.
+func DeleteRoleHandler(w http.ResponseWriter, r *http.Request) error {
+	vars := mux.Vars(r)
+	roleID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		return apperror.BadRequest("Invalid role ID")
+	}
+
+	role, err := database.GetRoleByID(roleID)
+	if err != nil {
+		return apperror.NotFound("Role not found")
+	}
+	if permissions.IsBuiltinRole(role.Name) {
+		return apperror.BadRequest("Built-in roles cannot be deleted")
+	}
+
+	if err := database.DeleteRole(roleID); err != nil {
+		return apperror.Internal(err, "Failed to delete role")
+	}
+	if err := recordModAction(r, database.ModRoleDelete, "role", roleID, map[string]string{"name": role.Name}, nil, ""); err != nil {
+		return err
+	}
+
+	http.Redirect(w, r, "/admin/roles", http.StatusSeeOther)
+	return nil
+}
+
+// SetUserRoleHandler assigns any existing role to a user.
+func SetUserRoleHandler(w http.ResponseWriter, r *http.Request) error {
+	vars := mux.Vars(r)
+	userID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		return apperror.BadRequest("Invalid user ID")
+	}
+
+	role := r.FormValue("role")
+	exists, err := database.RoleExists(role)
+	if err != nil {
+		return apperror.Internal(err, "Failed to check role")
+	}
+	if !exists {
+		return apperror.BadRequest("Unknown role")
+	}
+
+	before, err := database.GetUserRole(userID)
+	if err != nil {
+		return apperror.NotFound("User not found")
+	}
+	if err := database.UpdateUserRole(userID, role); err != nil {
+		return apperror.Internal(err, "Failed to update role")
+	}
+	if err := recordModAction(r, database.ModUserRole, "user", userID, map[string]string{"role": before}, map[string]string{"role": role}, ""); err != nil {
+		return err
+	}
+
+	http.Redirect(w, r, "/admin/panel", http.StatusSeeOther)
+	return nil
+}
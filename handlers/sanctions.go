@@ -0,0 +1,399 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"DytForum/apperror"
+	"DytForum/database"
+	"DytForum/logging"
+	"DytForum/middleware"
+	"DytForum/models"
+	"DytForum/permissions"
+	"DytForum/session"
+)
+
+const (
+	maxSanctionReasonLength = 500
+	maxSuspensionDays       = 365
+	maxBanDays              = 3650
+	// defaultSuspensionDays is preselected when suspending from a report.
+	defaultSuspensionDays = 7
+)
+
+// sanctionDurations are the lengths offered by the sanction forms, in days.
+// Zero means permanent and is only offered for bans.
+var sanctionDurations = []int{1, 3, 7, 14, 30, 90, 365}
+
+// sanctionLogActions maps sanction kinds to their moderation log action.
+var sanctionLogActions = map[string]string{
+	database.SanctionWarning:    database.ModUserWarn,
+	database.SanctionSuspension: database.ModUserSuspend,
+	database.SanctionBan:        database.ModUserBan,
+}
+
+// restrictionMessage tells a user why they cannot post or log in.
+func restrictionMessage(s models.Sanction) string {
+	until := "permanently"
+	if s.ExpiresAt != nil {
+		until = "until " + s.ExpiresAt.UTC().Format("2006-01-02 15:04") + " UTC"
+	}
+	what := "suspended"
+	if s.Kind == database.SanctionBan {
+		what = "banned"
+	}
+	return fmt.Sprintf("Your account is %s %s. Reason: %s", what, until, s.Reason)
+}
+
+// checkCanPost refuses users whose account is suspended or banned. Posting
+// handlers call it before changing anything.
+func checkCanPost(userID int) error {
+	s, err := database.ActiveRestriction(userID)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return apperror.Internal(err, "Failed to check account status")
+	}
+	return apperror.Forbidden(restrictionMessage(s))
+}
+
+// checkSanctionTarget refuses to sanction yourself or a staff account, that
+// is one whose role opens the moderator panel.
+func checkSanctionTarget(userID, moderatorID int) error {
+	if userID == moderatorID {
+		return apperror.BadRequest("You cannot sanction yourself")
+	}
+	role, err := database.GetUserRole(userID)
+	if err == sql.ErrNoRows {
+		return apperror.NotFound("User not found")
+	}
+	if err != nil {
+		return apperror.Internal(err, "Failed to fetch the user")
+	}
+	staff, err := database.RoleHasPermission(role, permissions.ModerationPanel)
+	if err != nil {
+		return apperror.Internal(err, "Failed to check permissions")
+	}
+	if staff {
+		return apperror.Forbidden("Staff accounts cannot be sanctioned; change their role first")
+	}
+	return nil
+}
+
+// issueSanction stores a sanction given by the logged in moderator, tells
+// the user about it and records it in the moderation log.
+func issueSanction(r *http.Request, s models.Sanction) error {
+	session, _ := session.Store.Get(r, "session-name")
+	s.IssuedBy = session.Values["userID"].(int)
+
+	sanctionID, err := database.CreateSanction(s)
+	if err != nil {
+		return apperror.Internal(err, "Failed to save the sanction")
+	}
+
+	notifySanctioned(r, s)
+	if err := database.CreateModAction(newSanctionAction(r, s, sanctionID)); err != nil {
+		return apperror.Internal(err, "The action was taken but could not be recorded in the moderation log")
+	}
+	return nil
+}
+
+// notifySanctioned tells a user about a sanction they received.
+func notifySanctioned(r *http.Request, s models.Sanction) {
+	message := "A moderator warned you. Reason: " + s.Reason
+	if s.Kind != database.SanctionWarning {
+		message = restrictionMessage(s)
+	}
+	if err := database.CreateNotification(s.UserID, message); err != nil {
+		logging.FromContext(r.Context()).Error("failed to notify sanctioned user", "user_id", s.UserID, "err", err)
+	}
+}
+
+// newSanctionAction builds the audit log entry of a stored sanction.
+func newSanctionAction(r *http.Request, s models.Sanction, sanctionID int) models.ModAction {
+	after := map[string]interface{}{"sanction_id": sanctionID, "kind": s.Kind, "expires_at": s.ExpiresAt}
+	return newModAction(r, sanctionLogActions[s.Kind], "user", s.UserID, nil, after, s.Reason)
+}
+
+// sanctionExpiry turns a number of days from a form into an expiry time.
+// Zero days means no expiry.
+func sanctionExpiry(days int) *time.Time {
+	if days == 0 {
+		return nil
+	}
+	expires := time.Now().UTC().Add(time.Duration(days) * 24 * time.Hour).Truncate(time.Second)
+	return &expires
+}
+
+// UserSanctionsHandler shows a user's sanction history with forms to warn,
+// suspend or ban them.
+func UserSanctionsHandler(w http.ResponseWriter, r *http.Request) error {
+	userID, err := pathID(r, "id")
+	if err != nil {
+		return err
+	}
+	user, err := database.GetUser(userID)
+	if err == sql.ErrNoRows {
+		return apperror.NotFound("User not found")
+	}
+	if err != nil {
+		return apperror.Internal(err, "Failed to fetch the user")
+	}
+	sanctions, err := database.ListUserSanctions(userID)
+	if err != nil {
+		return apperror.Internal(err, "Failed to fetch sanctions")
+	}
+
+	data := struct {
+		User            models.User
+		Sanctions       []models.Sanction
+		Durations       []int
+		DefaultDuration int
+		CanBan          bool
+		CanPurge        bool
+	}{
+		User:            user,
+		Sanctions:       sanctions,
+		Durations:       sanctionDurations,
+		DefaultDuration: defaultSuspensionDays,
+		CanBan:          currentUserCan(r, permissions.UserBan),
+		CanPurge:        currentUserCan(r, permissions.ThreadDelete),
+	}
+	return renderTemplate(w, r, moderatorUserPage, data)
+}
+
+// CreateSanctionHandler warns, suspends or bans a user. Suspensions need a
+// duration; bans without one are permanent and need user.ban.
+func CreateSanctionHandler(w http.ResponseWriter, r *http.Request) error {
+	userID, err := pathID(r, "id")
+	if err != nil {
+		return err
+	}
+	session, _ := session.Store.Get(r, "session-name")
+	if err := checkSanctionTarget(userID, session.Values["userID"].(int)); err != nil {
+		return err
+	}
+
+	kind := r.FormValue("kind")
+	reason := strings.TrimSpace(r.FormValue("reason"))
+	fields := map[string]string{}
+	validateText(fields, "reason", reason, maxSanctionReasonLength)
+	days, err := strconv.Atoi(r.FormValue("days"))
+	if err != nil && kind != database.SanctionWarning {
+		fields["days"] = "must be a number of days"
+	}
+	switch kind {
+	case database.SanctionWarning:
+		days = 0
+	case database.SanctionSuspension:
+		if days < 1 || days > maxSuspensionDays {
+			fields["days"] = fmt.Sprintf("must be between 1 and %d", maxSuspensionDays)
+		}
+	case database.SanctionBan:
+		if !currentUserCan(r, permissions.UserBan) {
+			return apperror.Forbidden("You are not allowed to ban users")
+		}
+		if days < 0 || days > maxBanDays {
+			fields["days"] = fmt.Sprintf("must be between 0 and %d", maxBanDays)
+		}
+	default:
+		fields["kind"] = "must be warning, suspension or ban"
+	}
+	if len(fields) > 0 {
+		return apperror.Invalid(fields)
+	}
+
+	err = issueSanction(r, models.Sanction{UserID: userID, Kind: kind, Reason: reason, ExpiresAt: sanctionExpiry(days)})
+	if err != nil {
+		return err
+	}
+	http.Redirect(w, r, fmt.Sprintf("/moderator/users/%d", userID), http.StatusSeeOther)
+	return nil
+}
+
+// RevokeSanctionHandler lifts a suspension or ban early. Lifting a ban needs
+// user.ban.
+func RevokeSanctionHandler(w http.ResponseWriter, r *http.Request) error {
+	sanctionID, err := pathID(r, "id")
+	if err != nil {
+		return err
+	}
+	sanction, err := database.GetSanction(sanctionID)
+	if err == sql.ErrNoRows {
+		return apperror.NotFound("Sanction not found")
+	}
+	if err != nil {
+		return apperror.Internal(err, "Failed to fetch the sanction")
+	}
+	if sanction.Kind == database.SanctionBan && !currentUserCan(r, permissions.UserBan) {
+		return apperror.Forbidden("You are not allowed to lift bans")
+	}
+
+	session, _ := session.Store.Get(r, "session-name")
+	err = database.RevokeSanction(sanctionID, session.Values["userID"].(int))
+	if err == database.ErrSanctionInactive {
+		return apperror.BadRequest("This sanction is no longer active")
+	}
+	if err != nil {
+		return apperror.Internal(err, "Failed to lift the sanction")
+	}
+
+	message := fmt.Sprintf("A moderator lifted your %s.", sanction.Kind)
+	if err := database.CreateNotification(sanction.UserID, message); err != nil {
+		logging.FromContext(r.Context()).Error("failed to notify user", "user_id", sanction.UserID, "err", err)
+	}
+	if err := recordModAction(r, database.ModSanctionRevoke, "user", sanction.UserID,
+		map[string]interface{}{"sanction_id": sanction.ID, "kind": sanction.Kind, "expires_at": sanction.ExpiresAt}, nil, ""); err != nil {
+		return err
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/moderator/users/%d", sanction.UserID), http.StatusSeeOther)
+	return nil
+}
+
+// ActiveSanctionsHandler lists the suspensions and bans in force.
+func ActiveSanctionsHandler(w http.ResponseWriter, r *http.Request) error {
+	sanctions, err := database.ListActiveSanctions()
+	if err != nil {
+		return apperror.Internal(err, "Failed to fetch sanctions")
+	}
+	data := struct {
+		Sanctions []models.Sanction
+	}{
+		Sanctions: sanctions,
+	}
+	return renderTemplate(w, r, moderatorSanctionsPage, data)
+}
+
+// BannedHandler explains to a banned user why their account is banned.
+// Users without an active ban are sent to the index.
+func BannedHandler(w http.ResponseWriter, r *http.Request) error {
+	userID, ok := appellantID(r)
+	if !ok {
+		http.Redirect(w, r, "/index", http.StatusSeeOther)
+		return nil
+	}
+	ban, err := database.ActiveBan(userID)
+	if err == sql.ErrNoRows {
+		http.Redirect(w, r, "/index", http.StatusSeeOther)
+		return nil
+	}
+	if err != nil {
+		return apperror.Internal(err, "Failed to check account status")
+	}
+	return renderBanNotice(w, r, ban)
+}
+
+func renderBanNotice(w http.ResponseWriter, r *http.Request, ban models.Sanction) error {
+	return renderTemplateStatus(w, r, http.StatusForbidden, bannedPage, struct {
+		Ban models.Sanction
+	}{
+		Ban: ban,
+	})
+}
+
+// checkRegistrationBan refuses to create an account from a banned IP address
+// or email domain.
+func checkRegistrationBan(r *http.Request, email string) error {
+	domain := ""
+	if at := strings.LastIndex(email, "@"); at >= 0 {
+		domain = strings.ToLower(email[at+1:])
+	}
+	_, err := database.MatchRegistrationBan(middleware.ClientIP(r), domain)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return apperror.Internal(err, "Failed to check registration bans")
+	}
+	return apperror.Forbidden("Registration is not possible from this network or email domain")
+}
+
+// RegistrationBansHandler lists the IP addresses and email domains that may
+// not register and adds new ones.
+func RegistrationBansHandler(w http.ResponseWriter, r *http.Request) error {
+	if r.Method == "POST" {
+		kind := r.FormValue("kind")
+		value := strings.ToLower(strings.TrimSpace(r.FormValue("value")))
+		reason := strings.TrimSpace(r.FormValue("reason"))
+		fields := map[string]string{}
+		validateText(fields, "reason", reason, maxSanctionReasonLength)
+		switch kind {
+		case database.RegistrationBanIP:
+			if ip := net.ParseIP(value); ip == nil {
+				fields["value"] = "must be an IP address"
+			} else {
+				value = ip.String()
+			}
+		case database.RegistrationBanEmailDomain:
+			value = strings.TrimPrefix(value, "@")
+			if value == "" || strings.ContainsAny(value, "@ ") || !strings.Contains(value, ".") {
+				fields["value"] = "must be a domain such as example.com"
+			}
+		default:
+			fields["kind"] = "must be ip or email_domain"
+		}
+		days, err := strconv.Atoi(r.FormValue("days"))
+		if err != nil || days < 0 || days > maxBanDays {
+			fields["days"] = fmt.Sprintf("must be between 0 and %d", maxBanDays)
+		}
+		if len(fields) > 0 {
+			return apperror.Invalid(fields)
+		}
+
+		session, _ := session.Store.Get(r, "session-name")
+		expires := sanctionExpiry(days)
+		if err := database.CreateRegistrationBan(kind, value, reason, session.Values["userID"].(int), expires); err != nil {
+			return apperror.Internal(err, "Failed to save the registration ban")
+		}
+		if err := recordModAction(r, database.ModRegistrationBanCreate, "registration_ban", 0, nil,
+			map[string]interface{}{"kind": kind, "value": value, "expires_at": expires}, reason); err != nil {
+			return err
+		}
+		http.Redirect(w, r, "/admin/registration-bans", http.StatusSeeOther)
+		return nil
+	}
+
+	bans, err := database.ListRegistrationBans()
+	if err != nil {
+		return apperror.Internal(err, "Failed to fetch registration bans")
+	}
+	data := struct {
+		Bans      []models.RegistrationBan
+		Durations []int
+	}{
+		Bans:      bans,
+		Durations: sanctionDurations,
+	}
+	return renderTemplate(w, r, adminRegistrationBansPage, data)
+}
+
+// DeleteRegistrationBanHandler lifts a registration ban.
+func DeleteRegistrationBanHandler(w http.ResponseWriter, r *http.Request) error {
+	banID, err := pathID(r, "id")
+	if err != nil {
+		return err
+	}
+	ban, err := database.GetRegistrationBan(banID)
+	if err == sql.ErrNoRows {
+		return apperror.NotFound("Registration ban not found")
+	}
+	if err != nil {
+		return apperror.Internal(err, "Failed to fetch the registration ban")
+	}
+	if err := database.DeleteRegistrationBan(banID); err != nil {
+		return apperror.Internal(err, "Failed to delete the registration ban")
+	}
+	if err := recordModAction(r, database.ModRegistrationBanDelete, "registration_ban", banID,
+		map[string]interface{}{"kind": ban.Kind, "value": ban.Value, "expires_at": ban.ExpiresAt}, nil, ""); err != nil {
+		return err
+	}
+	http.Redirect(w, r, "/admin/registration-bans", http.StatusSeeOther)
+	return nil
+}
@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+
+	"DytForum/apperror"
+	"DytForum/automod"
+	"DytForum/database"
+	"DytForum/logging"
+	"DytForum/spam"
+)
+
+// spamCheck is the spam classifier's verdict on a new post.
+type spamCheck struct {
+	// Score is from 0 to 100, or nil while the classifier has too few
+	// examples to judge.
+	Score *int
+	Hold  bool
+}
+
+// checkSpam scores a new post. A failure is logged and leaves the post
+// unscored so a broken filter cannot stop people from posting.
+func checkSpam(r *http.Request, title, content string) spamCheck {
+	var check spamCheck
+	tokens := spam.Tokens(database.SpamText(title, content))
+	model, err := database.SpamModel(tokens)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("failed to load spam model", "err", err)
+		return check
+	}
+	if !model.Ready() {
+		return check
+	}
+	threshold, err := database.SpamThreshold()
+	if err != nil {
+		logging.FromContext(r.Context()).Error("failed to read spam threshold", "err", err)
+	}
+	score := int(math.Round(model.Score(tokens) * 100))
+	check.Score = &score
+	check.Hold = score >= threshold
+	return check
+}
+
+// holdSpam holds a post the classifier judged to be spam unless the rules
+// already decided something stricter.
+func (d *automodDecision) holdSpam(check spamCheck) {
+	if check.Hold && (d.Visibility == "" || d.Visibility == automod.ActionApprove) {
+		d.Visibility = automod.ActionHold
+	}
+}
+
+// saveSpamScore stores the score of a post that was just created.
+func saveSpamScore(r *http.Request, check spamCheck, targetType string, targetID int) {
+	if check.Score == nil {
+		return
+	}
+	if err := database.SetSpamScore(targetType, targetID, *check.Score); err != nil {
+		logging.FromContext(r.Context()).Error("failed to save spam score", "target_type", targetType, "target_id", targetID, "err", err)
+	}
+}
+
+// trainSpam teaches the classifier a moderator's decision. Failures are
+// logged since the decision itself has been saved.
+func trainSpam(r *http.Request, targetType string, targetID int, isSpam bool, title, content string) {
+	if err := database.TrainSpam(targetType, targetID, isSpam, database.SpamText(title, content)); err != nil {
+		logging.FromContext(r.Context()).Error("failed to train spam filter", "target_type", targetType, "target_id", targetID, "err", err)
+	}
+}
+
+// SpamFilterHandler shows what the spam classifier has learned and sets the
+// score from which posts are held.
+func SpamFilterHandler(w http.ResponseWriter, r *http.Request) error {
+	threshold, err := database.SpamThreshold()
+	if err != nil {
+		return apperror.Internal(err, "Failed to read spam threshold")
+	}
+
+	if r.Method == "POST" {
+		value, err := strconv.Atoi(r.FormValue("threshold"))
+		if err != nil || value < 1 || value > 100 {
+			return apperror.Invalid(map[string]string{"threshold": "must be a number from 1 to 100"})
+		}
+		if err := database.SetSetting(database.SettingSpamThreshold, strconv.Itoa(value)); err != nil {
+			return apperror.Internal(err, "Failed to update spam threshold")
+		}
+		if err := recordModAction(r, database.ModSpamThreshold, "setting", 0,
+			map[string]int{database.SettingSpamThreshold: threshold}, map[string]int{database.SettingSpamThreshold: value}, ""); err != nil {
+			return err
+		}
+		http.Redirect(w, r, "/admin/spam", http.StatusSeeOther)
+		return nil
+	}
+
+	docs, tokens, err := database.SpamStats()
+	if err != nil {
+		return apperror.Internal(err, "Failed to fetch spam filter statistics")
+	}
+	return renderTemplate(w, r, adminSpamPage, struct {
+		Docs        spam.Counts
+		Tokens      int
+		Ready       bool
+		MinExamples int
+		Threshold   int
+	}{
+		Docs:        docs,
+		Tokens:      tokens,
+		Ready:       spam.Model{Docs: docs}.Ready(),
+		MinExamples: spam.MinExamples,
+		Threshold:   threshold,
+	})
+}
+
+// RetrainSpamHandler rebuilds the classifier from the recorded decisions,
+// optionally adding every approved and rejected thread first.
+func RetrainSpamHandler(w http.ResponseWriter, r *http.Request) error {
+	history := r.FormValue("history") != ""
+	docs, err := database.RetrainSpam(history)
+	if err != nil {
+		return apperror.Internal(err, "Failed to retrain spam filter")
+	}
+	if err := recordModAction(r, database.ModSpamRetrain, "setting", 0, nil,
+		map[string]interface{}{"history": history, "spam": docs.Spam, "ham": docs.Ham}, ""); err != nil {
+		return err
+	}
+
+	http.Redirect(w, r, "/admin/spam", http.StatusSeeOther)
+	return nil
+}
@@ -2,97 +2,103 @@
 package handlers
 
 import (
+	"database/sql"
 	"fmt"
-	"html/template"
-	"log"
 	"net/http"
 	"strconv"
+	"strings"
 
+	"DytForum/apperror"
 	"DytForum/database"
 	"DytForum/models"
+	"DytForum/permissions"
 	"DytForum/session"
 )
 
 // CreateThreadHandler handles the creation of a new thread.
-func CreateThreadHandler(w http.ResponseWriter, r *http.Request) {
+func CreateThreadHandler(w http.ResponseWriter, r *http.Request) error {
 	session, _ := session.Store.Get(r, "session-name")
-	role := session.Values["role"]
-	if role != "user" && role != "moderator" {
-		http.Error(w, "You must be logged in to create a thread", http.StatusUnauthorized)
-		return
-	}
 
 	if r.Method == "GET" {
 		categories, err := fetchCategories()
 		if err != nil {
-			http.Error(w, "Failed to fetch categories", http.StatusInternalServerError)
-			return
+			return apperror.Internal(err, "Failed to fetch categories")
 		}
 
-		tmpl := template.Must(template.ParseFiles("templates/create_thread.html"))
 		data := struct {
 			Categories []models.Category
 		}{
 			Categories: categories,
 		}
-		tmpl.Execute(w, data)
+		return renderTemplate(w, r, createThreadPage, data)
 	} else if r.Method == "POST" {
 		title := r.FormValue("title")
 		content := r.FormValue("content")
 		categoryID, err := strconv.Atoi(r.FormValue("category"))
 		if err != nil {
-			http.Error(w, "Invalid category ID", http.StatusBadRequest)
-			return
+			return apperror.BadRequest("Invalid category ID")
 		}
 		userID := session.Values["userID"].(int)
+		if err := checkCanPost(userID); err != nil {
+			return err
+		}
 
-		_, err = database.DB.Exec("INSERT INTO threads (category, title, content, user_id) VALUES (?, ?, ?, ?)", categoryID, title, content, userID)
-		if err != nil {
-			http.Error(w, "Failed to create thread", http.StatusInternalServerError)
-			return
+		if _, err := createThread(r, userID, categoryID, title, content); err != nil {
+			return err
 		}
+		postsTotal.Inc("thread")
 
 		http.Redirect(w, r, "/index", http.StatusSeeOther)
 	}
+	return nil
 }
 
 // ViewThreadHandler handles the display of a thread with its comments.
 
-func ViewThreadHandler(w http.ResponseWriter, r *http.Request) {
+func ViewThreadHandler(w http.ResponseWriter, r *http.Request) error {
 	threadIDStr := r.URL.Query().Get("id")
 	if threadIDStr == "" {
-		http.Error(w, "Missing thread ID", http.StatusBadRequest)
-		return
+		return apperror.BadRequest("Missing thread ID")
 	}
 
 	threadID, err := strconv.Atoi(threadIDStr)
 	if err != nil {
-		http.Error(w, "Invalid thread ID", http.StatusBadRequest)
-		return
+		return apperror.BadRequest("Invalid thread ID")
 	}
 
 	// Fetch the thread details
 	var thread models.Thread
-	err = database.DB.QueryRow("SELECT id, title, content, category, likes, dislikes, user_id, approved FROM threads WHERE id = ?", threadID).Scan(&thread.ID, &thread.Title, &thread.Content, &thread.Category, &thread.Likes, &thread.Dislikes, &thread.UserID, &thread.Approved)
+	err = database.DB.QueryRow("SELECT id, title, content, "+database.ThreadCategoryName+", likes, dislikes, user_id, approved, review_note FROM threads WHERE id = ?", threadID).Scan(&thread.ID, &thread.Title, &thread.Content, &thread.Category, &thread.Likes, &thread.Dislikes, &thread.UserID, &thread.Approved, &thread.ReviewNote)
+	if err == sql.ErrNoRows {
+		return apperror.NotFound("Thread not found")
+	}
 	if err != nil {
-		http.Error(w, "Failed to fetch thread details", http.StatusInternalServerError)
-		return
+		return apperror.Internal(err, "Failed to fetch thread details")
+	}
+	isAuthor := currentUserID(r) == thread.UserID
+	if thread.Approved != database.ThreadApproved && !isAuthor {
+		visible, err := currentUserModeratesThread(r, threadID)
+		if err != nil {
+			return apperror.Internal(err, "Failed to check permissions")
+		}
+		if !visible {
+			return apperror.NotFound("Thread not found")
+		}
 	}
 
 	// Fetch the comments for the thread
-	rows, err := database.DB.Query("SELECT id, user_id, content, username, likes, dislikes FROM comments WHERE thread_id = ?", threadID)
+	rows, err := database.DB.Query("SELECT id, user_id, content, username, likes, dislikes, approved FROM comments WHERE thread_id = ? AND (approved = ? OR user_id = ?)",
+		threadID, database.CommentVisible, currentUserID(r))
 	if err != nil {
-		http.Error(w, "Failed to fetch comments", http.StatusInternalServerError)
-		return
+		return apperror.Internal(err, "Failed to fetch comments")
 	}
 	defer rows.Close()
 
 	var comments []models.Comment
 	for rows.Next() {
 		var comment models.Comment
-		if err := rows.Scan(&comment.ID, &comment.UserID, &comment.Content, &comment.Username, &comment.Likes, &comment.Dislikes); err != nil {
-			http.Error(w, "Failed to scan comment", http.StatusInternalServerError)
-			return
+		if err := rows.Scan(&comment.ID, &comment.UserID, &comment.Content, &comment.Username, &comment.Likes, &comment.Dislikes, &comment.Approved); err != nil {
+			return apperror.Internal(err, "Failed to scan comment")
 		}
 		comment.ThreadID = threadID
 		comments = append(comments, comment)
@@ -102,42 +108,91 @@ func ViewThreadHandler(w http.ResponseWriter, r *http.Request) {
 	var creatorUsername string
 	err = database.DB.QueryRow("SELECT username FROM users WHERE id = ?", thread.UserID).Scan(&creatorUsername)
 	if err != nil {
-		http.Error(w, "Failed to fetch thread creator username", http.StatusInternalServerError)
-		return
+		return apperror.Internal(err, "Failed to fetch thread creator username")
 	}
 
 	// Construct the file path for the picture
 	picturePath := fmt.Sprintf("static/uploads/%d.jpg", threadID)
 
+	reasons, err := database.ListReportReasons()
+	if err != nil {
+		return apperror.Internal(err, "Failed to fetch report reasons")
+	}
+
 	// Render the thread page with comments
 	data := struct {
-		Thread       models.Thread
-		Comments     []models.Comment
-		Username     string
-		PicturePath  string
-		PictureError string
+		Thread        models.Thread
+		Comments      []models.Comment
+		Username      string
+		PicturePath   string
+		PictureError  string
+		CanReport     bool
+		ReportReasons []models.ReportReason
+		IsAuthor      bool
 	}{
-		Thread:      thread,
-		Comments:    comments,
-		Username:    creatorUsername,
-		PicturePath: picturePath,
+		Thread:        thread,
+		Comments:      comments,
+		Username:      creatorUsername,
+		PicturePath:   picturePath,
+		CanReport:     currentUserCan(r, permissions.ReportCreate),
+		ReportReasons: reasons,
+		IsAuthor:      isAuthor,
 	}
-	err = renderTemplate(w, "threads.html", data)
-	if err != nil {
-		http.Error(w, "Failed to render template", http.StatusInternalServerError)
+	return renderTemplate(w, r, threadPage, data)
+}
+
+// currentUserID returns the logged in user's ID, or zero.
+func currentUserID(r *http.Request) int {
+	session, _ := session.Store.Get(r, "session-name")
+	if auth, _ := session.Values["authenticated"].(bool); !auth {
+		return 0
 	}
+	userID, _ := session.Values["userID"].(int)
+	return userID
 }
 
-// renderTemplate renders the HTML templates.
-func renderTemplate(w http.ResponseWriter, tmpl string, data interface{}) error {
-	templates, err := template.ParseGlob("templates/*.html")
+// currentUserModeratesThread reports whether the current user reviews threads
+// in the thread's category, so may see it before it is approved.
+func currentUserModeratesThread(r *http.Request, threadID int) (bool, error) {
+	if currentUserID(r) == 0 || !currentUserCan(r, permissions.ThreadApprove) {
+		return false, nil
+	}
+	return currentModerationScope(r).allowsThread(threadID)
+}
+
+// ResubmitThreadHandler lets the author revise a thread a moderator asked
+// changes for. The revised thread goes back to the approval queue.
+func ResubmitThreadHandler(w http.ResponseWriter, r *http.Request) error {
+	threadID, err := pathID(r, "id")
 	if err != nil {
-		log.Printf("Error parsing templates: %v", err)
 		return err
 	}
-	err = templates.ExecuteTemplate(w, tmpl, data)
+	thread, err := database.GetThread(threadID)
+	if err == sql.ErrNoRows || (err == nil && thread.UserID != currentUserID(r)) {
+		return apperror.NotFound("Thread not found")
+	}
 	if err != nil {
-		log.Printf("Error executing template: %v", err)
+		return apperror.Internal(err, "Failed to fetch thread")
+	}
+	if thread.Approved != database.ThreadChangesRequested {
+		return apperror.BadRequest("Only threads a moderator asked changes for can be resubmitted")
+	}
+	if err := checkCanPost(thread.UserID); err != nil {
+		return err
+	}
+
+	title := strings.TrimSpace(r.FormValue("title"))
+	content := strings.TrimSpace(r.FormValue("content"))
+	fields := map[string]string{}
+	validateText(fields, "title", title, maxTitleLength)
+	validateText(fields, "content", content, maxContentLength)
+	if len(fields) > 0 {
+		return apperror.Invalid(fields)
+	}
+
+	if err := database.UpdateThread(threadID, title, content); err != nil {
+		return apperror.Internal(err, "Failed to update thread")
 	}
-	return err
+	http.Redirect(w, r, fmt.Sprintf("/thread?id=%d", threadID), http.StatusSeeOther)
+	return nil
 }
@@ -1,7 +1,7 @@
 package handlers
 
 import (
-	"log"
+	"log/slog"
 
 	"DytForum/database"
 	"DytForum/models"
@@ -13,13 +13,13 @@ func getThreadsByUser(username string) []models.Thread {
 	var userID int
 	err := database.DB.QueryRow(`SELECT id FROM users WHERE username = ?`, username).Scan(&userID)
 	if err != nil {
-		log.Printf("Failed to get user ID: %v", err)
+		slog.Error("failed to get user ID", "username", username, "err", err)
 		return nil
 	}
 
-	rows, err := database.DB.Query(`SELECT id, category, title, content, likes, dislikes FROM threads WHERE user_id = ?`, userID)
+	rows, err := database.DB.Query("SELECT id, "+database.ThreadCategoryName+", title, content, likes, dislikes FROM threads WHERE user_id = ?", userID)
 	if err != nil {
-		log.Printf("Failed to query threads: %v", err)
+		slog.Error("failed to query threads", "err", err)
 		return nil
 	}
 	defer rows.Close()
@@ -28,14 +28,14 @@ func getThreadsByUser(username string) []models.Thread {
 	for rows.Next() {
 		var thread models.Thread
 		if err := rows.Scan(&thread.ID, &thread.Category, &thread.Title, &thread.Content, &thread.Likes, &thread.Dislikes); err != nil {
-			log.Printf("Failed to scan thread: %v", err)
+			slog.Error("failed to scan thread", "err", err)
 			continue
 		}
 		threads = append(threads, thread)
 	}
 
 	if err := rows.Err(); err != nil {
-		log.Printf("Error iterating over thread rows: %v", err)
+		slog.Error("failed to iterate over threads", "err", err)
 	}
 
 	return threads
@@ -46,13 +46,13 @@ func getCommentsByUser(username string) []models.Comment {
 	var userID int
 	err := database.DB.QueryRow(`SELECT id FROM users WHERE username = ?`, username).Scan(&userID)
 	if err != nil {
-		log.Printf("Failed to get user ID: %v", err)
+		slog.Error("failed to get user ID", "username", username, "err", err)
 		return nil
 	}
 
 	rows, err := database.DB.Query(`SELECT c.id, c.thread_id, c.content FROM comments c INNER JOIN threads t ON c.thread_id = t.id WHERE t.user_id = ? AND c.user_id = ?`, userID, userID)
 	if err != nil {
-		log.Printf("Failed to query comments: %v", err)
+		slog.Error("failed to query comments", "err", err)
 		return nil
 	}
 	defer rows.Close()
@@ -61,14 +61,14 @@ func getCommentsByUser(username string) []models.Comment {
 	for rows.Next() {
 		var comment models.Comment
 		if err := rows.Scan(&comment.ID, &comment.ThreadID, &comment.Content); err != nil {
-			log.Printf("Failed to scan comment: %v", err)
+			slog.Error("failed to scan comment", "err", err)
 			continue
 		}
 		comments = append(comments, comment)
 	}
 
 	if err := rows.Err(); err != nil {
-		log.Printf("Error iterating over comment rows: %v", err)
+		slog.Error("failed to iterate over comments", "err", err)
 	}
 
 	return comments
@@ -0,0 +1,403 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"DytForum/apperror"
+	"DytForum/database"
+	"DytForum/logging"
+	"DytForum/middleware"
+	"DytForum/models"
+	"DytForum/permissions"
+	"DytForum/session"
+	"DytForum/totp"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	twoFactorIssuer    = "DytForum"
+	recoveryCodeCount  = 10
+	pending2FAKey      = "pending2FAUserID"
+	require2FASetupKey = "require2FASetup"
+)
+
+// beginLogin is called once a user's password has been verified. Users with
+// 2FA enabled are sent to the second login step, everyone else is logged in.
+func beginLogin(w http.ResponseWriter, r *http.Request, userID int, username, role string) error {
+	tf, err := database.GetTwoFactor(userID)
+	if err != nil {
+		return err
+	}
+
+	if tf.Enabled {
+		session, _ := session.Store.Get(r, "session-name")
+		session.Values["authenticated"] = false
+		session.Values[pending2FAKey] = userID
+		if err := session.Save(r, w); err != nil {
+			return err
+		}
+		http.Redirect(w, r, "/login/2fa", http.StatusSeeOther)
+		return nil
+	}
+
+	return completeLogin(w, r, userID, username, role)
+}
+
+// completeLogin records the successful login, stores the authenticated user in
+// the session and redirects to the landing page for their role. Staff accounts without 2FA are sent to
+// enrollment when the admin policy requires it.
+func completeLogin(w http.ResponseWriter, r *http.Request, userID int, username, role string) error {
+	session, _ := session.Store.Get(r, "session-name")
+	delete(session.Values, pending2FAKey)
+	session.Values["authenticated"] = true
+	session.Values["username"] = username
+	session.Values["userID"] = userID
+	session.Values["role"] = role
+
+	if err := recordLoginSuccess(userID, username, middleware.ClientIP(r)); err != nil {
+		logging.FromContext(r.Context()).Error("failed to record login", "user_id", userID, "err", err)
+	}
+	loginsTotal.Inc("password")
+
+	mustEnroll := false
+	if isStaffRole(role) {
+		tf, err := database.GetTwoFactor(userID)
+		if err != nil {
+			return err
+		}
+		required, err := database.GetBoolSetting(database.SettingRequireStaff2FA, false)
+		if err != nil {
+			return err
+		}
+		mustEnroll = required && !tf.Enabled
+	}
+	if mustEnroll {
+		session.Values[require2FASetupKey] = true
+	} else {
+		delete(session.Values, require2FASetupKey)
+	}
+
+	logging.FromContext(r.Context()).Info("login successful", "username", username, "user_id", userID, "role", role)
+	if err := session.Save(r, w); err != nil {
+		return err
+	}
+
+	switch {
+	case mustEnroll:
+		http.Redirect(w, r, "/account/2fa", http.StatusSeeOther)
+	case role == "admin":
+		http.Redirect(w, r, "/admin/panel", http.StatusSeeOther)
+	default:
+		http.Redirect(w, r, "/index", http.StatusSeeOther)
+	}
+	return nil
+}
+
+// isStaffRole reports whether role can open the moderator or admin panel,
+// which is what the staff 2FA policy applies to.
+func isStaffRole(role string) bool {
+	for _, perm := range []string{permissions.ModerationPanel, permissions.AdminPanel} {
+		if allowed, err := database.RoleHasPermission(role, perm); err == nil && allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// generateRecoveryCodes returns n random codes formatted as xxxx-xxxx.
+func generateRecoveryCodes(n int) ([]string, error) {
+	codes := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		buf := make([]byte, 5)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, err
+		}
+		code := strings.ToLower(base32.StdEncoding.EncodeToString(buf))
+		codes = append(codes, code[:4]+"-"+code[4:])
+	}
+	return codes, nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	code = strings.ReplaceAll(code, " ", "")
+	if len(code) == 8 && !strings.Contains(code, "-") {
+		code = code[:4] + "-" + code[4:]
+	}
+	return code
+}
+
+// checkTwoFactorCode reports whether code is a valid authenticator code for
+// the user that was not used before.
+func checkTwoFactorCode(tf models.TwoFactor, code string) (bool, error) {
+	step, ok := totp.ValidateAfter(tf.Secret, code, time.Now(), tf.LastStep)
+	if !ok {
+		return false, nil
+	}
+	return database.UseTwoFactorStep(tf.UserID, step)
+}
+
+// TwoFactorSetupHandler shows the 2FA status of the current user and handles
+// enrollment. GET creates a secret for users that are not enrolled yet and
+// keeps showing it until POST confirms it with a code from the authenticator
+// app, so reloading the page does not break an app that already scanned it.
+func TwoFactorSetupHandler(w http.ResponseWriter, r *http.Request) error {
+	session, _ := session.Store.Get(r, "session-name")
+	userID, ok := session.Values["userID"].(int)
+	if !ok {
+		return apperror.Unauthorized("You must be logged in to access this page")
+	}
+	username, _ := session.Values["username"].(string)
+	role, _ := session.Values["role"].(string)
+
+	tf, err := database.GetTwoFactor(userID)
+	if err != nil {
+		return apperror.Internal(err, "Failed to load two-factor settings")
+	}
+
+	if r.Method == "POST" {
+		if tf.Enabled || tf.Secret == "" {
+			http.Redirect(w, r, "/account/2fa", http.StatusSeeOther)
+			return nil
+		}
+		valid, err := checkTwoFactorCode(tf, r.FormValue("code"))
+		if err != nil {
+			return apperror.Internal(err, "Failed to check authentication code")
+		}
+		if !valid {
+			return apperror.BadRequest("Invalid authentication code")
+		}
+
+		codes, err := generateRecoveryCodes(recoveryCodeCount)
+		if err != nil {
+			return apperror.Internal(err, "Failed to generate recovery codes")
+		}
+		if err := database.EnableTwoFactor(userID, codes); err != nil {
+			return apperror.Internal(err, "Failed to enable two-factor authentication")
+		}
+
+		delete(session.Values, require2FASetupKey)
+		if err := session.Save(r, w); err != nil {
+			return apperror.Internal(err, "Failed to save session")
+		}
+
+		return renderTemplate(w, r, twoFactorRecoveryPage, struct{ Codes []string }{Codes: codes})
+	}
+
+	required, err := database.GetBoolSetting(database.SettingRequireStaff2FA, false)
+	if err != nil {
+		return apperror.Internal(err, "Failed to load two-factor settings")
+	}
+
+	data := struct {
+		Enabled       bool
+		Secret        string
+		URI           string
+		RecoveryLeft  int
+		Required      bool
+		MustEnrollNow bool
+	}{
+		Enabled:       tf.Enabled,
+		Required:      required && isStaffRole(role),
+		MustEnrollNow: session.Values[require2FASetupKey] == true,
+	}
+
+	if tf.Enabled {
+		data.RecoveryLeft, err = database.CountRecoveryCodes(userID)
+		if err != nil {
+			return apperror.Internal(err, "Failed to load recovery codes")
+		}
+	} else {
+		secret := tf.Secret
+		if secret == "" {
+			secret, err = totp.GenerateSecret()
+			if err != nil {
+				return apperror.Internal(err, "Failed to generate secret")
+			}
+			if err := database.SaveTwoFactorSecret(userID, secret); err != nil {
+				return apperror.Internal(err, "Failed to start two-factor enrollment")
+			}
+		}
+		data.Secret = secret
+		data.URI = totp.ProvisioningURI(twoFactorIssuer, username, secret)
+	}
+
+	return renderTemplate(w, r, twoFactorSetupPage, data)
+}
+
+// TwoFactorDisableHandler turns off 2FA for the current user after checking a
+// current code. Staff cannot disable 2FA while the admin policy requires it.
+func TwoFactorDisableHandler(w http.ResponseWriter, r *http.Request) error {
+	session, _ := session.Store.Get(r, "session-name")
+	userID, ok := session.Values["userID"].(int)
+	if !ok {
+		return apperror.Unauthorized("You must be logged in to access this page")
+	}
+	role, _ := session.Values["role"].(string)
+
+	required, err := database.GetBoolSetting(database.SettingRequireStaff2FA, false)
+	if err != nil {
+		return apperror.Internal(err, "Failed to load two-factor settings")
+	}
+	if required && isStaffRole(role) {
+		return apperror.Forbidden("Two-factor authentication is required for your role")
+	}
+
+	tf, err := database.GetTwoFactor(userID)
+	if err != nil {
+		return apperror.Internal(err, "Failed to load two-factor settings")
+	}
+	if !tf.Enabled {
+		return apperror.BadRequest("Invalid authentication code")
+	}
+	valid, err := checkTwoFactorCode(tf, r.FormValue("code"))
+	if err != nil {
+		return apperror.Internal(err, "Failed to check authentication code")
+	}
+	if !valid {
+		return apperror.BadRequest("Invalid authentication code")
+	}
+
+	if err := database.DisableTwoFactor(userID); err != nil {
+		return apperror.Internal(err, "Failed to disable two-factor authentication")
+	}
+
+	http.Redirect(w, r, "/account/2fa", http.StatusSeeOther)
+	return nil
+}
+
+// TwoFactorRecoveryCodesHandler replaces the recovery codes of the current
+// user after checking a current code and shows the new set once.
+func TwoFactorRecoveryCodesHandler(w http.ResponseWriter, r *http.Request) error {
+	session, _ := session.Store.Get(r, "session-name")
+	userID, ok := session.Values["userID"].(int)
+	if !ok {
+		return apperror.Unauthorized("You must be logged in to access this page")
+	}
+
+	tf, err := database.GetTwoFactor(userID)
+	if err != nil {
+		return apperror.Internal(err, "Failed to load two-factor settings")
+	}
+	if !tf.Enabled {
+		return apperror.BadRequest("Invalid authentication code")
+	}
+	valid, err := checkTwoFactorCode(tf, r.FormValue("code"))
+	if err != nil {
+		return apperror.Internal(err, "Failed to check authentication code")
+	}
+	if !valid {
+		return apperror.BadRequest("Invalid authentication code")
+	}
+
+	codes, err := generateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return apperror.Internal(err, "Failed to generate recovery codes")
+	}
+	if err := database.EnableTwoFactor(userID, codes); err != nil {
+		return apperror.Internal(err, "Failed to store recovery codes")
+	}
+
+	return renderTemplate(w, r, twoFactorRecoveryPage, struct{ Codes []string }{Codes: codes})
+}
+
+// TwoFactorLoginHandler is the second login step for users with 2FA enabled.
+// It accepts either a TOTP code or one of the user's recovery codes.
+func TwoFactorLoginHandler(w http.ResponseWriter, r *http.Request) error {
+	session, _ := session.Store.Get(r, "session-name")
+	userID, ok := session.Values[pending2FAKey].(int)
+	if !ok {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return nil
+	}
+
+	var username, role string
+	err := database.DB.QueryRow("SELECT username, role FROM users WHERE id = ?", userID).Scan(&username, &role)
+	if err != nil {
+		return apperror.Internal(err, "Failed to retrieve user")
+	}
+
+	page := twoFactorLoginPage
+	if r.Method == "GET" {
+		return renderLoginForm(w, r, page, http.StatusOK, "", false)
+	}
+
+	ip := middleware.ClientIP(r)
+	if ok, err := checkLoginThrottle(w, r, page, username, ip); !ok {
+		return err
+	}
+
+	tf, err := database.GetTwoFactor(userID)
+	if err != nil {
+		return apperror.Internal(err, "Failed to load two-factor settings")
+	}
+
+	valid := false
+	if code := r.FormValue("code"); code != "" {
+		if tf.Enabled {
+			valid, err = checkTwoFactorCode(tf, code)
+			if err != nil {
+				return apperror.Internal(err, "Failed to check authentication code")
+			}
+		}
+	} else if code := r.FormValue("recovery_code"); code != "" {
+		if tf.Enabled {
+			valid, err = database.UseRecoveryCode(userID, normalizeRecoveryCode(code))
+			if err != nil {
+				return apperror.Internal(err, "Failed to check recovery code")
+			}
+		}
+	}
+	if !valid {
+		if err := recordLoginFailure(username, ip); err != nil {
+			logging.FromContext(r.Context()).Error("failed to record login failure", "username", username, "err", err)
+		}
+		needChallenge, _ := challengeRequired(accountThrottleKey(username), ipThrottleKey(ip))
+		return renderLoginForm(w, r, page, http.StatusUnauthorized, "Invalid authentication code", needChallenge)
+	}
+
+	if err := completeLogin(w, r, userID, username, role); err != nil {
+		return apperror.Internal(err, "Failed to save session")
+	}
+	return nil
+}
+
+// ResetTwoFactorHandler lets an admin remove 2FA from an account, e.g. when a
+// user lost both their device and their recovery codes.
+func ResetTwoFactorHandler(w http.ResponseWriter, r *http.Request) error {
+	vars := mux.Vars(r)
+	userID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		return apperror.BadRequest("Invalid user ID")
+	}
+
+	if err := database.DisableTwoFactor(userID); err != nil {
+		return apperror.Internal(err, "Failed to reset two-factor authentication")
+	}
+	if err := recordModAction(r, database.ModUserReset2FA, "user", userID, nil, nil, ""); err != nil {
+		return err
+	}
+
+	http.Redirect(w, r, "/admin/panel", http.StatusSeeOther)
+	return nil
+}
+
+// TwoFactorPolicyHandler toggles whether moderators and admins must use 2FA.
+func TwoFactorPolicyHandler(w http.ResponseWriter, r *http.Request) error {
+	required := r.FormValue("require_staff_2fa") == "on"
+	if err := database.SetBoolSetting(database.SettingRequireStaff2FA, required); err != nil {
+		return apperror.Internal(err, "Failed to update two-factor policy")
+	}
+	if err := recordModAction(r, database.ModTwoFactorPolicy, "setting", 0, nil, map[string]bool{database.SettingRequireStaff2FA: required}, ""); err != nil {
+		return err
+	}
+
+	http.Redirect(w, r, "/admin/panel", http.StatusSeeOther)
+	return nil
+}
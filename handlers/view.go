@@ -3,26 +3,25 @@ package handlers
 
 import (
 	"database/sql"
-	"html/template"
 	"net/http"
 
+	"DytForum/apperror"
 	"DytForum/database"
 	"DytForum/models"
 )
 
-func ViewThreadsHandler(w http.ResponseWriter, r *http.Request) {
+func ViewThreadsHandler(w http.ResponseWriter, r *http.Request) error {
 	category := r.URL.Query().Get("category")
 
 	var rows *sql.Rows
 	var err error
 	if category != "" {
-		rows, err = database.DB.Query("SELECT id, title, content, likes, dislikes FROM threads WHERE category = ?", category)
+		rows, err = database.DB.Query("SELECT id, title, content, likes, dislikes FROM threads WHERE "+database.ThreadCategoryName+" = ?", category)
 	} else {
 		rows, err = database.DB.Query("SELECT id, title, content, likes, dislikes FROM threads")
 	}
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		return apperror.Internal(err, "Failed to fetch threads")
 	}
 	defer rows.Close()
 
@@ -31,12 +30,10 @@ func ViewThreadsHandler(w http.ResponseWriter, r *http.Request) {
 		var thread models.Thread
 		err := rows.Scan(&thread.ID, &thread.Title, &thread.Content, &thread.Likes, &thread.Dislikes)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
+			return apperror.Internal(err, "Failed to fetch threads")
 		}
 		threads = append(threads, thread)
 	}
 
-	tmpl := template.Must(template.ParseFiles("templates/threads.html"))
-	tmpl.Execute(w, threads)
+	return renderTemplate(w, r, threadPage, threads)
 }
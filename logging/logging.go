@@ -0,0 +1,42 @@
+// Package logging configures the structured logger used by the server.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+
+	"DytForum/requestid"
+)
+
+// Setup makes a text or JSON slog handler writing to w the default logger.
+// Messages from the standard log package go through it as well.
+func Setup(w io.Writer, format, level string) error {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("invalid log level %q", level)
+	}
+	opts := &slog.HandlerOptions{Level: lvl}
+
+	var handler slog.Handler
+	switch strings.ToLower(format) {
+	case "text":
+		handler = slog.NewTextHandler(w, opts)
+	case "json":
+		handler = slog.NewJSONHandler(w, opts)
+	default:
+		return fmt.Errorf("invalid log format %q", format)
+	}
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
+
+// FromContext returns the default logger with the request ID of ctx, if any.
+func FromContext(ctx context.Context) *slog.Logger {
+	if id := requestid.FromContext(ctx); id != "" {
+		return slog.Default().With("request_id", id)
+	}
+	return slog.Default()
+}
@@ -0,0 +1,264 @@
+// Package metrics collects counters, gauges and histograms and writes them
+// in the Prometheus text exposition format.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultBuckets are the latency buckets in seconds used by the histograms.
+var DefaultBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type collector interface {
+	write(w io.Writer)
+}
+
+var (
+	mu         sync.Mutex
+	collectors []collector
+)
+
+func register(c collector) {
+	mu.Lock()
+	defer mu.Unlock()
+	collectors = append(collectors, c)
+}
+
+// Write writes every registered metric to w.
+func Write(w io.Writer) {
+	mu.Lock()
+	cs := append([]collector(nil), collectors...)
+	mu.Unlock()
+	for _, c := range cs {
+		c.write(w)
+	}
+}
+
+// CounterVec is a set of counters that share a name and differ by labels.
+type CounterVec struct {
+	name, help string
+	labels     []string
+
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+// NewCounterVec registers a counter with the given label names.
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{name: name, help: help, labels: labels, values: map[string]float64{}}
+	register(c)
+	return c
+}
+
+// Inc adds one to the counter with the given label values.
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds v to the counter with the given label values.
+func (c *CounterVec) Add(v float64, labelValues ...string) {
+	key := labelString(c.labels, labelValues)
+	c.mu.Lock()
+	c.values[key] += v
+	c.mu.Unlock()
+}
+
+func (c *CounterVec) write(w io.Writer) {
+	writeHeader(w, c.name, c.help, "counter")
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, key := range sortedKeys(c.values) {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, key, formatFloat(c.values[key]))
+	}
+}
+
+// Counter is a counter without labels.
+type Counter struct {
+	vec *CounterVec
+}
+
+// NewCounter registers a counter without labels.
+func NewCounter(name, help string) Counter {
+	return Counter{vec: NewCounterVec(name, help)}
+}
+
+func (c Counter) Inc() {
+	c.vec.Inc()
+}
+
+// HistogramVec is a set of histograms that share a name and buckets.
+type HistogramVec struct {
+	name, help string
+	labels     []string
+	buckets    []float64
+
+	mu     sync.Mutex
+	series map[string]*histogram
+}
+
+type histogram struct {
+	counts []uint64 // one per bucket, not cumulative
+	count  uint64
+	sum    float64
+}
+
+// NewHistogramVec registers a histogram with the given buckets and labels.
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{name: name, help: help, labels: labels, buckets: buckets, series: map[string]*histogram{}}
+	register(h)
+	return h
+}
+
+// Observe records v for the given label values.
+func (h *HistogramVec) Observe(v float64, labelValues ...string) {
+	key := labelString(h.labels, labelValues)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogram{counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+	for i, upper := range h.buckets {
+		if v <= upper {
+			s.counts[i]++
+			break
+		}
+	}
+	s.count++
+	s.sum += v
+}
+
+// ObserveDuration records d in seconds.
+func (h *HistogramVec) ObserveDuration(d time.Duration, labelValues ...string) {
+	h.Observe(d.Seconds(), labelValues...)
+}
+
+func (h *HistogramVec) write(w io.Writer) {
+	writeHeader(w, h.name, h.help, "histogram")
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	keys := make([]string, 0, len(h.series))
+	for key := range h.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := h.series[key]
+		var cumulative uint64
+		for i, upper := range h.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, withLabel(key, "le", formatFloat(upper)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, withLabel(key, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, key, formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, key, s.count)
+	}
+}
+
+// GaugeFunc is a gauge whose values are computed when metrics are scraped.
+type GaugeFunc struct {
+	name, help string
+	labels     []string
+	fn         func() ([]Sample, error)
+}
+
+// Sample is one value of a GaugeFunc with its label values.
+type Sample struct {
+	LabelValues []string
+	Value       float64
+}
+
+// NewGaugeFunc registers a gauge without labels computed by fn.
+func NewGaugeFunc(name, help string, fn func() (float64, error)) {
+	NewGaugeVecFunc(name, help, func() ([]Sample, error) {
+		v, err := fn()
+		return []Sample{{Value: v}}, err
+	})
+}
+
+// NewGaugeVecFunc registers a gauge with labels computed by fn. A gauge
+// whose function fails is left out of the output.
+func NewGaugeVecFunc(name, help string, fn func() ([]Sample, error), labels ...string) {
+	register(&GaugeFunc{name: name, help: help, labels: labels, fn: fn})
+}
+
+func (g *GaugeFunc) write(w io.Writer) {
+	samples, err := g.fn()
+	if err != nil {
+		return
+	}
+	writeHeader(w, g.name, g.help, "gauge")
+	for _, s := range samples {
+		fmt.Fprintf(w, "%s%s %s\n", g.name, labelString(g.labels, s.LabelValues), formatFloat(s.Value))
+	}
+}
+
+func writeHeader(w io.Writer, name, help, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, escapeHelp(help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, kind)
+}
+
+// labelString renders {a="x",b="y"}, or "" without labels.
+func labelString(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		value := ""
+		if i < len(values) {
+			value = values[i]
+		}
+		b.WriteString(name)
+		b.WriteString(`="`)
+		b.WriteString(escapeLabel(value))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// withLabel adds one more label to a rendered label string.
+func withLabel(labels, name, value string) string {
+	pair := name + `="` + value + `"`
+	if labels == "" {
+		return "{" + pair + "}"
+	}
+	return labels[:len(labels)-1] + "," + pair + "}"
+}
+
+func sortedKeys(m map[string]float64) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+var (
+	labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
+func escapeHelp(s string) string  { return helpEscaper.Replace(s) }
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,124 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCounterVecExposition(t *testing.T) {
+	tests := []struct {
+		name   string
+		labels []string
+		add    func(c *CounterVec)
+		want   string
+	}{
+		{
+			name: "without labels",
+			add: func(c *CounterVec) {
+				c.Inc()
+				c.Add(2.5)
+			},
+			want: `# HELP test_total Help text.
+# TYPE test_total counter
+test_total 3.5
+`,
+		},
+		{
+			name:   "sorted by labels",
+			labels: []string{"method", "code"},
+			add: func(c *CounterVec) {
+				c.Inc("POST", "200")
+				c.Inc("GET", "404")
+				c.Inc("GET", "200")
+				c.Inc("GET", "200")
+			},
+			want: `# HELP test_total Help text.
+# TYPE test_total counter
+test_total{method="GET",code="200"} 2
+test_total{method="GET",code="404"} 1
+test_total{method="POST",code="200"} 1
+`,
+		},
+		{
+			name:   "escaped label values",
+			labels: []string{"path"},
+			add: func(c *CounterVec) {
+				c.Inc("a\"b\\c\nd")
+			},
+			want: `# HELP test_total Help text.
+# TYPE test_total counter
+test_total{path="a\"b\\c\nd"} 1
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &CounterVec{name: "test_total", help: "Help text.", labels: tt.labels, values: map[string]float64{}}
+			tt.add(c)
+			var b strings.Builder
+			c.write(&b)
+			if got := b.String(); got != tt.want {
+				t.Errorf("got\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHistogramVecExposition(t *testing.T) {
+	tests := []struct {
+		name    string
+		labels  []string
+		observe func(h *HistogramVec)
+		want    string
+	}{
+		{
+			name: "cumulative buckets",
+			observe: func(h *HistogramVec) {
+				h.Observe(0.05)
+				h.Observe(0.1)
+				h.Observe(0.7)
+				h.Observe(3)
+			},
+			want: `# HELP test_seconds Help text.
+# TYPE test_seconds histogram
+test_seconds_bucket{le="0.1"} 2
+test_seconds_bucket{le="1"} 3
+test_seconds_bucket{le="+Inf"} 4
+test_seconds_sum 3.85
+test_seconds_count 4
+`,
+		},
+		{
+			name:   "le after the other labels",
+			labels: []string{"route"},
+			observe: func(h *HistogramVec) {
+				h.Observe(0.5, "/b")
+				h.Observe(0.01, "/a")
+			},
+			want: `# HELP test_seconds Help text.
+# TYPE test_seconds histogram
+test_seconds_bucket{route="/a",le="0.1"} 1
+test_seconds_bucket{route="/a",le="1"} 1
+test_seconds_bucket{route="/a",le="+Inf"} 1
+test_seconds_sum{route="/a"} 0.01
+test_seconds_count{route="/a"} 1
+test_seconds_bucket{route="/b",le="0.1"} 0
+test_seconds_bucket{route="/b",le="1"} 1
+test_seconds_bucket{route="/b",le="+Inf"} 1
+test_seconds_sum{route="/b"} 0.5
+test_seconds_count{route="/b"} 1
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &HistogramVec{name: "test_seconds", help: "Help text.", labels: tt.labels, buckets: []float64{0.1, 1}, series: map[string]*histogram{}}
+			tt.observe(h)
+			var b strings.Builder
+			h.write(&b)
+			if got := b.String(); got != tt.want {
+				t.Errorf("got\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,167 @@
+package middleware
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"strings"
+	"time"
+
+	"DytForum/apitoken"
+	"DytForum/apperror"
+	"DytForum/database"
+	"DytForum/logging"
+	"DytForum/permissions"
+	"DytForum/session"
+)
+
+// Principal is the user an API request acts for.
+type Principal struct {
+	UserID   int
+	Username string
+	Role     string
+	// Scopes is nil for session requests, which may do anything the role
+	// allows, and the token's scopes for bearer token requests.
+	Scopes []string
+}
+
+// HasScope reports whether the principal may act within scope.
+func (p Principal) HasScope(scope string) bool {
+	if p.Scopes == nil {
+		return true
+	}
+	for _, s := range p.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+type principalKey struct{}
+
+// PrincipalFrom returns the user APIAuth attached to the request, if any.
+func PrincipalFrom(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(Principal)
+	return p, ok
+}
+
+// tokenTouchInterval limits how often a token's last use is written.
+const tokenTouchInterval = time.Minute
+
+// APIAuth identifies the user behind an API request from a bearer token or,
+// without an Authorization header, the session cookie. Guests pass through
+// without a principal so public endpoints keep working; disabled accounts
+// are refused. The role is read from the database so role changes apply
+// immediately.
+func APIAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var userID int
+		var scopes []string
+		if header := r.Header.Get("Authorization"); header != "" {
+			id, tokenScopes, err := authenticateToken(r, header)
+			if err != nil {
+				apperror.Write(w, r, err)
+				return
+			}
+			userID, scopes = id, tokenScopes
+		} else {
+			session, _ := session.Store.Get(r, "session-name")
+			auth, _ := session.Values["authenticated"].(bool)
+			id, ok := session.Values["userID"].(int)
+			if !auth || !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if session.Values["require2FASetup"] == true {
+				apperror.Write(w, r, apperror.Forbidden("Two-factor authentication must be set up first"))
+				return
+			}
+			userID = id
+		}
+
+		user, err := database.GetUser(userID)
+		if err != nil {
+			apperror.Write(w, r, apperror.Internal(err, "Failed to check permissions"))
+			return
+		}
+		if user.Disabled {
+			apperror.Write(w, r, apperror.Forbidden("This account has been disabled"))
+			return
+		}
+		if _, err := database.ActiveBan(user.ID); err == nil {
+			apperror.Write(w, r, apperror.Forbidden("This account has been banned"))
+			return
+		}
+		p := Principal{UserID: user.ID, Username: user.Username, Role: user.Role, Scopes: scopes}
+
+		needed := permissions.ScopeWrite
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			needed = permissions.ScopeRead
+		}
+		if !p.HasScope(needed) {
+			apperror.Write(w, r, apperror.Forbidden("This token does not have the "+needed+" scope"))
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, p)))
+	})
+}
+
+// authenticateToken checks a bearer token and returns its owner and scopes.
+func authenticateToken(r *http.Request, header string) (int, []string, error) {
+	secret, ok := strings.CutPrefix(header, "Bearer ")
+	if !ok {
+		return 0, nil, apperror.Unauthorized("Authorization must be a bearer token")
+	}
+	token, err := database.GetAPITokenByHash(apitoken.Hash(strings.TrimSpace(secret)))
+	if err == sql.ErrNoRows {
+		return 0, nil, apperror.Unauthorized("Invalid API token")
+	}
+	if err != nil {
+		return 0, nil, apperror.Internal(err, "Failed to check API token")
+	}
+	now := time.Now()
+	if token.RevokedAt != nil {
+		return 0, nil, apperror.Unauthorized("This API token has been revoked")
+	}
+	if now.After(token.ExpiresAt) {
+		return 0, nil, apperror.Unauthorized("This API token has expired")
+	}
+	if token.LastUsedAt == nil || now.Sub(*token.LastUsedAt) > tokenTouchInterval {
+		if err := database.TouchAPIToken(token.ID, now); err != nil {
+			logging.FromContext(r.Context()).Error("failed to record API token use", "token_id", token.ID, "err", err)
+		}
+	}
+	return token.UserID, token.Scopes, nil
+}
+
+// APIRequire only lets requests through whose principal's role grants perm
+// and, for tokens, whose scopes cover it. An empty perm only requires a
+// principal.
+func APIRequire(perm string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			p, ok := PrincipalFrom(r.Context())
+			if !ok {
+				apperror.Write(w, r, apperror.Unauthorized("Authentication required"))
+				return
+			}
+			if perm != "" {
+				if scope := permissions.ScopeFor(perm); !p.HasScope(scope) {
+					apperror.Write(w, r, apperror.Forbidden("This token does not have the "+scope+" scope"))
+					return
+				}
+				allowed, err := database.RoleHasPermission(p.Role, perm)
+				if err != nil {
+					apperror.Write(w, r, apperror.Internal(err, "Failed to check permissions"))
+					return
+				}
+				if !allowed {
+					apperror.Write(w, r, apperror.Forbidden("You do not have permission to do this"))
+					return
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
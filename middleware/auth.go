@@ -1,9 +1,11 @@
 package middleware
 
 import (
-	"log"
 	"net/http"
+	"strings"
 
+	"DytForum/apperror"
+	"DytForum/database"
 	"DytForum/session"
 )
 
@@ -12,56 +14,78 @@ func AuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		session, err := session.Store.Get(r, "session-name")
 		if err != nil {
-			log.Printf("AuthMiddleware: Failed to get session: %v", err)
-			http.Error(w, "Failed to get session", http.StatusInternalServerError)
+			apperror.Write(w, r, apperror.Internal(err, "Failed to get session"))
 			return
 		}
 
 		auth, ok := session.Values["authenticated"].(bool)
 		if !ok || !auth {
-			http.Error(w, "You must be logged in to access this page", http.StatusUnauthorized)
+			apperror.Write(w, r, apperror.Unauthorized("You must be logged in to access this page"))
 			return
 		}
-		next.ServeHTTP(w, r)
-	})
-}
-
-// AdminMiddleware kontrol eder
-func AdminMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		session, err := session.Store.Get(r, "session-name")
-		if err != nil {
-			log.Printf("AdminMiddleware: Failed to get session: %v", err)
-			http.Error(w, "Failed to get session", http.StatusInternalServerError)
-			return
+		if userID, ok := session.Values["userID"].(int); ok {
+			if _, disabled, err := database.GetUserStatus(userID); err == nil && disabled {
+				apperror.Write(w, r, apperror.Forbidden("This account has been disabled"))
+				return
+			}
+			if redirectBanned(w, r, userID) {
+				return
+			}
 		}
-
-		role, ok := session.Values["role"].(string)
-		if !ok || role != "admin" {
-			http.Error(w, "You must be an admin to access this page", http.StatusUnauthorized)
+		if mustEnroll2FA(w, r, session.Values) {
 			return
 		}
 		next.ServeHTTP(w, r)
 	})
 }
 
-// ModeratorMiddleware kontrol eder
-func ModeratorMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		session, err := session.Store.Get(r, "session-name")
-		if err != nil {
-			log.Printf("ModeratorMiddleware: Failed to get session: %v", err)
-			http.Error(w, "Failed to get session", http.StatusInternalServerError)
-			return
-		}
+// RequirePermission lets a request through only if the logged in user's
+// current role grants perm. Disabled and banned accounts are turned away
+// first, and staff without two-factor authentication are sent to enrol.
+func RequirePermission(perm string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			session, err := session.Store.Get(r, "session-name")
+			if err != nil {
+				apperror.Write(w, r, apperror.Internal(err, "Failed to get session"))
+				return
+			}
 
-		role, ok := session.Values["role"].(string)
-		if !ok || role != "moderator" {
-			http.Error(w, "You must be a moderator to access this page", http.StatusUnauthorized)
-			return
-		}
-		next.ServeHTTP(w, r)
-	})
+			auth, _ := session.Values["authenticated"].(bool)
+			userID, ok := session.Values["userID"].(int)
+			if !auth || !ok {
+				apperror.Write(w, r, apperror.Unauthorized("You must be logged in to access this page"))
+				return
+			}
+
+			// The role is read from the database so role changes apply immediately.
+			role, disabled, err := database.GetUserStatus(userID)
+			if err != nil {
+				apperror.Write(w, r, apperror.Internal(err, "Failed to check permissions"))
+				return
+			}
+			if disabled {
+				apperror.Write(w, r, apperror.Forbidden("This account has been disabled"))
+				return
+			}
+			if redirectBanned(w, r, userID) {
+				return
+			}
+			allowed, err := database.RoleHasPermission(role, perm)
+			if err != nil {
+				apperror.Write(w, r, apperror.Internal(err, "Failed to check permissions"))
+				return
+			}
+			if !allowed {
+				apperror.Write(w, r, apperror.Forbidden("You do not have permission to access this page"))
+				return
+			}
+			if mustEnroll2FA(w, r, session.Values) {
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
 }
 
 // GuestMiddleware ayarlar
@@ -75,3 +99,23 @@ func GuestMiddleware(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// redirectBanned sends users with an active ban to the ban notice and
+// reports whether it did so. Bans end on their own once they expire.
+func redirectBanned(w http.ResponseWriter, r *http.Request, userID int) bool {
+	if _, err := database.ActiveBan(userID); err != nil {
+		return false
+	}
+	http.Redirect(w, r, "/banned", http.StatusSeeOther)
+	return true
+}
+
+// mustEnroll2FA redirects staff that still have to set up two-factor
+// authentication to the enrollment page and reports whether it did so.
+func mustEnroll2FA(w http.ResponseWriter, r *http.Request, values map[interface{}]interface{}) bool {
+	if values["require2FASetup"] != true || strings.HasPrefix(r.URL.Path, "/account/2fa") {
+		return false
+	}
+	http.Redirect(w, r, "/account/2fa", http.StatusSeeOther)
+	return true
+}
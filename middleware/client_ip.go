@@ -0,0 +1,79 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+	"net/textproto"
+	"strings"
+
+	"DytForum/config"
+)
+
+var clientIPs struct {
+	proxies []*net.IPNet
+	header  string
+}
+
+// ConfigureClientIP sets which reverse proxies are trusted to report the
+// client address and in which header. Until it is called, or when no proxy
+// is trusted, ClientIP uses the address of the connection.
+func ConfigureClientIP(cfg config.Config) error {
+	proxies, err := cfg.TrustedProxyNetworks()
+	if err != nil {
+		return err
+	}
+	clientIPs.proxies = proxies
+	clientIPs.header = textproto.CanonicalMIMEHeaderKey(strings.TrimSpace(cfg.ClientIPHeader))
+	return nil
+}
+
+// ClientIP returns the address of the client that sent the request. When the
+// connection comes from a trusted proxy the address is read from the client
+// IP header instead; a list such as X-Forwarded-For is read from the right,
+// skipping the trusted proxies it passed through, so a client cannot pick
+// its own address by sending the header itself.
+func ClientIP(r *http.Request) string {
+	ip := remoteIP(r)
+	if !trustedProxy(ip) {
+		return ip
+	}
+	values := r.Header.Values(clientIPs.header)
+	for i := len(values) - 1; i >= 0; i-- {
+		hops := strings.Split(values[i], ",")
+		for j := len(hops) - 1; j >= 0; j-- {
+			hop := strings.TrimSpace(hops[j])
+			if net.ParseIP(hop) == nil {
+				// Anything before a malformed entry cannot be trusted.
+				return ip
+			}
+			ip = hop
+			if !trustedProxy(hop) {
+				return hop
+			}
+		}
+	}
+	return ip
+}
+
+// trustedProxy reports whether ip belongs to a trusted proxy network.
+func trustedProxy(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, network := range clientIPs.proxies {
+		if network.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// remoteIP returns the remote address of the request without the port.
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,81 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"DytForum/metrics"
+	"DytForum/session"
+
+	"github.com/gorilla/mux"
+)
+
+var (
+	httpRequests = metrics.NewCounterVec(
+		"forum_http_requests_total",
+		"HTTP requests by route, method and status code.",
+		"route", "method", "status",
+	)
+	httpDuration = metrics.NewHistogramVec(
+		"forum_http_request_duration_seconds",
+		"HTTP request latency by route and method.",
+		metrics.DefaultBuckets, "route", "method",
+	)
+)
+
+// activeWindow is how recently a user must have made a request to count as
+// an active session. Sessions live in cookies, so there is no server side
+// list to count instead.
+const activeWindow = 15 * time.Minute
+
+var activeUsers = struct {
+	sync.Mutex
+	seen map[int]time.Time
+}{seen: map[int]time.Time{}}
+
+func init() {
+	metrics.NewGaugeFunc("forum_active_sessions", "Logged in users seen in the last 15 minutes.", func() (float64, error) {
+		cutoff := time.Now().Add(-activeWindow)
+		activeUsers.Lock()
+		defer activeUsers.Unlock()
+		for id, at := range activeUsers.seen {
+			if at.Before(cutoff) {
+				delete(activeUsers.seen, id)
+			}
+		}
+		return float64(len(activeUsers.seen)), nil
+	})
+}
+
+// Metrics counts requests and records their latency under the route's path
+// template, so /thread?id=1 and /thread?id=2 share one series. Requests that
+// match no route are recorded as "unmatched".
+func Metrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+
+		route := "unmatched"
+		if current := mux.CurrentRoute(r); current != nil {
+			if tmpl, err := current.GetPathTemplate(); err == nil {
+				route = tmpl
+			}
+		}
+		httpRequests.Inc(route, r.Method, strconv.Itoa(rec.status))
+		httpDuration.ObserveDuration(time.Since(start), route, r.Method)
+
+		if session, err := session.Store.Get(r, "session-name"); err == nil {
+			if userID, ok := session.Values["userID"].(int); ok {
+				activeUsers.Lock()
+				activeUsers.seen[userID] = time.Now()
+				activeUsers.Unlock()
+			}
+		}
+	})
+}
@@ -0,0 +1,116 @@
+package middleware
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"DytForum/apperror"
+	"DytForum/config"
+	"DytForum/database"
+	"DytForum/logging"
+	"DytForum/metrics"
+	"DytForum/ratelimit"
+	"DytForum/session"
+)
+
+var rateLimited = metrics.NewCounterVec(
+	"forum_rate_limited_requests_total",
+	"Requests refused by a rate limit, by policy.",
+	"policy",
+)
+
+var rateLimits struct {
+	store         ratelimit.Store
+	policies      map[string]ratelimit.Policy
+	newAccountAge time.Duration
+}
+
+// ConfigureRateLimits sets the rate limit policies and where the buckets
+// are kept. Until it is called RateLimit lets every request through.
+func ConfigureRateLimits(cfg config.Config) error {
+	policies, err := cfg.RateLimitPolicies()
+	if err != nil {
+		return err
+	}
+	rateLimits.policies = policies
+	rateLimits.newAccountAge = cfg.NewAccountAge
+	if cfg.RateLimitStore == "database" {
+		rateLimits.store = &database.RateLimitStore{}
+	} else {
+		rateLimits.store = ratelimit.NewMemory()
+	}
+	return nil
+}
+
+// RateLimit limits requests that change something under the named policy:
+// per user when logged in, otherwise per IP address. Accounts younger than
+// the new account age get the policy's ".new" variant. GET and HEAD pass
+// through, and so does everything when the store fails, so an outage of the
+// limiter cannot stop people from posting.
+func RateLimit(name string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if rateLimits.store == nil || r.Method == http.MethodGet || r.Method == http.MethodHead {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			policyName := name
+			subject := "ip:" + ClientIP(r)
+			if userID, ok := requestUserID(r); ok {
+				subject = "user:" + strconv.Itoa(userID)
+				if isNewAccount(r, userID) {
+					policyName += ".new"
+				}
+			}
+			policy, ok := rateLimits.policies[policyName]
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			allowed, wait, err := rateLimits.store.Take(name+":"+subject, policy, time.Now())
+			if err != nil {
+				logging.FromContext(r.Context()).Error("failed to check rate limit", "policy", policyName, "err", err)
+				next.ServeHTTP(w, r)
+				return
+			}
+			if !allowed {
+				rateLimited.Inc(policyName)
+				seconds := int(math.Ceil(wait.Seconds()))
+				w.Header().Set("Retry-After", strconv.Itoa(seconds))
+				apperror.Write(w, r, apperror.TooManyRequests(fmt.Sprintf("You are doing that too often. Try again in %d seconds.", seconds)))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// requestUserID returns the user behind an API request or a logged in
+// session.
+func requestUserID(r *http.Request) (int, bool) {
+	if p, ok := PrincipalFrom(r.Context()); ok {
+		return p.UserID, true
+	}
+	session, _ := session.Store.Get(r, "session-name")
+	if auth, _ := session.Values["authenticated"].(bool); !auth {
+		return 0, false
+	}
+	userID, ok := session.Values["userID"].(int)
+	return userID, ok
+}
+
+// isNewAccount reports whether the user registered less than the new
+// account age ago. Accounts older than creation times count as established.
+func isNewAccount(r *http.Request, userID int) bool {
+	created, err := database.AccountCreatedAt(userID)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("failed to read account age", "user_id", userID, "err", err)
+		return false
+	}
+	return created != nil && time.Since(*created) < rateLimits.newAccountAge
+}
@@ -1,6 +1,8 @@
 // models/models.go
 package models
 
+import "time"
+
 type User struct {
 	ID         int
 	Username   string
@@ -10,29 +12,40 @@ type User struct {
 	GitHubID   int
 	FacebookID string
 	Role       string
+
+	TwoFactorEnabled bool
+	Disabled         bool
 }
 
 type Thread struct {
-	ID       int
-	UserID   int
-	Category string
-	Title    string
-	Content  string
-	Likes    int
-	Dislikes int
-	Username string
-	Comments []Comment
-	Approved int
+	ID         int       `json:"id"`
+	UserID     int       `json:"user_id"`
+	CategoryID int       `json:"category_id"`
+	Category   string    `json:"category"`
+	Title      string    `json:"title"`
+	Content    string    `json:"content"`
+	Likes      int       `json:"likes"`
+	Dislikes   int       `json:"dislikes"`
+	Username   string    `json:"username"`
+	Comments   []Comment `json:"comments,omitempty"`
+	Approved   int       `json:"approved"`
+	ReviewNote string    `json:"review_note,omitempty"`
+	// SpamScore is the spam classifier's score from 0 to 100, or nil when
+	// the thread was not scored. Only moderators see it.
+	SpamScore *int `json:"-"`
 }
 
 type Comment struct {
-	ID       int
-	ThreadID int
-	UserID   int
-	Content  string
-	Likes    int
-	Dislikes int
-	Username string
+	ID       int    `json:"id"`
+	ThreadID int    `json:"thread_id"`
+	UserID   int    `json:"user_id"`
+	Content  string `json:"content"`
+	Likes    int    `json:"likes"`
+	Dislikes int    `json:"dislikes"`
+	Username string `json:"username"`
+	Approved int    `json:"approved"`
+	// SpamScore is like Thread.SpamScore.
+	SpamScore *int `json:"-"`
 }
 
 type Like struct {
@@ -76,16 +89,208 @@ type ProfileData struct {
 	Threads          []Thread
 	Comments         []Comment
 }
+
+// Report is a user's report about a thread, comment or user. ThreadID and
+// Title name the thread the content belongs to, Content is the reported
+// text and AuthorID the user responsible for it (the reported user itself
+// for user reports).
 type Report struct {
+	ID             int        `json:"id"`
+	TargetType     string     `json:"target_type"`
+	TargetID       int        `json:"target_id"`
+	ThreadID       int        `json:"thread_id"`
+	UserID         int        `json:"user_id"`
+	Reason         string     `json:"reason"`
+	Details        string     `json:"details"`
+	Username       string     `json:"username"`
+	Title          string     `json:"title"`
+	Content        string     `json:"content"`
+	AuthorID       int        `json:"author_id"`
+	AuthorName     string     `json:"author_name"`
+	ContentDeleted bool       `json:"content_deleted"`
+	Status         string     `json:"status"`
+	DuplicateOf    int        `json:"duplicate_of,omitempty"`
+	ReportCount    int        `json:"report_count"`
+	AssignedTo     int        `json:"assigned_to,omitempty"`
+	AssigneeName   string     `json:"assignee_name,omitempty"`
+	Actions        []string   `json:"actions,omitempty"`
+	ResolutionNote string     `json:"resolution_note,omitempty"`
+	ResolvedByName string     `json:"resolved_by,omitempty"`
+	CreatedAt      *time.Time `json:"created_at"`
+	ResolvedAt     *time.Time `json:"resolved_at"`
+}
+type ReportReason struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+type Category struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+type TwoFactor struct {
+	UserID  int
+	Secret  string
+	Enabled bool
+	// LastStep is the TOTP time step of the last accepted code.
+	LastStep int64
+}
+type LoginAttempt struct {
+	ID        int
+	Username  string
+	IP        string
+	Success   bool
+	CreatedAt time.Time
+}
+
+// Sanction is a warning, suspension or ban issued to a user. ExpiresAt is
+// nil for warnings and permanent bans. Active is false once the sanction has
+// expired or was revoked.
+type Sanction struct {
+	ID            int
+	UserID        int
+	Username      string
+	Kind          string
+	Reason        string
+	IssuedBy      int
+	IssuerName    string
+	CreatedAt     time.Time
+	ExpiresAt     *time.Time
+	RevokedAt     *time.Time
+	RevokedByName string
+	Active        bool
+}
+
+// RegistrationBan blocks new accounts from an IP address or an email domain.
+type RegistrationBan struct {
+	ID            int
+	Kind          string
+	Value         string
+	Reason        string
+	CreatedByName string
+	CreatedAt     time.Time
+	ExpiresAt     *time.Time
+}
+
+// Appeal is a user's request to reverse a moderation action that affected
+// them. Action is the appealed entry of the moderation log.
+type Appeal struct {
+	ID            int
+	UserID        int
+	Username      string
+	Message       string
+	Status        string
+	AssignedTo    int
+	AssigneeName  string
+	DecidedByName string
+	DecisionNote  string
+	CreatedAt     time.Time
+	DecidedAt     *time.Time
+	Action        ModAction
+}
+
+// AutomodRule is an automoderation rule. It matches a new post when every
+// condition it sets holds: Words is a comma or newline separated list of
+// which any must appear, Pattern a regular expression, and the numeric
+// conditions are unused when zero, or nil for MaxTrustLevel. Hits,
+// RecentHits and LastHitAt are only filled in by the rule list.
+type AutomodRule struct {
+	ID             int
+	Name           string
+	Target         string
+	Words          string
+	Pattern        string
+	MinLinks       int
+	MaxAccountDays int
+	MaxTrustLevel  *int
+	MinRepeats     int
+	Action         string
+	Enabled        bool
+	CreatedAt      time.Time
+	Hits           int
+	RecentHits     int
+	LastHitAt      *time.Time
+}
+
+// AutomodHit records that a rule matched a post. TargetID is zero for
+// comments that were rejected and never stored.
+type AutomodHit struct {
+	ID         int
+	RuleID     int
+	TargetType string
+	TargetID   int
+	ThreadID   int
+	UserID     int
+	Username   string
+	Action     string
+	CreatedAt  time.Time
+}
+
+// Post is a thread or comment with its author, as automoderation rules and
+// the spam filter see it. Title is empty for comments.
+type Post struct {
+	Type     string
 	ID       int
 	ThreadID int
 	UserID   int
-	Reason   string
 	Username string
 	Title    string
 	Content  string
 }
-type Category struct {
-	ID   int
-	Name string
+
+// ModAction is one entry of the moderation audit log. Before and After are
+// JSON snapshots of the changed state; either may be empty.
+type ModAction struct {
+	ID         int
+	ActorID    int
+	ActorName  string
+	Action     string
+	TargetType string
+	TargetID   int
+	Before     string
+	After      string
+	Reason     string
+	CreatedAt  time.Time
+}
+
+// ModActionCount is how often an action was taken in a month, for the
+// transparency report.
+type ModActionCount struct {
+	Month  string
+	Action string
+	Count  int
+}
+type LoginThrottle struct {
+	Key         string
+	Failures    int
+	LockedUntil time.Time
+	LastFailure time.Time
+}
+type Notification struct {
+	ID        int
+	UserID    int
+	Message   string
+	Read      bool
+	CreatedAt time.Time
+}
+type Role struct {
+	ID          int
+	Name        string
+	Permissions []string
+}
+type APIToken struct {
+	ID         int
+	UserID     int
+	Name       string
+	Prefix     string
+	Scopes     []string
+	CreatedAt  time.Time
+	ExpiresAt  time.Time
+	LastUsedAt *time.Time
+	RevokedAt  *time.Time
+}
+type CategoryModerator struct {
+	CategoryID   int
+	CategoryName string
+	UserID       int
+	Username     string
 }
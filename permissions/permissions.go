@@ -0,0 +1,106 @@
+// permissions/permissions.go
+package permissions
+
+// Permission names checked by RequirePermission and the handlers.
+const (
+	ThreadCreate   = "thread.create"
+	CommentCreate  = "comment.create"
+	ReactionCreate = "reaction.create"
+	ReportCreate   = "report.create"
+
+	ModerationPanel = "moderation.panel"
+	ThreadApprove   = "thread.approve"
+	ThreadDelete    = "thread.delete"
+	ReportView      = "report.view"
+	ReportResolve   = "report.resolve"
+	UserSuspend     = "user.suspend"
+	AppealReview    = "appeal.review"
+	// ModerateAllCategories lifts the category scope of the moderation permissions.
+	ModerateAllCategories = "moderation.all_categories"
+
+	AdminPanel     = "admin.panel"
+	CategoryManage = "category.manage"
+	ReasonManage   = "report.reasons"
+	UserManage     = "user.manage"
+	UserBan        = "user.ban"
+	RoleManage     = "role.manage"
+	SecurityManage = "security.manage"
+	AuditView      = "audit.view"
+	AutomodManage  = "automod.manage"
+)
+
+// Scopes limit what a personal API token may do on top of the permissions
+// of its owner's role.
+const (
+	ScopeRead     = "read"
+	ScopeWrite    = "write"
+	ScopeModerate = "moderate"
+)
+
+// Scopes lists every token scope in the order they are shown to users.
+var Scopes = []string{ScopeRead, ScopeWrite, ScopeModerate}
+
+// ScopeFor returns the scope a token needs to use perm: moderate for the
+// moderation and admin permissions, write for everything else.
+func ScopeFor(perm string) string {
+	for _, p := range Defaults[UserRole] {
+		if p == perm {
+			return ScopeWrite
+		}
+	}
+	return ScopeModerate
+}
+
+// Built-in role names. They cannot be deleted, and AdminRole always keeps
+// every permission so admins cannot lock themselves out.
+const (
+	UserRole      = "user"
+	ModeratorRole = "moderator"
+	AdminRole     = "admin"
+)
+
+type Definition struct {
+	Name        string
+	Description string
+}
+
+// All lists every known permission in the order they are shown to admins.
+var All = []Definition{
+	{ThreadCreate, "Create threads"},
+	{CommentCreate, "Write comments"},
+	{ReactionCreate, "Like and dislike threads and comments"},
+	{ReportCreate, "Report content"},
+	{ModerationPanel, "Open the moderator panel"},
+	{ThreadApprove, "Approve or reject pending threads"},
+	{ThreadDelete, "Delete threads"},
+	{ReportView, "View reports"},
+	{ReportResolve, "Resolve reports"},
+	{UserSuspend, "Warn users and suspend them from posting"},
+	{AppealReview, "Decide appeals against other moderators' actions"},
+	{ModerateAllCategories, "Moderate every category instead of only assigned ones"},
+	{AdminPanel, "Open the admin panel"},
+	{CategoryManage, "Create and delete categories"},
+	{ReasonManage, "Manage the reasons offered when reporting content"},
+	{UserManage, "Change user roles and review moderator requests"},
+	{UserBan, "Ban users and block registrations by IP address or email domain"},
+	{RoleManage, "Create roles and edit their permissions"},
+	{SecurityManage, "Manage login security and two-factor policy"},
+	{AuditView, "View and export the moderation log"},
+	{AutomodManage, "Manage automoderation rules"},
+}
+
+// Defaults maps the built-in roles to the permissions they get when a
+// permission is first added to the database. Admins can change the mapping
+// afterwards.
+var Defaults = map[string][]string{
+	UserRole: {ThreadCreate, CommentCreate, ReactionCreate, ReportCreate},
+	ModeratorRole: {
+		ThreadCreate, CommentCreate, ReactionCreate, ReportCreate,
+		ModerationPanel, ThreadApprove, ThreadDelete, ReportView, ReportResolve, UserSuspend, AppealReview,
+	},
+}
+
+// IsBuiltinRole reports whether role is one of the roles the forum ships with.
+func IsBuiltinRole(role string) bool {
+	return role == UserRole || role == ModeratorRole || role == AdminRole
+}
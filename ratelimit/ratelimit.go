@@ -0,0 +1,152 @@
+// Package ratelimit implements token bucket rate limits. Buckets live in a
+// Store: Memory keeps them in the process, and the database package has a
+// store that instances sharing a database can use together.
+package ratelimit
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Policy allows Requests requests per Per. The bucket starts full, so up to
+// Requests requests may come at once, and refills evenly over Per.
+type Policy struct {
+	Requests int
+	Per      time.Duration
+}
+
+// ParsePolicy parses a policy written as "requests/period", e.g. "10/1m".
+func ParsePolicy(s string) (Policy, error) {
+	requests, per, ok := strings.Cut(strings.TrimSpace(s), "/")
+	if !ok {
+		return Policy{}, fmt.Errorf("policy %q must look like 10/1m", s)
+	}
+	n, err := strconv.Atoi(requests)
+	if err != nil || n < 1 {
+		return Policy{}, fmt.Errorf("policy %q: requests must be a positive number", s)
+	}
+	d, err := time.ParseDuration(per)
+	if err != nil || d <= 0 {
+		return Policy{}, fmt.Errorf("policy %q: period must be a positive duration", s)
+	}
+	return Policy{Requests: n, Per: d}, nil
+}
+
+func (p Policy) String() string {
+	return strconv.Itoa(p.Requests) + "/" + p.Per.String()
+}
+
+// ParsePolicies applies comma separated "name=requests/period" overrides to
+// a copy of defaults. Only names found in defaults are accepted.
+func ParsePolicies(s string, defaults map[string]Policy) (map[string]Policy, error) {
+	policies := make(map[string]Policy, len(defaults))
+	for name, p := range defaults {
+		policies[name] = p
+	}
+	for _, field := range strings.Split(s, ",") {
+		if strings.TrimSpace(field) == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(field, "=")
+		name = strings.TrimSpace(name)
+		if !ok {
+			return nil, fmt.Errorf("%q must look like name=10/1m", field)
+		}
+		if _, known := defaults[name]; !known {
+			names := make([]string, 0, len(defaults))
+			for n := range defaults {
+				names = append(names, n)
+			}
+			sort.Strings(names)
+			return nil, fmt.Errorf("unknown policy %q, expected one of %s", name, strings.Join(names, ", "))
+		}
+		p, err := ParsePolicy(value)
+		if err != nil {
+			return nil, err
+		}
+		policies[name] = p
+	}
+	return policies, nil
+}
+
+// Bucket is the state of one key's bucket.
+type Bucket struct {
+	Tokens  float64
+	Updated time.Time
+}
+
+// Take refills b for the time since it was last updated and removes one
+// token. It returns the new state, whether a token was available and, if
+// not, how long until one will be. A zero Bucket is a new, full one.
+func (p Policy) Take(b Bucket, now time.Time) (Bucket, bool, time.Duration) {
+	capacity := float64(p.Requests)
+	perToken := p.Per / time.Duration(p.Requests)
+	if b.Updated.IsZero() {
+		b.Tokens = capacity
+	} else if elapsed := now.Sub(b.Updated); elapsed > 0 {
+		b.Tokens += float64(elapsed) / float64(perToken)
+		if b.Tokens > capacity {
+			b.Tokens = capacity
+		}
+	}
+	b.Updated = now
+	if b.Tokens >= 1 {
+		b.Tokens--
+		return b, true, 0
+	}
+	return b, false, time.Duration((1 - b.Tokens) * float64(perToken))
+}
+
+// FullAt returns when b will be full again, after which it can be
+// forgotten.
+func (p Policy) FullAt(b Bucket) time.Time {
+	missing := float64(p.Requests) - b.Tokens
+	return b.Updated.Add(time.Duration(missing * float64(p.Per/time.Duration(p.Requests))))
+}
+
+// Store keeps the buckets. Take removes a token from the bucket under key,
+// reporting whether one was available and, if not, how long until one is.
+type Store interface {
+	Take(key string, p Policy, now time.Time) (bool, time.Duration, error)
+}
+
+// pruneInterval is how often Memory forgets buckets that are full again.
+const pruneInterval = time.Minute
+
+type memoryBucket struct {
+	Bucket
+	fullAt time.Time
+}
+
+// Memory is a Store for a single instance.
+type Memory struct {
+	mu        sync.Mutex
+	buckets   map[string]memoryBucket
+	lastPrune time.Time
+}
+
+// NewMemory returns an empty in-memory store.
+func NewMemory() *Memory {
+	return &Memory{buckets: map[string]memoryBucket{}}
+}
+
+func (m *Memory) Take(key string, p Policy, now time.Time) (bool, time.Duration, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if now.Sub(m.lastPrune) >= pruneInterval {
+		for k, b := range m.buckets {
+			if !now.Before(b.fullAt) {
+				delete(m.buckets, k)
+			}
+		}
+		m.lastPrune = now
+	}
+
+	b, ok, wait := p.Take(m.buckets[key].Bucket, now)
+	m.buckets[key] = memoryBucket{Bucket: b, fullAt: p.FullAt(b)}
+	return ok, wait, nil
+}
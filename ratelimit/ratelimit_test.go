@@ -0,0 +1,129 @@
+package ratelimit
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPolicyTake(t *testing.T) {
+	// Two requests a minute: the bucket holds two tokens and gains one
+	// every 30 seconds.
+	p := Policy{Requests: 2, Per: time.Minute}
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	steps := []struct {
+		after   time.Duration
+		allowed bool
+		wait    time.Duration
+		tokens  float64
+	}{
+		{0, true, 0, 1},
+		{0, true, 0, 0},
+		{0, false, 30 * time.Second, 0},
+		{15 * time.Second, false, 15 * time.Second, 0.5},
+		{45 * time.Second, true, 0, 0.5},
+		// The clock going backwards refills nothing.
+		{40 * time.Second, false, 15 * time.Second, 0.5},
+		// A long pause fills the bucket but not beyond its capacity.
+		{10 * time.Minute, true, 0, 1},
+	}
+
+	var b Bucket
+	for i, s := range steps {
+		var allowed bool
+		var wait time.Duration
+		b, allowed, wait = p.Take(b, start.Add(s.after))
+		if allowed != s.allowed || wait != s.wait || b.Tokens != s.tokens {
+			t.Errorf("step %d at +%s: allowed %v, wait %s, %v tokens; want %v, %s, %v",
+				i, s.after, allowed, wait, b.Tokens, s.allowed, s.wait, s.tokens)
+		}
+	}
+}
+
+func TestPolicyFullAt(t *testing.T) {
+	p := Policy{Requests: 4, Per: time.Hour}
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		tokens float64
+		want   time.Duration
+	}{
+		{4, 0},
+		{3, 15 * time.Minute},
+		{0.5, 52*time.Minute + 30*time.Second},
+	}
+	for _, tt := range tests {
+		got := p.FullAt(Bucket{Tokens: tt.tokens, Updated: now})
+		if want := now.Add(tt.want); !got.Equal(want) {
+			t.Errorf("FullAt with %v tokens = %s, want %s", tt.tokens, got, want)
+		}
+	}
+}
+
+func TestParsePolicies(t *testing.T) {
+	defaults := map[string]Policy{
+		"comment": {Requests: 10, Per: time.Minute},
+		"thread":  {Requests: 5, Per: 10 * time.Minute},
+	}
+	tests := []struct {
+		in      string
+		want    map[string]Policy
+		wantErr bool
+	}{
+		{in: "", want: defaults},
+		{in: "comment=20/1m", want: map[string]Policy{
+			"comment": {Requests: 20, Per: time.Minute},
+			"thread":  {Requests: 5, Per: 10 * time.Minute},
+		}},
+		{in: " comment = 3/30s , thread=1/1h,", want: map[string]Policy{
+			"comment": {Requests: 3, Per: 30 * time.Second},
+			"thread":  {Requests: 1, Per: time.Hour},
+		}},
+		{in: "comment", wantErr: true},
+		{in: "reaction=1/1m", wantErr: true},
+		{in: "comment=0/1m", wantErr: true},
+		{in: "comment=5/0s", wantErr: true},
+		{in: "comment=5/soon", wantErr: true},
+		{in: "comment=5", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParsePolicies(tt.in, defaults)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParsePolicies(%q) = %v, want an error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParsePolicies(%q): %v", tt.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParsePolicies(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+	if defaults["comment"].Requests != 10 {
+		t.Error("ParsePolicies changed the defaults")
+	}
+}
+
+func TestMemoryKeepsKeysApart(t *testing.T) {
+	m := NewMemory()
+	p := Policy{Requests: 1, Per: time.Minute}
+	now := time.Now()
+	for _, tt := range []struct {
+		key     string
+		allowed bool
+	}{
+		{"comment:user:1", true},
+		{"comment:user:1", false},
+		{"comment:user:2", true},
+	} {
+		allowed, _, err := m.Take(tt.key, p, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if allowed != tt.allowed {
+			t.Errorf("Take(%s) allowed = %v, want %v", tt.key, allowed, tt.allowed)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+// Package requestid gives every request an ID that is logged with it and
+// returned to the client in the X-Request-ID header.
+package requestid
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"regexp"
+)
+
+// Header is the request and response header carrying the ID.
+const Header = "X-Request-ID"
+
+type contextKey struct{}
+
+// incoming IDs from a proxy are kept only if they are safe to log.
+var validID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// Middleware stores the request ID in the request context, reusing a valid
+// X-Request-ID sent by a proxy in front of the server.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(Header)
+		if !validID.MatchString(id) {
+			id = newID()
+		}
+		w.Header().Set(Header, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), contextKey{}, id)))
+	})
+}
+
+// FromContext returns the request ID, or "" outside of Middleware.
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(contextKey{}).(string)
+	return id
+}
+
+func newID() string {
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"net/http"
+
+	"DytForum/apperror"
+	"DytForum/handlers"
+	"DytForum/middleware"
+	"DytForum/permissions"
+
+	"github.com/gorilla/mux"
+)
+
+// newRouter registers every route of the forum.
+func newRouter() *mux.Router {
+	r := mux.NewRouter()
+	r.Use(middleware.Metrics)
+	r.NotFoundHandler = middleware.Metrics(apperror.Handler(handlers.NotFoundHandler))
+	r.MethodNotAllowedHandler = middleware.Metrics(apperror.Handler(handlers.MethodNotAllowedHandler))
+	r.Handle("/metrics", apperror.Handler(handlers.MetricsHandler)).Methods("GET")
+	r.Handle("/healthz", apperror.Handler(handlers.HealthzHandler)).Methods("GET")
+	r.Handle("/readyz", apperror.Handler(handlers.ReadyzHandler)).Methods("GET")
+	r.Handle("/", apperror.Handler(handlers.HomeHandler))
+
+	// Auth routes
+	r.Handle("/auth/github/login", apperror.Handler(handlers.GitHubLogin))
+	r.Handle("/auth/github/callback", apperror.Handler(handlers.GitHubCallback))
+	r.Handle("/auth/google/login", apperror.Handler(handlers.GoogleLogin))
+	r.Handle("/auth/google/callback", apperror.Handler(handlers.GoogleCallback))
+	r.Handle("/auth/facebook", apperror.Handler(handlers.FacebookLogin))
+	r.Handle("/auth/facebook/callback", apperror.Handler(handlers.FacebookCallback))
+
+	// Login and Register routes
+	r.Handle("/register", apperror.Handler(handlers.RegisterHandler)).Methods("GET", "POST")
+	r.Handle("/login", apperror.Handler(handlers.LoginHandler)).Methods("GET", "POST")
+	r.Handle("/login/2fa", apperror.Handler(handlers.TwoFactorLoginHandler)).Methods("GET", "POST")
+
+	// can wraps a handler so it only runs for users whose role grants perm.
+	can := func(perm string, h apperror.Handler) http.Handler {
+		return middleware.RequirePermission(perm)(h)
+	}
+	// limit applies the named rate limit policy to a handler.
+	limit := func(policy string, h http.Handler) http.Handler {
+		return middleware.RateLimit(policy)(h)
+	}
+
+	// Like/Dislike routes
+	r.Handle("/like-thread", limit("reaction", can(permissions.ReactionCreate, handlers.LikeThread))).Methods("POST")
+	r.Handle("/like-comment", limit("reaction", can(permissions.ReactionCreate, handlers.LikeComment))).Methods("POST")
+
+	// Public moderation statistics
+	r.Handle("/transparency", apperror.Handler(handlers.TransparencyHandler)).Methods("GET")
+
+	// Shown to logged in users whose account was banned
+	r.Handle("/banned", apperror.Handler(handlers.BannedHandler)).Methods("GET")
+
+	// Appeals; banned users reach them from the ban notice without a login
+	r.Handle("/appeals", apperror.Handler(handlers.AppealsHandler)).Methods("GET", "POST")
+
+	// Moderator request route
+	r.Handle("/moderator-request", apperror.Handler(handlers.ModeratorRequestHandler)).Methods("GET", "POST")
+
+	// Protected endpoints
+	protected := r.NewRoute().Subrouter()
+	protected.Use(middleware.AuthMiddleware)
+	protected.Handle("/profile", apperror.Handler(handlers.ProfileHandler))
+	protected.Handle("/create-thread", limit("thread", can(permissions.ThreadCreate, handlers.CreateThreadHandler))).Methods("GET", "POST")
+	protected.Handle("/resubmit-thread/{id:[0-9]+}", limit("thread", can(permissions.ThreadCreate, handlers.ResubmitThreadHandler))).Methods("POST")
+	protected.Handle("/create-comment", limit("comment", can(permissions.CommentCreate, handlers.CreateCommentHandler))).Methods("POST")
+	protected.Handle("/report", limit("report", can(permissions.ReportCreate, handlers.ReportHandler))).Methods("GET", "POST")
+	protected.Handle("/account/2fa", apperror.Handler(handlers.TwoFactorSetupHandler)).Methods("GET", "POST")
+	protected.Handle("/account/2fa/disable", apperror.Handler(handlers.TwoFactorDisableHandler)).Methods("POST")
+	protected.Handle("/account/2fa/recovery-codes", apperror.Handler(handlers.TwoFactorRecoveryCodesHandler)).Methods("POST")
+	protected.Handle("/account/tokens", apperror.Handler(handlers.APITokensHandler)).Methods("GET", "POST")
+	protected.Handle("/account/tokens/{id:[0-9]+}/revoke", apperror.Handler(handlers.RevokeAPITokenHandler)).Methods("POST")
+
+	// Moderator endpoints
+	r.Handle("/moderator/panel", can(permissions.ModerationPanel, handlers.ModeratorPanelHandler)).Methods("GET")
+	r.Handle("/moderator/approve-thread/{id:[0-9]+}", can(permissions.ThreadApprove, handlers.ApproveThreadHandler)).Methods("POST")
+	r.Handle("/moderator/reject-thread/{id:[0-9]+}", can(permissions.ThreadApprove, handlers.RejectThreadHandler)).Methods("POST")
+	r.Handle("/moderator/request-changes/{id:[0-9]+}", can(permissions.ThreadApprove, handlers.RequestChangesHandler)).Methods("POST")
+	r.Handle("/moderator/threads/{id:[0-9]+}", can(permissions.ThreadApprove, handlers.ReviewThreadHandler)).Methods("GET")
+	r.Handle("/moderator/reports", can(permissions.ReportView, handlers.ListReportsHandler)).Methods("GET")
+	r.Handle("/moderator/reports/{id:[0-9]+}", can(permissions.ReportView, handlers.ReportDetailHandler)).Methods("GET")
+	r.Handle("/moderator/reports/{id:[0-9]+}/assign", can(permissions.ReportResolve, handlers.AssignReportHandler)).Methods("POST")
+	r.Handle("/moderator/reports/{id:[0-9]+}/resolve", can(permissions.ReportResolve, handlers.ResolveReportHandler)).Methods("POST")
+	r.Handle("/moderator/delete-thread/{id:[0-9]+}", can(permissions.ThreadDelete, handlers.DeleteThreadHandler)).Methods("POST")
+	r.Handle("/moderator/bulk", can(permissions.ModerationPanel, handlers.BulkModerationHandler)).Methods("POST")
+	r.Handle("/moderator/approve-comment/{id:[0-9]+}", can(permissions.ThreadApprove, handlers.ApproveCommentHandler)).Methods("POST")
+	r.Handle("/moderator/delete-comment/{id:[0-9]+}", can(permissions.ThreadDelete, handlers.DeleteCommentHandler)).Methods("POST")
+	r.Handle("/moderator/sanctions", can(permissions.UserSuspend, handlers.ActiveSanctionsHandler)).Methods("GET")
+	r.Handle("/moderator/sanctions/{id:[0-9]+}/revoke", can(permissions.UserSuspend, handlers.RevokeSanctionHandler)).Methods("POST")
+	r.Handle("/moderator/users/{id:[0-9]+}", can(permissions.UserSuspend, handlers.UserSanctionsHandler)).Methods("GET")
+	r.Handle("/moderator/users/{id:[0-9]+}/sanctions", can(permissions.UserSuspend, handlers.CreateSanctionHandler)).Methods("POST")
+	r.Handle("/moderator/appeals", can(permissions.AppealReview, handlers.ListAppealsHandler)).Methods("GET")
+	r.Handle("/moderator/appeals/{id:[0-9]+}", can(permissions.AppealReview, handlers.AppealDetailHandler)).Methods("GET")
+	r.Handle("/moderator/appeals/{id:[0-9]+}/decide", can(permissions.AppealReview, handlers.DecideAppealHandler)).Methods("POST")
+
+	// Admin endpoints
+	r.Handle("/admin", apperror.Handler(handlers.AdminLoginHandler)).Methods("GET", "POST")
+	r.Handle("/admin/panel", can(permissions.AdminPanel, handlers.AdminPanelHandler)).Methods("GET")
+	r.Handle("/admin/moderator-requests", can(permissions.UserManage, handlers.ListModeratorRequestsHandler)).Methods("GET")
+	r.Handle("/admin/approve-moderator/{id:[0-9]+}", can(permissions.UserManage, handlers.ApproveModeratorHandler)).Methods("POST")
+	r.Handle("/admin/reject-moderator/{id:[0-9]+}", can(permissions.UserManage, handlers.RejectModeratorHandler)).Methods("POST")
+	r.Handle("/admin/promote-user/{id:[0-9]+}", can(permissions.UserManage, handlers.PromoteUserHandler)).Methods("POST")
+	r.Handle("/admin/demote-user/{id:[0-9]+}", can(permissions.UserManage, handlers.DemoteUserHandler)).Methods("POST")
+	r.Handle("/admin/set-role/{id:[0-9]+}", can(permissions.UserManage, handlers.SetUserRoleHandler)).Methods("POST")
+	r.Handle("/admin/reset-2fa/{id:[0-9]+}", can(permissions.UserManage, handlers.ResetTwoFactorHandler)).Methods("POST")
+	r.Handle("/admin/create-category", can(permissions.CategoryManage, handlers.CreateCategoryHandler)).Methods("POST")
+	r.Handle("/admin/delete-category", can(permissions.CategoryManage, handlers.DeleteCategoryHandler)).Methods("POST")
+	r.Handle("/admin/category-moderators", can(permissions.CategoryManage, handlers.AssignCategoryModeratorHandler)).Methods("POST")
+	r.Handle("/admin/category-moderators/remove", can(permissions.CategoryManage, handlers.RemoveCategoryModeratorHandler)).Methods("POST")
+	r.Handle("/admin/report-reasons", can(permissions.ReasonManage, handlers.ReportReasonsHandler)).Methods("GET", "POST")
+	r.Handle("/admin/report-reasons/{id:[0-9]+}/delete", can(permissions.ReasonManage, handlers.DeleteReportReasonHandler)).Methods("POST")
+	r.Handle("/admin/registration-bans", can(permissions.UserBan, handlers.RegistrationBansHandler)).Methods("GET", "POST")
+	r.Handle("/admin/registration-bans/{id:[0-9]+}/delete", can(permissions.UserBan, handlers.DeleteRegistrationBanHandler)).Methods("POST")
+	r.Handle("/admin/roles", can(permissions.RoleManage, handlers.RolesHandler)).Methods("GET", "POST")
+	r.Handle("/admin/roles/{id:[0-9]+}/permissions", can(permissions.RoleManage, handlers.UpdateRolePermissionsHandler)).Methods("POST")
+	r.Handle("/admin/roles/{id:[0-9]+}/delete", can(permissions.RoleManage, handlers.DeleteRoleHandler)).Methods("POST")
+	r.Handle("/admin/2fa-policy", can(permissions.SecurityManage, handlers.TwoFactorPolicyHandler)).Methods("POST")
+	r.Handle("/admin/security", can(permissions.SecurityManage, handlers.AdminSecurityHandler)).Methods("GET")
+	r.Handle("/admin/unlock", can(permissions.SecurityManage, handlers.UnlockLoginHandler)).Methods("POST")
+	r.Handle("/admin/automod", can(permissions.AutomodManage, handlers.AutomodRulesHandler)).Methods("GET")
+	r.Handle("/admin/automod/new", can(permissions.AutomodManage, handlers.AutomodRuleHandler)).Methods("GET", "POST")
+	r.Handle("/admin/automod/{id:[0-9]+}", can(permissions.AutomodManage, handlers.AutomodRuleHandler)).Methods("GET", "POST")
+	r.Handle("/admin/automod/{id:[0-9]+}/delete", can(permissions.AutomodManage, handlers.DeleteAutomodRuleHandler)).Methods("POST")
+	r.Handle("/admin/spam", can(permissions.AutomodManage, handlers.SpamFilterHandler)).Methods("GET", "POST")
+	r.Handle("/admin/spam/retrain", can(permissions.AutomodManage, handlers.RetrainSpamHandler)).Methods("POST")
+	r.Handle("/admin/mod-log", can(permissions.AuditView, handlers.ModLogHandler)).Methods("GET")
+	r.Handle("/admin/mod-log.csv", can(permissions.AuditView, handlers.ModLogCSVHandler)).Methods("GET")
+
+	// JSON API
+	r.Handle("/api/openapi.json", apperror.Handler(handlers.APIOpenAPIHandler)).Methods("GET")
+	r.Handle("/api/docs", apperror.Handler(handlers.APIDocsHandler)).Methods("GET")
+	api := r.PathPrefix("/api/v1").Subrouter()
+	api.Use(middleware.APIAuth)
+	apiCan := func(perm string, h apperror.Handler) http.Handler {
+		return middleware.APIRequire(perm)(h)
+	}
+	api.Handle("/me", apiCan("", handlers.APIMeHandler)).Methods("GET")
+	api.Handle("/categories", apperror.Handler(handlers.APICategoriesHandler)).Methods("GET")
+	api.Handle("/threads", apperror.Handler(handlers.APIListThreadsHandler)).Methods("GET")
+	api.Handle("/threads", limit("thread", apiCan(permissions.ThreadCreate, handlers.APICreateThreadHandler))).Methods("POST")
+	api.Handle("/threads/{id:[0-9]+}", apperror.Handler(handlers.APIGetThreadHandler)).Methods("GET")
+	api.Handle("/threads/{id:[0-9]+}", apiCan(permissions.ThreadCreate, handlers.APIUpdateThreadHandler)).Methods("PATCH")
+	api.Handle("/threads/{id:[0-9]+}/comments", apperror.Handler(handlers.APIListCommentsHandler)).Methods("GET")
+	api.Handle("/threads/{id:[0-9]+}/comments", limit("comment", apiCan(permissions.CommentCreate, handlers.APICreateCommentHandler))).Methods("POST")
+	api.Handle("/threads/{id:[0-9]+}/reaction", limit("reaction", apiCan(permissions.ReactionCreate, handlers.APIThreadReactionHandler))).Methods("PUT")
+	api.Handle("/comments/{id:[0-9]+}", apiCan(permissions.CommentCreate, handlers.APIUpdateCommentHandler)).Methods("PATCH")
+	api.Handle("/comments/{id:[0-9]+}/reaction", limit("reaction", apiCan(permissions.ReactionCreate, handlers.APICommentReactionHandler))).Methods("PUT")
+	api.Handle("/reports", limit("report", apiCan(permissions.ReportCreate, handlers.APICreateReportHandler))).Methods("POST")
+	api.Handle("/reports", apiCan(permissions.ReportView, handlers.APIListReportsHandler)).Methods("GET")
+	api.Handle("/report-reasons", apperror.Handler(handlers.APIReportReasonsHandler)).Methods("GET")
+
+	// Public endpoints
+	public := r.NewRoute().Subrouter()
+	public.Handle("/index", apperror.Handler(handlers.IndexHandler))
+	public.Handle("/thread", apperror.Handler(handlers.ViewThreadHandler)).Methods("GET")
+	public.Handle("/profile", apperror.Handler(handlers.ProfileHandler)).Methods("GET")
+	public.Handle("/logout", apperror.Handler(handlers.LogoutHandler)).Methods("GET")
+
+	// Static files
+	r.PathPrefix("/static/").Handler(http.StripPrefix("/static/", http.FileServer(http.Dir("./static"))))
+
+	return r
+}
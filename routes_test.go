@@ -0,0 +1,57 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"DytForum/handlers"
+
+	"github.com/gorilla/mux"
+)
+
+// routeVariable matches mux variables with a pattern such as {id:[0-9]+}.
+var routeVariable = regexp.MustCompile(`\{([^}:]+):[^}]*\}`)
+
+// TestAPIRoutesDocumented fails when a route under /api/v1 is registered
+// without being described in the OpenAPI document, or the other way round.
+func TestAPIRoutesDocumented(t *testing.T) {
+	const prefix = "/api/v1"
+	doc := handlers.APIDocument()
+
+	registered := map[string]bool{}
+	err := newRouter().Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		tmpl, err := route.GetPathTemplate()
+		if err != nil || !strings.HasPrefix(tmpl, prefix+"/") {
+			return nil
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			t.Errorf("API route %s does not restrict its methods", tmpl)
+			return nil
+		}
+		path := routeVariable.ReplaceAllString(strings.TrimPrefix(tmpl, prefix), "{$1}")
+		for _, method := range methods {
+			key := method + " " + path
+			registered[key] = true
+			if _, ok := doc.Paths[path][strings.ToLower(method)]; !ok {
+				t.Errorf("%s %s is registered but missing from the OpenAPI document", method, tmpl)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(registered) == 0 {
+		t.Fatal("no API routes found")
+	}
+
+	for path, operations := range doc.Paths {
+		for method := range operations {
+			if key := strings.ToUpper(method) + " " + path; !registered[key] {
+				t.Errorf("%s is documented but not registered", key)
+			}
+		}
+	}
+}
@@ -0,0 +1,177 @@
+// server/server.go
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"DytForum/config"
+)
+
+var shuttingDown atomic.Bool
+
+// ShuttingDown reports whether a termination signal has been received and
+// the server is draining its connections.
+func ShuttingDown() bool {
+	return shuttingDown.Load()
+}
+
+// Run serves handler until SIGINT or SIGTERM, then stops accepting new
+// connections and waits up to cfg.ShutdownTimeout for in-flight requests.
+// With TLS enabled the certificate is reloaded on SIGHUP.
+func Run(cfg config.Config, handler http.Handler) error {
+	srv := &http.Server{
+		Addr:              cfg.Addr,
+		Handler:           handler,
+		ReadTimeout:       cfg.ReadTimeout,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+	}
+
+	var certs *certReloader
+	if cfg.TLSEnabled() {
+		var err error
+		certs, err = newCertReloader(cfg.TLSCertFile, cfg.TLSKeyFile)
+		if err != nil {
+			return err
+		}
+		srv.TLSConfig = &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			GetCertificate: certs.GetCertificate,
+		}
+	}
+
+	servers := []*http.Server{srv}
+	if cfg.RedirectAddr != "" {
+		servers = append(servers, &http.Server{
+			Addr:              cfg.RedirectAddr,
+			Handler:           redirectHandler(cfg),
+			ReadTimeout:       cfg.ReadTimeout,
+			ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+			WriteTimeout:      cfg.WriteTimeout,
+			IdleTimeout:       cfg.IdleTimeout,
+		})
+	}
+
+	errs := make(chan error, len(servers))
+	for i, s := range servers {
+		go func(s *http.Server, main bool) {
+			var err error
+			if main && certs != nil {
+				slog.Info("server started", "addr", s.Addr, "tls", true)
+				err = s.ListenAndServeTLS("", "")
+			} else if main {
+				slog.Info("server started", "addr", s.Addr, "tls", false)
+				err = s.ListenAndServe()
+			} else {
+				slog.Info("redirecting HTTP requests", "addr", s.Addr, "to", cfg.BaseURL)
+				err = s.ListenAndServe()
+			}
+			if !errors.Is(err, http.ErrServerClosed) {
+				errs <- err
+			}
+		}(s, i == 0)
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(signals)
+
+	for {
+		select {
+		case err := <-errs:
+			shutdown(servers, cfg)
+			return err
+		case sig := <-signals:
+			if sig == syscall.SIGHUP {
+				if certs == nil {
+					continue
+				}
+				if err := certs.reload(); err != nil {
+					slog.Error("failed to reload TLS certificate, keeping the old one", "err", err)
+				} else {
+					slog.Info("reloaded TLS certificate")
+				}
+				continue
+			}
+			slog.Info("shutting down", "signal", sig.String())
+			shuttingDown.Store(true)
+			if cfg.ShutdownDelay > 0 {
+				slog.Info("waiting before closing listeners", "delay", cfg.ShutdownDelay)
+				time.Sleep(cfg.ShutdownDelay)
+			}
+			return shutdown(servers, cfg)
+		}
+	}
+}
+
+// shutdown gracefully stops every server within the configured timeout.
+func shutdown(servers []*http.Server, cfg config.Config) error {
+	shuttingDown.Store(true)
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+
+	var firstErr error
+	for _, s := range servers {
+		if err := s.Shutdown(ctx); err != nil {
+			slog.Error("failed to shut down cleanly", "addr", s.Addr, "err", err)
+			s.Close()
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+// redirectHandler sends plain HTTP requests to the same path under BaseURL.
+func redirectHandler(cfg config.Config) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, cfg.URL(r.URL.RequestURI()), http.StatusMovedPermanently)
+	})
+}
+
+// certReloader serves the most recently loaded certificate so it can be
+// replaced without restarting the server.
+type certReloader struct {
+	certFile, keyFile string
+
+	mu   sync.RWMutex
+	cert *tls.Certificate
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	c := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := c.reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *certReloader) reload() error {
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.cert = &cert
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.cert, nil
+}
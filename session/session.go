@@ -1,30 +1,19 @@
 package session
 
 import (
-	"os"
+	"DytForum/config"
 
 	"github.com/gorilla/sessions"
-	"github.com/joho/godotenv"
 )
 
 var Store *sessions.CookieStore
 
-func Init() {
-	err := godotenv.Load()
-	if err != nil {
-		panic("Error loading .env file")
-	}
-
-	sessionKey := os.Getenv("SESSION_KEY")
-	if sessionKey == "" {
-		panic("SESSION_KEY environment variable is not set")
-	}
-
-	Store = sessions.NewCookieStore([]byte(sessionKey))
+func Init(cfg config.Config) {
+	Store = sessions.NewCookieStore([]byte(cfg.SessionKey))
 	Store.Options = &sessions.Options{
 		Path:     "/",
-		MaxAge:   3600 * 24, // 24 hours
+		MaxAge:   cfg.SessionMaxAge,
 		HttpOnly: true,
-		Secure:   false, // Production'da true olmalı
+		Secure:   cfg.CookieSecure,
 	}
 }
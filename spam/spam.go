@@ -0,0 +1,75 @@
+// Package spam is a naive Bayes classifier that learns from moderators'
+// decisions which posts are spam. It only does the arithmetic; storing the
+// training data is up to the caller.
+package spam
+
+import (
+	"math"
+	"strings"
+	"unicode"
+)
+
+// MinExamples is how many spam and how many legitimate examples the model
+// needs before it scores posts.
+const MinExamples = 5
+
+const (
+	minTokenLength = 2
+	maxTokenLength = 40
+)
+
+// Tokens splits text into the distinct lower case words the classifier
+// learns from. Very short and very long words are left out.
+func Tokens(text string) []string {
+	seen := map[string]bool{}
+	var tokens []string
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if n := len([]rune(word)); n < minTokenLength || n > maxTokenLength || seen[word] {
+			continue
+		}
+		seen[word] = true
+		tokens = append(tokens, word)
+	}
+	return tokens
+}
+
+// Counts is how many spam and legitimate (ham) examples contain a token,
+// or how many examples there are in total.
+type Counts struct {
+	Spam int
+	Ham  int
+}
+
+// Model is the training data needed to score a post: the example totals
+// and the counts of the post's tokens. Tokens missing from the map were
+// never seen in training.
+type Model struct {
+	Docs   Counts
+	Tokens map[string]Counts
+}
+
+// Ready reports whether the model has seen enough examples of both kinds.
+func (m Model) Ready() bool {
+	return m.Docs.Spam >= MinExamples && m.Docs.Ham >= MinExamples
+}
+
+// Score returns the probability, between 0 and 1, that a post with these
+// tokens is spam. Tokens never seen in training are ignored.
+func (m Model) Score(tokens []string) float64 {
+	total := float64(m.Docs.Spam + m.Docs.Ham)
+	logSpam := math.Log(float64(m.Docs.Spam) / total)
+	logHam := math.Log(float64(m.Docs.Ham) / total)
+	for _, token := range tokens {
+		c, ok := m.Tokens[token]
+		if !ok || c.Spam+c.Ham == 0 {
+			continue
+		}
+		// Laplace smoothing keeps a token seen in only one kind of post
+		// from deciding the score on its own.
+		logSpam += math.Log(float64(c.Spam+1) / float64(m.Docs.Spam+2))
+		logHam += math.Log(float64(c.Ham+1) / float64(m.Docs.Ham+2))
+	}
+	return 1 / (1 + math.Exp(logHam-logSpam))
+}
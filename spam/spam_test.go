@@ -0,0 +1,82 @@
+package spam
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTokens(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"", nil},
+		{"Buy CHEAP pills, buy now!", []string{"buy", "cheap", "pills", "now"}},
+		{"a b cd", []string{"cd"}},
+		{"Diyet 2024: şeker", []string{"diyet", "2024", "şeker"}},
+		{strings.Repeat("a", maxTokenLength+1) + " ok ok", []string{"ok"}},
+	}
+	for _, tt := range tests {
+		if got := Tokens(tt.text); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Tokens(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestModelScore(t *testing.T) {
+	// Two spam posts both mention a casino and a win, two legitimate
+	// posts both mention a diet, and "free" shows up once in each kind.
+	model := Model{
+		Docs: Counts{Spam: 2, Ham: 2},
+		Tokens: map[string]Counts{
+			"casino": {Spam: 2},
+			"win":    {Spam: 2},
+			"diet":   {Ham: 2},
+			"free":   {Spam: 1, Ham: 1},
+		},
+	}
+	tests := []struct {
+		tokens []string
+		want   float64
+	}{
+		{nil, 0.5},
+		{[]string{"unseen"}, 0.5},
+		{[]string{"casino"}, 0.75},
+		{[]string{"diet"}, 0.25},
+		{[]string{"free"}, 0.5},
+		{[]string{"casino", "diet"}, 0.5},
+		{[]string{"casino", "win"}, 0.9},
+		{[]string{"casino", "win", "free", "unseen"}, 0.9},
+	}
+	for _, tt := range tests {
+		if got := model.Score(tt.tokens); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("Score(%q) = %v, want %v", tt.tokens, got, tt.want)
+		}
+	}
+}
+
+func TestModelScorePrior(t *testing.T) {
+	model := Model{Docs: Counts{Spam: 3, Ham: 1}}
+	if got := model.Score([]string{"unseen"}); math.Abs(got-0.75) > 1e-9 {
+		t.Errorf("Score = %v, want the spam share 0.75", got)
+	}
+}
+
+func TestModelReady(t *testing.T) {
+	tests := []struct {
+		docs Counts
+		want bool
+	}{
+		{Counts{}, false},
+		{Counts{Spam: MinExamples, Ham: MinExamples - 1}, false},
+		{Counts{Spam: MinExamples - 1, Ham: MinExamples}, false},
+		{Counts{Spam: MinExamples, Ham: MinExamples}, true},
+	}
+	for _, tt := range tests {
+		if got := (Model{Docs: tt.docs}).Ready(); got != tt.want {
+			t.Errorf("Ready with %+v = %v, want %v", tt.docs, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,10 @@
+// Package templates holds the HTML templates compiled into the binary.
+package templates
+
+import "embed"
+
+// FS contains the page templates, the layouts they are rendered in and the
+// partials they share.
+//
+//go:embed *.html layouts/*.html partials/*.html
+var FS embed.FS
@@ -0,0 +1,105 @@
+// Package totp implements RFC 6238 time-based one-time passwords.
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// Period is the number of seconds each code stays valid.
+	Period = 30
+	// Digits is the length of generated codes.
+	Digits = 6
+	// Skew is how many periods before/after the current one are accepted.
+	Skew = 1
+)
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateSecret returns a new random base32 encoded shared secret.
+func GenerateSecret() (string, error) {
+	buf := make([]byte, 20)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return encoding.EncodeToString(buf), nil
+}
+
+// ProvisioningURI builds the otpauth:// URI that authenticator apps read from a QR code.
+func ProvisioningURI(issuer, account, secret string) string {
+	label := url.PathEscape(issuer + ":" + account)
+	params := url.Values{}
+	params.Set("secret", secret)
+	params.Set("issuer", issuer)
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", fmt.Sprint(Digits))
+	params.Set("period", fmt.Sprint(Period))
+	return "otpauth://totp/" + label + "?" + params.Encode()
+}
+
+// GenerateCode returns the code for the given secret at time t.
+func GenerateCode(secret string, t time.Time) (string, error) {
+	key, err := encoding.DecodeString(strings.ToUpper(strings.TrimSpace(secret)))
+	if err != nil {
+		return "", err
+	}
+	return hotp(key, uint64(t.Unix()/Period)), nil
+}
+
+// Validate reports whether code is valid for secret at time t, allowing for clock skew.
+func Validate(secret, code string, t time.Time) bool {
+	_, ok := ValidateAfter(secret, code, t, 0)
+	return ok
+}
+
+// ValidateAfter is Validate for codes of time steps later than after, and
+// also returns the step the code belongs to. Storing the step of each
+// accepted code and passing it as after keeps a code from being used twice.
+func ValidateAfter(secret, code string, t time.Time, after int64) (int64, bool) {
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	if len(code) != Digits {
+		return 0, false
+	}
+	key, err := encoding.DecodeString(strings.ToUpper(strings.TrimSpace(secret)))
+	if err != nil {
+		return 0, false
+	}
+	counter := t.Unix() / Period
+	for i := -Skew; i <= Skew; i++ {
+		step := counter + int64(i)
+		if step <= after {
+			continue
+		}
+		if hmac.Equal([]byte(hotp(key, uint64(step))), []byte(code)) {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// hotp implements RFC 4226 with dynamic truncation.
+func hotp(key []byte, counter uint64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], counter)
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < Digits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", Digits, value%mod)
+}
@@ -0,0 +1,66 @@
+package totp
+
+import (
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA1 key of the RFC 6238 test vectors, "12345678901234567890".
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestGenerateCodeRFC6238(t *testing.T) {
+	// The RFC lists eight digit codes; six digit codes are their last six.
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		code, err := GenerateCode(rfcSecret, time.Unix(tt.unix, 0))
+		if err != nil {
+			t.Fatalf("GenerateCode(%d): %v", tt.unix, err)
+		}
+		if code != tt.code {
+			t.Errorf("GenerateCode(%d) = %s, want %s", tt.unix, code, tt.code)
+		}
+	}
+}
+
+func TestValidateAfter(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	step := now.Unix() / Period
+	code, _ := GenerateCode(rfcSecret, now)
+	previous, _ := GenerateCode(rfcSecret, now.Add(-Period*time.Second))
+	tooOld, _ := GenerateCode(rfcSecret, now.Add(-2*Period*time.Second))
+
+	tests := []struct {
+		name     string
+		code     string
+		after    int64
+		wantStep int64
+		wantOK   bool
+	}{
+		{"current code", code, 0, step, true},
+		{"with spaces", code[:3] + " " + code[3:], 0, step, true},
+		{"previous step within skew", previous, 0, step - 1, true},
+		{"outside skew", tooOld, 0, 0, false},
+		{"already used", code, step, 0, false},
+		{"earlier code after a later one was used", previous, step, 0, false},
+		{"wrong length", "12345", 0, 0, false},
+	}
+	for _, tt := range tests {
+		gotStep, ok := ValidateAfter(rfcSecret, tt.code, now, tt.after)
+		if ok != tt.wantOK || gotStep != tt.wantStep {
+			t.Errorf("%s: ValidateAfter = %d, %v, want %d, %v", tt.name, gotStep, ok, tt.wantStep, tt.wantOK)
+		}
+	}
+	if !Validate(rfcSecret, code, now) {
+		t.Error("Validate rejected the current code")
+	}
+}